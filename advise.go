@@ -0,0 +1,197 @@
+package zdd
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	dropIndexPattern  = regexp.MustCompile(`(?is)^DROP\s+INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+EXISTS\s+)?(.*?)(?:\s+(?:CASCADE|RESTRICT))?$`)
+	alterIndexPattern = regexp.MustCompile(`(?is)^ALTER\s+INDEX\s+(?:IF\s+EXISTS\s+)?([^\s]+)\s+RENAME\s+TO\s+([^\s]+)$`)
+)
+
+type (
+	// IndexUsage is an index of the database with how often it has been scanned
+	IndexUsage struct {
+		Schema     string
+		Name       string
+		Table      string
+		Scans      int64 // idx_scan of pg_stat_user_indexes since statistics were last reset
+		SizeBytes  int64
+		Definition string // CREATE INDEX statement, as returned by pg_get_indexdef
+		// Enforces is set for unique and primary key indexes and those backing constraints, which
+		// cannot be dropped for going unused
+		Enforces bool
+	}
+
+	// IndexInspector is implemented by databases that can report index usage
+	IndexInspector interface {
+		// IndexUsage returns the indexes of user tables and when the database's statistics were
+		// last reset, zero if never
+		IndexUsage() ([]IndexUsage, time.Time, error)
+	}
+
+	// TrackedIndex is an index created by a deployment and not dropped by a later one
+	TrackedIndex struct {
+		Name      string // Normalized name, qualified by its table's schema unless public
+		Table     string
+		CreatedBy *Deployment
+	}
+
+	// IndexSuggestion suggests dropping an unused index created by a deployment
+	IndexSuggestion struct {
+		Index     IndexUsage
+		CreatedBy *Deployment
+		SQL       string // Contract statement dropping the index
+		Rollback  string // Statement recreating the index
+	}
+
+	// IndexAdvice is the result of AdviseIndexes
+	IndexAdvice struct {
+		// StatsReset is when usage statistics were last reset, zero if never; an index scanned
+		// rarely since a recent reset may still be needed
+		StatsReset  time.Time
+		Suggestions []IndexSuggestion
+	}
+)
+
+// TrackIndexes replays the SQL files of deployments, in order, and returns the indexes they
+// create that no later deployment drops. Renamed indexes are tracked under their new name.
+func TrackIndexes(deployments []Deployment) ([]TrackedIndex, error) {
+	var tracked []TrackedIndex
+	for i := range deployments {
+		deployment := &deployments[i]
+		for _, task := range deployment.Tasks() {
+			if task.TaskType != "sql" {
+				continue
+			}
+			content, err := readPsqlFile(task.Path, func(string) {})
+			if err != nil {
+				return nil, err
+			}
+			for _, statement := range scanStatements(content) {
+				sql := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(statement.SQL, " "))
+				tracked = trackIndexStatement(tracked, sql, deployment)
+			}
+		}
+	}
+	return tracked, nil
+}
+
+// trackIndexStatement applies a statement, without comments, to the tracked indexes
+func trackIndexStatement(tracked []TrackedIndex, sql string, deployment *Deployment) []TrackedIndex {
+	untrack := func(name string) []TrackedIndex {
+		return slices.DeleteFunc(tracked, func(index TrackedIndex) bool { return index.Name == name })
+	}
+
+	if match := createIndexPattern.FindStringSubmatch(sql); match != nil {
+		// An index lives in its table's schema
+		name := match[3]
+		if !strings.Contains(name, ".") && strings.Contains(match[4], ".") {
+			name = match[4][:strings.LastIndex(match[4], ".")+1] + name
+		}
+		name = normalizeName(name)
+		return append(untrack(name), TrackedIndex{Name: name, Table: normalizeName(match[4]), CreatedBy: deployment})
+	}
+
+	if match := dropIndexPattern.FindStringSubmatch(sql); match != nil {
+		for _, name := range strings.Split(match[1], ",") {
+			tracked = untrack(normalizeName(name))
+		}
+		return tracked
+	}
+
+	if match := alterIndexPattern.FindStringSubmatch(sql); match != nil {
+		from := normalizeName(match[1])
+		to := normalizeName(match[2])
+		if schema := from[:max(strings.LastIndex(from, "."), 0)]; schema != "" && !strings.Contains(to, ".") {
+			to = schema + "." + to
+		}
+		for i := range tracked {
+			if tracked[i].Name == from {
+				tracked[i].Name = to
+			}
+		}
+	}
+	return tracked
+}
+
+// AdviseIndexes matches the indexes the deployments of the module create against the
+// database's index usage, and suggests dropping those scanned at most maxScans times. Indexes
+// enforcing uniqueness or constraints are never suggested. Statistics only count scans on this
+// server, so indexes used by queries on replicas are suggested too.
+func AdviseIndexes(module Module, db IndexInspector, maxScans int64) (*IndexAdvice, error) {
+	deployments, err := LoadModuleDeployments(module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local deployments: %w", err)
+	}
+	tracked, err := TrackIndexes(deployments)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, statsReset, err := db.IndexUsage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index usage: %w", err)
+	}
+
+	advice := &IndexAdvice{StatsReset: statsReset}
+	for _, index := range usage {
+		if index.Enforces || index.Scans > maxScans {
+			continue
+		}
+		qualified := quoteIdentifier(index.Schema) + "." + quoteIdentifier(index.Name)
+		name := normalizeName(qualified)
+		i := slices.IndexFunc(tracked, func(tracked TrackedIndex) bool { return tracked.Name == name })
+		if i < 0 {
+			continue // Indexes not created by deployments are not zdd's to drop
+		}
+		advice.Suggestions = append(advice.Suggestions, IndexSuggestion{
+			Index:     index,
+			CreatedBy: tracked[i].CreatedBy,
+			SQL:       fmt.Sprintf("DROP INDEX IF EXISTS %s;", qualified),
+			Rollback:  index.Definition + ";",
+		})
+	}
+	return advice, nil
+}
+
+// ContractSQL returns a contract.sql dropping every suggested index
+func (a *IndexAdvice) ContractSQL() string {
+	var sql strings.Builder
+	sql.WriteString("-- Drops indexes unused according to pg_stat_user_indexes, suggested by zdd advise indexes.\n")
+	sql.WriteString("-- DROP INDEX briefly locks each table; check replicas before dropping indexes they may use.\n")
+	for _, suggestion := range a.Suggestions {
+		fmt.Fprintf(&sql, "\n-- Created by deployment %s, scanned %d times\n%s\n", suggestion.CreatedBy.Key(), suggestion.Index.Scans, suggestion.SQL)
+	}
+	return sql.String()
+}
+
+// RollbackSQL returns a revert.sql recreating every suggested index
+func (a *IndexAdvice) RollbackSQL() string {
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "-- Recreates the indexes dropped by contract.sql, generated by %s; deploy never runs it\n", ApplicationName())
+	for _, suggestion := range a.Suggestions {
+		fmt.Fprintf(&sql, "%s\n", suggestion.Rollback)
+	}
+	return sql.String()
+}
+
+// CreateIndexContractDeployment creates a deployment whose contract phase drops the suggested
+// indexes, with a revert.sql recreating them. An empty name defaults to drop_unused_indexes.
+func CreateIndexContractDeployment(module Module, name string, advice *IndexAdvice) (*Deployment, error) {
+	if len(advice.Suggestions) == 0 {
+		return nil, fmt.Errorf("no unused indexes to drop")
+	}
+	if name == "" {
+		name = "drop_unused_indexes"
+	}
+
+	return createDeploymentFiles(module, name, []deploymentFile{
+		{"contract.sql", advice.ContractSQL(), 0644},
+		{revertFileName, advice.RollbackSQL(), 0644},
+	})
+}
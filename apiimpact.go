@@ -0,0 +1,103 @@
+package zdd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// APIImpactConfig configures the API resources reports list as impacted by pending deployments
+	APIImpactConfig struct {
+		// Mapping is the path of a file mapping tables to the API resources built on them
+		Mapping string `yaml:"mapping"`
+	}
+
+	// APIResource is a GraphQL type or REST resource and the tables it is built on
+	APIResource struct {
+		Name   string   `yaml:"name"`   // e.g. "GraphQL Order" or "GET /v1/orders"
+		Tables []string `yaml:"tables"` // e.g. orders or billing.invoices
+		// Owners are mentioned in reports, e.g. @acme/orders-api, so posting a report pings them
+		Owners []string `yaml:"owners"`
+	}
+
+	// APIMapping maps tables to the API resources built on them
+	APIMapping struct {
+		Resources []APIResource `yaml:"resources"`
+	}
+
+	// APIImpact is an API resource built on tables that pending deployments change
+	APIImpact struct {
+		Resource    string   `json:"resource"`
+		Owners      []string `json:"owners"`
+		Tables      []string `json:"tables"`      // The resource's tables the deployments change
+		Deployments []string `json:"deployments"` // Keys of the pending deployments changing them
+	}
+)
+
+// LoadAPIMapping reads the mapping of tables to API resources at path
+func LoadAPIMapping(path string) (*APIMapping, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API mapping: %w", err)
+	}
+	var mapping APIMapping
+	if err := yaml.Unmarshal(content, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to parse API mapping %s: %w", path, err)
+	}
+	for i, resource := range mapping.Resources {
+		if resource.Name == "" {
+			return nil, fmt.Errorf("invalid API mapping %s: resource %d has no name", path, i+1)
+		}
+	}
+	return &mapping, nil
+}
+
+// Impacts returns the API resources built on tables that the pending deployments of the modules
+// create, alter, index or write rows of, in the mapping's order
+func (m *APIMapping) Impacts(modules []Module, db DatabaseProvider) ([]APIImpact, error) {
+	statuses, err := moduleStatuses(modules, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Normalized table name -> keys of the pending deployments touching it
+	touched := make(map[string][]string)
+	for _, status := range statuses {
+		for _, deployment := range status.Pending {
+			tables, err := touchedTables(&deployment)
+			if err != nil {
+				return nil, err
+			}
+			for _, table := range tables {
+				touched[normalizeName(table)] = append(touched[normalizeName(table)], deployment.Key())
+			}
+		}
+	}
+
+	impacts := make([]APIImpact, 0)
+	for _, resource := range m.Resources {
+		impact := APIImpact{Resource: resource.Name, Owners: resource.Owners, Tables: make([]string, 0), Deployments: make([]string, 0)}
+		if impact.Owners == nil {
+			impact.Owners = make([]string, 0)
+		}
+		for _, table := range resource.Tables {
+			keys, ok := touched[normalizeName(table)]
+			if !ok {
+				continue
+			}
+			impact.Tables = append(impact.Tables, table)
+			for _, key := range keys {
+				if !slices.Contains(impact.Deployments, key) {
+					impact.Deployments = append(impact.Deployments, key)
+				}
+			}
+		}
+		if len(impact.Tables) > 0 {
+			impacts = append(impacts, impact)
+		}
+	}
+	return impacts, nil
+}
@@ -0,0 +1,152 @@
+package zdd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// approvalAnnotationPattern matches an approval annotation line of an SQL file, e.g.
+	// "-- zdd:requires-approval security", and captures its scopes
+	approvalAnnotationPattern = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*zdd:requires-approval\b(.*)$`)
+	// approvalScopePattern matches approval scope names
+	approvalScopePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+)
+
+type (
+	// Approval records that a deployment's changes were approved for a scope, such as security
+	Approval struct {
+		Module       string
+		DeploymentID string
+		Scope        string
+		// Token references the approval, e.g. a review URL or change ticket
+		Token string
+		// Checksum is the deployment's checksum when it was approved; the approval lapses if the
+		// deployment changes
+		Checksum   string
+		ApprovedBy string
+		ApprovedAt *time.Time
+	}
+
+	// ApprovalStore is implemented by databases that record approvals
+	ApprovalStore interface {
+		RecordApproval(approval Approval) error
+		// GetApprovals returns the approvals recorded for the deployment
+		GetApprovals(deployment Deployment) ([]Approval, error)
+	}
+)
+
+// RequiredApprovals returns the scopes that the `-- zdd:requires-approval` annotations of the SQL
+// file at path require, in order of appearance
+func RequiredApprovals(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SQL file %s: %w", path, err)
+	}
+
+	var scopes []string
+	for _, match := range approvalAnnotationPattern.FindAllStringSubmatch(string(content), -1) {
+		fields := strings.FieldsFunc(match[1], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' })
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%s: zdd:requires-approval names no scope", path)
+		}
+		for _, scope := range fields {
+			if !approvalScopePattern.MatchString(scope) {
+				return nil, fmt.Errorf("%s: invalid approval scope %q (use lower-case letters, digits, - and _)", path, scope)
+			}
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// ParseApprovals parses --approval values of the form scope:token into tokens keyed by scope
+func ParseApprovals(values []string) (map[string]string, error) {
+	approvals := make(map[string]string)
+	for _, value := range values {
+		scope, token, ok := strings.Cut(value, ":")
+		if !ok || !approvalScopePattern.MatchString(scope) || strings.TrimSpace(token) == "" {
+			return nil, fmt.Errorf("invalid approval %q (expected scope:token, e.g. security:CHG-1234)", value)
+		}
+		approvals[scope] = strings.TrimSpace(token)
+	}
+	return approvals, nil
+}
+
+// CheckApprovals ensures every scope required by the annotations of the plan's pending SQL files
+// is approved, before any task runs: by a token in Approvals, which is recorded with the
+// deployment if the database is an ApprovalStore, or by an approval recorded for the deployment
+// as it is now.
+func (p *Plan) CheckApprovals() error {
+	store, storing := p.db.(ApprovalStore)
+	checked := make(map[string]bool) // Deployment key and scope
+	for _, task := range p.Tasks {
+		if task.TaskType != "sql" || p.AlreadyDeployed[task.Deployment.Key()] {
+			continue
+		}
+		scopes, err := RequiredApprovals(task.Path)
+		if err != nil {
+			return err
+		}
+
+		deployment := task.Deployment
+		for _, scope := range scopes {
+			if checked[deployment.Key()+" "+scope] {
+				continue
+			}
+			checked[deployment.Key()+" "+scope] = true
+
+			checksum := CalculateChecksum(*deployment)
+			if token, ok := p.Approvals[scope]; ok {
+				if !storing {
+					continue
+				}
+				approval := Approval{Module: deployment.Module, DeploymentID: deployment.ID, Scope: scope, Token: token, Checksum: checksum}
+				if err := store.RecordApproval(approval); err != nil {
+					return fmt.Errorf("failed to record %s approval of deployment %s: %w", scope, deployment.Key(), err)
+				}
+				continue
+			}
+
+			approved := false
+			if storing {
+				approvals, err := store.GetApprovals(*deployment)
+				if err != nil {
+					return fmt.Errorf("failed to read approvals of deployment %s: %w", deployment.Key(), err)
+				}
+				approved = slices.ContainsFunc(approvals, func(a Approval) bool { return a.Scope == scope && a.Checksum == checksum })
+			}
+			if !approved {
+				return fmt.Errorf("%s requires %s approval; pass --approval %s:<token> or record one with `zdd approve %s --scope %s --token <token>`", task.Path, scope, scope, deployment.ID, scope)
+			}
+		}
+	}
+	return nil
+}
+
+// Approve records an approval of the deployment as it is now for scope
+func Approve(store ApprovalStore, deployment Deployment, scope, token string) error {
+	if !approvalScopePattern.MatchString(scope) {
+		return fmt.Errorf("invalid approval scope %q (use lower-case letters, digits, - and _)", scope)
+	}
+	if strings.TrimSpace(token) == "" {
+		return fmt.Errorf("an approval token is required")
+	}
+	approval := Approval{
+		Module:       deployment.Module,
+		DeploymentID: deployment.ID,
+		Scope:        scope,
+		Token:        strings.TrimSpace(token),
+		Checksum:     CalculateChecksum(deployment),
+	}
+	if err := store.RecordApproval(approval); err != nil {
+		return fmt.Errorf("failed to record %s approval of deployment %s: %w", scope, deployment.Key(), err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package zdd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const assertFileName = "assert.sql"
+
+// expectPattern matches the "-- expect: rows = 0" and "-- expect: value = 42" lines of assert.sql
+var expectPattern = regexp.MustCompile(`^--\s*expect:\s*(rows|value)\s*=\s*(.*?)\s*$`)
+
+type (
+	// Assertion is a query with an expected result, checked after a deployment's contract phase
+	Assertion struct {
+		Name string `yaml:"name"`
+		// Deployment selects the deployment (id, or module/id) in zdd.yaml; unused in assert.sql
+		Deployment string `yaml:"deployment"`
+		Query      string `yaml:"query"`
+		// Rows is the expected number of rows returned by Query
+		Rows *int `yaml:"rows"`
+		// Value is the expected value of the first column of the single row returned by Query
+		Value *string `yaml:"value"`
+	}
+
+	// AssertionResult is the outcome of checking an assertion
+	AssertionResult struct {
+		Module       string
+		DeploymentID string
+		Name         string
+		Passed       bool
+		Expected     string
+		Actual       string
+	}
+
+	// AssertionRunner is implemented by databases that can evaluate and record assertions
+	AssertionRunner interface {
+		// QueryValues runs query and returns every row with its columns rendered as text
+		QueryValues(query string) ([][]string, error)
+		RecordAssertion(result AssertionResult) error
+	}
+)
+
+// LoadAssertions parses an assert.sql file. Each query is preceded by a line stating its
+// expected result, either "-- expect: rows = N" or "-- expect: value = V".
+func LoadAssertions(path string) ([]Assertion, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var assertions []Assertion
+	var query strings.Builder
+	lineNumber := 0
+
+	flush := func() {
+		if len(assertions) > 0 {
+			assertions[len(assertions)-1].Query = strings.TrimSuffix(strings.TrimSpace(query.String()), ";")
+		}
+		query.Reset()
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+
+		matches := expectPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			if len(assertions) == 0 && strings.TrimSpace(line) != "" && !strings.HasPrefix(strings.TrimSpace(line), "--") {
+				return nil, fmt.Errorf("%s:%d: query has no preceding \"-- expect:\" line", path, lineNumber)
+			}
+			query.WriteString(line + "\n")
+			continue
+		}
+
+		flush()
+		assertion := Assertion{Name: fmt.Sprintf("%s:%d", filepath.Base(path), lineNumber)}
+		switch matches[1] {
+		case "rows":
+			rows, err := strconv.Atoi(matches[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid row count %q", path, lineNumber, matches[2])
+			}
+			assertion.Rows = &rows
+		case "value":
+			value := strings.Trim(matches[2], `'"`)
+			assertion.Value = &value
+		}
+		assertions = append(assertions, assertion)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	flush()
+
+	for _, assertion := range assertions {
+		if assertion.Query == "" {
+			return nil, fmt.Errorf("%s: assertion %s has no query", path, assertion.Name)
+		}
+	}
+
+	return assertions, nil
+}
+
+// AddAssertions attaches assertions from the config file to the pending deployments they name.
+// Assertions for deployments that are not part of the plan are ignored.
+func (p *Plan) AddAssertions(assertions []Assertion) error {
+	for _, assertion := range assertions {
+		if assertion.Query == "" || (assertion.Rows == nil) == (assertion.Value == nil) {
+			return fmt.Errorf("assertion %q must have a query and exactly one of rows or value", assertion.Name)
+		}
+
+		for _, task := range p.Tasks {
+			if task.Deployment.Key() == assertion.Deployment {
+				task.Deployment.Assertions = append(task.Deployment.Assertions, assertion)
+				break
+			}
+		}
+	}
+
+	// A deployment's tasks are contiguous, so insert an assert task before the first post task
+	// (or at the end) of each deployment that has assertions but no assert task yet
+	var tasks []Task
+	for start := 0; start < len(p.Tasks); {
+		deployment := p.Tasks[start].Deployment
+		end := start
+		for end < len(p.Tasks) && p.Tasks[end].Deployment == deployment {
+			end++
+		}
+		group := p.Tasks[start:end]
+		start = end
+
+		if len(deployment.Assertions) == 0 || slices.ContainsFunc(group, func(t Task) bool { return t.TaskType == "assert" }) {
+			tasks = append(tasks, group...)
+			continue
+		}
+
+		post := slices.IndexFunc(group, func(t Task) bool {
+			return t.Deployment.layout.phaseIndex(t.Phase) >= t.Deployment.layout.phaseIndex("post")
+		})
+		if post < 0 {
+			post = len(group)
+		}
+		tasks = append(tasks, group[:post]...)
+		tasks = append(tasks, deployment.assertTask())
+		tasks = append(tasks, group[post:]...)
+	}
+	p.Tasks = tasks
+
+	return nil
+}
+
+// assertTask checks the deployment's assertions once its contract phase has run
+func (d *Deployment) assertTask() Task {
+	path := filepath.Join(d.Directory, assertFileName)
+	if _, err := os.Stat(path); err != nil {
+		path = ""
+	}
+	return Task{TaskType: "assert", Path: path, Phase: "contract", Deployment: d}
+}
+
+// runAssertions checks every assertion of the deployment, recording each result, and fails
+// if any of them does not hold
+func (p *Plan) runAssertions(deployment *Deployment) error {
+	runner, ok := p.db.(AssertionRunner)
+	if !ok {
+		return fmt.Errorf("database does not support assertions")
+	}
+
+	var failed []string
+	for _, assertion := range deployment.Assertions {
+		rows, err := runner.QueryValues(assertion.Query)
+		if err != nil {
+			return fmt.Errorf("assertion %s failed to run: %w", assertion.Name, err)
+		}
+
+		result := AssertionResult{
+			Module:       deployment.Module,
+			DeploymentID: deployment.ID,
+			Name:         assertion.Name,
+		}
+		if assertion.Rows != nil {
+			result.Expected = fmt.Sprintf("%d rows", *assertion.Rows)
+			result.Actual = fmt.Sprintf("%d rows", len(rows))
+		} else {
+			result.Expected = *assertion.Value
+			switch {
+			case len(rows) != 1 || len(rows[0]) == 0:
+				result.Actual = fmt.Sprintf("%d rows", len(rows))
+			default:
+				result.Actual = rows[0][0]
+			}
+		}
+		result.Passed = result.Expected == result.Actual
+
+		if err := runner.RecordAssertion(result); err != nil {
+			return fmt.Errorf("failed to record assertion %s: %w", assertion.Name, err)
+		}
+
+		if result.Passed {
+			fmt.Fprintf(p.out(), "  Assertion %s passed\n", assertion.Name)
+			continue
+		}
+		fmt.Fprintf(p.out(), "  Assertion %s failed: expected %s, got %s\n", assertion.Name, result.Expected, result.Actual)
+		failed = append(failed, assertion.Name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("assertions failed for deployment %s: %s", deployment.Key(), strings.Join(failed, ", "))
+	}
+	return nil
+}
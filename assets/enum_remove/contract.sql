@@ -0,0 +1,10 @@
+-- Contract: once no running application version writes {{.Value}}, forbid it with a check
+-- constraint. Dropping the value from {{.Type}} would rewrite every table using the type, so
+-- the type keeps it but no row can use it.
+{{- range .Columns}}
+ALTER TABLE {{.Table}} ADD CONSTRAINT {{.Constraint}} CHECK ({{.Column}} <> {{$.Value}}) NOT VALID;
+{{- if $.Replacement}}
+UPDATE {{.Table}} SET {{.Column}} = {{$.Replacement}} WHERE {{.Column}} = {{$.Value}};
+{{- end}}
+ALTER TABLE {{.Table}} VALIDATE CONSTRAINT {{.Constraint}};
+{{- end}}
@@ -0,0 +1,8 @@
+{{- if .Replacement -}}
+-- Migrate: move existing rows from {{.Value}} to {{.Replacement}}
+{{- range .Columns}}
+UPDATE {{.Table}} SET {{.Column}} = {{$.Replacement}} WHERE {{.Column}} = {{$.Value}};
+{{- end}}
+{{else -}}
+-- Migrate: move existing rows off {{.Value}} here; contract fails while any row still uses it
+{{end -}}
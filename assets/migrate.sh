@@ -4,8 +4,10 @@
 #   ZDD_IS_HEAD: "true" if this is the latest deployment being applied
 #   ZDD_DEPLOYMENT_ID: Current deployment ID
 #   ZDD_DEPLOYMENT_NAME: Current deployment name
-#   ZDD_PHASE: Current phase (expand/migrate/contract/post)
+#   ZDD_PHASE: Current phase (expand/migrate/contract/post, or a phase configured in zdd.yaml)
+#   ZDD_PHASES: Comma-separated phases in the order they run
 #   ZDD_DEPLOYMENTS_PATH: Path to deployments directory
+#   ZDD_MODULE: Module name (empty unless modules are configured)
 #   ZDD_DATABASE_URL: Database connection string
 
 set -e
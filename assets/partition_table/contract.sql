@@ -0,0 +1,23 @@
+-- Contract: stop mirroring and swap the tables, so {{.TableName}} is partitioned. The old table
+-- is kept as {{.OldName}}; drop it once nothing depends on it (such as sequences it owns
+-- that the partitioned table's defaults still use).
+DROP TRIGGER IF EXISTS {{.Trigger}} ON {{.Table}};
+DROP FUNCTION IF EXISTS {{.Function}}();
+ALTER TABLE {{.Table}} RENAME TO {{.OldBare}};
+ALTER TABLE {{.New}} RENAME TO {{.TableBare}};
+
+-- Partitions follow the table's name, so later partitions.yaml directives recognise them
+DO $$
+DECLARE
+    part record;
+BEGIN
+    FOR part IN
+        SELECT n.nspname, c.relname FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        JOIN pg_namespace n ON n.oid = c.relnamespace
+        WHERE i.inhparent = {{.TableLiteral}}::regclass AND left(c.relname, {{.PrefixLength}}) = {{.PrefixLiteral}}
+    LOOP
+        EXECUTE format('ALTER TABLE %I.%I RENAME TO %I', part.nspname, part.relname,
+            {{.RenamedPrefixLiteral}} || substr(part.relname, {{.PrefixLength}} + 1));
+    END LOOP;
+END $$;
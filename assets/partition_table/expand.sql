@@ -0,0 +1,23 @@
+-- Expand: create {{.NewName}} with the columns of {{.TableName}}, partitioned by range of
+-- {{.ColumnName}}, and mirror every write to {{.TableName}} into it. Rows land in the default partition until
+-- partitions.yaml creates partitions for their range.
+CREATE TABLE IF NOT EXISTS {{.New}} (LIKE {{.Table}} INCLUDING DEFAULTS INCLUDING CONSTRAINTS)
+    PARTITION BY RANGE ({{.Column}});
+CREATE TABLE IF NOT EXISTS {{.Default}} PARTITION OF {{.New}} DEFAULT;
+CREATE INDEX IF NOT EXISTS {{.KeyIndex}} ON {{.New}} ({{.Key}});
+
+CREATE OR REPLACE FUNCTION {{.Function}}() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        DELETE FROM {{.New}} WHERE {{.Key}} = OLD.{{.Key}};
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO {{.New}} SELECT (NEW).*;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS {{.Trigger}} ON {{.Table}};
+CREATE TRIGGER {{.Trigger}} AFTER INSERT OR UPDATE OR DELETE ON {{.Table}}
+    FOR EACH ROW EXECUTE FUNCTION {{.Function}}();
@@ -0,0 +1,5 @@
+-- Migrate: copy rows written before the mirror trigger was installed. Rows older than the
+-- created partitions stay in the default partition until partitions are created for them.
+INSERT INTO {{.New}}
+SELECT * FROM {{.Table}} o
+WHERE NOT EXISTS (SELECT 1 FROM {{.New}} n WHERE n.{{.Key}} = o.{{.Key}});
@@ -0,0 +1,4 @@
+-- Contract: once no running application version uses {{.Old}}, stop syncing and drop it
+DROP TRIGGER IF EXISTS {{.Trigger}} ON {{.Table}};
+DROP FUNCTION IF EXISTS {{.Function}}();
+ALTER TABLE {{.Table}} DROP COLUMN IF EXISTS {{.Old}};
@@ -0,0 +1,36 @@
+-- Expand: add {{.New}} alongside {{.Old}} with the same type, and keep the two in sync
+-- while application versions using either column are running
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_attribute
+        WHERE attrelid = {{.TableLiteral}}::regclass AND attname = {{.NewLiteral}} AND NOT attisdropped
+    ) THEN
+        EXECUTE format('ALTER TABLE %s ADD COLUMN %s %s', {{.TableLiteral}}, {{.NewIdentLiteral}}, (
+            SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+            WHERE attrelid = {{.TableLiteral}}::regclass AND attname = {{.OldLiteral}} AND NOT attisdropped
+        ));
+    END IF;
+END $$;
+
+CREATE OR REPLACE FUNCTION {{.Function}}() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        IF NEW.{{.New}} IS NULL THEN
+            NEW.{{.New}} := NEW.{{.Old}};
+        END IF;
+        IF NEW.{{.Old}} IS NULL THEN
+            NEW.{{.Old}} := NEW.{{.New}};
+        END IF;
+    ELSIF NEW.{{.Old}} IS DISTINCT FROM OLD.{{.Old}} THEN
+        NEW.{{.New}} := NEW.{{.Old}};
+    ELSIF NEW.{{.New}} IS DISTINCT FROM OLD.{{.New}} THEN
+        NEW.{{.Old}} := NEW.{{.New}};
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS {{.Trigger}} ON {{.Table}};
+CREATE TRIGGER {{.Trigger}} BEFORE INSERT OR UPDATE ON {{.Table}}
+    FOR EACH ROW EXECUTE FUNCTION {{.Function}}();
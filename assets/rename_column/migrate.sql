@@ -0,0 +1,2 @@
+-- Migrate: backfill {{.New}} for rows written before the sync trigger was installed
+UPDATE {{.Table}} SET {{.New}} = {{.Old}} WHERE {{.New}} IS DISTINCT FROM {{.Old}};
@@ -0,0 +1,4 @@
+-- Contract: once every running application version uses {{.New}}, stop syncing and drop {{.Table}}
+DROP TRIGGER IF EXISTS {{.Trigger}} ON {{.Table}};
+DROP FUNCTION IF EXISTS {{.Function}}();
+DROP TABLE IF EXISTS {{.Table}};
@@ -0,0 +1,19 @@
+-- Expand: create {{.New}} as a copy of {{.Table}}'s structure, and mirror every write to
+-- {{.Table}} into it while application versions using the old table are running
+CREATE TABLE IF NOT EXISTS {{.New}} (LIKE {{.Table}} INCLUDING ALL);
+
+CREATE OR REPLACE FUNCTION {{.Function}}() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        DELETE FROM {{.New}} WHERE {{.Key}} = OLD.{{.Key}};
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO {{.New}} OVERRIDING SYSTEM VALUE SELECT (NEW).*;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS {{.Trigger}} ON {{.Table}};
+CREATE TRIGGER {{.Trigger}} AFTER INSERT OR UPDATE OR DELETE ON {{.Table}}
+    FOR EACH ROW EXECUTE FUNCTION {{.Function}}();
@@ -0,0 +1,4 @@
+-- Migrate: copy rows written before the sync trigger was installed
+INSERT INTO {{.New}} OVERRIDING SYSTEM VALUE
+SELECT * FROM {{.Table}}
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+-- Contract: rename {{.TableName}} to {{.NewName}} and leave an updatable view under the old name, so
+-- application versions still using it keep working until the next deployment drops the view
+ALTER TABLE {{.Table}} RENAME TO {{.NewBare}};
+CREATE VIEW {{.Table}} AS SELECT * FROM {{.New}};
@@ -0,0 +1,4 @@
+-- Contract: once every running application version sets {{.Setting}} for its session (e.g.
+-- SET {{.Setting}} = '42'), enforce the policy. Roles owning the table bypass it unless
+-- FORCE ROW LEVEL SECURITY is added, which also filters later deployments run as the owner.
+ALTER TABLE {{.Table}} ENABLE ROW LEVEL SECURITY;
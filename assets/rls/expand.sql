@@ -0,0 +1,7 @@
+-- Expand: add a tenant isolation policy to {{.TableName}}. Policies have no effect until row
+-- level security is enabled in contract, so application versions that do not set
+-- {{.Setting}} keep working meanwhile.
+DROP POLICY IF EXISTS {{.Policy}} ON {{.Table}};
+CREATE POLICY {{.Policy}} ON {{.Table}}
+    USING ({{.Column}}::text = current_setting({{.SettingLiteral}}, true))
+    WITH CHECK ({{.Column}}::text = current_setting({{.SettingLiteral}}, true));
@@ -0,0 +1,203 @@
+package zdd
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type (
+	// StatementBencher is a DatabaseProvider that can time statements one at a time
+	StatementBencher interface {
+		DatabaseProvider
+		// BenchStatements runs statements in order in one transaction and commits it. It returns
+		// the duration of each statement and the relation locks they took, each held from the
+		// start of the statement that first took it until the commit.
+		BenchStatements(statements []string) ([]time.Duration, []LockHold, error)
+	}
+
+	// LockHold is a relation lock a transaction held
+	LockHold struct {
+		Relation  string
+		Mode      string // e.g. AccessExclusiveLock
+		Statement int    // Index of the statement that took it
+		Held      time.Duration
+	}
+
+	// StatementTiming is how long a statement of an SQL file took
+	StatementTiming struct {
+		Line      int
+		Statement string // The statement's first words
+		Duration  time.Duration
+	}
+
+	// FileBench is the timing of an SQL file of a benchmarked deployment
+	FileBench struct {
+		Task       string // Task.Name, e.g. "migrate:sql"
+		Path       string
+		Duration   time.Duration
+		Statements []StatementTiming
+		Locks      []LockHold
+	}
+
+	// BenchResult is the timing of a deployment replayed by Bench
+	BenchResult struct {
+		Deployment Deployment
+		Files      []FileBench
+		// Skipped are the tasks Bench did not run, such as scripts
+		Skipped  []string
+		Duration time.Duration
+	}
+)
+
+// Bench replays the deployment with ID id of the module against a sample database, such as a
+// restored copy of production, and times its SQL files statement by statement, along with the
+// relation locks each file's transaction holds. The sample must have every earlier deployment
+// of the module applied and not this one. Other tasks, such as scripts, are not run. The
+// deployment is recorded as applied to the sample, with a task run for each SQL file, so
+// deploys estimate their duration from it with --history-url.
+func Bench(module Module, id string, db StatementBencher) (*BenchResult, error) {
+	deployments, err := LoadModuleDeployments(module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local deployments: %w", err)
+	}
+	i := slices.IndexFunc(deployments, func(d Deployment) bool { return d.ID == id })
+	if i < 0 {
+		return nil, fmt.Errorf("deployment %s not found", id)
+	}
+	deployment := deployments[i]
+
+	plan, err := BuildModulesPlan([]Module{module}, db)
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[string]bool)
+	for _, task := range plan.Tasks {
+		pending[task.Deployment.Key()] = true
+	}
+	if !pending[deployment.Key()] {
+		return nil, fmt.Errorf("deployment %s is already applied to the sample database; restore a copy from before it", deployment.Key())
+	}
+	for _, earlier := range deployments[:i] {
+		if pending[earlier.Key()] {
+			return nil, fmt.Errorf("deployment %s precedes %s but is not applied to the sample database; deploy it there first", earlier.Key(), deployment.Key())
+		}
+	}
+
+	result := &BenchResult{Deployment: deployment}
+	for _, task := range deployment.Tasks() {
+		if task.TaskType != "sql" {
+			result.Skipped = append(result.Skipped, task.Name()+" "+filepath.Base(task.Path))
+			continue
+		}
+
+		file, err := benchFile(db, task)
+		if err != nil {
+			return nil, err
+		}
+		result.Files = append(result.Files, *file)
+		result.Duration += file.Duration
+
+		if err := recordBenchRun(db, task, file.Duration); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.RecordDeployment(deployment, CalculateChecksum(deployment)); err != nil {
+		return nil, fmt.Errorf("failed to record deployment %s: %w", deployment.Key(), err)
+	}
+	return result, nil
+}
+
+// benchFile runs the statements of an SQL task in one transaction and times them
+func benchFile(db StatementBencher, task Task) (*FileBench, error) {
+	content, err := readPsqlFile(task.Path, func(string) {})
+	if err != nil {
+		return nil, err
+	}
+
+	file := &FileBench{Task: task.Name(), Path: task.Path}
+	var statements []string
+	for _, statement := range scanStatements(content) {
+		sql := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(statement.SQL, " "))
+		if sql == "" {
+			continue
+		}
+		statements = append(statements, statement.SQL)
+		file.Statements = append(file.Statements, StatementTiming{Line: statement.Line, Statement: firstWords(sql, 6)})
+	}
+	if len(statements) > 0 {
+		statements[0] = runCommentHeader(*task.Deployment, task.Path) + statements[0]
+	}
+
+	durations, locks, err := db.BenchStatements(statements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s: %w", task.Path, err)
+	}
+	for i, duration := range durations {
+		file.Statements[i].Duration = duration
+		file.Duration += duration
+	}
+	file.Locks = locks
+	return file, nil
+}
+
+// recordBenchRun records the duration of a benchmarked SQL file in the sample's task history, if
+// it keeps one
+func recordBenchRun(db DatabaseProvider, task Task, duration time.Duration) error {
+	history, ok := db.(TaskHistory)
+	if !ok {
+		return nil
+	}
+	checksum, err := FileChecksum(task.Path)
+	if err != nil {
+		return err
+	}
+	run := TaskRun{
+		Module:       task.Deployment.Module,
+		DeploymentID: task.Deployment.ID,
+		Task:         task.Name(),
+		FileChecksum: checksum,
+		Duration:     duration,
+		Runner:       runnerConnection,
+	}
+	if err := history.RecordTaskRun(run); err != nil {
+		return fmt.Errorf("failed to record task run: %w", err)
+	}
+	return nil
+}
+
+// String formats the timings for display, slowest statements and longest locks first
+func (r *BenchResult) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "Deployment %s: %s took %s\n", r.Deployment.Key(), r.Deployment.Name, formatEstimate(r.Duration))
+	var longest *LockHold
+	for _, file := range r.Files {
+		fmt.Fprintf(&s, "\n%s %s: %s\n", file.Task, filepath.Base(file.Path), formatEstimate(file.Duration))
+
+		statements := slices.Clone(file.Statements)
+		slices.SortStableFunc(statements, func(a, b StatementTiming) int { return int(b.Duration - a.Duration) })
+		for _, statement := range statements {
+			fmt.Fprintf(&s, "  line %-4d %10s  %s\n", statement.Line, formatEstimate(statement.Duration), statement.Statement)
+		}
+
+		locks := slices.Clone(file.Locks)
+		slices.SortStableFunc(locks, func(a, b LockHold) int { return int(b.Held - a.Held) })
+		for i, lock := range locks {
+			fmt.Fprintf(&s, "  lock %s %s held %s from line %d\n", lock.Mode, lock.Relation, formatEstimate(lock.Held), file.Statements[lock.Statement].Line)
+			if lock.Mode == "AccessExclusiveLock" && (longest == nil || lock.Held > longest.Held) {
+				longest = &locks[i]
+			}
+		}
+	}
+
+	if longest != nil {
+		fmt.Fprintf(&s, "\nLongest AccessExclusiveLock: %s on %s; reads and writes of it wait that long\n", formatEstimate(longest.Held), longest.Relation)
+	}
+	for _, task := range r.Skipped {
+		fmt.Fprintf(&s, "Not benchmarked: %s\n", task)
+	}
+	return s.String()
+}
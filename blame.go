@@ -0,0 +1,102 @@
+package zdd
+
+import (
+	"fmt"
+	"slices"
+)
+
+type (
+	// SchemaInspector is a DatabaseProvider that can describe individual schema objects
+	SchemaInspector interface {
+		DatabaseProvider
+		// DescribeObject returns a textual definition of the object, or "" if it does not exist.
+		// kind is one of "table", "column" or "index"; columns are named table.column.
+		DescribeObject(kind, name string) (string, error)
+	}
+
+	// BlameResult reports which deployments created and last modified a schema object
+	BlameResult struct {
+		Kind       string
+		Name       string
+		CreatedBy  *Deployment
+		ModifiedBy *Deployment // Last deployment that changed the definition, nil if never changed after creation
+		DroppedBy  *Deployment // Set if the object no longer exists after all deployments
+	}
+)
+
+// BlameKinds are the object kinds supported by Blame
+var BlameKinds = []string{"table", "column", "index"}
+
+// Blame replays all deployments of the module one at a time against a scratch database and
+// inspects the object after each one to attribute its creation and last modification.
+// The scratch database must be empty; it is left with every deployment applied.
+func Blame(module Module, db SchemaInspector, kind, name string) (*BlameResult, error) {
+	if !slices.Contains(BlameKinds, kind) {
+		return nil, fmt.Errorf("unsupported object kind %q (expected one of %v)", kind, BlameKinds)
+	}
+
+	deployments, err := LoadModuleDeployments(module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local deployments: %w", err)
+	}
+
+	result := &BlameResult{Kind: kind, Name: name}
+
+	previous, err := db.DescribeObject(kind, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %s %s: %w", kind, name, err)
+	}
+	if previous != "" {
+		return nil, fmt.Errorf("%s %s already exists before any deployment; is the scratch database empty?", kind, name)
+	}
+
+	for i := range deployments {
+		deployment := deployments[i]
+
+		plan := &Plan{
+			Tasks:           deployment.Tasks(),
+			AlreadyDeployed: make(map[string]bool),
+			db:              db,
+			Runners:         module.Layout.Runners,
+		}
+		if err := plan.Execute(); err != nil {
+			return nil, fmt.Errorf("failed to replay deployment %s: %w", deployment.ID, err)
+		}
+
+		current, err := db.DescribeObject(kind, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe %s %s: %w", kind, name, err)
+		}
+
+		switch {
+		case previous == "" && current != "":
+			result.CreatedBy = &deployment
+			result.ModifiedBy = nil
+			result.DroppedBy = nil
+		case previous != "" && current == "":
+			result.DroppedBy = &deployment
+		case previous != current:
+			result.ModifiedBy = &deployment
+		}
+
+		previous = current
+	}
+
+	return result, nil
+}
+
+// String formats the blame result for display
+func (r *BlameResult) String() string {
+	if r.CreatedBy == nil {
+		return fmt.Sprintf("%s %s is not created by any deployment", r.Kind, r.Name)
+	}
+
+	s := fmt.Sprintf("%s %s\n  created by:       %s - %s\n", r.Kind, r.Name, r.CreatedBy.ID, r.CreatedBy.Name)
+	if r.ModifiedBy != nil {
+		s += fmt.Sprintf("  last modified by: %s - %s\n", r.ModifiedBy.ID, r.ModifiedBy.Name)
+	}
+	if r.DroppedBy != nil {
+		s += fmt.Sprintf("  dropped by:       %s - %s\n", r.DroppedBy.ID, r.DroppedBy.Name)
+	}
+	return s
+}
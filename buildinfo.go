@@ -0,0 +1,94 @@
+package zdd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Version, Commit and BuildDate identify the running build. Release builds set them with
+// -ldflags "-X github.com/mantty/zdd.Version=... -X github.com/mantty/zdd.Commit=... -X github.com/mantty/zdd.BuildDate=..."
+var (
+	Version   = "0.1.0"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// BuildInfo describes the running zdd binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// CurrentBuildInfo returns the build info of the running binary. Commit and build date fall
+// back to the VCS stamp Go embeds when they were not set at link time.
+func CurrentBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+
+	return info
+}
+
+// String renders the build info on a single line for `zdd version`
+func (b BuildInfo) String() string {
+	s := fmt.Sprintf("zdd %s", b.Version)
+	if b.Commit != "" {
+		s += fmt.Sprintf(" (commit %s", b.Commit)
+		if b.BuildDate != "" {
+			s += fmt.Sprintf(", built %s", b.BuildDate)
+		}
+		s += ")"
+	}
+	return s + fmt.Sprintf(" %s %s", b.GoVersion, b.Platform)
+}
+
+// ApplicationName is the PostgreSQL application_name zdd connects with, so its sessions
+// are identifiable in pg_stat_activity and server logs
+func ApplicationName() string {
+	return "zdd/" + Version
+}
+
+// RunID returns the UUID identifying this zdd invocation, which correlates its log lines, the
+// environment of its scripts (ZDD_RUN_ID), the database records it writes and its reports
+var RunID = sync.OnceValue(func() string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate run ID: %v", err))
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40 // Version 4
+	uuid[8] = uuid[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+})
+
+// sqlCommentHeader tags SQL of a deployment so server logs show its origin. Rendered scripts and
+// packs are run later, so only SQL zdd executes itself names the run.
+func sqlCommentHeader(deployment Deployment, path string) string {
+	return fmt.Sprintf("/* %s deployment=%s file=%s */\n", ApplicationName(), deployment.Key(), filepath.Base(path))
+}
+
+// runCommentHeader tags SQL zdd executes for a deployment with its origin and the run executing it
+func runCommentHeader(deployment Deployment, path string) string {
+	return fmt.Sprintf("/* %s deployment=%s file=%s run=%s */\n", ApplicationName(), deployment.Key(), filepath.Base(path), RunID())
+}
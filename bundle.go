@@ -0,0 +1,249 @@
+package zdd
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const (
+	// bundleMagic ends every bundle, after the size of the archive
+	bundleMagic = "ZDDBNDL1"
+	// bundleTrailerSize is the size of the archive size and the magic
+	bundleTrailerSize = 8 + len(bundleMagic)
+	// bundleManifestName is the manifest's name in the archive
+	bundleManifestName = ".zdd-bundle.json"
+)
+
+type (
+	// BundleManifest describes the project a bundle was built from. Paths are relative to the
+	// project directory and use forward slashes.
+	BundleManifest struct {
+		Version         string `json:"version"`
+		DeploymentsPath string `json:"deployments_path"`
+		Config          string `json:"config,omitempty"` // Empty when the project has no config file
+	}
+
+	// Bundle is a project's config file and deployments appended to a zdd executable, which runs
+	// them when started
+	Bundle struct {
+		Manifest BundleManifest
+		archive  *zip.Reader
+		file     *os.File
+	}
+)
+
+// BuildBundle writes the executable followed by an archive of the files and directories in paths,
+// relative to root, and the manifest
+func BuildBundle(w io.Writer, executable, root string, paths []string, manifest BundleManifest) error {
+	exe, err := os.Open(executable)
+	if err != nil {
+		return fmt.Errorf("failed to open executable: %w", err)
+	}
+	defer exe.Close()
+
+	// An executable that is itself a bundle contributes only the zdd binary
+	exeSize, err := bundleOffset(exe)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(exe, 0, exeSize)); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, p := range paths {
+		if err := addToArchive(zw, root, p); err != nil {
+			return err
+		}
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	mw, err := zw.Create(bundleManifestName)
+	if err != nil {
+		return fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+	if _, err := mw.Write(content); err != nil {
+		return fmt.Errorf("failed to write bundle manifest: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle archive: %w", err)
+	}
+
+	trailer := binary.BigEndian.AppendUint64(nil, uint64(archive.Len()))
+	trailer = append(trailer, bundleMagic...)
+	if _, err := w.Write(append(archive.Bytes(), trailer...)); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// addToArchive adds the file or directory tree at root/rel to the archive, keeping file modes so
+// scripts stay executable
+func addToArchive(zw *zip.Writer, root, rel string) error {
+	return filepath.WalkDir(filepath.Join(root, rel), func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read file info: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("cannot bundle %s: only regular files are supported", p)
+		}
+
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", p, err)
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return fmt.Errorf("failed to bundle %s: %w", p, err)
+		}
+		header.Name = filepath.ToSlash(name)
+		header.Method = zip.Deflate
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("failed to bundle %s: %w", p, err)
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		_, err = fw.Write(content)
+		return err
+	})
+}
+
+// OpenBundle opens the bundle appended to the executable at path. It returns nil if the
+// executable is not a bundle.
+func OpenBundle(executable string) (*Bundle, error) {
+	file, err := os.Open(executable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open executable: %w", err)
+	}
+
+	offset, err := bundleOffset(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read executable: %w", err)
+	}
+	size := info.Size() - int64(bundleTrailerSize) - offset
+	if size <= 0 {
+		file.Close()
+		return nil, nil
+	}
+
+	archive, err := zip.NewReader(io.NewSectionReader(file, offset, size), size)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("bundle archive is corrupt: %w", err)
+	}
+	bundle := &Bundle{archive: archive, file: file}
+
+	content, err := fs.ReadFile(archive, bundleManifestName)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("bundle has no manifest: %w", err)
+	}
+	if err := json.Unmarshal(content, &bundle.Manifest); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to parse bundle manifest: %w", err)
+	}
+
+	return bundle, nil
+}
+
+// bundleOffset returns where the bundle archive starts in the executable, which is its size if
+// it has no bundle
+func bundleOffset(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read executable: %w", err)
+	}
+	if info.Size() < int64(bundleTrailerSize) {
+		return info.Size(), nil
+	}
+
+	trailer := make([]byte, bundleTrailerSize)
+	if _, err := file.ReadAt(trailer, info.Size()-int64(bundleTrailerSize)); err != nil {
+		return 0, fmt.Errorf("failed to read executable: %w", err)
+	}
+	if string(trailer[8:]) != bundleMagic {
+		return info.Size(), nil
+	}
+
+	size := binary.BigEndian.Uint64(trailer[:8])
+	if size > uint64(info.Size()-int64(bundleTrailerSize)) {
+		return 0, fmt.Errorf("bundle trailer is corrupt")
+	}
+	return info.Size() - int64(bundleTrailerSize) - int64(size), nil
+}
+
+// Extract writes the bundled files into dir, which becomes the project directory
+func (b *Bundle) Extract(dir string) error {
+	for _, f := range b.archive.File {
+		if f.Name == bundleManifestName {
+			continue
+		}
+		name := path.Clean(f.Name)
+		if !fs.ValidPath(name) {
+			return fmt.Errorf("bundle contains an invalid path %q", f.Name)
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := extractFile(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes a bundled file to target with its mode
+func extractFile(f *zip.File, target string) error {
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read %s from bundle: %w", f.Name, err)
+	}
+	defer r.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", target, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+	}
+	return out.Close()
+}
+
+// Close closes the executable the bundle was read from
+func (b *Bundle) Close() error {
+	return b.file.Close()
+}
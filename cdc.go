@@ -0,0 +1,203 @@
+package zdd
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	cdcCheckAuto   = "auto"
+	cdcCheckAlways = "always"
+	cdcCheckNever  = "never"
+)
+
+// cdcConnectors are the CDC connectors with checks of their own
+var cdcConnectors = []string{"debezium"}
+
+type (
+	// CDCConfig controls the checks for DDL that breaks logical replication or CDC consumers
+	CDCConfig struct {
+		// Check is "auto" (the default: only when the database has logical replication slots or
+		// publications), "always" or "never"
+		Check string `yaml:"check"`
+		// Fail stops the deploy, before anything is applied, when a check warns
+		Fail bool `yaml:"fail"`
+		// Connectors enables the checks of the connectors consuming the database, e.g. [debezium]
+		Connectors []string `yaml:"connectors"`
+		// Ignore lists checks to skip by name, e.g. [drop-table]
+		Ignore []string `yaml:"ignore"`
+	}
+
+	// CDCInspector is implemented by databases that can report their logical replication consumers
+	CDCInspector interface {
+		// LogicalReplication returns the names of logical replication slots and publications
+		LogicalReplication() (slots, publications []string, err error)
+	}
+
+	// CDCWarning is a statement in a pending SQL file that may break CDC consumers
+	CDCWarning struct {
+		Path    string
+		Line    int
+		Check   string // Name of the check, usable in cdc.ignore
+		Message string
+	}
+
+	// cdcRule matches a kind of statement; the first non-empty group of pattern is the table
+	cdcRule struct {
+		name    string
+		pattern *regexp.Regexp
+		// connector limits the rule to consumers using it; empty applies to every consumer
+		connector string
+		message   string
+	}
+)
+
+// cdcTable matches a possibly schema-qualified table name, stopping at punctuation
+const cdcTable = `([^\s;(,]+)`
+
+var cdcRules = []cdcRule{
+	{
+		name:    "drop-primary-key",
+		pattern: regexp.MustCompile(`(?is)\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*\bDROP\s+CONSTRAINT\s+(?:IF\s+EXISTS\s+)?"?\w*_pkey\b`),
+		message: "drops the primary key of %s; without a replica identity, UPDATE and DELETE fail while it is published (set REPLICA IDENTITY FULL or USING INDEX first)",
+	},
+	{
+		name:    "replica-identity-nothing",
+		pattern: regexp.MustCompile(`(?is)\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*\bREPLICA\s+IDENTITY\s+NOTHING\b`),
+		message: "sets REPLICA IDENTITY NOTHING on %s; UPDATE and DELETE fail while it is published",
+	},
+	{
+		name:    "unlogged-table",
+		pattern: regexp.MustCompile(`(?is)\bCREATE\s+UNLOGGED\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?` + cdcTable + `|\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*\bSET\s+UNLOGGED\b`),
+		message: "makes %s unlogged; its changes are not written to the WAL, so consumers never receive them",
+	},
+	{
+		name:    "rename",
+		pattern: regexp.MustCompile(`(?is)\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*\bRENAME\b`),
+		message: "renames %s or one of its columns; consumers see the new name as a different table or column",
+	},
+	{
+		name:    "alter-column-type",
+		pattern: regexp.MustCompile(`(?is)\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*\bALTER\s+(?:COLUMN\s+)?\S+\s+(?:SET\s+DATA\s+)?TYPE\b`),
+		message: "changes a column type of %s; consumers with a registered schema may reject the new type",
+	},
+	{
+		name:    "drop-table",
+		pattern: regexp.MustCompile(`(?is)\bDROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?` + cdcTable),
+		message: "drops %s; connectors configured to capture it may fail",
+	},
+	{
+		name:      "truncate",
+		pattern:   regexp.MustCompile(`(?is)\bTRUNCATE\s+(?:TABLE\s+)?(?:ONLY\s+)?` + cdcTable),
+		connector: "debezium",
+		message:   "truncates %s; Debezium skips TRUNCATE events unless skipped.operations allows them, leaving consumers with the old rows",
+	},
+}
+
+// sqlCommentPattern matches SQL line and block comments
+var sqlCommentPattern = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+
+// String formats the warning as path:line: message (check)
+func (w CDCWarning) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", w.Path, w.Line, w.Message, w.Check)
+}
+
+// CheckCDC scans the SQL files of the plan for statements that break logical replication or
+// CDC consumers. Every warning is returned; with config.Fail, any warning is also an error.
+func (p *Plan) CheckCDC(config CDCConfig) ([]CDCWarning, error) {
+	if err := config.check(); err != nil {
+		return nil, err
+	}
+
+	switch config.Check {
+	case cdcCheckNever:
+		return nil, nil
+	case "", cdcCheckAuto:
+		inspector, ok := p.db.(CDCInspector)
+		if !ok {
+			return nil, nil
+		}
+		slots, publications, err := inspector.LogicalReplication()
+		if err != nil {
+			return nil, err
+		}
+		if len(slots) == 0 && len(publications) == 0 {
+			return nil, nil
+		}
+	}
+
+	var warnings []CDCWarning
+	for _, task := range p.Tasks {
+		if task.TaskType != "sql" {
+			continue
+		}
+		content, err := readPsqlFile(task.Path, func(string) {})
+		if err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, config.scan(task.Path, content)...)
+	}
+
+	if config.Fail && len(warnings) > 0 {
+		return warnings, fmt.Errorf("%d statements may break CDC consumers; fix them or list their checks in cdc.ignore", len(warnings))
+	}
+	return warnings, nil
+}
+
+// check validates the configured mode, connectors and ignored checks
+func (c CDCConfig) check() error {
+	if c.Check != "" && !slices.Contains([]string{cdcCheckAuto, cdcCheckAlways, cdcCheckNever}, c.Check) {
+		return fmt.Errorf("invalid cdc.check %q (expected auto, always or never)", c.Check)
+	}
+	for _, connector := range c.Connectors {
+		if !slices.Contains(cdcConnectors, connector) {
+			return fmt.Errorf("unknown CDC connector %q (expected one of %v)", connector, cdcConnectors)
+		}
+	}
+	for _, name := range c.Ignore {
+		if !slices.ContainsFunc(cdcRules, func(rule cdcRule) bool { return rule.name == name }) {
+			return fmt.Errorf("unknown CDC check %q in cdc.ignore", name)
+		}
+	}
+	return nil
+}
+
+// scan returns the warnings for the SQL in content, read from path
+func (c CDCConfig) scan(path, content string) []CDCWarning {
+	// Blank out comments, keeping newlines so matches report their original line
+	content = sqlCommentPattern.ReplaceAllStringFunc(content, func(comment string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '\n' {
+				return r
+			}
+			return ' '
+		}, comment)
+	})
+
+	var warnings []CDCWarning
+	for _, rule := range cdcRules {
+		if slices.Contains(c.Ignore, rule.name) || (rule.connector != "" && !slices.Contains(c.Connectors, rule.connector)) {
+			continue
+		}
+		for _, match := range rule.pattern.FindAllStringSubmatchIndex(content, -1) {
+			var table string
+			for i := 2; i < len(match); i += 2 {
+				if match[i] >= 0 {
+					table = content[match[i]:match[i+1]]
+					break
+				}
+			}
+			warnings = append(warnings, CDCWarning{
+				Path:    path,
+				Line:    strings.Count(content[:match[0]], "\n") + 1,
+				Check:   rule.name,
+				Message: fmt.Sprintf(rule.message, table),
+			})
+		}
+	}
+
+	slices.SortStableFunc(warnings, func(a, b CDCWarning) int { return a.Line - b.Line })
+	return warnings
+}
@@ -0,0 +1,156 @@
+package zdd
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// changelogDateLayouts are the date formats `zdd changelog --since` accepts
+var changelogDateLayouts = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"}
+
+type (
+	// ChangelogEntry is an applied deployment listed in a changelog
+	ChangelogEntry struct {
+		Module    string
+		ID        string
+		Name      string
+		AppliedAt time.Time
+		// Ticket is the deployment's ticket, if it has one
+		Ticket *Ticket
+		// Tables are the tables the deployment's SQL creates, alters, indexes or writes rows of;
+		// empty if the deployment is no longer found locally
+		Tables []string
+		RunID  string
+	}
+
+	// Changelog lists the deployments applied since a deployment or a date, in the order they
+	// were applied, for release notes
+	Changelog struct {
+		Since   string
+		Entries []ChangelogEntry
+	}
+)
+
+// BuildChangelog lists the deployments of the modules applied after since, which is the ID
+// (or module/ID key) of an applied deployment or a date such as 2026-10-01 or an RFC 3339 time
+func BuildChangelog(modules []Module, db DatabaseProvider, since string) (*Changelog, error) {
+	statuses, err := moduleStatuses(modules, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Deployment
+	for _, status := range statuses {
+		applied = append(applied, status.Applied...)
+		applied = append(applied, status.Missing...)
+	}
+	slices.SortStableFunc(applied, func(a, b Deployment) int { return a.AppliedAt.Compare(*b.AppliedAt) })
+
+	start, err := changelogStart(applied, since)
+	if err != nil {
+		return nil, err
+	}
+
+	changelog := &Changelog{Since: since, Entries: make([]ChangelogEntry, 0)}
+	for _, deployment := range applied[start:] {
+		entry := ChangelogEntry{
+			Module:    deployment.Module,
+			ID:        deployment.ID,
+			Name:      deployment.Name,
+			AppliedAt: *deployment.AppliedAt,
+			Ticket:    deployment.Ticket,
+			RunID:     deployment.RunID,
+		}
+		if deployment.Directory != "" {
+			if entry.Tables, err = touchedTables(&deployment); err != nil {
+				return nil, err
+			}
+		}
+		changelog.Entries = append(changelog.Entries, entry)
+	}
+	return changelog, nil
+}
+
+// changelogStart returns the index of the first deployment of applied, sorted by when they were
+// applied, that was applied after since
+func changelogStart(applied []Deployment, since string) (int, error) {
+	for _, layout := range changelogDateLayouts {
+		date, err := time.ParseInLocation(layout, since, time.Local)
+		if err != nil {
+			continue
+		}
+		start := slices.IndexFunc(applied, func(d Deployment) bool { return !d.AppliedAt.Before(date) })
+		if start < 0 {
+			return len(applied), nil
+		}
+		return start, nil
+	}
+
+	var matches []int
+	for i, deployment := range applied {
+		if deployment.ID == since || deployment.Key() == since {
+			matches = append(matches, i)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("%q is neither an applied deployment nor a date (e.g. 2026-10-01)", since)
+	case 1:
+		return matches[0] + 1, nil
+	default:
+		return 0, fmt.Errorf("deployment %s is applied in several modules; use module/ID", since)
+	}
+}
+
+// LinkTickets links the tickets of entries that have no URL, such as those of deployments no
+// longer found locally, to the issue tracker at baseURL, e.g. https://example.atlassian.net
+func (c *Changelog) LinkTickets(baseURL string) {
+	if baseURL == "" {
+		return
+	}
+	for _, entry := range c.Entries {
+		if entry.Ticket != nil && entry.Ticket.URL == "" {
+			entry.Ticket.URL = strings.TrimSuffix(baseURL, "/") + "/browse/" + entry.Ticket.Key
+		}
+	}
+}
+
+// WriteMarkdown renders the changelog as markdown for release notes: a heading, then an item
+// per deployment with its ticket and the tables it touched
+func (c *Changelog) WriteMarkdown(w io.Writer) error {
+	var s strings.Builder
+	fmt.Fprintf(&s, "## Database changes since %s\n\n", c.Since)
+	if len(c.Entries) == 0 {
+		s.WriteString("No deployments were applied.\n")
+	}
+	for _, entry := range c.Entries {
+		fmt.Fprintf(&s, "- **%s** %s", deploymentKey(entry.Module, entry.ID), strings.ReplaceAll(entry.Name, "_", " "))
+		if ticket := entry.Ticket; ticket != nil {
+			link := ticket.Key
+			if ticket.URL != "" {
+				link = fmt.Sprintf("[%s](%s)", ticket.Key, ticket.URL)
+			}
+			if ticket.Summary != "" {
+				fmt.Fprintf(&s, " (%s: %s)", link, ticket.Summary)
+			} else {
+				fmt.Fprintf(&s, " (%s)", link)
+			}
+		}
+		fmt.Fprintf(&s, ", applied %s\n", entry.AppliedAt.Local().Format("2006-01-02 15:04"))
+		if len(entry.Tables) > 0 {
+			tables := make([]string, len(entry.Tables))
+			for i, table := range entry.Tables {
+				tables[i] = "`" + table + "`"
+			}
+			fmt.Fprintf(&s, "  - Tables: %s\n", strings.Join(tables, ", "))
+		}
+	}
+
+	if _, err := io.WriteString(w, s.String()); err != nil {
+		return fmt.Errorf("failed to write changelog: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,438 @@
+package zdd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Faults RunChaos injects into deploys
+const (
+	// FaultConnectionDrop terminates the database session of a deploy while an SQL task's
+	// transaction is open
+	FaultConnectionDrop = "connection-drop"
+	// FaultKill kills a deploy with SIGKILL between tasks, so it cleans nothing up
+	FaultKill = "kill"
+	// FaultConcurrent starts a second deploy at the same time as the first
+	FaultConcurrent = "concurrent"
+)
+
+// chaosEnv names the environment variable that makes a plan inject a fault for RunChaos, in
+// builds with the zddchaos tag: "kill-after:N" kills the process once N tasks have completed
+// and "drop-in-sql:N" terminates the session during the Nth SQL task
+const chaosEnv = "ZDD_CHAOS"
+
+// ChaosFaults are the faults RunChaos injects by default
+var ChaosFaults = []string{FaultConnectionDrop, FaultKill, FaultConcurrent}
+
+type (
+	// ChaosOptions configures RunChaos. Every scenario deploys to a scratch database in separate
+	// processes, as zdd deploy does in production.
+	ChaosOptions struct {
+		Modules []Module
+		// Faults are the faults to inject; empty injects all of ChaosFaults
+		Faults []string
+		// Reset empties the scratch database before each scenario
+		Reset func() error
+		// Open connects to the scratch database, to check invariants between deploys
+		Open func() (DatabaseProvider, error)
+		// Deploy returns a command running zdd deploy against the scratch database, with
+		// --takeover if takeover is set. RunChaos adds to its environment and sets its output.
+		Deploy func(takeover bool) *exec.Cmd
+		// Output receives progress messages; nil writes to stdout
+		Output io.Writer
+	}
+
+	// ChaosResult is the outcome of one scenario of RunChaos
+	ChaosResult struct {
+		Scenario string // e.g. "kill after task 2"
+		// Skipped is set when the deploy finished before the fault could be injected
+		Skipped bool
+		// Err is the first invariant the scenario violated, nil if all held
+		Err error
+		// Output is the combined output of the scenario's deploys
+		Output string
+	}
+
+	// chaosScenario is a fault injected at a point of the deploy
+	chaosScenario struct {
+		fault string
+		point int // Tasks completed before a kill, or the SQL task a connection drops in
+	}
+
+	// chaosRun is the outcome of a deploy process
+	chaosRun struct {
+		err    error
+		output string
+	}
+
+	// chaosFault is the fault chaosEnv asks this process to inject
+	chaosFault struct {
+		kind  string // "kill-after" or "drop-in-sql"; empty injects nothing
+		point int
+	}
+)
+
+// RunChaos deploys the modules to a scratch database while injecting faults, and checks after
+// each that the failure model holds: a deploy that fails records no deployment as applied, a
+// deployment left running by a killed deploy is only resumed with --takeover, concurrent
+// deploys never both apply a deployment, and a final deploy applies everything exactly once
+// with no attempts left over. Faults are injected after the first, a middle and the last task.
+func RunChaos(opts ChaosOptions) ([]ChaosResult, error) {
+	if !chaosSupported {
+		return nil, fmt.Errorf("zdd was built without fault injection; build it with -tags zddchaos")
+	}
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	faults := opts.Faults
+	if len(faults) == 0 {
+		faults = ChaosFaults
+	}
+	for _, fault := range faults {
+		if !slices.Contains(ChaosFaults, fault) {
+			return nil, fmt.Errorf("unknown fault %q (expected one of %s)", fault, strings.Join(ChaosFaults, ", "))
+		}
+	}
+
+	tasks, sqlTasks, err := chaosTaskCounts(opts)
+	if err != nil {
+		return nil, err
+	}
+	if tasks == 0 {
+		return nil, fmt.Errorf("no deployments to deploy")
+	}
+
+	var scenarios []chaosScenario
+	for _, fault := range faults {
+		switch fault {
+		case FaultKill:
+			// Killing after the last task would interrupt recording, not the tasks
+			for _, point := range chaosPoints(tasks - 1) {
+				scenarios = append(scenarios, chaosScenario{fault, point})
+			}
+		case FaultConnectionDrop:
+			for _, point := range chaosPoints(sqlTasks) {
+				scenarios = append(scenarios, chaosScenario{fault, point})
+			}
+		case FaultConcurrent:
+			scenarios = append(scenarios, chaosScenario{fault: fault})
+		}
+	}
+
+	var results []ChaosResult
+	for _, scenario := range scenarios {
+		fmt.Fprintf(out, "Scenario: %s\n", scenario)
+		if err := opts.reset(); err != nil {
+			return results, err
+		}
+
+		result := opts.runScenario(scenario)
+		switch {
+		case result.Skipped:
+			fmt.Fprintln(out, "  skipped: the deploy finished before the fault was injected")
+		case result.Err != nil:
+			fmt.Fprintf(out, "  FAILED: %v\n", result.Err)
+		default:
+			fmt.Fprintln(out, "  ok")
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// String describes the scenario, e.g. "kill after task 2"
+func (s chaosScenario) String() string {
+	switch s.fault {
+	case FaultKill:
+		return fmt.Sprintf("kill after task %d", s.point)
+	case FaultConnectionDrop:
+		return fmt.Sprintf("connection drop in SQL task %d", s.point)
+	}
+	return "concurrent deploys"
+}
+
+// env is the value of chaosEnv injecting the scenario's fault
+func (s chaosScenario) env() string {
+	switch s.fault {
+	case FaultKill:
+		return fmt.Sprintf("%s=kill-after:%d", chaosEnv, s.point)
+	case FaultConnectionDrop:
+		return fmt.Sprintf("%s=drop-in-sql:%d", chaosEnv, s.point)
+	}
+	return ""
+}
+
+// chaosPoints returns the first, a middle and the last of n points, without repeats
+func chaosPoints(n int) []int {
+	if n < 1 {
+		return nil
+	}
+	return slices.Compact([]int{1, (n + 1) / 2, n})
+}
+
+// chaosTaskCounts plans the modules against the empty scratch database and counts the tasks
+// and SQL tasks a deploy runs
+func chaosTaskCounts(opts ChaosOptions) (int, int, error) {
+	if err := opts.reset(); err != nil {
+		return 0, 0, err
+	}
+	db, err := opts.Open()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+
+	plan, err := BuildModulesPlan(opts.Modules, db)
+	if err != nil {
+		return 0, 0, err
+	}
+	sqlTasks := 0
+	for _, task := range plan.Tasks {
+		if task.TaskType == "sql" {
+			sqlTasks++
+		}
+	}
+	return len(plan.Tasks), sqlTasks, nil
+}
+
+// reset empties the scratch database and creates the deployment schema, as a database that
+// has been deployed to before has it, so concurrent deploys do not race to create it
+func (opts ChaosOptions) reset() error {
+	if err := opts.Reset(); err != nil {
+		return fmt.Errorf("failed to reset scratch database: %w", err)
+	}
+	db, err := opts.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.InitDeploymentSchema(); err != nil {
+		return fmt.Errorf("failed to initialize deployment schema: %w", err)
+	}
+	return nil
+}
+
+// deploy runs a deploy process with the environment variable env, if any
+func (opts ChaosOptions) deploy(takeover bool, env string) chaosRun {
+	cmd := opts.Deploy(takeover)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = slices.DeleteFunc(cmd.Env, func(v string) bool { return strings.HasPrefix(v, chaosEnv+"=") })
+	if env != "" {
+		cmd.Env = append(cmd.Env, env)
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	return chaosRun{err: err, output: output.String()}
+}
+
+// runScenario injects the scenario's fault, recovers, and checks the invariants
+func (opts ChaosOptions) runScenario(scenario chaosScenario) (result ChaosResult) {
+	result = ChaosResult{Scenario: scenario.String()}
+	var runs []chaosRun
+	defer func() {
+		var outputs []string
+		for _, run := range runs {
+			outputs = append(outputs, run.output)
+		}
+		result.Output = strings.Join(outputs, "\n")
+	}()
+
+	if scenario.fault == FaultConcurrent {
+		concurrent := make([]chaosRun, 2)
+		var wg sync.WaitGroup
+		for i := range concurrent {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				concurrent[i] = opts.deploy(false, "")
+			}()
+		}
+		wg.Wait()
+		runs = append(runs, concurrent...)
+
+		if concurrent[0].err != nil && concurrent[1].err != nil {
+			result.Err = fmt.Errorf("both concurrent deploys failed: %v; %v", concurrent[0].err, concurrent[1].err)
+			return result
+		}
+		for _, run := range concurrent {
+			if run.err != nil && !strings.Contains(run.output, "another zdd process") {
+				result.Err = fmt.Errorf("a concurrent deploy failed for another reason than the other deploy: %v", run.err)
+				return result
+			}
+		}
+	} else {
+		faulted := opts.deploy(false, scenario.env())
+		runs = append(runs, faulted)
+		if !strings.Contains(faulted.output, "chaos: ") {
+			result.Skipped = true
+			return result
+		}
+		if faulted.err == nil {
+			result.Err = fmt.Errorf("the deploy succeeded despite the fault")
+			return result
+		}
+
+		// Started deployments are left running by a killed deploy, or failed
+		wantStatus := "failed"
+		if scenario.fault == FaultKill {
+			wantStatus = "running"
+		}
+		if err := opts.checkInterrupted(wantStatus); err != nil {
+			result.Err = err
+			return result
+		}
+
+		if scenario.fault == FaultKill {
+			// The killed deploy's heartbeat is fresh, so its deployment must not be taken over
+			rerun := opts.deploy(false, "")
+			runs = append(runs, rerun)
+			if rerun.err == nil {
+				result.Err = fmt.Errorf("a deploy resumed the deployment a killed deploy left running without --takeover")
+				return result
+			}
+			if err := opts.checkInterrupted(wantStatus); err != nil {
+				result.Err = fmt.Errorf("after the refused rerun: %w", err)
+				return result
+			}
+		}
+	}
+
+	recovery := opts.deploy(scenario.fault == FaultKill, "")
+	runs = append(runs, recovery)
+	if recovery.err != nil {
+		result.Err = fmt.Errorf("the recovery deploy failed: %v", recovery.err)
+		return result
+	}
+	result.Err = opts.checkComplete(runs)
+	return result
+}
+
+// checkInterrupted checks the state a failed deploy leaves: no deployment recorded as applied,
+// since deployments are recorded once all tasks have run, and the deployments it started left
+// with the status
+func (opts ChaosOptions) checkInterrupted(status string) error {
+	db, err := opts.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := db.GetAppliedDeployments()
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		return fmt.Errorf("the failed deploy recorded deployment %s as applied", applied[0].Key())
+	}
+
+	lister, ok := db.(AttemptLister)
+	if !ok {
+		return nil
+	}
+	attempts, err := lister.GetDeploymentAttempts()
+	if err != nil {
+		return err
+	}
+	if len(attempts) == 0 {
+		return fmt.Errorf("the failed deploy left no %s deployment", status)
+	}
+	for _, attempt := range attempts {
+		if attempt.Status != status {
+			return fmt.Errorf("the failed deploy left deployment %s %s instead of %s", attempt.Key(), attempt.Status, status)
+		}
+	}
+	return nil
+}
+
+// checkComplete checks that every deployment is applied with its current checksum, that no
+// attempts are left, and that no two runs applied the same deployment
+func (opts ChaosOptions) checkComplete(runs []chaosRun) error {
+	db, err := opts.Open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := db.GetAppliedDeployments()
+	if err != nil {
+		return err
+	}
+	checksums := make(map[string]string)
+	for _, record := range applied {
+		checksums[record.Key()] = record.Checksum
+	}
+
+	var errs []error
+	for _, module := range opts.Modules {
+		deployments, err := LoadModuleDeployments(module)
+		if err != nil {
+			return err
+		}
+		for _, deployment := range deployments {
+			checksum, ok := checksums[deployment.Key()]
+			switch {
+			case !ok:
+				errs = append(errs, fmt.Errorf("deployment %s was not applied", deployment.Key()))
+			case checksum != CalculateChecksum(deployment):
+				errs = append(errs, fmt.Errorf("deployment %s was recorded with checksum %s, not %s", deployment.Key(), checksum, CalculateChecksum(deployment)))
+			}
+
+			appliedBy := 0
+			for _, run := range runs {
+				if strings.Contains(run.output, fmt.Sprintf("Deployment %s applied successfully", deployment.Key())) {
+					appliedBy++
+				}
+			}
+			if appliedBy > 1 {
+				errs = append(errs, fmt.Errorf("deployment %s was applied by %d deploys", deployment.Key(), appliedBy))
+			}
+		}
+	}
+
+	if lister, ok := db.(AttemptLister); ok {
+		attempts, err := lister.GetDeploymentAttempts()
+		if err != nil {
+			return err
+		}
+		for _, attempt := range attempts {
+			errs = append(errs, fmt.Errorf("deployment %s was left %s", attempt.Key(), attempt.Status))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// dropConnection appends a statement terminating the session to the SQL of the nth SQL task,
+// if it is the one the fault drops the connection in
+func (f chaosFault) dropConnection(sql string, n int, out io.Writer) string {
+	if f.kind != "drop-in-sql" || f.point != n {
+		return sql
+	}
+	fmt.Fprintf(out, "chaos: dropping the connection in SQL task %d\n", n)
+	return sql + "\n;SELECT pg_terminate_backend(pg_backend_pid());\n"
+}
+
+// kill kills this process without cleaning up once completed tasks have run, if the fault
+// kills after them
+func (f chaosFault) kill(completed int, out io.Writer) {
+	if f.kind != "kill-after" || f.point != completed {
+		return
+	}
+	fmt.Fprintf(out, "chaos: killing the deploy after task %d\n", completed)
+	if process, err := os.FindProcess(os.Getpid()); err == nil {
+		process.Kill() // SIGKILL on Unix
+	}
+	os.Exit(137) // Never continue, even if the kill is delayed or failed
+}
@@ -0,0 +1,22 @@
+//go:build zddchaos
+
+package zdd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// chaosSupported is set in builds that can inject the faults of RunChaos
+const chaosSupported = true
+
+// chaosFromEnv returns the fault chaosEnv asks for; an unset or invalid value injects nothing
+func chaosFromEnv() chaosFault {
+	kind, point, ok := strings.Cut(os.Getenv(chaosEnv), ":")
+	n, err := strconv.Atoi(point)
+	if !ok || err != nil || (kind != "kill-after" && kind != "drop-in-sql") {
+		return chaosFault{}
+	}
+	return chaosFault{kind: kind, point: n}
+}
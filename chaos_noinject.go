@@ -0,0 +1,11 @@
+//go:build !zddchaos
+
+package zdd
+
+// chaosSupported is set in builds that can inject the faults of RunChaos
+const chaosSupported = false
+
+// chaosFromEnv injects nothing; only builds with the zddchaos tag read chaosEnv
+func chaosFromEnv() chaosFault {
+	return chaosFault{}
+}
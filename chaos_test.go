@@ -0,0 +1,74 @@
+//go:build zddchaos
+
+package zdd_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mantty/zdd"
+	"github.com/mantty/zdd/postgres"
+)
+
+func TestRunChaos_FailureModelHolds(t *testing.T) {
+	ctx := context.Background()
+	scratchURL := strings.Replace(sharedDBURL, "/test?", "/zdd_chaos?", 1)
+
+	deploymentsDir := createTestDeploymentDir(t)
+	for i, table := range []string{"widgets", "gadgets"} {
+		deploymentDir := filepath.Join(deploymentsDir, fmt.Sprintf("%06d_create_%s", i+1, table))
+		if err := os.MkdirAll(deploymentDir, 0755); err != nil {
+			t.Fatalf("Failed to create deployment: %v", err)
+		}
+		files := map[string]string{
+			"expand.sql":   fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INT PRIMARY KEY, name TEXT);", table),
+			"migrate.sql":  fmt.Sprintf("INSERT INTO %s VALUES (1, 'one') ON CONFLICT DO NOTHING;", table),
+			"contract.sql": fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS name;", table),
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(deploymentDir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write %s: %v", name, err)
+			}
+		}
+	}
+
+	var out strings.Builder
+	results, err := zdd.RunChaos(zdd.ChaosOptions{
+		Modules: []zdd.Module{{Path: deploymentsDir}},
+		Reset:   func() error { return postgres.ResetDatabase(ctx, scratchURL, nil) },
+		Open: func() (zdd.DatabaseProvider, error) {
+			return postgres.NewDB(ctx, scratchURL)
+		},
+		Deploy: func(takeover bool) *exec.Cmd {
+			cmd := exec.Command(os.Args[0])
+			cmd.Env = append(os.Environ(), "ZDD_TEST_DEPLOY_URL="+scratchURL, "ZDD_TEST_DEPLOYMENTS_PATH="+deploymentsDir)
+			if takeover {
+				cmd.Env = append(cmd.Env, "ZDD_TEST_TAKEOVER=1")
+			}
+			return cmd
+		},
+		Output: &out,
+	})
+	if err != nil {
+		t.Fatalf("Failed to run chaos scenarios: %v", err)
+	}
+
+	injected := make(map[string]bool)
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("Scenario %s violated the failure model: %v\n%s", result.Scenario, result.Err, result.Output)
+		}
+		if !result.Skipped {
+			injected[strings.Fields(result.Scenario)[0]] = true
+		}
+	}
+	// Six tasks: kills after tasks 1, 3 and 5, drops in SQL tasks 1, 3 and 6, and a concurrent run
+	if len(results) != 7 || !injected["kill"] || !injected["connection"] || !injected["concurrent"] {
+		t.Errorf("Expected every fault to be injected, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,189 @@
+package zdd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type (
+	// ClassifiedStatement is a statement of an SQL file and the phase it most likely belongs in
+	ClassifiedStatement struct {
+		SQL    string
+		Line   int
+		Phase  string // expand, migrate or contract; empty when the statement is not recognized
+		Reason string
+	}
+
+	// sqlLine is a statement of an SQL file and the line it starts on
+	sqlLine struct {
+		SQL  string
+		Line int
+	}
+
+	// classifyRule assigns statements matching pattern to a phase
+	classifyRule struct {
+		pattern *regexp.Regexp
+		phase   string
+		reason  string
+	}
+)
+
+// classifyRules are tried in order; the first match classifies a statement
+var classifyRules = []classifyRule{
+	{regexp.MustCompile(`(?is)^ALTER\s+TABLE\b.*\bNOT\s+VALID\b`), "expand", "adds a constraint that only checks new rows"},
+	{regexp.MustCompile(`(?is)^DROP\b`), "contract", "drops an object"},
+	{regexp.MustCompile(`(?is)^TRUNCATE\b`), "contract", "deletes every row"},
+	{regexp.MustCompile(`(?is)^REVOKE\b`), "contract", "removes privileges"},
+	{regexp.MustCompile(`(?is)^ALTER\s+TABLE\b.*\bDROP\s+(?:COLUMN|CONSTRAINT)\b`), "contract", "drops a column or constraint"},
+	{regexp.MustCompile(`(?is)^ALTER\b.*\bRENAME\b`), "contract", "renames an object the running application may use"},
+	{regexp.MustCompile(`(?is)^ALTER\s+TABLE\b.*\bSET\s+NOT\s+NULL\b`), "contract", "makes a column required"},
+	{regexp.MustCompile(`(?is)^ALTER\s+TABLE\b.*\bALTER\s+(?:COLUMN\s+)?\S+\s+(?:SET\s+DATA\s+)?TYPE\b`), "contract", "changes a column type"},
+	{regexp.MustCompile(`(?is)^ALTER\s+TABLE\b.*\bVALIDATE\s+CONSTRAINT\b`), "contract", "enforces a constraint on existing rows"},
+	{regexp.MustCompile(`(?is)^ALTER\s+TABLE\b.*\bADD\s+(?:CONSTRAINT\s+\S+\s+)?(?:PRIMARY\s+KEY|UNIQUE|CHECK|FOREIGN\s+KEY|EXCLUDE)\b`), "contract", "adds a constraint that existing rows must satisfy"},
+	{regexp.MustCompile(`(?is)^(?:INSERT|UPDATE|DELETE|MERGE|COPY|CALL|REFRESH|SELECT)\b`), "migrate", "changes or reads data"},
+	{regexp.MustCompile(`(?is)^ALTER\s+TABLE\b.*\bADD\b`), "expand", "adds a column"},
+	{regexp.MustCompile(`(?is)^ALTER\s+TABLE\b.*\bALTER\s+(?:COLUMN\s+)?\S+\s+(?:SET\s+DEFAULT|DROP\s+NOT\s+NULL)\b`), "expand", "relaxes a column"},
+	{regexp.MustCompile(`(?is)^(?:CREATE|COMMENT|GRANT)\b`), "expand", "creates an object"},
+}
+
+// ClassifySQL splits content into statements and suggests the phase of each: additive changes
+// belong in expand, data changes in migrate, and destructive changes in contract. The rules
+// are heuristics for review, not a guarantee.
+func ClassifySQL(content string) []ClassifiedStatement {
+	var statements []ClassifiedStatement
+	for _, statement := range scanStatements(content) {
+		classified := ClassifiedStatement{SQL: statement.SQL, Line: statement.Line, Reason: "not recognized; review it"}
+		keywords := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(statement.SQL, " "))
+		for _, rule := range classifyRules {
+			if rule.pattern.MatchString(keywords) {
+				classified.Phase, classified.Reason = rule.phase, rule.reason
+				break
+			}
+		}
+		statements = append(statements, classified)
+	}
+	return statements
+}
+
+// Summary returns the first line of the statement without comments, shortened for display
+func (s ClassifiedStatement) Summary() string {
+	summary, _, _ := strings.Cut(strings.TrimSpace(sqlCommentPattern.ReplaceAllString(s.SQL, "")), "\n")
+	if summary = strings.TrimSpace(summary); len(summary) > 72 {
+		summary = summary[:69] + "..."
+	}
+	return summary
+}
+
+// CreateClassifiedDeployment creates a deployment with the statements written to the SQL
+// files of their phases. Unrecognized statements go to migrate, marked for review.
+func CreateClassifiedDeployment(module Module, name string, statements []ClassifiedStatement) (*Deployment, error) {
+	l, err := module.Layout.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	content := make(map[string]*strings.Builder)
+	for _, statement := range statements {
+		phase := statement.Phase
+		if phase == "" {
+			phase = "migrate"
+		}
+		if content[phase] == nil {
+			content[phase] = &strings.Builder{}
+		}
+		b := content[phase]
+		if statement.Phase == "" {
+			fmt.Fprintf(b, "-- zdd classify: %s\n", statement.Reason)
+		}
+		fmt.Fprintf(b, "%s;\n\n", statement.SQL)
+	}
+	if len(content) == 0 {
+		return nil, fmt.Errorf("no statements to classify")
+	}
+
+	var files []deploymentFile
+	for _, phase := range l.sqlPhases {
+		if b, ok := content[phase]; ok {
+			files = append(files, deploymentFile{phase + ".sql", strings.TrimRight(b.String(), "\n") + "\n", 0644})
+		}
+	}
+	return createDeploymentFiles(module, name, files)
+}
+
+// scanStatements splits SQL at top-level semicolons into statements without the semicolon and
+// the line each starts on, skipping those with only comments
+func scanStatements(sql string) []sqlLine {
+	var (
+		statements []sqlLine
+		start      int
+		line       = 1
+		startLine  = 0 // Line of the statement's first token; 0 until one is seen
+	)
+	flush := func(end int) {
+		if startLine > 0 {
+			statements = append(statements, sqlLine{SQL: strings.TrimSpace(sql[start:end]), Line: startLine})
+		}
+		start, startLine = end+1, 0
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		skip := ""
+		switch {
+		case c == '\n':
+			line++
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			continue
+		case strings.HasPrefix(sql[i:], "--"):
+			skip = "\n"
+		case strings.HasPrefix(sql[i:], "/*"):
+			skip = "*/"
+		}
+		if skip != "" {
+			end := strings.Index(sql[i+2:], skip)
+			if end < 0 {
+				end = len(sql) - i - 2
+			}
+			// Leave the newline ending a line comment to the loop, which counts it
+			if skip == "\n" {
+				i += 2 + end - 1
+			} else {
+				line += strings.Count(sql[i:i+2+end], "\n")
+				i += 2 + end + len(skip) - 1
+			}
+			continue
+		}
+
+		if startLine == 0 && c != ';' {
+			startLine = line
+		}
+		switch {
+		case c == ';':
+			flush(i)
+		case c == '\'' || c == '"':
+			end := i + 1
+			for end < len(sql) && !(sql[end] == c && (end+1 >= len(sql) || sql[end+1] != c)) {
+				if sql[end] == c {
+					end++ // Doubled quote
+				}
+				end++
+			}
+			line += strings.Count(sql[i:min(end, len(sql))], "\n")
+			i = end
+		case c == '$' && (i == 0 || !isPsqlNameChar(sql[i-1])):
+			if tag, ok := dollarTag(sql[i:]); ok {
+				end := strings.Index(sql[i+len(tag):], tag)
+				if end < 0 {
+					end = len(sql) - i - len(tag)
+				}
+				body := sql[i:min(i+2*len(tag)+end, len(sql))]
+				line += strings.Count(body, "\n")
+				i += len(body) - 1
+			}
+		}
+	}
+	flush(len(sql))
+	return statements
+}
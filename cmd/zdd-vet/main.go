@@ -0,0 +1,225 @@
+// Command zdd-vet lints the deployments trees Go packages embed with //go:embed, so bad SQL is
+// caught before any pipeline runs. Run it on directories,
+//
+//	zdd-vet ./...
+//
+// or through go vet, which runs it on each package of the build:
+//
+//	go vet -vettool=$(which zdd-vet) ./...
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mantty/zdd"
+)
+
+// vetConfig is the part of the config go vet passes a vet tool for each package that zdd-vet reads
+type vetConfig struct {
+	ID         string // Package ID, which keys the JSON output
+	GoFiles    []string
+	VetxOnly   bool   // Only compute facts for dependent packages, which zdd-vet has none of
+	VetxOutput string // Facts file go vet expects to be written
+	Stdout     string // File the JSON output is written to, instead of stdout
+}
+
+// jsonDiagnostic is a diagnostic in the JSON output go vet reads from vet tools
+type jsonDiagnostic struct {
+	Posn    string `json:"posn"` // e.g. migrations/000001_init/expand.sql:3:1
+	Message string `json:"message"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run vets the directories or the go vet package config in args and returns the exit status
+func run(args []string) int {
+	// The go vet tool protocol: -V=full identifies the tool for the build cache, -flags lists
+	// the flags it accepts and a .cfg argument describes one package
+	if len(args) == 1 && args[0] == "-V=full" {
+		id, err := executableID()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "zdd-vet: %v\n", err)
+			return 1
+		}
+		fmt.Printf("zdd-vet version devel buildID=%s\n", id)
+		return 0
+	}
+	if len(args) == 1 && args[0] == "-flags" {
+		fmt.Println("[]")
+		return 0
+	}
+
+	if len(args) > 0 && strings.HasSuffix(args[len(args)-1], ".cfg") {
+		return runPackage(args[len(args)-1], slices.Contains(args, "-json"))
+	}
+
+	issues, err := vetDirectories(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zdd-vet: %v\n", err)
+		return 1
+	}
+
+	for _, issue := range issues {
+		fmt.Fprintln(os.Stderr, issue)
+	}
+	if len(issues) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runPackage vets the package described by the go vet config file at path and returns the exit
+// status. With jsonOutput, as go vet runs it, issues and errors are reported as JSON and the
+// status is 0.
+func runPackage(path string, jsonOutput bool) int {
+	config, issues, err := vetPackage(path)
+	if !jsonOutput {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "zdd-vet: %v\n", err)
+			return 1
+		}
+		for _, issue := range issues {
+			fmt.Fprintln(os.Stderr, issue)
+		}
+		if len(issues) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	// The output maps the package ID to the analyzer's diagnostics or error
+	var result any
+	if err != nil {
+		result = map[string]string{"error": err.Error()}
+	} else {
+		diagnostics := make([]jsonDiagnostic, 0, len(issues))
+		for _, issue := range issues {
+			diagnostics = append(diagnostics, jsonDiagnostic{
+				Posn:    fmt.Sprintf("%s:%d:1", issue.Path, max(issue.Line, 1)),
+				Message: fmt.Sprintf("%s (%s)", issue.Message, issue.Check),
+			})
+		}
+		result = diagnostics
+	}
+	output, err := json.Marshal(map[string]map[string]any{config.ID: {"zdd": result}})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zdd-vet: %v\n", err)
+		return 1
+	}
+
+	if config.Stdout != "" {
+		err = os.WriteFile(config.Stdout, output, 0666)
+	} else {
+		_, err = os.Stdout.Write(output)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zdd-vet: failed to write output: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// vetPackage reads the go vet config file at path and vets the package it describes
+func vetPackage(path string) (vetConfig, []zdd.LintIssue, error) {
+	var config vetConfig
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, nil, fmt.Errorf("failed to read vet config: %w", err)
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return config, nil, fmt.Errorf("failed to parse vet config %s: %w", path, err)
+	}
+
+	if config.VetxOutput != "" {
+		if err := os.WriteFile(config.VetxOutput, nil, 0666); err != nil {
+			return config, nil, fmt.Errorf("failed to write vet facts: %w", err)
+		}
+	}
+	if config.VetxOnly {
+		return config, nil, nil
+	}
+
+	trees, err := zdd.FindEmbeddedTrees(config.GoFiles)
+	if err != nil {
+		return config, nil, err
+	}
+	issues, err := zdd.VetEmbeddedTrees(trees)
+	return config, issues, err
+}
+
+// vetDirectories vets the Go packages in dirs, default the current directory. A dir ending in
+// /... also vets its subdirectories.
+func vetDirectories(dirs []string) ([]zdd.LintIssue, error) {
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	var issues []zdd.LintIssue
+	for _, dir := range dirs {
+		var (
+			found []zdd.LintIssue
+			err   error
+		)
+		if root, ok := strings.CutSuffix(dir, "/..."); ok {
+			if root == "" {
+				root = "/"
+			}
+			found, err = zdd.VetEmbeddedDeployments(root)
+		} else {
+			found, err = vetDirectory(dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+	}
+	return issues, nil
+}
+
+// vetDirectory vets the Go package in dir, without its subdirectories
+func vetDirectory(dir string) ([]zdd.LintIssue, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var goFiles []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".go") {
+			goFiles = append(goFiles, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	trees, err := zdd.FindEmbeddedTrees(goFiles)
+	if err != nil {
+		return nil, err
+	}
+	return zdd.VetEmbeddedTrees(trees)
+}
+
+// executableID returns a hash of the running executable, which go vet caches results under
+func executableID() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find executable: %w", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open executable: %w", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("failed to read executable: %w", err)
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
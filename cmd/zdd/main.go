@@ -2,19 +2,26 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/mantty/zdd"
 	"github.com/mantty/zdd/postgres"
+	"github.com/mantty/zdd/selfupdate"
+	"github.com/mantty/zdd/sqlite"
 	"github.com/urfave/cli/v3"
 )
 
-const (
-	version = "0.1.0"
-)
+// configKey is the context key under which the loaded project config is stored
+type configKey struct{}
 
 func main() {
 	ctx := context.Background()
@@ -22,7 +29,11 @@ func main() {
 	cmd := &cli.Command{
 		Name:    "zdd",
 		Usage:   "Zero Downtime Deployments - SQL migrations and app deployments",
-		Version: version,
+		Version: zdd.Version,
+		Before:  loadConfig,
+		After:   updateNotice,
+		// Provides `zdd completion bash|zsh|fish`
+		EnableShellCompletion: true,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    "database-url",
@@ -37,6 +48,42 @@ func main() {
 				Value:   "migrations",
 				Sources: cli.EnvVars("ZDD_DEPLOYMENTS_PATH"),
 			},
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "Path to project config file",
+				Value:   "zdd.yaml",
+				Sources: cli.EnvVars("ZDD_CONFIG"),
+			},
+			&cli.BoolFlag{
+				Name:    "allow-scripts",
+				Usage:   "Allow phase scripts to run (overrides allow_scripts in the config file)",
+				Sources: cli.EnvVars("ZDD_ALLOW_SCRIPTS"),
+			},
+			&cli.StringFlag{
+				Name:    "module",
+				Aliases: []string{"m"},
+				Usage:   "Only operate on this module from the config file",
+				Sources: cli.EnvVars("ZDD_MODULE"),
+			},
+			&cli.BoolFlag{
+				Name:  "no-color",
+				Usage: "Disable colored output (also disabled by NO_COLOR or when stdout is not a terminal)",
+			},
+			&cli.BoolFlag{
+				Name:    "utc",
+				Usage:   "Render timestamps in UTC as RFC3339",
+				Sources: cli.EnvVars("ZDD_UTC"),
+			},
+			&cli.BoolFlag{
+				Name:  "no-pager",
+				Usage: "Do not pipe long output through $PAGER",
+			},
+			&cli.StringSliceFlag{
+				Name:    "protected-url",
+				Usage:   "Substring of database URLs that must never be dropped or reset",
+				Sources: cli.EnvVars("ZDD_PROTECTED_URLS"),
+			},
 		},
 		Commands: []*cli.Command{
 			{
@@ -51,51 +98,761 @@ func main() {
 						},
 					},
 				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "rename-column",
+						Usage: "Generate a zero-downtime column rename, written as table.column=new_column",
+					},
+					&cli.StringFlag{
+						Name:  "rename-table",
+						Usage: "Generate a zero-downtime table rename, written as table=new_table",
+					},
+					&cli.StringFlag{
+						Name:  "key",
+						Usage: "Key column matching rows of the old and new table or column",
+						Value: "id",
+					},
+					&cli.StringFlag{
+						Name:  "strategy",
+						Usage: "Rename strategy: trigger (sync old and new until contract) or view (tables only: rename at contract, keep a view under the old name for one release)",
+						Value: zdd.RenameStrategyTrigger,
+					},
+					&cli.StringFlag{
+						Name:  "add-enum-value",
+						Usage: "Generate a deployment adding a value to an enum type, written as type=value",
+					},
+					&cli.StringFlag{
+						Name:  "remove-enum-value",
+						Usage: "Generate a deployment forbidding an enum value with check constraints, written as type=value",
+					},
+					&cli.StringSliceFlag{
+						Name:  "column",
+						Usage: "Column using the enum type, written as table.column (required with --remove-enum-value)",
+					},
+					&cli.StringFlag{
+						Name:  "replace-with",
+						Usage: "Value rows using the removed enum value are moved to",
+					},
+					&cli.StringFlag{
+						Name:  "partition-table",
+						Usage: "Generate a deployment moving a table to a range-partitioned one, written as table.column with the partition key column",
+					},
+					&cli.StringFlag{
+						Name:  "enable-rls",
+						Usage: "Generate a deployment enabling row-level security with a tenant isolation policy, written as table.tenant_column",
+					},
+					&cli.StringFlag{
+						Name:  "tenant-setting",
+						Usage: "Session setting holding the current tenant for --enable-rls",
+						Value: "app.tenant_id",
+					},
+					&cli.StringFlag{
+						Name:  "interval",
+						Usage: "Partition interval for --partition-table: day, week, month or year",
+						Value: "month",
+					},
+					&cli.StringFlag{
+						Name:  "from-sql",
+						Usage: "Create a deployment from an existing SQL file, checking it for problems",
+					},
+					&cli.StringFlag{
+						Name:  "phase",
+						Usage: "Phase the --from-sql file runs in, e.g. expand, migrate or contract",
+						Value: "migrate",
+					},
+					&cli.StringFlag{
+						Name:  "from-jira",
+						Usage: "Create a deployment for a Jira issue, e.g. PROJ-123, named from its summary unless a name is given",
+					},
+				},
 				Action: createCommand,
 			},
 			{
-				Name:   "list",
-				Usage:  "List deployments and their status",
+				Name:  "list",
+				Usage: "List deployments and their status",
+				Flags: []cli.Flag{
+					formatFlag("Print the deployments as text, or as JSON in the form of zdd state --json"),
+				},
 				Action: listCommand,
 			},
 			{
-				Name:   "deploy",
-				Usage:  "Apply pending deployments",
+				Name:  "show",
+				Usage: "Show the files and tasks of a deployment",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "id",
+						UsageText: "ID",
+					},
+				},
+				ShellComplete: completeDeploymentIDs,
+				Action:        showCommand,
+			},
+			{
+				Name:  "classify",
+				Usage: "Suggest the phase each statement of an SQL file belongs in",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "file",
+						UsageText: "FILE",
+					},
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "create",
+						Usage: "Create a deployment with this name, splitting the statements into its phase files",
+					},
+				},
+				Action: classifyCommand,
+			},
+			{
+				Name:  "lint",
+				Usage: "Check deployment SQL for statements zdd cannot run or that are not backward compatible in their phase",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "pending",
+						Usage: "Only check deployments not yet applied to the database",
+					},
+					&cli.BoolFlag{
+						Name:  "rollbacks",
+						Usage: "Write a revert.sql for deployments without revert SQL whose SQL has a provable inverse",
+					},
+				},
+				Action: lintCommand,
+			},
+			{
+				Name:  "deploy",
+				Usage: "Apply pending deployments",
+				Flags: append(deployFlags(),
+					&cli.StringFlag{
+						Name:  "from-pack",
+						Usage: "Bootstrap a database without applied deployments from a pack file before applying the rest",
+					},
+					formatFlag("Print progress as text, or the task results and resulting state as JSON with progress on stderr"),
+				),
 				Action: deployCommand,
 			},
+			{
+				Name:  "apply",
+				Usage: "Apply pending deployments non-interactively, for wrapping by tools such as Terraform",
+				Flags: append(deployFlags(),
+					&cli.BoolFlag{
+						Name:  "auto-approve",
+						Usage: "Apply without confirmation (required)",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Same as --format json",
+					},
+					formatFlag("Print progress as text, or the task results and resulting state as JSON with progress on stderr"),
+				),
+				Action: applyCommand,
+			},
+			{
+				Name:  "contract",
+				Usage: "Finish contract-phase work outside a deploy",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "cleanup-views",
+						Usage: "Drop the compatibility views left by view-strategy table renames",
+					},
+				},
+				Action: contractCommand,
+			},
+			{
+				Name:  "rollback",
+				Usage: "Run the revert SQL of the most recently applied deployment and remove its record, so it can be deployed again",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "id",
+						UsageText: "[ID]",
+					},
+				},
+				ShellComplete: completeDeploymentIDs,
+				Action:        rollbackCommand,
+			},
+			{
+				Name:  "purge-trash",
+				Usage: "Drop the tables and columns contract phases moved to the trash once their retention period ends",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "older-than",
+						Usage: "Purge objects trashed at least this long ago (default: trash.retention or 168h)",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "List the objects that would be purged without dropping them",
+					},
+				},
+				Action: purgeTrashCommand,
+			},
+			{
+				Name:  "pack",
+				Usage: "Compile every deployment into a single pack file for bootstrapping fresh databases",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "Pack file to write",
+						Required: true,
+					},
+				},
+				Action: packCommand,
+			},
+			{
+				Name:  "render",
+				Usage: "Write the pending deployments as a psql script to review and run by hand",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "out",
+						Aliases:  []string{"o"},
+						Usage:    "Script file to write",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Render every deployment without connecting to a database",
+					},
+					&cli.BoolFlag{
+						Name:  "skip-scripts",
+						Usage: "Leave out script tasks, recording them as skipped",
+					},
+					&cli.StringSliceFlag{
+						Name:  "phases",
+						Usage: "Render only these phases (e.g. expand,migrate)",
+					},
+				},
+				Action: renderCommand,
+			},
+			{
+				Name:  "bundle",
+				Usage: "Build self-contained executables that apply the deployments",
+				Commands: []*cli.Command{
+					{
+						Name:  "build",
+						Usage: "Write a zdd executable with the config file and deployments embedded",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "output",
+								Aliases:  []string{"o"},
+								Usage:    "Path of the executable to write",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "zdd-binary",
+								Usage: "zdd executable to embed, e.g. one built for the target platform (default: this one)",
+							},
+						},
+						Action: bundleBuildCommand,
+					},
+				},
+			},
+			{
+				Name:  "cutover",
+				Usage: "Migrate a green copy of the database and switch traffic to it, resuming an interrupted cutover",
+				Flags: append(deployFlags(),
+					&cli.BoolFlag{
+						Name:  "restart",
+						Usage: "Discard the progress of an earlier cutover and start from the restore step",
+					},
+				),
+				Action: cutoverCommand,
+			},
+			{
+				Name:  "state",
+				Usage: "Show the deployment state",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the state as JSON",
+					},
+					&cli.StringFlag{
+						Name:  "report",
+						Usage: "Render the state as a report in this format: " + strings.Join(zdd.ReportFormats, ", "),
+					},
+					&cli.StringFlag{
+						Name:  "template",
+						Usage: "Render the state with this Go template (e.g. change-request.md.tmpl), HTML-escaped if it ends in .html",
+					},
+				},
+				Action: stateCommand,
+			},
+			{
+				Name:  "changelog",
+				Usage: "Render the deployments applied since a deployment or date as markdown for release notes",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "since",
+						Usage:    "ID of the last deployment of the previous release, or a date (e.g. 2026-10-01)",
+						Required: true,
+					},
+				},
+				Action: changelogCommand,
+			},
+			{
+				Name:  "deprecations",
+				Usage: "Register and list the tables and columns that pending contract phases drop",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the deprecations as JSON",
+					},
+					&cli.BoolFlag{
+						Name:  "registered",
+						Usage: "List the deprecations registered in the database instead of registering those of the local deployments",
+					},
+				},
+				Action: deprecationsCommand,
+			},
+			{
+				Name:  "check-usage",
+				Usage: "Fail if application code still references tables or columns that pending contract phases drop",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:     "go",
+						Usage:    "Go packages to check, as directories or dir/... for their trees (e.g. ./...)",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "registered",
+						Usage: "Check against the deprecations registered in the database, e.g. from an application's repository",
+					},
+				},
+				Action: checkUsageCommand,
+			},
+			{
+				Name:  "unlock",
+				Usage: "Clear the heartbeats and running deployments of deploys that stopped without finishing",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "stale-after",
+						Usage: "Clear deploys without a heartbeat for this long (default: project.stale_after or 2m)",
+					},
+				},
+				Action: unlockCommand,
+			},
+			{
+				Name:  "reset",
+				Usage: "Drop and recreate a development database, then reapply all deployments and seeds",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dev",
+						Usage: "Confirm the target is a development database (required)",
+					},
+					&cli.StringFlag{
+						Name:    "seeds-path",
+						Usage:   "Path to directory of seed SQL files applied after deployments",
+						Value:   "seeds",
+						Sources: cli.EnvVars("ZDD_SEEDS_PATH"),
+					},
+				},
+				Action: resetCommand,
+			},
+			{
+				Name:  "gen",
+				Usage: "Generate code from deployments",
+				Commands: []*cli.Command{
+					{
+						Name:  "go",
+						Usage: "Generate Go constants for deployment IDs",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "package",
+								Usage: "Go package name of the generated file",
+								Value: "schemaver",
+							},
+							&cli.StringFlag{
+								Name:    "output",
+								Aliases: []string{"o"},
+								Usage:   "File to write to (default: stdout)",
+							},
+						},
+						Action: genGoCommand,
+					},
+					{
+						Name:  "k8s-job",
+						Usage: "Generate a Kubernetes Job manifest that runs zdd, configured by the kubernetes section of the config file",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "init-container",
+								Usage: "Render an initContainers entry instead of a Job",
+							},
+							&cli.StringFlag{
+								Name:    "output",
+								Aliases: []string{"o"},
+								Usage:   "File to write to (default: stdout)",
+							},
+						},
+						Action: genK8sJobCommand,
+					},
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "Write the migrated schema for codegen tools: the exports of the config file, or one --format",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Export in this format instead of the config's exports: " + strings.Join(zdd.ExportFormats, ", "),
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "File to write --format to (default: stdout)",
+					},
+					&cli.StringFlag{
+						Name:  "package",
+						Usage: "Go package of gorm models (default: the output's directory name)",
+					},
+				},
+				Action: exportCommand,
+			},
+			{
+				Name:  "advise",
+				Usage: "Suggest contract deployments from how the database is used",
+				Commands: []*cli.Command{
+					{
+						Name:  "indexes",
+						Usage: "Suggest dropping indexes created by deployments that pg_stat_user_indexes shows are unused",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "max-scans",
+								Usage: "Suggest indexes scanned at most this many times since statistics were reset",
+							},
+							&cli.BoolFlag{
+								Name:  "create",
+								Usage: "Create a deployment whose contract phase drops the suggested indexes",
+							},
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "Name of the deployment --create creates",
+								Value: "drop_unused_indexes",
+							},
+						},
+						Action: adviseIndexesCommand,
+					},
+				},
+			},
+			{
+				Name:  "blame",
+				Usage: "Report which deployments created and last modified a table, column or index",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "kind",
+						UsageText: strings.Join(zdd.BlameKinds, "|"),
+					},
+					&cli.StringArg{
+						Name:      "name",
+						UsageText: "NAME",
+					},
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "scratch-url",
+						Usage:   "Connection string of a disposable database that deployments are replayed into",
+						Sources: cli.EnvVars("ZDD_SCRATCH_DATABASE_URL"),
+					},
+				},
+				ShellComplete: completeBlameKinds,
+				Action:        blameCommand,
+			},
+			{
+				Name:  "schema-hash",
+				Usage: "Record in each deployment's zdd.yaml the hash of the schema it leaves, which deploy then checks",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "scratch-url",
+						Usage:   "Connection string of a disposable database that deployments are replayed into",
+						Sources: cli.EnvVars("ZDD_SCRATCH_DATABASE_URL"),
+					},
+				},
+				Action: schemaHashCommand,
+			},
+			{
+				Name:  "bench",
+				Usage: "Time a deployment's SQL statement by statement, and its lock hold times, against a sample copy of the database",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "id",
+						UsageText: "ID",
+					},
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "sample-url",
+						Usage:   "Connection string of a restored sample or staging copy with every earlier deployment applied",
+						Sources: cli.EnvVars("ZDD_SAMPLE_DATABASE_URL"),
+					},
+				},
+				Action: benchCommand,
+			},
+			{
+				Name:  "approve",
+				Usage: "Record an approval of a deployment for a scope its SQL files require with -- zdd:requires-approval",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "id",
+						UsageText: "ID",
+					},
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "scope",
+						Usage:    "Scope approved, e.g. security",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "token",
+						Usage:    "Reference of the approval, e.g. a review URL or change ticket",
+						Required: true,
+					},
+				},
+				Action: approveCommand,
+			},
+			{
+				Name:  "test",
+				Usage: "Check that deploys survive failures by deploying to a scratch database while injecting them; needs a zdd built with -tags zddchaos",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "chaos",
+						Usage: "Inject dropped connections, killed deploys and concurrent deploys, and check the failure model holds",
+					},
+					&cli.StringSliceFlag{
+						Name:  "fault",
+						Usage: "Only inject these faults: " + strings.Join(zdd.ChaosFaults, ", "),
+					},
+					&cli.StringFlag{
+						Name:    "scratch-url",
+						Usage:   "Connection string of a disposable database that is recreated for every scenario",
+						Sources: cli.EnvVars("ZDD_SCRATCH_DATABASE_URL"),
+					},
+				},
+				Action: testCommand,
+			},
+			{
+				Name:   "upgrade-metadata",
+				Usage:  "Copy the deployment history of early zdd releases from zdd_migrations into zdd_deployments",
+				Action: upgradeMetadataCommand,
+			},
+			{
+				Name:   "doctor",
+				Usage:  "Check the database connection and the version of zdd's metadata schema",
+				Action: doctorCommand,
+			},
+			{
+				Name:  "version",
+				Usage: "Print build information",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print build information as JSON",
+					},
+				},
+				Action: versionCommand,
+			},
+			{
+				Name:  "self-update",
+				Usage: "Replace this binary with the latest release from GitHub",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "check",
+						Usage: "Only report whether a newer release is available",
+					},
+				},
+				Action: selfUpdateCommand,
+			},
 		},
 	}
 
-	if err := cmd.Run(ctx, os.Args); err != nil {
+	log.SetPrefix(fmt.Sprintf("run=%s ", zdd.RunID()))
+	args, cleanup, err := bundleArgs(os.Args)
+	if err != nil {
 		log.Fatal(err)
 	}
+	err = cmd.Run(ctx, args)
+	cleanup()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// bundleArgs prepares to run the project embedded by `zdd bundle build`, if this executable has
+// one: it extracts the project into a temporary directory, which becomes the working directory,
+// and points the config and deployments path at it. DATABASE_URL is accepted for the database
+// URL, and running without arguments deploys. cleanup removes the directory.
+func bundleArgs(args []string) ([]string, func(), error) {
+	noop := func() {}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return args, noop, nil // Not knowing our own path only rules out bundle mode
+	}
+	bundle, err := zdd.OpenBundle(executable)
+	if err != nil || bundle == nil {
+		return args, noop, err
+	}
+	defer bundle.Close()
+
+	dir, err := os.MkdirTemp("", "zdd-bundle-*")
+	if err != nil {
+		return nil, noop, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if err := bundle.Extract(dir); err != nil {
+		cleanup()
+		return nil, noop, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		cleanup()
+		return nil, noop, fmt.Errorf("failed to enter bundle directory: %w", err)
+	}
+
+	env := map[string]string{
+		"ZDD_DEPLOYMENTS_PATH": bundle.Manifest.DeploymentsPath,
+		"ZDD_CONFIG":           bundle.Manifest.Config,
+		"ZDD_NO_INDEX":         "1", // The directory is new every run, so an index would only grow
+	}
+	if os.Getenv("ZDD_DATABASE_URL") == "" {
+		env["ZDD_DATABASE_URL"] = os.Getenv("DATABASE_URL")
+	}
+	for name, value := range env {
+		if value != "" {
+			os.Setenv(name, value)
+		}
+	}
+
+	if len(args) == 1 {
+		args = append(args, "deploy")
+	}
+	return args, cleanup, nil
 }
 
 func createCommand(ctx context.Context, cmd *cli.Command) error {
 	name := cmd.StringArg("name")
-	if name == "" {
+
+	generators := 0
+	for _, flag := range []string{"rename-column", "rename-table", "add-enum-value", "remove-enum-value", "partition-table", "enable-rls", "from-sql", "from-jira"} {
+		if cmd.IsSet(flag) {
+			generators++
+		}
+	}
+	switch {
+	case generators > 1:
+		return fmt.Errorf("only one of --rename-column, --rename-table, --add-enum-value, --remove-enum-value, --partition-table, --enable-rls, --from-sql and --from-jira can be given")
+	case generators == 0 && name == "":
 		return fmt.Errorf("deployment name is required")
 	}
 
-	deploymentsPath := cmd.String("deployments-path")
+	module, err := selectModule(ctx, cmd)
+	if err != nil {
+		return err
+	}
 
-	deployment, err := zdd.CreateDeployment(deploymentsPath, name)
+	deployment, err := createDeployment(ctx, cmd, module, name)
 	if err != nil {
 		return fmt.Errorf("failed to create deployment: %w", err)
 	}
 
 	fmt.Printf("Created deployment %s\n", deployment.Directory)
 
+	if cmd.IsSet("from-sql") {
+		issues, err := zdd.LintSQL(module.Layout, filepath.Join(deployment.Directory, cmd.String("phase")+".sql"), cmd.String("phase"))
+		if err != nil {
+			return err
+		}
+		if len(issues) == 0 {
+			fmt.Println("No issues found")
+		}
+		for _, issue := range issues {
+			fmt.Printf("Warning: %s\n", issue)
+		}
+	}
+
 	return nil
 }
 
+// createDeployment creates a blank deployment, one generated from the rename, enum, partition
+// or row-level security flags, one importing an SQL file, or one for a Jira issue
+func createDeployment(ctx context.Context, cmd *cli.Command, module zdd.Module, name string) (*zdd.Deployment, error) {
+	switch {
+	case cmd.IsSet("rename-column"), cmd.IsSet("rename-table"):
+		var rename zdd.Rename
+		var err error
+		if cmd.IsSet("rename-column") {
+			rename, err = zdd.ParseColumnRename(cmd.String("rename-column"))
+		} else {
+			rename, err = zdd.ParseTableRename(cmd.String("rename-table"))
+		}
+		if err != nil {
+			return nil, err
+		}
+		rename.Key = cmd.String("key")
+		rename.Strategy = cmd.String("strategy")
+		return zdd.CreateRenameDeployment(module, name, rename)
+
+	case cmd.IsSet("add-enum-value"):
+		change, err := zdd.ParseEnumChange(cmd.String("add-enum-value"))
+		if err != nil {
+			return nil, err
+		}
+		return zdd.CreateAddEnumValueDeployment(module, name, change)
+
+	case cmd.IsSet("remove-enum-value"):
+		change, err := zdd.ParseEnumChange(cmd.String("remove-enum-value"))
+		if err != nil {
+			return nil, err
+		}
+		change.Columns = cmd.StringSlice("column")
+		change.Replacement = cmd.String("replace-with")
+		return zdd.CreateRemoveEnumValueDeployment(module, name, change)
+
+	case cmd.IsSet("partition-table"):
+		table, column, err := splitTableColumn(cmd.String("partition-table"))
+		if err != nil {
+			return nil, err
+		}
+		return zdd.CreatePartitionDeployment(module, name, zdd.PartitionMigration{
+			Table:    table,
+			Column:   column,
+			Interval: cmd.String("interval"),
+			Key:      cmd.String("key"),
+		})
+
+	case cmd.IsSet("from-sql"):
+		return zdd.CreateDeploymentFromSQL(module, name, cmd.String("phase"), cmd.String("from-sql"))
+
+	case cmd.IsSet("from-jira"):
+		fetcher := &zdd.JiraFetcher{Config: configFromContext(ctx).Jira}
+		return zdd.CreateTicketDeployment(ctx, module, name, cmd.String("from-jira"), fetcher)
+
+	case cmd.IsSet("enable-rls"):
+		table, column, err := splitTableColumn(cmd.String("enable-rls"))
+		if err != nil {
+			return nil, err
+		}
+		return zdd.CreateTenantPolicyDeployment(module, name, zdd.TenantPolicy{
+			Table:   table,
+			Column:  column,
+			Setting: cmd.String("tenant-setting"),
+		})
+
+	default:
+		return zdd.CreateDeployment(module, name)
+	}
+}
+
+// splitTableColumn splits a flag value written as [schema.]table.column
+func splitTableColumn(spec string) (string, string, error) {
+	dot := strings.LastIndex(spec, ".")
+	if dot <= 0 || dot == len(spec)-1 {
+		return "", "", fmt.Errorf("invalid column %q (expected table.column)", spec)
+	}
+	return spec[:dot], spec[dot+1:], nil
+}
+
 func listCommand(ctx context.Context, cmd *cli.Command) error {
-	deploymentsPath := cmd.String("deployments-path")
 	databaseURL := cmd.String("database-url")
 
-	// Convert relative deployments path to absolute
-	var err error
-	deploymentsPath, err = resolveDeploymentsPath(deploymentsPath)
+	modules, err := selectModules(ctx, cmd)
 	if err != nil {
 		return err
 	}
@@ -110,42 +867,1776 @@ func listCommand(ctx context.Context, cmd *cli.Command) error {
 		defer db.Close()
 	}
 
-	return zdd.ListDeployments(deploymentsPath, db)
-}
-
-func deployCommand(ctx context.Context, cmd *cli.Command) error {
-	deploymentsPath := cmd.String("deployments-path")
-	databaseURL := cmd.String("database-url")
-
-	// Convert relative deployments path to absolute
-	deploymentsPath, err := resolveDeploymentsPath(deploymentsPath)
+	jsonOutput, err := jsonFormat(ctx, cmd)
 	if err != nil {
 		return err
 	}
+	if jsonOutput {
+		state, err := buildState(ctx, modules, db)
+		if err != nil {
+			return err
+		}
+		return printJSON(state)
+	}
 
-	if databaseURL == "" {
-		return fmt.Errorf("database URL is required for deployments")
+	out := newPagedOutput(cmd)
+	if err := zdd.WriteModuleList(out, modules, db, tableStyle(cmd)); err != nil {
+		return err
 	}
+	return out.Close()
+}
 
-	// Connect to database
-	db, err := newDatabase(ctx, databaseURL)
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+// formatFlag is the --format flag of commands that print text or JSON
+func formatFlag(usage string) cli.Flag {
+	return &cli.StringFlag{
+		Name:  "format",
+		Usage: usage,
+		Value: "text",
+	}
+}
+
+// jsonFormat reports whether --format, or the config's project format without it, selects
+// JSON output
+func jsonFormat(ctx context.Context, cmd *cli.Command) (bool, error) {
+	format := cmd.String("format")
+	if !cmd.IsSet("format") && configFromContext(ctx).Project.Format != "" {
+		format = configFromContext(ctx).Project.Format
+	}
+	switch format {
+	case "text":
+		return false, nil
+	case "json":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown format %q; expected text or json", format)
+	}
+}
+
+func classifyCommand(ctx context.Context, cmd *cli.Command) error {
+	path := cmd.StringArg("file")
+	if path == "" {
+		return fmt.Errorf("SQL file is required")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read SQL file: %w", err)
+	}
+
+	statements := zdd.ClassifySQL(string(content))
+	if len(statements) == 0 {
+		return fmt.Errorf("%s has no statements", path)
+	}
+
+	counts := make(map[string]int)
+	for _, statement := range statements {
+		phase := statement.Phase
+		if phase == "" {
+			phase = "unrecognized"
+		}
+		counts[phase]++
+
+		fmt.Printf("%s:%d: %s (%s)\n  %s\n", path, statement.Line, phase, statement.Reason, statement.Summary())
+	}
+
+	var summary []string
+	for _, phase := range []string{"expand", "migrate", "contract", "unrecognized"} {
+		if counts[phase] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[phase], phase))
+		}
+	}
+	fmt.Printf("%d statements: %s\n", len(statements), strings.Join(summary, ", "))
+
+	if name := cmd.String("create"); name != "" {
+		module, err := selectModule(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		deployment, err := zdd.CreateClassifiedDeployment(module, name, statements)
+		if err != nil {
+			return fmt.Errorf("failed to create deployment: %w", err)
+		}
+		fmt.Printf("Created deployment %s\n", deployment.Directory)
+	}
+	return nil
+}
+
+func lintCommand(ctx context.Context, cmd *cli.Command) error {
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	var deployments []zdd.Deployment
+	var contractIssues []zdd.LintIssue
+	if cmd.Bool("pending") {
+		databaseURL := cmd.String("database-url")
+		if databaseURL == "" {
+			return fmt.Errorf("database URL is required for --pending")
+		}
+		db, err := newDatabase(ctx, databaseURL)
+		if err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer db.Close()
+
+		plan, err := zdd.BuildModulesPlan(modules, db)
+		if err != nil {
+			return err
+		}
+		for _, task := range plan.Tasks {
+			if len(deployments) == 0 || deployments[len(deployments)-1].Key() != task.Deployment.Key() {
+				deployments = append(deployments, *task.Deployment)
+			}
+		}
+		if contractIssues, err = plan.CheckContracts(); err != nil {
+			return err
+		}
+	} else {
+		for _, module := range modules {
+			local, err := zdd.LoadModuleDeployments(module)
+			if err != nil {
+				return err
+			}
+			deployments = append(deployments, local...)
+		}
+	}
+
+	issues, err := zdd.LintDeployments(deployments)
+	if err != nil {
+		return err
+	}
+	issues = append(issues, contractIssues...)
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	// Missing rollbacks are reported without failing, as deploy never runs revert SQL
+	written, warnings, err := zdd.CheckRollbacks(deployments, cmd.Bool("rollbacks"))
+	if err != nil {
+		return err
+	}
+	for _, path := range written {
+		fmt.Printf("Wrote %s\n", path)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("%d issues found in %d deployments", len(issues), len(deployments))
+	}
+	fmt.Printf("No issues found in %d deployments\n", len(deployments))
+	return nil
+}
+
+func showCommand(ctx context.Context, cmd *cli.Command) error {
+	id := cmd.StringArg("id")
+	if id == "" {
+		return fmt.Errorf("deployment ID is required")
+	}
+
+	module, err := selectModule(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	deployments, err := zdd.LoadModuleDeployments(module)
+	if err != nil {
+		return err
+	}
+
+	for _, deployment := range deployments {
+		if deployment.ID != id {
+			continue
+		}
+
+		out := newPagedOutput(cmd)
+		fmt.Fprintf(out, "Deployment %s: %s\n", deployment.Key(), deployment.Name)
+		fmt.Fprintf(out, "Directory: %s\n", deployment.Directory)
+		fmt.Fprintf(out, "Checksum: %s\n", zdd.CalculateChecksum(deployment))
+		fmt.Fprintln(out, "Tasks:")
+		for _, task := range deployment.Tasks() {
+			fmt.Fprintf(out, "  %s %s: %s\n", task.Phase, task.TaskType, filepath.Base(task.Path))
+		}
+		for _, task := range deployment.RevertTasks() {
+			fmt.Fprintf(out, "Revert: %s\n", filepath.Base(task.Path))
+		}
+		if len(deployment.FeatureFlags) > 0 {
+			fmt.Fprintln(out, "Feature flags:")
+			for _, flag := range deployment.FeatureFlags {
+				fmt.Fprintf(out, "  %s = %s after %s\n", flag.Name, flag.Value, flag.Phase)
+			}
+		}
+		return out.Close()
+	}
+
+	return fmt.Errorf("deployment %s not found in %s", id, module.Path)
+}
+
+// completeDeploymentIDs completes a deployment ID argument from the local deployments
+// directories. Completion runs before the Before hook, so the config is loaded here and
+// any error simply yields no suggestions.
+func completeDeploymentIDs(ctx context.Context, cmd *cli.Command) {
+	if cmd.NArg() > 0 {
+		return
+	}
+
+	if config, err := zdd.LoadConfig(cmd.String("config")); err == nil {
+		ctx = context.WithValue(ctx, configKey{}, config)
+		if !cmd.IsSet("deployments-path") && config.Project.DeploymentsPath != "" {
+			_ = cmd.Set("deployments-path", config.Project.DeploymentsPath)
+		}
+	}
+
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return
+	}
+
+	for _, module := range modules {
+		ids, err := zdd.DeploymentIDs(module)
+		if err != nil {
+			continue
+		}
+		for _, id := range ids {
+			fmt.Fprintln(cmd.Root().Writer, id)
+		}
+	}
+}
+
+// completeBlameKinds completes the object kind argument of blame
+func completeBlameKinds(ctx context.Context, cmd *cli.Command) {
+	if cmd.NArg() > 0 {
+		return
+	}
+	for _, kind := range zdd.BlameKinds {
+		fmt.Fprintln(cmd.Root().Writer, kind)
+	}
+}
+
+// tableStyle renders timestamps as selected by --utc, and colors tables only when stdout is a
+// terminal and color is not disabled with --no-color or the NO_COLOR convention (https://no-color.org)
+func tableStyle(cmd *cli.Command) zdd.TableStyle {
+	style := zdd.TableStyle{UTC: cmd.Bool("utc")}
+	if !cmd.Bool("no-color") && os.Getenv("NO_COLOR") == "" {
+		style.Color = isTerminal(os.Stdout)
+	}
+	return style
+}
+
+// isTerminal reports whether f is a character device such as a TTY
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// deployFlags are the flags shared by deploy and apply
+func deployFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "skip-scripts",
+			Usage: "Do not execute phase scripts",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-sql",
+			Usage: "Do not execute phase SQL files",
+		},
+		&cli.StringSliceFlag{
+			Name:  "phases",
+			Usage: "Only execute tasks in these phases (e.g. expand,migrate)",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-contract-check",
+			Usage: "Do not check that contract statements only drop objects that exist and whose replacements were created",
+		},
+		&cli.StringFlag{
+			Name:    "history-url",
+			Usage:   "Database whose task run history (e.g. from staging) estimates durations; default the target database",
+			Sources: cli.EnvVars("ZDD_HISTORY_DATABASE_URL"),
+		},
+		&cli.BoolFlag{
+			Name:  "takeover",
+			Usage: "Resume deployments left running by a zdd process that is no longer running",
+		},
+		&cli.DurationFlag{
+			Name:    "deploy-lock-timeout",
+			Usage:   "Wait this long for another deploy on the database to finish (default: project.deploy_lock_timeout or 0, fail at once)",
+			Sources: cli.EnvVars("ZDD_DEPLOY_LOCK_TIMEOUT"),
+		},
+		&cli.BoolFlag{
+			Name:  "verify-rollbacks",
+			Usage: "Check that the revert SQL of each pending deployment restores the prior schema on a scratch database before deploying",
+		},
+		&cli.StringFlag{
+			Name:  "scratch-database",
+			Usage: "Name of the database --verify-rollbacks creates and drops on the target server",
+			Value: "zdd_scratch",
+		},
+		&cli.StringSliceFlag{
+			Name:    "approval",
+			Usage:   "Approve SQL files annotated with -- zdd:requires-approval <scope>, as scope:token (e.g. security:CHG-1234)",
+			Sources: cli.EnvVars("ZDD_APPROVALS"),
+		},
+	}
+}
+
+func deployCommand(ctx context.Context, cmd *cli.Command) error {
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	jsonOutput, err := jsonFormat(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDeploymentDatabase(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// With JSON output, stdout carries only the result
+	out := io.Writer(os.Stdout)
+	if jsonOutput {
+		out = os.Stderr
+	}
+
+	if packPath := cmd.String("from-pack"); packPath != "" {
+		pack, err := zdd.ReadPack(packPath)
+		if err != nil {
+			return err
+		}
+		if err := zdd.ApplyPack(db, pack, out); err != nil {
+			return err
+		}
+	}
+
+	// Build and execute plan
+	plan, err := buildDeployPlan(ctx, cmd, modules, db)
+	if err != nil {
+		return err
+	}
+	return executeDeployPlan(ctx, plan, modules, db, jsonOutput)
+}
+
+// executeDeployPlan executes a deploy plan, printing progress on stdout, or on stderr with the
+// task results and resulting state as JSON on stdout
+func executeDeployPlan(ctx context.Context, plan *zdd.Plan, modules []zdd.Module, db zdd.DatabaseProvider, jsonOutput bool) error {
+	out := io.Writer(os.Stdout)
+	if jsonOutput {
+		out = os.Stderr
+	}
+	plan.Output = out
+
+	err := plan.Execute()
+	if err == nil {
+		err = exportAfterDeploy(ctx, db, out)
+	}
+	if !jsonOutput {
+		return err
+	}
+
+	// A failed deploy still reports the tasks that ran and the state it left
+	state, stateErr := zdd.BuildState(modules, db)
+	if stateErr != nil && err == nil {
+		return stateErr
+	}
+	result := zdd.NewApplyResult(plan, state)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if printErr := printJSON(result); printErr != nil && err == nil {
+		return printErr
+	}
+	return err
+}
+
+func applyCommand(ctx context.Context, cmd *cli.Command) error {
+	if !cmd.Bool("auto-approve") {
+		return fmt.Errorf("apply never prompts for confirmation; pass --auto-approve to apply pending deployments")
+	}
+	jsonOutput, err := jsonFormat(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	jsonOutput = jsonOutput || cmd.Bool("json")
+
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDeploymentDatabase(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	plan, err := buildDeployPlan(ctx, cmd, modules, db)
+	if err != nil {
+		return err
+	}
+	return executeDeployPlan(ctx, plan, modules, db, jsonOutput)
+}
+
+func contractCommand(ctx context.Context, cmd *cli.Command) error {
+	if !cmd.Bool("cleanup-views") {
+		return fmt.Errorf("nothing to do; pass --cleanup-views to drop compatibility views")
+	}
+
+	db, err := openDeploymentDatabase(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return zdd.CleanupCompatViews(db, os.Stdout)
+}
+
+func rollbackCommand(ctx context.Context, cmd *cli.Command) error {
+	module, err := selectModule(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDeploymentDatabase(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	plan, err := zdd.BuildRollbackPlan(module, db, cmd.StringArg("id"))
+	if err != nil {
+		return err
+	}
+	return plan.Rollback()
+}
+
+func purgeTrashCommand(ctx context.Context, cmd *cli.Command) error {
+	olderThan := configFromContext(ctx).Trash.RetentionDuration()
+	if cmd.IsSet("older-than") {
+		olderThan = cmd.Duration("older-than")
+	}
+	if olderThan < 0 {
+		return fmt.Errorf("--older-than must not be negative")
+	}
+
+	db, err := openDeploymentDatabase(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return zdd.PurgeTrash(db, olderThan, cmd.Bool("dry-run"), os.Stdout)
+}
+
+func packCommand(ctx context.Context, cmd *cli.Command) error {
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	pack, err := zdd.CompileModulesPack(modules)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(cmd.String("output"))
+	if err != nil {
+		return fmt.Errorf("failed to create pack file: %w", err)
+	}
+	if _, err := pack.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func renderCommand(ctx context.Context, cmd *cli.Command) error {
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	// Pending deployments are read from the database without changing it
+	var db zdd.DatabaseProvider
+	if !cmd.Bool("all") {
+		databaseURL := cmd.String("database-url")
+		if databaseURL == "" {
+			return fmt.Errorf("database URL is required to find pending deployments; pass --all to render every deployment")
+		}
+		if db, err = newDatabase(ctx, databaseURL); err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer db.Close()
+	}
+
+	plan, err := zdd.BuildModulesPlan(modules, db)
+	if err != nil {
+		return err
+	}
+	if err := plan.Skip(zdd.SkipOptions{
+		Scripts: cmd.Bool("skip-scripts"),
+		Phases:  cmd.StringSlice("phases"),
+	}); err != nil {
+		return err
+	}
+
+	plan.Trash = configFromContext(ctx).Trash.Enabled
+	project := configFromContext(ctx).Project
+	settings := map[string]string{"application_name": zdd.ApplicationName()}
+	if project.LockTimeout > 0 {
+		settings["lock_timeout"] = postgresDuration(project.LockTimeout)
+	}
+	if project.StatementTimeout > 0 {
+		settings["statement_timeout"] = postgresDuration(project.StatementTimeout)
+	}
+	if project.TargetSchema != "" {
+		settings["search_path"] = project.TargetSchema
+	}
+
+	file, err := os.Create(cmd.String("out"))
+	if err != nil {
+		return fmt.Errorf("failed to create script file: %w", err)
+	}
+	if err := plan.Render(file, zdd.RenderOptions{Preamble: postgres.SetupSchemaSQL(), Settings: settings}); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write script file: %w", err)
+	}
+
+	fmt.Printf("Rendered %d tasks to %s\n", len(plan.Tasks), cmd.String("out"))
+	return nil
+}
+
+func bundleBuildCommand(ctx context.Context, cmd *cli.Command) error {
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	root, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	relative := func(p string) (string, error) {
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s is outside the working directory, so it cannot be bundled", p)
+		}
+		return rel, nil
+	}
+
+	var paths []string
+	manifest := zdd.BundleManifest{Version: zdd.Version}
+	for _, module := range modules {
+		rel, err := relative(module.Path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, rel)
+		manifest.DeploymentsPath = filepath.ToSlash(rel)
+	}
+	if len(modules) > 1 || modules[0].Name != "" {
+		manifest.DeploymentsPath = "" // Modules come from the config file
+	}
+
+	if configPath := cmd.String("config"); configPath != "" {
+		if _, err := os.Stat(configPath); err == nil {
+			abs, err := filepath.Abs(configPath)
+			if err != nil {
+				return fmt.Errorf("failed to resolve config path: %w", err)
+			}
+			rel, err := relative(abs)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, rel)
+			manifest.Config = filepath.ToSlash(rel)
+		}
+	}
+
+	executable := cmd.String("zdd-binary")
+	if executable == "" {
+		if executable, err = os.Executable(); err != nil {
+			return fmt.Errorf("failed to find the zdd executable: %w", err)
+		}
+	}
+
+	output := cmd.String("output")
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	if err := zdd.BuildBundle(file, executable, root, paths, manifest); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	fmt.Printf("Bundle written to %s; run it with DATABASE_URL set to deploy\n", output)
+	return nil
+}
+
+func cutoverCommand(ctx context.Context, cmd *cli.Command) error {
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	blueURL := cmd.String("database-url")
+	if blueURL == "" {
+		return fmt.Errorf("database URL of the blue database is required for a cutover")
+	}
+
+	config := configFromContext(ctx).Cutover
+	if cmd.Bool("restart") {
+		if err := os.Remove(config.StatePath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove cutover state: %w", err)
+		}
+	}
+
+	cutover := &zdd.Cutover{
+		Config:  config,
+		BlueURL: blueURL,
+		Modules: modules,
+		Connect: func(url string) (zdd.DatabaseProvider, error) {
+			return newDatabase(ctx, url)
+		},
+		Migrate: func(green zdd.DatabaseProvider) error {
+			plan, err := buildDeployPlan(ctx, cmd, modules, green)
+			if err != nil {
+				return err
+			}
+			return plan.Execute()
+		},
+	}
+	return cutover.Run(ctx)
+}
+
+// buildState builds the state of the modules, listing the API resources that pending deployments
+// impact when the config maps tables to them
+func buildState(ctx context.Context, modules []zdd.Module, db zdd.DatabaseProvider) (*zdd.State, error) {
+	state, err := zdd.BuildState(modules, db)
+	if err != nil {
+		return nil, err
+	}
+	path := configFromContext(ctx).APIImpact.Mapping
+	if path == "" {
+		return state, nil
+	}
+	mapping, err := zdd.LoadAPIMapping(path)
+	if err != nil {
+		return nil, err
+	}
+	if state.APIImpacts, err = mapping.Impacts(modules, db); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func stateCommand(ctx context.Context, cmd *cli.Command) error {
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	// Connect to database if URL provided
+	var db zdd.DatabaseProvider
+	if databaseURL := cmd.String("database-url"); databaseURL != "" {
+		db, err = newDatabase(ctx, databaseURL)
+		if err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer db.Close()
+	}
+
+	if format, templatePath := cmd.String("report"), cmd.String("template"); format != "" || templatePath != "" {
+		state, err := buildState(ctx, modules, db)
+		if err != nil {
+			return err
+		}
+		return zdd.WriteReport(os.Stdout, state, format, templatePath, configFromContext(ctx).Report)
+	}
+
+	jsonOutput := cmd.Bool("json")
+	if !cmd.IsSet("json") {
+		jsonOutput = configFromContext(ctx).Project.Format == "json"
+	}
+	if !jsonOutput {
+		out := newPagedOutput(cmd)
+		if err := zdd.WriteModuleList(out, modules, db, tableStyle(cmd)); err != nil {
+			return err
+		}
+		return out.Close()
+	}
+
+	state, err := buildState(ctx, modules, db)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(state)
+}
+
+// unlockCommand clears the heartbeats and running deployments of deploys that have gone
+// --stale-after without a heartbeat, unless their session still holds its lock
+func unlockCommand(ctx context.Context, cmd *cli.Command) error {
+	staleAfter := configFromContext(ctx).Project.StaleAfterDuration()
+	if cmd.IsSet("stale-after") {
+		staleAfter = cmd.Duration("stale-after")
+	}
+	if staleAfter <= 0 {
+		return fmt.Errorf("--stale-after must be positive")
+	}
+
+	db, err := openDeploymentDatabase(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	clearer, ok := db.(zdd.StaleRunClearer)
+	if !ok {
+		return fmt.Errorf("database does not support clearing stale deploys")
+	}
+	cleared, held, err := clearer.ClearStaleRuns(staleAfter)
+	if err != nil {
+		return err
+	}
+
+	for _, run := range cleared {
+		fmt.Printf("Cleared run %s by %s, last seen %s\n", run.RunID, run.RunBy, run.UpdatedAt.Format(time.RFC3339))
+	}
+	for _, run := range held {
+		fmt.Fprintf(os.Stderr, "Run %s by %s (pid %d on %s) has no heartbeat since %s but its session still holds its lock; stop that process first\n", run.RunID, run.RunBy, run.PID, run.Host, run.UpdatedAt.Format(time.RFC3339))
+	}
+	if len(held) > 0 {
+		return fmt.Errorf("%d stale deploys are still connected and were not cleared", len(held))
+	}
+	if len(cleared) == 0 {
+		fmt.Printf("No deploys without a heartbeat for %s\n", staleAfter)
+	}
+	return nil
+}
+
+// openDeploymentDatabase connects to the required database and initializes the deployment schema
+func openDeploymentDatabase(ctx context.Context, cmd *cli.Command) (zdd.DatabaseProvider, error) {
+	databaseURL := cmd.String("database-url")
+	if databaseURL == "" {
+		return nil, fmt.Errorf("database URL is required for deployments")
+	}
+
+	// Connect to database
+	db, err := newDatabase(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Initialize deployment schema
+	if err := db.InitDeploymentSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize deployment schema: %w", err)
+	}
+
+	// Deploying without the history of an early zdd release would reapply its deployments
+	if upgrader, ok := db.(zdd.LegacyHistoryUpgrader); ok {
+		legacy, err := upgrader.HasLegacyHistory()
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if legacy {
+			db.Close()
+			return nil, fmt.Errorf("the database has deployment history in zdd_migrations from an earlier zdd; run `zdd upgrade-metadata` first")
+		}
+	}
+
+	return db, nil
+}
+
+// buildDeployPlan builds the plan for deploy and apply, honouring the skip flags and script policy
+func buildDeployPlan(ctx context.Context, cmd *cli.Command, modules []zdd.Module, db zdd.DatabaseProvider) (*zdd.Plan, error) {
+	plan, err := buildPlan(ctx, modules, db)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Takeover, err = configFromContext(ctx).Project.TakeoverPolicy(); err != nil {
+		return nil, err
+	}
+	plan.Takeover.Force = cmd.Bool("takeover")
+	plan.DeployLockTimeout = time.Duration(configFromContext(ctx).Project.DeployLockTimeout)
+	if cmd.IsSet("deploy-lock-timeout") {
+		plan.DeployLockTimeout = cmd.Duration("deploy-lock-timeout")
+	}
+
+	if err := plan.Skip(zdd.SkipOptions{
+		Scripts: cmd.Bool("skip-scripts"),
+		SQL:     cmd.Bool("skip-sql"),
+		Phases:  cmd.StringSlice("phases"),
+	}); err != nil {
+		return nil, err
+	}
+
+	if plan.Approvals, err = zdd.ParseApprovals(cmd.StringSlice("approval")); err != nil {
+		return nil, err
+	}
+
+	if err := applyScriptPolicy(ctx, cmd, plan); err != nil {
+		return nil, err
+	}
+
+	warnings, err := plan.CheckCDC(configFromContext(ctx).CDC)
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !cmd.Bool("skip-contract-check") {
+		issues, err := plan.CheckContracts()
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range issues {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", issue)
+		}
+		if len(issues) > 0 {
+			return nil, fmt.Errorf("%d contract statements drop objects that will not exist; fix them or pass --skip-contract-check", len(issues))
+		}
+	}
+
+	if cmd.Bool("verify-rollbacks") {
+		if err := verifyRollbacks(ctx, cmd, plan, modules, db); err != nil {
+			return nil, err
+		}
+	}
+
+	// Estimates are advisory, so problems reading the history never block a deploy
+	if err := loadEstimates(ctx, cmd, plan, db); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: no duration estimates: %v\n", err)
+	}
+
+	return plan, nil
+}
+
+// buildPlan builds the plan of the modules with the settings of the config file and the tasks it
+// adds: assertions, validations, view cleanup, replica checks, extensions and RLS checks. Scripts
+// of phases that run for the head deployment only are dropped from the others.
+func buildPlan(ctx context.Context, modules []zdd.Module, db zdd.DatabaseProvider) (*zdd.Plan, error) {
+	config := configFromContext(ctx)
+	plan, err := zdd.BuildModulesPlan(modules, db)
+	if err != nil {
+		return nil, err
+	}
+	if err := configurePlan(ctx, plan); err != nil {
+		return nil, err
+	}
+
+	if err := plan.AddAssertions(config.Asserts); err != nil {
+		return nil, err
+	}
+
+	if err := plan.AddValidations(config.Validations); err != nil {
+		return nil, err
+	}
+
+	if err := plan.AddViewCleanup(); err != nil {
+		return nil, err
+	}
+
+	if err := plan.AddReplicaChecks(replicaCheck(ctx)); err != nil {
+		return nil, err
+	}
+
+	if err := plan.AddExtensions(config.Extensions); err != nil {
+		return nil, err
+	}
+
+	if err := plan.RequireRLS(config.RLS); err != nil {
+		return nil, err
+	}
+
+	if err := plan.SkipHeadOnly(config.HeadOnlyPhases()); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// verifyRollbacks shadow-applies the plan's deployments and their revert SQL to a scratch
+// database on the target's server, and fails if a rollback does not restore the prior schema
+func verifyRollbacks(ctx context.Context, cmd *cli.Command, plan *zdd.Plan, modules []zdd.Module, db zdd.DatabaseProvider) error {
+	scratch, err := postgres.NewScratchDatabase(ctx, db.ConnectionString(), cmd.String("scratch-database"), databaseOptions(ctx)...)
+	if err != nil {
+		return err
+	}
+	defer scratch.Close(ctx)
+
+	results, err := plan.VerifyRollbacks(modules, scratch.DB)
+	if err != nil {
+		return fmt.Errorf("failed to verify rollbacks: %w", err)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Passed {
+			fmt.Fprintf(os.Stderr, "Rollback of %s verified\n", result.Key())
+			continue
+		}
+		failed++
+		fmt.Fprintf(os.Stderr, "Error: rollback of %s does not restore the prior schema:\n", result.Key())
+		for _, difference := range result.Differences {
+			fmt.Fprintf(os.Stderr, "  %s\n", difference)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d rollbacks failed verification; fix them or deploy without --verify-rollbacks", failed)
+	}
+	return nil
+}
+
+// replicaCheck compares the replicas of the config file with the primary's schema, or is nil
+// without replicas
+func replicaCheck(ctx context.Context) *zdd.ReplicaCheck {
+	config := configFromContext(ctx).Replicas
+	if len(config.URLs) == 0 {
+		return nil
+	}
+	return &zdd.ReplicaCheck{
+		URLs:    config.URLs,
+		Timeout: config.TimeoutDuration(),
+		Connect: func(url string) (zdd.ReplicaConn, error) {
+			return postgres.OpenReplica(ctx, url, databaseOptions(ctx)...)
+		},
+	}
+}
+
+// loadEstimates estimates task durations from the run history in --history-url, or in the
+// target database when it keeps one
+func loadEstimates(ctx context.Context, cmd *cli.Command, plan *zdd.Plan, db zdd.DatabaseProvider) error {
+	if historyURL := cmd.String("history-url"); historyURL != "" {
+		historyDB, err := newDatabase(ctx, historyURL)
+		if err != nil {
+			return fmt.Errorf("failed to connect to history database: %w", err)
+		}
+		defer historyDB.Close()
+		db = historyDB
+	}
+
+	history, ok := db.(zdd.TaskHistory)
+	if !ok {
+		return nil
+	}
+	return plan.LoadEstimates(history)
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func resetCommand(ctx context.Context, cmd *cli.Command) error {
+	if !cmd.Bool("dev") {
+		return fmt.Errorf("reset drops the target database and requires the --dev flag")
+	}
+
+	databaseURL := cmd.String("database-url")
+
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	if databaseURL == "" {
+		return fmt.Errorf("database URL is required for reset")
+	}
+
+	if err := postgres.ResetDatabase(ctx, databaseURL, cmd.StringSlice("protected-url")); err != nil {
+		return fmt.Errorf("failed to reset database: %w", err)
+	}
+	fmt.Println("Database reset")
+
+	db, err := newDatabase(ctx, databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	plan, err := buildPlan(ctx, modules, db)
+	if err != nil {
+		return err
+	}
+
+	if err := applyScriptPolicy(ctx, cmd, plan); err != nil {
+		return err
+	}
+
+	if err := plan.Execute(); err != nil {
+		return err
+	}
+
+	return zdd.ApplySeeds(cmd.String("seeds-path"), db)
+}
+
+func genGoCommand(ctx context.Context, cmd *cli.Command) error {
+	module, err := selectModule(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	deployments, err := zdd.LoadModuleDeployments(module)
+	if err != nil {
+		return fmt.Errorf("failed to load deployments: %w", err)
+	}
+
+	src, err := zdd.GenerateGo(deployments, cmd.String("package"))
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(cmd.String("output"), src)
+}
+
+func genK8sJobCommand(ctx context.Context, cmd *cli.Command) error {
+	config := configFromContext(ctx).Kubernetes
+
+	generate := zdd.GenerateKubernetesJob
+	if cmd.Bool("init-container") {
+		generate = zdd.GenerateKubernetesInitContainer
+	}
+
+	manifest, err := generate(config)
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(cmd.String("output"), manifest)
+}
+
+// writeOutput writes content to path, or to stdout when path is empty
+func writeOutput(path string, content []byte) error {
+	if path == "" {
+		_, err := os.Stdout.Write(content)
+		return err
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func adviseIndexesCommand(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Int("max-scans") < 0 {
+		return fmt.Errorf("--max-scans must not be negative")
+	}
+
+	module, err := selectModule(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	db, err := newDatabase(ctx, cmd.String("database-url"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	inspector, ok := db.(zdd.IndexInspector)
+	if !ok {
+		return fmt.Errorf("database does not report index usage")
+	}
+	advice, err := zdd.AdviseIndexes(module, inspector, int64(cmd.Int("max-scans")))
+	if err != nil {
+		return err
+	}
+
+	if advice.StatsReset.IsZero() {
+		fmt.Println("Index statistics have never been reset")
+	} else {
+		fmt.Printf("Index statistics were last reset %s\n", advice.StatsReset.Local().Format("2006-01-02 15:04:05 MST"))
+	}
+	if len(advice.Suggestions) == 0 {
+		fmt.Println("No unused indexes created by deployments")
+		return nil
+	}
+
+	for _, suggestion := range advice.Suggestions {
+		index := suggestion.Index
+		fmt.Printf("\n%s.%s on %s: %d scans, %s, created by %s - %s\n", index.Schema, index.Name, index.Table, index.Scans, zdd.FormatBytes(index.SizeBytes), suggestion.CreatedBy.ID, suggestion.CreatedBy.Name)
+		fmt.Printf("  %s\n", suggestion.SQL)
+	}
+	fmt.Println("\nScans on replicas are not counted; check them before dropping an index")
+
+	if !cmd.Bool("create") {
+		return nil
+	}
+	deployment, err := zdd.CreateIndexContractDeployment(module, cmd.String("name"), advice)
+	if err != nil {
+		return fmt.Errorf("failed to create deployment: %w", err)
+	}
+	fmt.Printf("Created deployment %s\n", deployment.Directory)
+	return nil
+}
+
+func blameCommand(ctx context.Context, cmd *cli.Command) error {
+	kind := cmd.StringArg("kind")
+	name := cmd.StringArg("name")
+	if kind == "" || name == "" {
+		return fmt.Errorf("object kind and name are required")
+	}
+
+	scratchURL := cmd.String("scratch-url")
+	if scratchURL == "" {
+		return fmt.Errorf("scratch database URL is required for blame")
+	}
+
+	module, err := selectModule(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	// The scratch database is recreated so the replay starts from an empty schema
+	if err := postgres.ResetDatabase(ctx, scratchURL, cmd.StringSlice("protected-url")); err != nil {
+		return fmt.Errorf("failed to reset scratch database: %w", err)
+	}
+
+	db, err := postgres.NewDB(ctx, scratchURL, databaseOptions(ctx)...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to scratch database: %w", err)
+	}
+	defer db.Close()
+
+	result, err := zdd.Blame(module, db, kind, name)
+	if err != nil {
+		return err
+	}
+
+	out := newPagedOutput(cmd)
+	fmt.Fprint(out, result)
+	return out.Close()
+}
+
+func schemaHashCommand(ctx context.Context, cmd *cli.Command) error {
+	scratchURL := cmd.String("scratch-url")
+	if scratchURL == "" {
+		return fmt.Errorf("scratch database URL is required for schema-hash")
+	}
+
+	module, err := selectModule(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	// The scratch database is recreated so the replay starts from an empty schema
+	if err := postgres.ResetDatabase(ctx, scratchURL, cmd.StringSlice("protected-url")); err != nil {
+		return fmt.Errorf("failed to reset scratch database: %w", err)
+	}
+
+	db, err := postgres.NewDB(ctx, scratchURL, databaseOptions(ctx)...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to scratch database: %w", err)
+	}
+	defer db.Close()
+
+	changed, err := zdd.GenerateSchemaHashes(module, db)
+	if err != nil {
+		return err
+	}
+	for _, deployment := range changed {
+		fmt.Printf("Recorded schema hash %s for deployment %s\n", deployment.SchemaHash[:12], deployment.Key())
+	}
+	if len(changed) == 0 {
+		fmt.Println("Schema hashes are up to date")
+	}
+	return nil
+}
+
+func benchCommand(ctx context.Context, cmd *cli.Command) error {
+	id := cmd.StringArg("id")
+	if id == "" {
+		return fmt.Errorf("deployment ID is required")
+	}
+	sampleURL := cmd.String("sample-url")
+	if sampleURL == "" {
+		return fmt.Errorf("sample database URL is required for bench")
+	}
+
+	module, err := selectModule(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	db, err := postgres.NewDB(ctx, sampleURL, databaseOptions(ctx)...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to sample database: %w", err)
+	}
+	defer db.Close()
+
+	result, err := zdd.Bench(module, id, db)
+	if err != nil {
+		return err
+	}
+
+	out := newPagedOutput(cmd)
+	fmt.Fprint(out, result)
+	return out.Close()
+}
+
+func changelogCommand(ctx context.Context, cmd *cli.Command) error {
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	db, err := newDatabase(ctx, cmd.String("database-url"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	changelog, err := zdd.BuildChangelog(modules, db, cmd.String("since"))
+	if err != nil {
+		return err
+	}
+	changelog.LinkTickets(configFromContext(ctx).Jira.URL)
+	return changelog.WriteMarkdown(os.Stdout)
+}
+
+func deprecationsCommand(ctx context.Context, cmd *cli.Command) error {
+	db, err := newDatabase(ctx, cmd.String("database-url"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 	defer db.Close()
 
-	// Initialize deployment schema
-	if err := db.InitDeploymentSchema(); err != nil {
-		return fmt.Errorf("failed to initialize deployment schema: %w", err)
+	var deprecations []zdd.Deprecation
+	if cmd.Bool("registered") {
+		registry, ok := db.(zdd.DeprecationRegistry)
+		if !ok {
+			return fmt.Errorf("database does not keep a deprecation registry")
+		}
+		if deprecations, err = registry.GetDeprecations(); err != nil {
+			return err
+		}
+	} else {
+		modules, err := selectModules(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		plan, err := zdd.BuildModulesPlan(modules, db)
+		if err != nil {
+			return err
+		}
+		if deprecations, err = plan.RegisterDeprecations(modules); err != nil {
+			return err
+		}
 	}
 
-	// Build and execute plan
-	plan, err := zdd.BuildPlan(deploymentsPath, db)
+	if cmd.Bool("json") {
+		if deprecations == nil {
+			deprecations = []zdd.Deprecation{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(deprecations)
+	}
+	return zdd.WriteDeprecations(os.Stdout, deprecations)
+}
+
+func checkUsageCommand(ctx context.Context, cmd *cli.Command) error {
+	db, err := newDatabase(ctx, cmd.String("database-url"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	var deprecations []zdd.Deprecation
+	if cmd.Bool("registered") {
+		registry, ok := db.(zdd.DeprecationRegistry)
+		if !ok {
+			return fmt.Errorf("database does not keep a deprecation registry")
+		}
+		if deprecations, err = registry.GetDeprecations(); err != nil {
+			return err
+		}
+	} else {
+		modules, err := selectModules(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		plan, err := zdd.BuildModulesPlan(modules, db)
+		if err != nil {
+			return err
+		}
+		if deprecations, err = plan.Deprecations(); err != nil {
+			return err
+		}
+	}
+
+	issues, err := zdd.CheckGoUsage(cmd.StringSlice("go"), deprecations)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("%d references to tables and columns that pending contract phases drop", len(issues))
+	}
+	fmt.Printf("No references to the %d tables and columns that pending contract phases drop\n", len(deprecations))
+	return nil
+}
+
+func exportCommand(ctx context.Context, cmd *cli.Command) error {
+	db, err := newDatabase(ctx, cmd.String("database-url"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	format := cmd.String("format")
+	if format == "" {
+		exports := configFromContext(ctx).Exports
+		if len(exports) == 0 {
+			return fmt.Errorf("nothing to export; pass --format or configure exports in the config file")
+		}
+		return zdd.ExportSchema(db, exports, os.Stdout)
+	}
+
+	export := zdd.ExportConfig{Format: format, Path: cmd.String("output"), Package: cmd.String("package")}
+	if export.Path != "" {
+		return zdd.ExportSchema(db, []zdd.ExportConfig{export}, os.Stdout)
+	}
+	exporter, ok := db.(zdd.SchemaExporter)
+	if !ok {
+		return fmt.Errorf("database cannot export its schema")
+	}
+	schema, err := exporter.ExportSchema()
+	if err != nil {
+		return err
+	}
+	content, err := schema.Render(export)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(content)
+	return err
+}
+
+// exportAfterDeploy regenerates the config's exports from the migrated schema
+func exportAfterDeploy(ctx context.Context, db zdd.DatabaseProvider, w io.Writer) error {
+	exports := configFromContext(ctx).Exports
+	if len(exports) == 0 {
+		return nil
+	}
+	if err := zdd.ExportSchema(db, exports, w); err != nil {
+		return fmt.Errorf("deployed, but failed to export the schema: %w", err)
+	}
+	return nil
+}
+
+func approveCommand(ctx context.Context, cmd *cli.Command) error {
+	id := cmd.StringArg("id")
+	if id == "" {
+		return fmt.Errorf("deployment ID is required")
+	}
+
+	module, err := selectModule(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	deployments, err := zdd.LoadModuleDeployments(module)
+	if err != nil {
+		return fmt.Errorf("failed to load local deployments: %w", err)
+	}
+	i := slices.IndexFunc(deployments, func(d zdd.Deployment) bool { return d.ID == id })
+	if i < 0 {
+		return fmt.Errorf("deployment %s not found", id)
+	}
+	deployment := deployments[i]
+
+	scope := cmd.String("scope")
+	required := false
+	for _, task := range deployment.Tasks() {
+		if task.TaskType != "sql" {
+			continue
+		}
+		scopes, err := zdd.RequiredApprovals(task.Path)
+		if err != nil {
+			return err
+		}
+		required = required || slices.Contains(scopes, scope)
+	}
+	if !required {
+		fmt.Fprintf(os.Stderr, "Warning: no SQL file of deployment %s requires %s approval\n", deployment.Key(), scope)
+	}
+
+	db, err := newDatabase(ctx, cmd.String("database-url"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	store, ok := db.(zdd.ApprovalStore)
+	if !ok {
+		return fmt.Errorf("database does not record approvals")
+	}
+	if err := zdd.Approve(store, deployment, scope, cmd.String("token")); err != nil {
+		return err
+	}
+	fmt.Printf("Approved deployment %s for %s\n", deployment.Key(), scope)
+	return nil
+}
+
+func testCommand(ctx context.Context, cmd *cli.Command) error {
+	if !cmd.Bool("chaos") {
+		return fmt.Errorf("nothing to test; pass --chaos")
+	}
+
+	scratchURL := cmd.String("scratch-url")
+	if scratchURL == "" {
+		return fmt.Errorf("scratch database URL is required for test")
+	}
+	// Checked up front, as every scenario recreates the scratch database
+	if err := postgres.CheckResetSafety(scratchURL, cmd.StringSlice("protected-url")); err != nil {
+		return err
+	}
+
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the zdd executable: %w", err)
+	}
+
+	// Deploys run as separate processes, so they can be killed, with the same project settings
+	args := []string{
+		"--config", cmd.String("config"),
+		"--deployments-path", cmd.String("deployments-path"),
+		"--database-url", scratchURL,
+		"--no-color",
+	}
+	if cmd.IsSet("module") {
+		args = append(args, "--module", cmd.String("module"))
+	}
+	if cmd.IsSet("allow-scripts") {
+		args = append(args, fmt.Sprintf("--allow-scripts=%t", cmd.Bool("allow-scripts")))
+	}
+
+	results, err := zdd.RunChaos(zdd.ChaosOptions{
+		Modules: modules,
+		Faults:  cmd.StringSlice("fault"),
+		Reset: func() error {
+			return postgres.ResetDatabase(ctx, scratchURL, cmd.StringSlice("protected-url"))
+		},
+		Open: func() (zdd.DatabaseProvider, error) {
+			return newDatabase(ctx, scratchURL)
+		},
+		Deploy: func(takeover bool) *exec.Cmd {
+			deployArgs := append(slices.Clone(args), "deploy")
+			if takeover {
+				deployArgs = append(deployArgs, "--takeover")
+			}
+			return exec.CommandContext(ctx, exe, deployArgs...)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("\n--- %s: output of its deploys ---\n%s", result.Scenario, result.Output)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d scenarios violated the failure model", failed, len(results))
+	}
+	fmt.Printf("All %d scenarios passed\n", len(results))
+	return nil
+}
+
+// doctorCommand reports the zdd version and the database's metadata schema version. Connecting
+// fails if the schema was migrated by a newer zdd.
+func doctorCommand(ctx context.Context, cmd *cli.Command) error {
+	fmt.Printf("zdd:             %s\n", zdd.Version)
+
+	databaseURL := cmd.String("database-url")
+	if databaseURL == "" {
+		return fmt.Errorf("database URL is required")
+	}
+	db, err := postgres.NewDB(ctx, databaseURL, databaseOptions(ctx)...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	fmt.Println("Database:        connected")
+
+	// Connecting migrates the metadata schema, so it is now at the version of this build
+	version, err := db.MetadataVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Metadata schema: version %d (this zdd supports up to %d)\n", version, postgres.MetadataSchemaVersion())
+
+	legacy, err := db.HasLegacyHistory()
+	if err != nil {
+		return err
+	}
+	if legacy {
+		fmt.Println("Legacy history:  zdd_migrations needs upgrading; run `zdd upgrade-metadata`")
+	}
+	return nil
+}
+
+// upgradeMetadataCommand copies the history of the zdd_migrations schema of early releases into
+// zdd_deployments
+func upgradeMetadataCommand(ctx context.Context, cmd *cli.Command) error {
+	db, err := newDatabase(ctx, cmd.String("database-url"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	upgrader, ok := db.(zdd.LegacyHistoryUpgrader)
+	if !ok {
+		return fmt.Errorf("database does not support upgrading legacy history")
+	}
+	legacy, err := upgrader.HasLegacyHistory()
+	if err != nil {
+		return err
+	}
+	if !legacy {
+		fmt.Println("No zdd_migrations history to upgrade")
+		return nil
+	}
+
+	upgraded, err := upgrader.UpgradeLegacyHistory()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recorded %d deployments from zdd_migrations; the old schema was kept as zdd_migrations_upgraded\n", upgraded)
+	return nil
+}
+
+func versionCommand(ctx context.Context, cmd *cli.Command) error {
+	info := zdd.CurrentBuildInfo()
+	if cmd.Bool("json") {
+		return printJSON(info)
+	}
+
+	fmt.Println(info)
+	return nil
+}
+
+func selfUpdateCommand(ctx context.Context, cmd *cli.Command) error {
+	release, err := selfupdate.LatestRelease(ctx, selfupdate.DefaultRepository)
+	if err != nil {
+		return err
+	}
+
+	if !isNewerVersion(release.Version(), zdd.Version) {
+		fmt.Printf("zdd %s is the latest version\n", zdd.Version)
+		return nil
+	}
+
+	if cmd.Bool("check") {
+		fmt.Printf("zdd %s is available (current: %s)\n", release.Version(), zdd.Version)
+		return nil
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+	exePath, err = filepath.EvalSymlinks(exePath)
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	if err := selfupdate.Update(ctx, release, exePath); err != nil {
+		return fmt.Errorf("failed to update zdd: %w", err)
+	}
+
+	fmt.Printf("Updated zdd %s -> %s\n", zdd.Version, release.Version())
+	return nil
+}
+
+// updateNotice prints a notice on stderr when update_check is enabled and a newer release exists.
+// The check is bounded by a short timeout so it never noticeably delays a command.
+func updateNotice(ctx context.Context, cmd *cli.Command) error {
+	if !configFromContext(ctx).UpdateCheck || cmd.Args().First() == "self-update" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if notice := selfupdate.NewerVersionNotice(ctx, selfupdate.DefaultRepository, zdd.Version, isNewerVersion); notice != "" {
+		fmt.Fprintln(os.Stderr, notice)
+	}
+	return nil
+}
+
+// isNewerVersion reports whether latest is a later release than current
+func isNewerVersion(latest, current string) bool {
+	return zdd.CheckVersion(latest, ">"+current) == nil
+}
+
+// loadConfig reads the project config file, stores it in the context, and uses its
+// project section as the default for any flag not set on the command line or environment
+func loadConfig(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+	config, err := zdd.LoadConfig(cmd.String("config"))
+	if err != nil {
+		return ctx, err
+	}
+
+	if config.RequiredVersion != "" {
+		if err := zdd.CheckVersion(zdd.Version, config.RequiredVersion); err != nil {
+			return ctx, err
+		}
+	}
+
+	defaults := map[string]string{
+		"deployments-path": config.Project.DeploymentsPath,
+		"database-url":     config.Project.DatabaseURL,
+	}
+	for name, value := range defaults {
+		if value == "" || cmd.IsSet(name) {
+			continue
+		}
+		if err := cmd.Set(name, value); err != nil {
+			return ctx, fmt.Errorf("failed to apply config default for --%s: %w", name, err)
+		}
+	}
+
+	if config.Project.UTC && !cmd.IsSet("utc") {
+		if err := cmd.Set("utc", "true"); err != nil {
+			return ctx, fmt.Errorf("failed to apply config default for --utc: %w", err)
+		}
+	}
+
+	return context.WithValue(ctx, configKey{}, config), nil
+}
+
+// configFromContext returns the project config loaded by loadConfig
+func configFromContext(ctx context.Context) *zdd.Config {
+	if config, ok := ctx.Value(configKey{}).(*zdd.Config); ok {
+		return config
+	}
+	return &zdd.Config{}
+}
+
+// configurePlan applies the settings of the config file that are not about scripts to the plan
+func configurePlan(ctx context.Context, plan *zdd.Plan) error {
+	config := configFromContext(ctx)
+	plan.TargetSchema = config.Project.TargetSchema
+	plan.RestorePoints = config.Project.RestorePoints
+	plan.SizeReport = config.Project.SizeReport
+	plan.ContractPacing = config.ContractPacing
+	plan.Retry = config.Retry
+	plan.Trash = config.Trash.Enabled
+
+	flags, err := zdd.NewFlagProvider(config.FeatureFlags)
+	if err != nil {
+		return err
+	}
+	plan.Flags = flags
+	return nil
+}
+
+// applyScriptPolicy disallows scripts in the plan unless the --allow-scripts flag, or
+// allow_scripts in the config file when the flag is unset, permits them. Allowed scripts
+// run in the sandbox configured in the config file, if any.
+func applyScriptPolicy(ctx context.Context, cmd *cli.Command, plan *zdd.Plan) error {
+	config := configFromContext(ctx)
+	plan.Sandbox = config.Sandbox
+	plan.ScriptTimeout = time.Duration(config.Project.ScriptTimeout)
+	plan.ScriptGracePeriod = time.Duration(config.Project.ScriptGracePeriod)
+
+	allowed := config.ScriptsAllowed()
+	if cmd.IsSet("allow-scripts") {
+		allowed = cmd.Bool("allow-scripts")
+	}
+
+	// Record the configuration as merged with the command line, so it shows what was in force
+	effective := *config
+	effective.AllowScripts = &allowed
+	effective.Project.DeploymentsPath = cmd.String("deployments-path")
+	effective.Project.DatabaseURL = cmd.String("database-url")
+	snapshot, err := zdd.NewConfigSnapshot(effective, os.Environ())
 	if err != nil {
 		return err
 	}
+	plan.ConfigSnapshot = snapshot
+
+	if allowed {
+		return nil
+	}
+	return plan.DisallowScripts()
+}
+
+// selectModules returns the modules to operate on: the module chosen with --module, every module
+// in the config file, or the deployments path as the default module when none are configured
+func selectModules(ctx context.Context, cmd *cli.Command) ([]zdd.Module, error) {
+	configured := configFromContext(ctx).Modules
+	selected := cmd.String("module")
+
+	if len(configured) == 0 {
+		if selected != "" {
+			return nil, fmt.Errorf("module %q selected but no modules are configured", selected)
+		}
+		configured = []zdd.Module{{Path: cmd.String("deployments-path")}}
+	}
+
+	var modules []zdd.Module
+	for _, module := range configured {
+		if selected != "" && module.Name != selected {
+			continue
+		}
+
+		path, err := resolveDeploymentsPath(module.Path)
+		if err != nil {
+			return nil, err
+		}
+		module.Path = path
+		module.Layout = configFromContext(ctx).Layout()
+		modules = append(modules, module)
+	}
+
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("unknown module %q", selected)
+	}
+
+	return modules, nil
+}
+
+// selectModule is selectModules for commands that operate on a single module
+func selectModule(ctx context.Context, cmd *cli.Command) (zdd.Module, error) {
+	modules, err := selectModules(ctx, cmd)
+	if err != nil {
+		return zdd.Module{}, err
+	}
+
+	if len(modules) > 1 {
+		return zdd.Module{}, fmt.Errorf("multiple modules are configured; select one with --module")
+	}
 
-	return plan.Execute()
+	return modules[0], nil
 }
 
 // resolveDeploymentsPath converts a relative path to absolute, returns path unchanged if already absolute or empty
@@ -160,13 +2651,46 @@ func resolveDeploymentsPath(path string) (string, error) {
 	return path, nil
 }
 
-// newDatabase creates a new database connection
-// Currently only supports PostgreSQL
+// newDatabase creates a new database connection: a SQLite database file for sqlite: URLs, and
+// PostgreSQL otherwise
 func newDatabase(ctx context.Context, databaseURL string) (zdd.DatabaseProvider, error) {
 	if databaseURL == "" {
 		return nil, fmt.Errorf("database URL is required")
 	}
 
-	// For now, we only support PostgreSQL
-	return postgres.NewDB(ctx, databaseURL)
+	if sqlite.IsURL(databaseURL) {
+		db, err := sqlite.NewDB(ctx, databaseURL)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+	db, err := postgres.NewDB(ctx, databaseURL, databaseOptions(ctx)...)
+	if err != nil {
+		return nil, err
+	}
+	db.SetBatchStatements(configFromContext(ctx).Project.BatchStatements)
+	return db, nil
+}
+
+// databaseOptions applies the session settings from the project config
+func databaseOptions(ctx context.Context) []postgres.Option {
+	project := configFromContext(ctx).Project
+
+	opts := []postgres.Option{postgres.WithRuntimeParam("application_name", zdd.ApplicationName())}
+	if project.LockTimeout > 0 {
+		opts = append(opts, postgres.WithRuntimeParam("lock_timeout", postgresDuration(project.LockTimeout)))
+	}
+	if project.StatementTimeout > 0 {
+		opts = append(opts, postgres.WithRuntimeParam("statement_timeout", postgresDuration(project.StatementTimeout)))
+	}
+	if project.TargetSchema != "" {
+		opts = append(opts, postgres.WithRuntimeParam("search_path", project.TargetSchema))
+	}
+	return opts
+}
+
+// postgresDuration formats d as a PostgreSQL interval setting in milliseconds
+func postgresDuration(d zdd.Duration) string {
+	return fmt.Sprintf("%dms", time.Duration(d).Milliseconds())
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/urfave/cli/v3"
+)
+
+// pagedOutput buffers a command's output and, when closed, pipes it through a pager if it is
+// taller than the terminal, like git does. Otherwise the output is written straight to stdout.
+type pagedOutput struct {
+	buf     bytes.Buffer
+	enabled bool
+}
+
+// newPagedOutput returns the writer long-output commands print to. Paging is disabled by
+// --no-pager, or when stdout is not a terminal.
+func newPagedOutput(cmd *cli.Command) *pagedOutput {
+	return &pagedOutput{enabled: !cmd.Bool("no-pager") && isTerminal(os.Stdout)}
+}
+
+func (o *pagedOutput) Write(p []byte) (int, error) {
+	return o.buf.Write(p)
+}
+
+// Close flushes the buffered output to the pager or stdout
+func (o *pagedOutput) Close() error {
+	height := terminalHeight(os.Stdout)
+	if !o.enabled || height == 0 || bytes.Count(o.buf.Bytes(), []byte("\n")) < height {
+		_, err := io.Copy(os.Stdout, &o.buf)
+		return err
+	}
+
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+
+	// Honour the pager command's own arguments, as git does, by running it through the shell
+	page := exec.Command("/bin/sh", "-c", pager)
+	page.Stdin = &o.buf
+	page.Stdout = os.Stdout
+	page.Stderr = os.Stderr
+	// Let less pass colors through and exit immediately if the output fits after all
+	if os.Getenv("LESS") == "" {
+		page.Env = append(os.Environ(), "LESS=FRX")
+	}
+
+	if err := page.Run(); err != nil {
+		// Fall back to plain output if the pager is missing or fails before reading anything
+		if o.buf.Len() > 0 {
+			_, err = io.Copy(os.Stdout, &o.buf)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// terminalHeight returns the terminal height from $LINES, or 0 if unknown
+func terminalHeight(f *os.File) int {
+	lines, _ := strconv.Atoi(os.Getenv("LINES"))
+	return lines
+}
@@ -0,0 +1,259 @@
+package zdd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultConfigFile = "zdd.yaml"
+)
+
+// envVarPattern matches ${VAR} and ${VAR:-fallback} references in config values
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// schemaNamePattern matches schema names that need no quoting, for project.target_schema
+var schemaNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+type (
+	// Config holds project settings read from zdd.yaml
+	Config struct {
+		// RequiredVersion is a constraint such as ">=0.3" the running zdd binary must satisfy
+		RequiredVersion string `yaml:"required_version"`
+		// AllowScripts permits phase scripts to run; nil means the default (true)
+		AllowScripts *bool `yaml:"allow_scripts"`
+		// Sandbox constrains how phase scripts are executed
+		Sandbox *SandboxConfig `yaml:"sandbox"`
+		// Project holds defaults for CLI flags and execution settings
+		Project ProjectConfig `yaml:"project"`
+		// Modules lists independent deployment trees; when empty the deployments path is the only tree
+		Modules []Module `yaml:"modules"`
+		// Kubernetes parameterises `zdd gen k8s-job`
+		Kubernetes KubernetesConfig `yaml:"kubernetes"`
+		// Asserts are checked after the contract phase of the deployments they name
+		Asserts []Assertion `yaml:"asserts"`
+		// Validations compare old and new columns before the contract phase of the deployments they name
+		Validations []Validation `yaml:"validations"`
+		// Extensions are installed or updated before the first expand task, e.g. [pg_trgm, postgis>=3.3]
+		Extensions []Extension `yaml:"extensions"`
+		// RLS requires row-level security on tables created by deployments
+		RLS RLSConfig `yaml:"rls"`
+		// CDC controls the checks for DDL that breaks logical replication or CDC consumers
+		CDC CDCConfig `yaml:"cdc"`
+		// Cutover configures blue/green cutovers with `zdd cutover`
+		Cutover CutoverConfig `yaml:"cutover"`
+		// UpdateCheck prints a notice on stderr when a newer zdd release is available
+		UpdateCheck bool `yaml:"update_check"`
+		// Phases replaces the phase list, in execution order, e.g. [preflight, expand, migrate,
+		// contract, verify, post]; it must include the built-in phases in their usual order
+		Phases []string `yaml:"phases"`
+		// Scripts configures the scripts of each phase, keyed by phase name
+		Scripts map[string]ScriptConfig `yaml:"scripts"`
+		// IDs configures the width of deployment IDs, their separator from the name and an
+		// optional date prefix
+		IDs IDFormat `yaml:"ids"`
+		// Jira configures the Jira instance `zdd create --from-jira` fetches issues from
+		Jira JiraConfig `yaml:"jira"`
+		// Runners configures external commands that run SQL files and scripts, e.g. psql or python3
+		Runners Runners `yaml:"runners"`
+		// Trash keeps tables and columns dropped by contract SQL until `zdd purge-trash`
+		Trash TrashConfig `yaml:"trash"`
+		// Retry re-runs idempotent deployments that fail on a deadlock or serialization failure
+		Retry RetryConfig `yaml:"retry"`
+		// ContractPacing pauses and checks the database's health between the destructive
+		// statements of contract SQL files
+		ContractPacing ContractPacingConfig `yaml:"contract_pacing"`
+		// Replicas must have the primary's schema before each deployment's contract phase runs
+		Replicas ReplicasConfig `yaml:"replicas"`
+		// Report localizes the phase names and labels of `zdd state --report`
+		Report ReportConfig `yaml:"report"`
+		// FeatureFlags configures the provider setting the feature flags of deployments' flags.yaml
+		FeatureFlags FeatureFlagsConfig `yaml:"feature_flags"`
+		// Exports are regenerated from the migrated schema after every deploy, e.g. a schema.sql
+		// for sqlc
+		Exports []ExportConfig `yaml:"exports"`
+		// APIImpact lists the API resources that pending deployments impact in state reports
+		APIImpact APIImpactConfig `yaml:"api_impact"`
+	}
+
+	// ScriptConfig configures the scripts of a phase
+	ScriptConfig struct {
+		// HeadOnly runs the scripts only for the last pending deployment of each module
+		HeadOnly bool `yaml:"head_only"`
+	}
+
+	// ProjectConfig holds project-wide defaults; command line flags and environment variables take precedence
+	ProjectConfig struct {
+		DeploymentsPath string `yaml:"deployments_path"`
+		DatabaseURL     string `yaml:"database_url"`
+		// ScriptTimeout bounds each phase script (default 5m); a sandbox timeout takes precedence
+		ScriptTimeout Duration `yaml:"script_timeout"`
+		// ScriptGracePeriod is how long a timed-out script has to exit after SIGTERM before it
+		// and the processes it started are killed (default 10s)
+		ScriptGracePeriod Duration `yaml:"script_grace_period"`
+		// LockTimeout and StatementTimeout set the PostgreSQL settings of the same name for every session
+		LockTimeout      Duration `yaml:"lock_timeout"`
+		StatementTimeout Duration `yaml:"statement_timeout"`
+		// UTC renders timestamps in UTC as RFC3339 instead of local time
+		UTC bool `yaml:"utc"`
+		// Format is the output format of commands that print text or JSON when no flag selects
+		// one: "text" (the default) or "json"
+		Format string `yaml:"format"`
+		// BatchStatements pipelines the statements of a SQL file in one round trip instead of
+		// sending the file as a single simple query
+		BatchStatements bool `yaml:"batch_statements"`
+		// Takeover is "stale" (the default) to take over deployments left running by a deploy
+		// that has gone StaleAfter without a heartbeat, or "manual" to require --takeover or zdd unlock
+		Takeover string `yaml:"takeover"`
+		// StaleAfter is how long a deploy may go without a heartbeat before it counts as
+		// abandoned (default 2m)
+		StaleAfter Duration `yaml:"stale_after"`
+		// DeployLockTimeout is how long a deploy waits for another deploy on the same database to
+		// finish before failing (default 0, fail at once)
+		DeployLockTimeout Duration `yaml:"deploy_lock_timeout"`
+		// NonExecutableScripts is "error" (the default) to fail on phase scripts without the
+		// executable bit, "interpreter" to run them with the interpreter of their #! line, or
+		// "skip" to ignore them
+		NonExecutableScripts string `yaml:"non_executable_scripts"`
+		// TargetSchema is the schema deployment SQL and scripts run in, set as their search_path,
+		// so several applications can share a database. Without modules, deployments are
+		// recorded under a module named after it.
+		TargetSchema string `yaml:"target_schema"`
+		// RestorePoints creates a PostgreSQL restore point (pg_create_restore_point) before each
+		// deployment's contract phase and records its name with the deployment
+		RestorePoints bool `yaml:"restore_points"`
+		// SizeReport reports how each deployment's migrate and contract phases change the size
+		// and estimated bloat of the tables its SQL touches
+		SizeReport bool `yaml:"size_report"`
+	}
+)
+
+// LoadConfig reads the config file at path. A missing file yields the default config.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		path = defaultConfigFile
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		return &Config{}, nil // Empty file
+	}
+
+	interpolateEnv(&doc)
+
+	if err := validateConfig(path, &doc); err != nil {
+		return nil, fmt.Errorf("invalid config file:\n%w", err)
+	}
+
+	var config Config
+	if err := doc.Decode(&config); err != nil {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			configErr.File = path
+		}
+		return nil, fmt.Errorf("invalid config file: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, module := range config.Modules {
+		if module.Name == "" || module.Path == "" {
+			return nil, fmt.Errorf("invalid config file %s: every module needs a name and a path", path)
+		}
+		if seen[module.Name] {
+			return nil, fmt.Errorf("invalid config file %s: duplicate module %q", path, module.Name)
+		}
+		seen[module.Name] = true
+	}
+
+	if err := config.Layout().Check(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	phases := builtinPhases
+	if len(config.Phases) > 0 {
+		phases = config.Phases
+	}
+	for phase := range config.Scripts {
+		if !slices.Contains(phases, phase) {
+			return nil, fmt.Errorf("invalid config file %s: scripts configured for unknown phase %q (expected one of %v)", path, phase, phases)
+		}
+	}
+
+	if format := config.Project.Format; format != "" && format != "text" && format != "json" {
+		return nil, fmt.Errorf("invalid config file %s: invalid project.format %q (expected text or json)", path, format)
+	}
+	if schema := config.Project.TargetSchema; schema != "" && !schemaNamePattern.MatchString(schema) {
+		return nil, fmt.Errorf("invalid config file %s: invalid project.target_schema %q (expected a lower-case unquoted identifier)", path, schema)
+	}
+	for _, export := range config.Exports {
+		if err := export.check(); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	return &config, nil
+}
+
+// ScriptsAllowed reports whether phase scripts may be executed
+func (c *Config) ScriptsAllowed() bool {
+	return c.AllowScripts == nil || *c.AllowScripts
+}
+
+// HeadOnlyPhases returns the sorted phases whose scripts run only for the head deployment
+func (c *Config) HeadOnlyPhases() []string {
+	var phases []string
+	for phase, scripts := range c.Scripts {
+		if scripts.HeadOnly {
+			phases = append(phases, phase)
+		}
+	}
+	slices.Sort(phases)
+	return phases
+}
+
+// interpolateEnv replaces ${VAR} and ${VAR:-fallback} in every scalar value (not key) of node.
+// As in the shell, the fallback is used when VAR is unset or empty, and an unset VAR without
+// a fallback expands to the empty string.
+func interpolateEnv(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		if !envVarPattern.MatchString(node.Value) {
+			return
+		}
+
+		node.Value = envVarPattern.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			matches := envVarPattern.FindStringSubmatch(ref)
+			if value := os.Getenv(matches[1]); value != "" {
+				return value
+			}
+			return matches[2]
+		})
+
+		// Let plain scalars be re-resolved so e.g. ${ALLOW_SCRIPTS} can expand to a bool
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+		return
+	}
+
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue // Keys are never interpolated
+		}
+		interpolateEnv(child)
+	}
+}
@@ -0,0 +1,200 @@
+package zdd
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// ConfigError describes a problem at a specific position in a config file
+	ConfigError struct {
+		File   string
+		Line   int
+		Column int
+		Msg    string
+	}
+)
+
+var unmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// validateConfig checks the parsed document against the shape of Config, reporting every
+// unknown key and structurally wrong value rather than silently ignoring them
+func validateConfig(file string, doc *yaml.Node) error {
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		doc = doc.Content[0]
+	}
+
+	var errs []error
+	validateNode(file, doc, reflect.TypeFor[Config](), "", &errs)
+	return errors.Join(errs...)
+}
+
+// validateNode validates node against t, appending problems to errs
+func validateNode(file string, node *yaml.Node, t reflect.Type, path string, errs *[]error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Types with custom decoding validate themselves when decoded
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	fail := func(n *yaml.Node, format string, args ...any) {
+		*errs = append(*errs, &ConfigError{File: file, Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			fail(node, "%s must be a mapping", describePath(path))
+			return
+		}
+
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				msg := fmt.Sprintf("unknown key %q in %s", key.Value, describePath(path))
+				if suggestion := closestKey(key.Value, fields); suggestion != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+				}
+				fail(key, "%s", msg)
+				continue
+			}
+			validateNode(file, value, field.Type, joinPath(path, key.Value), errs)
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			fail(node, "%s must be a list", describePath(path))
+			return
+		}
+		for i, item := range node.Content {
+			validateNode(file, item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			fail(node, "%s must be a mapping", describePath(path))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			validateNode(file, value, t.Elem(), joinPath(path, key.Value), errs)
+		}
+
+	case reflect.Interface:
+		// Any value is accepted
+
+	default:
+		if node.Kind != yaml.ScalarNode {
+			fail(node, "%s must be a %s", describePath(path), kindName(t.Kind()))
+			return
+		}
+		// Decode the scalar on its own to catch wrong types such as `allow_scripts: maybe`
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			fail(node, "%s must be a %s, got %q", describePath(path), kindName(t.Kind()), node.Value)
+		}
+	}
+}
+
+// yamlFields maps yaml keys to the struct fields they decode into
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// closestKey returns the known key within edit distance 2 of key, if any
+func closestKey(key string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for candidate := range fields {
+		if d := editDistance(key, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+
+	return prev[len(b)]
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "config"
+	}
+	return path
+}
+
+// kindName describes a scalar kind in the terms used by YAML authors
+func kindName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	default:
+		return kind.String()
+	}
+}
@@ -0,0 +1,311 @@
+package zdd
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	createTablePattern  = regexp.MustCompile(`(?is)^CREATE\s+(?:(?:GLOBAL|LOCAL|TEMP|TEMPORARY|UNLOGGED)\s+)*TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([^\s(]+)\s*(.*)$`)
+	alterTablePattern   = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?([^\s(]+)\s+(.*)$`)
+	dropTablePattern    = regexp.MustCompile(`(?is)^DROP\s+TABLE\s+(IF\s+EXISTS\s+)?(.*?)(?:\s+(?:CASCADE|RESTRICT))?$`)
+	addColumnPattern    = regexp.MustCompile(`(?is)^ADD\s+(?:COLUMN\s+)?(?:IF\s+NOT\s+EXISTS\s+)?([^\s,]+)`)
+	dropColumnPattern   = regexp.MustCompile(`(?is)^DROP\s+(?:COLUMN\s+)?(IF\s+EXISTS\s+)?([^\s,]+)`)
+	renameColumnPattern = regexp.MustCompile(`(?is)^RENAME\s+(?:COLUMN\s+)?([^\s,]+)\s+TO\s+([^\s,]+)`)
+	renameTablePattern  = regexp.MustCompile(`(?is)^RENAME\s+TO\s+([^\s,]+)`)
+
+	// constraintKeywords start table elements and ALTER TABLE actions that are not columns
+	constraintKeywords = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "EXCLUDE", "LIKE"}
+)
+
+// schemaModel tracks the tables and columns that replayed SQL creates and drops. Objects it has
+// not seen are unknown, since they may predate the replayed deployments.
+type schemaModel struct {
+	objects map[string]bool // "table:t" and "column:t.c"; false once dropped
+	open    map[string]bool // Tables whose columns are not known, such as CREATE TABLE ... AS
+}
+
+// CheckContracts replays the plan's SQL against a model of the tables and columns it creates
+// and drops, and reports contract statements that drop a table or column neither an earlier
+// task nor the database has, or that drop a validated column before its replacement from
+// validate.yaml exists. Drops with IF EXISTS are not reported. Objects the model has not seen
+// are looked up in the database if it is a SchemaInspector; once a script has run, they are
+// assumed to exist, since scripts may create anything.
+func (p *Plan) CheckContracts() ([]LintIssue, error) {
+	model := schemaModel{objects: make(map[string]bool), open: make(map[string]bool)}
+	inspector, _ := p.db.(SchemaInspector)
+	scriptRan := false
+
+	// exists reports whether the object exists at this point of the plan, and whether that is
+	// known, given what the model knows of it
+	exists := func(kind, name string, state, known bool) (bool, bool, error) {
+		if known {
+			return state, true, nil
+		}
+		if scriptRan || inspector == nil {
+			return false, false, nil
+		}
+		definition, err := inspector.DescribeObject(kind, name)
+		if err != nil {
+			return false, false, err
+		}
+		return definition != "", true, nil
+	}
+
+	var issues []LintIssue
+	for _, task := range p.Tasks {
+		if task.TaskType == "script" {
+			scriptRan = true
+		}
+		if task.TaskType != "sql" {
+			continue
+		}
+
+		content, err := readPsqlFile(task.Path, func(string) {})
+		if err != nil {
+			return nil, err
+		}
+		for _, statement := range scanStatements(content) {
+			sql := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(statement.SQL, " "))
+			drops := model.apply(sql)
+			if task.Phase != "contract" {
+				continue
+			}
+
+			for _, drop := range drops {
+				issue := func(check, format string, args ...any) {
+					issues = append(issues, LintIssue{Path: task.Path, Line: statement.Line, Check: check, Message: fmt.Sprintf(format, args...)})
+				}
+
+				if !drop.ifExists {
+					found, known, err := exists(drop.kind, drop.name, drop.existed, drop.known)
+					if err != nil {
+						return nil, err
+					}
+					if known && !found {
+						issue("contract-missing-object", "drops %s %s, which no earlier task creates and the database does not have", drop.kind, drop.name)
+					}
+				}
+
+				for _, v := range task.Deployment.Validations {
+					table := normalizeName(v.Table)
+					if drop.name != table+"."+normalizeName(v.Column) && drop.name != table {
+						continue
+					}
+					newTable := v.NewTable
+					if newTable == "" {
+						newTable = v.Table
+					}
+					replacement := normalizeName(newTable) + "." + normalizeName(v.NewColumn)
+					state, stateKnown := model.state("column", replacement)
+					found, known, err := exists("column", replacement, state, stateKnown)
+					if err != nil {
+						return nil, err
+					}
+					if known && !found {
+						issue("contract-missing-replacement", "drops %s %s before its replacement %s exists; create it in an earlier phase or deployment", drop.kind, drop.name, replacement)
+					}
+				}
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// modelDrop is a table or column dropped by a statement, and what the model knew of it before
+type modelDrop struct {
+	kind     string
+	name     string
+	ifExists bool
+	existed  bool
+	known    bool
+}
+
+// state returns whether the model has the object, and whether it knows
+func (m schemaModel) state(kind, name string) (bool, bool) {
+	if kind == "column" {
+		table := name[:max(strings.LastIndex(name, "."), 0)]
+		tableExists, tableKnown := m.objects["table:"+table]
+		switch {
+		case tableKnown && !tableExists:
+			return false, true
+		case m.open[table]:
+			return false, false
+		case tableKnown:
+			// The model saw the table created, so it knows all of its columns
+			return m.objects["column:"+name], true
+		}
+	}
+	exists, known := m.objects[kind+":"+name]
+	return exists, known
+}
+
+// apply replays a statement, without comments, on the model and returns what it drops
+func (m schemaModel) apply(sql string) []modelDrop {
+	drop := func(kind, name string, ifExists bool) modelDrop {
+		existed, known := m.state(kind, name)
+		return modelDrop{kind: kind, name: name, ifExists: ifExists, existed: existed, known: known}
+	}
+
+	if match := createTablePattern.FindStringSubmatch(sql); match != nil {
+		table := normalizeName(match[1])
+		m.objects["table:"+table] = true
+		delete(m.open, table)
+		columns, ok := tableColumns(match[2])
+		if !ok {
+			m.open[table] = true
+		}
+		for _, column := range columns {
+			m.objects["column:"+table+"."+column] = true
+		}
+		return nil
+	}
+
+	if match := dropTablePattern.FindStringSubmatch(sql); match != nil {
+		var drops []modelDrop
+		for _, name := range strings.Split(match[2], ",") {
+			table := normalizeName(name)
+			drops = append(drops, drop("table", table, match[1] != ""))
+			m.objects["table:"+table] = false
+			delete(m.open, table)
+		}
+		return drops
+	}
+
+	match := alterTablePattern.FindStringSubmatch(sql)
+	if match == nil {
+		return nil
+	}
+	table := normalizeName(match[1])
+	var drops []modelDrop
+	for _, action := range splitTopLevel(match[2]) {
+		if len(strings.Fields(action)) < 2 {
+			continue
+		}
+		target := strings.ToUpper(strings.Fields(action)[1])
+		switch {
+		case renameTablePattern.MatchString(action):
+			renamed := normalizeName(renameTablePattern.FindStringSubmatch(action)[1])
+			m.renameTable(table, renamed)
+			table = renamed
+
+		case renameColumnPattern.MatchString(action) && target != "CONSTRAINT":
+			names := renameColumnPattern.FindStringSubmatch(action)
+			m.objects["column:"+table+"."+normalizeName(names[1])] = false
+			m.objects["column:"+table+"."+normalizeName(names[2])] = true
+
+		case addColumnPattern.MatchString(action) && !isConstraintKeyword(target):
+			m.objects["column:"+table+"."+normalizeName(addColumnPattern.FindStringSubmatch(action)[1])] = true
+
+		case dropColumnPattern.MatchString(action) && !isConstraintKeyword(target):
+			names := dropColumnPattern.FindStringSubmatch(action)
+			column := table + "." + normalizeName(names[2])
+			drops = append(drops, drop("column", column, names[1] != ""))
+			m.objects["column:"+column] = false
+		}
+	}
+	return drops
+}
+
+// renameTable moves a table and its columns to a new name
+func (m schemaModel) renameTable(from, to string) {
+	for key, exists := range m.objects {
+		if rest, ok := strings.CutPrefix(key, "column:"+from+"."); ok {
+			m.objects["column:"+to+"."+rest] = exists
+			delete(m.objects, key)
+		}
+	}
+	m.objects["table:"+from] = false
+	m.objects["table:"+to] = true
+	if m.open[from] {
+		m.open[to] = true
+		delete(m.open, from)
+	}
+}
+
+// tableColumns returns the column names of a CREATE TABLE definition after the table name; ok
+// is false when the columns cannot be known from it, as with AS, LIKE or PARTITION OF
+func tableColumns(definition string) ([]string, bool) {
+	if !strings.HasPrefix(definition, "(") {
+		return nil, false
+	}
+	depth, end := 0, -1
+	for i, c := range definition {
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			if depth--; depth == 0 {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return nil, false
+	}
+
+	var columns []string
+	for _, element := range splitTopLevel(definition[1:end]) {
+		fields := strings.Fields(element)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.EqualFold(fields[0], "LIKE") {
+			return columns, false
+		}
+		if !isConstraintKeyword(strings.ToUpper(fields[0])) {
+			columns = append(columns, normalizeName(fields[0]))
+		}
+	}
+	return columns, true
+}
+
+// splitTopLevel splits s at commas outside parentheses and quotes, trimming each part
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote rune
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// isConstraintKeyword reports whether an upper-case word starts something other than a column
+func isConstraintKeyword(word string) bool {
+	return slices.Contains(constraintKeywords, word)
+}
+
+// normalizeName folds an SQL name the way PostgreSQL does: unquoted parts are lower-cased and
+// quotes are removed. The public schema is dropped, so qualified and unqualified names match.
+func normalizeName(name string) string {
+	parts := strings.Split(strings.TrimSpace(name), ".")
+	for i, part := range parts {
+		if strings.HasPrefix(part, `"`) && strings.HasSuffix(part, `"`) && len(part) > 1 {
+			parts[i] = strings.ReplaceAll(part[1:len(part)-1], `""`, `"`)
+		} else {
+			parts[i] = strings.ToLower(part)
+		}
+	}
+	if len(parts) > 1 && parts[0] == "public" {
+		parts = parts[1:]
+	}
+	return strings.Join(parts, ".")
+}
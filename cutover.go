@@ -0,0 +1,269 @@
+package zdd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const defaultCutoverStateFile = ".zdd-cutover.json"
+
+// CutoverSteps are the steps of a blue/green cutover, in order
+var CutoverSteps = []string{"restore", "migrate", "verify", "switch"}
+
+type (
+	// CutoverConfig configures `zdd cutover`, which migrates a green copy of the blue database and
+	// switches traffic to it with user-provided scripts
+	CutoverConfig struct {
+		// GreenURL is the database restored from blue and migrated
+		GreenURL string `yaml:"green_url"`
+		// RestoreScript restores green from the blue primary
+		RestoreScript string `yaml:"restore_script"`
+		// SwitchScript moves traffic from blue to green
+		SwitchScript string `yaml:"switch_script"`
+		// StateFile records completed steps so an interrupted cutover resumes; default .zdd-cutover.json
+		StateFile string `yaml:"state_file"`
+		// RowCountTolerance is the fraction by which a table's row count may differ between blue and
+		// green, as blue keeps taking writes after the restore (default: 0)
+		RowCountTolerance float64 `yaml:"row_count_tolerance"`
+	}
+
+	// CutoverState is the progress of a cutover, kept in the state file between runs
+	CutoverState struct {
+		StartedAt time.Time            `json:"started_at"`
+		Completed map[string]time.Time `json:"completed"` // Completion time of each finished step
+	}
+
+	// Cutover runs a blue/green cutover, resuming after the steps its state file records
+	Cutover struct {
+		Config  CutoverConfig
+		BlueURL string
+		// Modules are the deployment trees green must be up to date with
+		Modules []Module
+		// Connect opens a database by URL
+		Connect func(url string) (DatabaseProvider, error)
+		// Migrate applies the pending deployments to the green database
+		Migrate func(green DatabaseProvider) error
+		// Output receives progress messages; nil writes to stdout
+		Output io.Writer
+	}
+
+	// valueQuerier is implemented by databases that can run ad-hoc queries
+	valueQuerier interface {
+		QueryValues(query string) ([][]string, error)
+	}
+)
+
+// StatePath returns the path of the cutover state file
+func (c CutoverConfig) StatePath() string {
+	if c.StateFile == "" {
+		return defaultCutoverStateFile
+	}
+	return c.StateFile
+}
+
+// Run executes the steps not completed by an earlier run, saving progress after each
+func (c *Cutover) Run(ctx context.Context) error {
+	switch {
+	case c.Config.GreenURL == "":
+		return fmt.Errorf("cutover.green_url is required")
+	case c.Config.RestoreScript == "" || c.Config.SwitchScript == "":
+		return fmt.Errorf("cutover.restore_script and cutover.switch_script are required")
+	case c.Config.GreenURL == c.BlueURL:
+		return fmt.Errorf("the green database must differ from the blue database")
+	}
+
+	state, err := LoadCutoverState(c.Config.StatePath())
+	if err != nil {
+		return err
+	}
+	if done, ok := state.Completed[CutoverSteps[len(CutoverSteps)-1]]; ok {
+		return fmt.Errorf("the cutover started at %s was completed at %s; remove %s to start another", state.StartedAt.Format(time.RFC3339), done.Format(time.RFC3339), c.Config.StatePath())
+	}
+
+	for i, step := range CutoverSteps {
+		if done, ok := state.Completed[step]; ok {
+			fmt.Fprintf(c.out(), "[%d/%d] %s: completed at %s\n", i+1, len(CutoverSteps), step, done.Format(time.RFC3339))
+			continue
+		}
+
+		fmt.Fprintf(c.out(), "[%d/%d] %s\n", i+1, len(CutoverSteps), step)
+		if err := c.runStep(ctx, step); err != nil {
+			return fmt.Errorf("cutover step %s failed (rerun to resume): %w", step, err)
+		}
+
+		state.Completed[step] = time.Now()
+		if err := state.Save(c.Config.StatePath()); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(c.out(), "Cutover completed: traffic is on the green database")
+	return nil
+}
+
+// LoadCutoverState reads the state file at path; a missing file starts a new cutover
+func LoadCutoverState(path string) (*CutoverState, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &CutoverState{StartedAt: time.Now(), Completed: make(map[string]time.Time)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cutover state %s: %w", path, err)
+	}
+
+	var state CutoverState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse cutover state %s: %w", path, err)
+	}
+	if state.Completed == nil {
+		state.Completed = make(map[string]time.Time)
+	}
+	return &state, nil
+}
+
+// Save writes the state to path
+func (s *CutoverState) Save(path string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cutover state: %w", err)
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write cutover state %s: %w", path, err)
+	}
+	return nil
+}
+
+// runStep executes a single cutover step
+func (c *Cutover) runStep(ctx context.Context, step string) error {
+	switch step {
+	case "restore":
+		return c.runScript(ctx, step, c.Config.RestoreScript)
+	case "switch":
+		return c.runScript(ctx, step, c.Config.SwitchScript)
+	}
+
+	green, err := c.Connect(c.Config.GreenURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to green database: %w", err)
+	}
+	defer green.Close()
+
+	if step == "migrate" {
+		if err := green.InitDeploymentSchema(); err != nil {
+			return fmt.Errorf("failed to initialize deployment schema: %w", err)
+		}
+		return c.Migrate(green)
+	}
+
+	blue, err := c.Connect(c.BlueURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to blue database: %w", err)
+	}
+	defer blue.Close()
+
+	return c.verify(blue, green)
+}
+
+// runScript runs a user-provided step script with both database URLs in its environment
+func (c *Cutover) runScript(ctx context.Context, step, script string) error {
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Env = append(os.Environ(),
+		"ZDD_CUTOVER_STEP="+step,
+		"ZDD_BLUE_DATABASE_URL="+c.BlueURL,
+		"ZDD_GREEN_DATABASE_URL="+c.Config.GreenURL,
+	)
+	cmd.Stdout = c.out()
+	cmd.Stderr = c.out()
+
+	fmt.Fprintf(c.out(), "  Executing %s script: %s\n", step, script)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("script %s failed: %w", script, err)
+	}
+	return nil
+}
+
+// verify checks that green has every deployment applied and that the row count of each table
+// it shares with blue is within the tolerance
+func (c *Cutover) verify(blue, green DatabaseProvider) error {
+	state, err := BuildState(c.Modules, green)
+	if err != nil {
+		return err
+	}
+	if !state.UpToDate {
+		return fmt.Errorf("green database is not up to date: %d pending and %d missing deployments", state.Pending, state.Missing)
+	}
+	fmt.Fprintf(c.out(), "  Schema: all %d deployments applied\n", len(state.Deployments))
+
+	blueQuerier, ok := blue.(valueQuerier)
+	greenQuerier, ok2 := green.(valueQuerier)
+	if !ok || !ok2 {
+		return fmt.Errorf("database does not support row count verification")
+	}
+
+	tables, err := blueQuerier.QueryValues("SELECT quote_ident(schemaname) || '.' || quote_ident(relname) FROM pg_stat_user_tables WHERE schemaname <> 'zdd_deployments' ORDER BY 1")
+	if err != nil {
+		return fmt.Errorf("failed to list blue tables: %w", err)
+	}
+
+	var compared, mismatches int
+	for _, row := range tables {
+		table := row[0]
+		exists, err := greenQuerier.QueryValues(fmt.Sprintf("SELECT to_regclass(%s) IS NOT NULL", quoteLiteral(table)))
+		if err != nil {
+			return fmt.Errorf("failed to look up %s in green: %w", table, err)
+		}
+		if exists[0][0] != "true" {
+			fmt.Fprintf(c.out(), "  Rows: %s not in green, skipped\n", table)
+			continue
+		}
+
+		blueCount, err := countRows(blueQuerier, table)
+		if err != nil {
+			return err
+		}
+		greenCount, err := countRows(greenQuerier, table)
+		if err != nil {
+			return err
+		}
+		compared++
+
+		if math.Abs(float64(blueCount-greenCount)) > c.Config.RowCountTolerance*float64(max(blueCount, 1)) {
+			fmt.Fprintf(c.out(), "  Rows: %s differs: blue %d, green %d\n", table, blueCount, greenCount)
+			mismatches++
+		}
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("%d tables differ in row count between blue and green", mismatches)
+	}
+	fmt.Fprintf(c.out(), "  Rows: %d tables match\n", compared)
+	return nil
+}
+
+// countRows returns the number of rows in table, an already quoted name
+func countRows(db valueQuerier, table string) (int64, error) {
+	rows, err := db.QueryValues("SELECT count(*) FROM " + table)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rows of %s: %w", table, err)
+	}
+	count, err := strconv.ParseInt(rows[0][0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse row count of %s: %w", table, err)
+	}
+	return count, nil
+}
+
+// out returns the writer for progress messages
+func (c *Cutover) out() io.Writer {
+	if c.Output == nil {
+		return os.Stdout
+	}
+	return c.Output
+}
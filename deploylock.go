@@ -0,0 +1,86 @@
+package zdd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrDeployInProgress is returned when another process holds the database's deploy lock
+var ErrDeployInProgress = errors.New("another deployment is in progress")
+
+// DeployLocker is implemented by databases that serialize deploys with a lock, so two processes
+// such as CI jobs cannot interleave their deployments
+type DeployLocker interface {
+	// LockDeploys takes the deploy lock, waiting up to timeout for another process to release
+	// it. It returns ErrDeployInProgress if the lock is still held after the timeout.
+	LockDeploys(timeout time.Duration) error
+	// UnlockDeploys releases the deploy lock, if held
+	UnlockDeploys() error
+}
+
+// lockDeploys takes the database's deploy lock, if the database has one, and returns the
+// function releasing it
+func (p *Plan) lockDeploys() (func(), error) {
+	locker, ok := p.db.(DeployLocker)
+	if !ok {
+		return func() {}, nil
+	}
+	if err := locker.LockDeploys(p.DeployLockTimeout); err != nil {
+		if errors.Is(err, ErrDeployInProgress) {
+			return nil, p.deployInProgress(err)
+		}
+		return nil, err
+	}
+	// The original error matters more than a failure to unlock, and closing the connection
+	// releases the lock anyway
+	return func() { locker.UnlockDeploys() }, nil
+}
+
+// checkAppliedMeanwhile fails if another deploy applied any of the plan's deployments after the
+// plan was built, such as while Execute waited for the deploy lock
+func (p *Plan) checkAppliedMeanwhile() error {
+	var modules []string
+	planned := make(map[string]bool)
+	for _, task := range slices.Concat(p.Skipped, p.Tasks) {
+		if key := task.Deployment.Key(); !p.AlreadyDeployed[key] {
+			planned[key] = true
+			if !slices.Contains(modules, task.Deployment.Module) {
+				modules = append(modules, task.Deployment.Module)
+			}
+		}
+	}
+	records, err := appliedRecords(p.db, modules)
+	if err != nil {
+		return fmt.Errorf("failed to get applied deployments: %w", err)
+	}
+	for _, record := range records {
+		if key := deploymentKey(record.Module, record.ID); planned[key] {
+			return fmt.Errorf("deployment %s was applied by another deploy while this one waited for the deploy lock; run the deploy again", key)
+		}
+	}
+	return nil
+}
+
+// deployInProgress describes the deploys that may hold the deploy lock, from their heartbeats
+func (p *Plan) deployInProgress(err error) error {
+	hint := "wait for it to finish or raise project.deploy_lock_timeout"
+	recorder, ok := p.db.(HeartbeatRecorder)
+	if !ok {
+		return fmt.Errorf("%w; %s", err, hint)
+	}
+	runs, runsErr := recorder.GetDeployRuns()
+	if runsErr != nil || len(runs) == 0 {
+		return fmt.Errorf("%w; %s", err, hint)
+	}
+	descriptions := make([]string, len(runs))
+	for i, run := range runs {
+		descriptions[i] = fmt.Sprintf("run %s by %s since %s", run.RunID, run.RunBy, run.StartedAt.Format(time.RFC3339))
+		if run.CurrentTask != "" {
+			descriptions[i] += ", at " + run.CurrentTask
+		}
+	}
+	return fmt.Errorf("%w (%s); %s", err, strings.Join(descriptions, "; "), hint)
+}
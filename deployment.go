@@ -1,20 +1,36 @@
 package zdd
 
 import (
+	"bytes"
 	"crypto/sha256"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 const (
 	deploymentsDir = "migrations"
+
+	// maxNameLength bounds deployment names, keeping directory names well within file system limits
+	maxNameLength = 64
 )
 
 var (
@@ -39,11 +55,13 @@ var (
 	//go:embed assets/contract.sql
 	contractSQLTemplate string
 
-	// Regex pattern for deployment directory naming
-	deploymentDirPattern = regexp.MustCompile(`^(\d{6})_(.+)$`)
+	// nonNameChars are replaced with an underscore in deployment names
+	nonNameChars = regexp.MustCompile(`[^a-z0-9]+`)
 
-	// Regex pattern for matching deployment sql and sh files
-	deploymentFilePattern = regexp.MustCompile(`^(expand|migrate|contract|post)\.(sh|sql)$`)
+	// transliterations spell letters in ASCII that do not decompose into an ASCII letter and accents
+	transliterations = map[rune]string{
+		'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'ł': "l", 'þ': "th", 'ı': "i",
+	}
 )
 
 type (
@@ -51,22 +69,72 @@ type (
 	Deployment struct {
 		ID        string
 		Name      string
+		Module    string // Empty for the default (unnamed) module
 		AppliedAt *time.Time
 		Phases    map[string]DeploymentPhase
 		Directory string
+		// SkippedTasks names tasks (e.g. "expand:script") left out when the deployment was applied
+		SkippedTasks []string
+		// Assertions are checked after the contract phase, from assert.sql or the config file
+		Assertions []Assertion
+		// Validations are checked before the contract phase, from validate.yaml or the config file
+		Validations []Validation
+		// CompatViews are created by the contract phase and tracked until a later deployment, from views.yaml
+		CompatViews []CompatView
+		// EnumValues are added before the expand SQL, from enums.yaml
+		EnumValues []EnumValues
+		// Partitions are maintained after the SQL of the phase each names, from partitions.yaml
+		Partitions []PartitionDirective
+		// Ticket is the issue tracker ticket the deployment implements, from ticket.yaml
+		Ticket *Ticket
+		// SchemaHash is the expected SchemaHash after the deployment, from zdd.yaml
+		SchemaHash string
+		// RestorePoint names the restore point created before the contract phase, if one was
+		RestorePoint string
+		// RevertPath is the deployment's revert.sql, which undoes the whole deployment when it is
+		// rolled back and which deploy never runs; empty if it has none
+		RevertPath string
+		// FeatureFlags are set after the phase each names, from flags.yaml
+		FeatureFlags []FeatureFlag
+		// PgTAPPaths are the deployment's pgTAP test files (*.pgtap.sql), run by name after the
+		// post phase
+		PgTAPPaths []string
+		// ConfigSnapshot is the JSON ConfigSnapshot of the deploy that applied it, if recorded
+		ConfigSnapshot json.RawMessage
+		// RunID is the RunID of the zdd invocation that applied it
+		RunID string
+		// AppliedChecksum is the CalculateChecksum recorded when it was applied, if any
+		AppliedChecksum string
+
+		// layout is the layout the deployment was loaded with; nil for the default one
+		layout *layout
 	}
 
 	// DeploymentDBRecord represents a deployment record in the zdd_deployments table
 	DeploymentDBRecord struct {
 		ID        string
 		Name      string
+		Module    string
 		AppliedAt time.Time
 		Checksum  string // Optional: for integrity checking
-	}
-
+		// SkippedTasks names tasks (e.g. "expand:script") left out when the deployment was applied
+		SkippedTasks []string
+		Ticket       string // Key of the deployment's ticket, if it had one
+		RestorePoint string // Restore point created before the contract phase, if one was
+		// ConfigSnapshot is the JSON ConfigSnapshot of the deploy that applied it, if recorded
+		ConfigSnapshot json.RawMessage
+		// RunID is the RunID of the zdd invocation that applied it
+		RunID string
+	}
+
+	// DeploymentPhase holds the files of a phase in the order they run: the unnumbered file
+	// (expand.sql) first, then numbered ones (expand.1.sql, expand.2.sql, ...) by number
 	DeploymentPhase struct {
-		ScriptFilePath *string
-		SQLFilePath    *string
+		ScriptFilePaths []string
+		SQLFilePaths    []string
+		// RevertFilePath is the phase's <phase>.revert.sql, which undoes the phase when the
+		// deployment is rolled back and which deploy never runs; empty if it has none
+		RevertFilePath string
 	}
 
 	// DeploymentStatus represents the status of deployments in the system
@@ -75,6 +143,39 @@ type (
 		Applied []Deployment
 		Pending []Deployment
 		Missing []Deployment // Deployments that exist in DB but not locally
+		// Attempts are the unfinished attempts at pending deployments, keyed by Deployment.Key
+		Attempts map[string]DeploymentAttempt
+	}
+
+	// DeploymentAttempt is an attempt at applying a deployment that is running or failed
+	DeploymentAttempt struct {
+		ID        string
+		Name      string
+		Module    string
+		Status    string // "running" or "failed"
+		StartedAt time.Time
+		RunBy     string // user@host of the zdd process
+		Error     string // Excerpt of the error a failed attempt stopped with
+	}
+
+	// ModuleRecordLister is implemented by databases that can return the applied deployments of
+	// selected modules only, so trees sharing the metadata tables do not read each other's history
+	ModuleRecordLister interface {
+		GetModuleAppliedDeployments(modules []string) ([]DeploymentDBRecord, error)
+	}
+
+	// AttemptLister is implemented by databases that keep failed and running deployment attempts
+	AttemptLister interface {
+		GetDeploymentAttempts() ([]DeploymentAttempt, error)
+	}
+
+	// LegacyHistoryUpgrader is implemented by databases that can upgrade the migration history
+	// recorded by early zdd releases, before the zdd_deployments schema
+	LegacyHistoryUpgrader interface {
+		HasLegacyHistory() (bool, error)
+		// UpgradeLegacyHistory records the legacy history as applied deployments and returns how
+		// many were recorded
+		UpgradeLegacyHistory() (int, error)
 	}
 
 	// DatabaseProvider interface abstracts database operations
@@ -89,8 +190,14 @@ type (
 	}
 )
 
-// LoadDeployments scans the deployments directory and loads all deployments
+// LoadDeployments scans the deployments directory and loads all deployments with the default
+// layout
 func LoadDeployments(deploymentsPath string) ([]Deployment, error) {
+	return loadDeployments(deploymentsPath, defaultLayout)
+}
+
+// loadDeployments scans the deployments directory and loads all deployments with the layout
+func loadDeployments(deploymentsPath string, l *layout) ([]Deployment, error) {
 	if deploymentsPath == "" {
 		deploymentsPath = deploymentsDir
 	}
@@ -105,40 +212,98 @@ func LoadDeployments(deploymentsPath string) ([]Deployment, error) {
 	}
 
 	deploymentDirs := make(map[string]string) // id -> directory name
+	var duplicates []string
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
 		}
 
-		matches := deploymentDirPattern.FindStringSubmatch(entry.Name())
+		matches := l.dirPattern.FindStringSubmatch(entry.Name())
 		if len(matches) != 3 {
 			continue // Skip directories that don't match deployment pattern
 		}
 
+		// Entries are sorted by name, so the first directory of an ID sorts before the others
 		id := matches[1]
+		if first, exists := deploymentDirs[id]; exists {
+			duplicates = append(duplicates, fmt.Sprintf("deployment ID %s is used by both %s and %s; renumber one of them", id, first, entry.Name()))
+			continue
+		}
 		deploymentDirs[id] = entry.Name()
 	}
+	if len(duplicates) > 0 {
+		return nil, errors.New(strings.Join(duplicates, "\n"))
+	}
+
+	ids := make([]string, 0, len(deploymentDirs))
+	for id := range deploymentDirs {
+		ids = append(ids, id)
+	}
+	// Deployments run in ID order. IDs are unique and of equal width, so sorting them as strings
+	// orders them numerically, independent of the order the filesystem lists directories in.
+	sort.Strings(ids)
+
+	// Load directories in parallel
+	deployments := make([]Deployment, len(ids))
+	errs := make([]error, len(ids))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			deployment, err := loadDeployment(deploymentsPath, id, deploymentDirs[id], l)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to load deployment %s: %w", id, err)
+				return
+			}
+			deployments[i] = *deployment
+		}()
+	}
+	wg.Wait()
 
-	var deployments []Deployment
-	for id, dirName := range deploymentDirs {
-		deployment, err := loadDeployment(deploymentsPath, id, dirName)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load deployment %s: %w", id, err)
-		}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return deployments, nil
+}
 
-		deployments = append(deployments, *deployment)
+// DeploymentIDs lists the IDs of the module's deployments without reading their files, for
+// callers such as shell completion that must be fast
+func DeploymentIDs(module Module) ([]string, error) {
+	l, err := module.Layout.compile()
+	if err != nil {
+		return nil, err
 	}
+	return deploymentIDs(module.Path, l)
+}
 
-	// Sort deployments by ID (which is sequential)
-	sort.Slice(deployments, func(i, j int) bool {
-		return deployments[i].ID < deployments[j].ID
-	})
+// deploymentIDs lists the IDs of the deployments in the deployments directory with the layout
+func deploymentIDs(deploymentsPath string, l *layout) ([]string, error) {
+	entries, err := os.ReadDir(deploymentsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read deployments directory: %w", err)
+	}
 
-	return deployments, nil
+	var ids []string
+	for _, entry := range entries {
+		if matches := l.dirPattern.FindStringSubmatch(entry.Name()); entry.IsDir() && len(matches) == 3 {
+			ids = append(ids, matches[1])
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
 }
 
-// loadFiles loads sql and script files for a deployment
+// loadFiles loads sql and script files for a deployment, with the deployment's layout
 func loadFiles(deployment *Deployment, deploymentPath string) error {
+	l := deployment.layout.orDefault()
 	entries, err := os.ReadDir(deploymentPath)
 	if err != nil {
 		return fmt.Errorf("failed to read deployment directory %s: %w", deploymentPath, err)
@@ -150,18 +315,116 @@ func loadFiles(deployment *Deployment, deploymentPath string) error {
 		}
 
 		name := entry.Name()
-		matches := deploymentFilePattern.FindStringSubmatch(name)
-		if len(matches) != 3 {
+		if name == assertFileName {
+			assertions, err := LoadAssertions(filepath.Join(deploymentPath, name))
+			if err != nil {
+				return err
+			}
+			deployment.Assertions = append(deployment.Assertions, assertions...)
+			continue
+		}
+
+		if name == validateFileName {
+			validations, err := LoadValidations(filepath.Join(deploymentPath, name))
+			if err != nil {
+				return err
+			}
+			deployment.Validations = append(deployment.Validations, validations...)
+			continue
+		}
+
+		if name == partitionsFileName {
+			directives, err := LoadPartitionDirectives(filepath.Join(deploymentPath, name))
+			if err != nil {
+				return err
+			}
+			for i, directive := range directives {
+				if !slices.Contains(l.phases, directive.Phase) {
+					return fmt.Errorf("%s: directive #%d: unknown phase %q (expected one of %v)", filepath.Join(deploymentPath, name), i+1, directive.Phase, l.phases)
+				}
+			}
+			deployment.Partitions = directives
+			continue
+		}
+
+		if name == enumsFileName {
+			enums, err := LoadEnumValues(filepath.Join(deploymentPath, name))
+			if err != nil {
+				return err
+			}
+			deployment.EnumValues = enums
+			continue
+		}
+
+		if name == ticketFileName {
+			ticket, err := LoadTicket(filepath.Join(deploymentPath, name))
+			if err != nil {
+				return err
+			}
+			deployment.Ticket = ticket
+			continue
+		}
+
+		if name == deploymentConfigFileName {
+			config, err := LoadDeploymentConfig(filepath.Join(deploymentPath, name))
+			if err != nil {
+				return err
+			}
+			deployment.SchemaHash = config.SchemaHash
+			continue
+		}
+
+		if name == revertFileName {
+			deployment.RevertPath = filepath.Join(deploymentPath, name)
+			continue
+		}
+		if phase, ok := strings.CutSuffix(name, revertSuffix); ok && slices.Contains(l.phases, phase) {
+			deploymentPhase := deployment.Phases[phase]
+			deploymentPhase.RevertFilePath = filepath.Join(deploymentPath, name)
+			deployment.Phases[phase] = deploymentPhase
+			continue
+		}
+
+		if name == viewsFileName {
+			views, err := LoadCompatViews(filepath.Join(deploymentPath, name))
+			if err != nil {
+				return err
+			}
+			deployment.CompatViews = views
+			continue
+		}
+
+		if name == flagsFileName {
+			flags, err := LoadFeatureFlags(filepath.Join(deploymentPath, name))
+			if err != nil {
+				return err
+			}
+			for _, flag := range flags {
+				if !slices.Contains(l.phases, flag.Phase) {
+					return fmt.Errorf("%s: flag %s: invalid phase %q (expected one of %v)", filepath.Join(deploymentPath, name), flag.Name, flag.Phase, l.phases)
+				}
+			}
+			deployment.FeatureFlags = flags
+			continue
+		}
+
+		if strings.HasSuffix(name, pgTAPSuffix) {
+			deployment.PgTAPPaths = append(deployment.PgTAPPaths, filepath.Join(deploymentPath, name))
+			continue
+		}
+
+		matches := l.filePattern.FindStringSubmatch(name)
+		if len(matches) != 4 {
 			continue
 		}
 
 		phase := matches[1]
-		fileType := matches[2]
+		fileType := matches[3]
 		filePath := filepath.Join(deploymentPath, name)
 
 		deploymentPhase := deployment.Phases[phase]
 		if fileType == "sql" {
-			deploymentPhase.SQLFilePath = &filePath
+			deploymentPhase.SQLFilePaths = append(deploymentPhase.SQLFilePaths, filePath)
 			deployment.Phases[phase] = deploymentPhase
 			continue
 		}
@@ -171,21 +434,59 @@ func loadFiles(deployment *Deployment, deploymentPath string) error {
 			return fmt.Errorf("failed to read file info: %w", err)
 		}
 
-		if info.Mode()&0111 != 0 {
-			deploymentPhase.ScriptFilePath = &filePath
+		accepted, err := l.acceptScript(filePath, info.Mode())
+		if err != nil {
+			return err
+		}
+		if accepted {
+			deploymentPhase.ScriptFilePaths = append(deploymentPhase.ScriptFilePaths, filePath)
 			deployment.Phases[phase] = deploymentPhase
 		}
 	}
 
+	if deployment.RevertPath != "" {
+		for _, deploymentPhase := range deployment.Phases {
+			if deploymentPhase.RevertFilePath != "" {
+				return fmt.Errorf("%s: has both %s and <phase>%s files; undo the deployment with one or the other", deploymentPath, revertFileName, revertSuffix)
+			}
+		}
+	}
+
+	// Directory entries are sorted by name, which puts expand.10.sql before expand.2.sql
+	for phase, deploymentPhase := range deployment.Phases {
+		sortPhaseFiles(deploymentPhase.SQLFilePaths, l)
+		sortPhaseFiles(deploymentPhase.ScriptFilePaths, l)
+		deployment.Phases[phase] = deploymentPhase
+	}
+
 	return nil
 }
 
-// loadDeployment loads a single deployment from its directory
-func loadDeployment(deploymentsPath, id, dirName string) (*Deployment, error) {
+// sortPhaseFiles sorts the files of a phase into the order they run: the unnumbered file first,
+// then numbered files by number
+func sortPhaseFiles(paths []string, l *layout) {
+	number := func(path string) int {
+		matches := l.filePattern.FindStringSubmatch(filepath.Base(path))
+		if len(matches) != 4 || matches[2] == "" {
+			return -1
+		}
+		n, _ := strconv.Atoi(matches[2])
+		return n
+	}
+	slices.SortStableFunc(paths, func(a, b string) int {
+		if n, m := number(a), number(b); n != m {
+			return n - m
+		}
+		return strings.Compare(a, b)
+	})
+}
+
+// loadDeployment loads a single deployment from its directory with the layout
+func loadDeployment(deploymentsPath, id, dirName string, l *layout) (*Deployment, error) {
 	deploymentPath := filepath.Join(deploymentsPath, dirName)
 
 	// Extract name from directory name
-	matches := deploymentDirPattern.FindStringSubmatch(dirName)
+	matches := l.dirPattern.FindStringSubmatch(dirName)
 	if len(matches) != 3 {
 		return nil, fmt.Errorf("invalid deployment directory name: %s", dirName)
 	}
@@ -195,6 +496,7 @@ func loadDeployment(deploymentsPath, id, dirName string) (*Deployment, error) {
 		Name:      matches[2],
 		Directory: deploymentPath,
 		Phases:    make(map[string]DeploymentPhase),
+		layout:    l,
 	}
 
 	if err := loadFiles(deployment, deploymentPath); err != nil {
@@ -205,65 +507,156 @@ func loadDeployment(deploymentsPath, id, dirName string) (*Deployment, error) {
 }
 
 // getNextDeploymentID determines the next sequential deployment ID by checking existing deployment directories
-func getNextDeploymentID(deploymentsPath string) (string, error) {
-	// Check if deployments directory exists
+func getNextDeploymentID(deploymentsPath string, l *layout) (string, error) {
+	ids, err := deploymentIDs(deploymentsPath, l)
+	if err != nil {
+		return "", err
+	}
+	return l.IDs.nextID(ids)
+}
+
+// deploymentName turns name into a name safe for directories on any file system: letters are
+// lower-cased and transliterated to ASCII where possible (é to e, ß to ss), anything but letters
+// and digits becomes a single underscore, and the result is cut to maxNameLength
+func deploymentName(name string) (string, error) {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(strings.ToLower(name)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue // Accents, separated from their letters by the decomposition
+		}
+		if spelled, ok := transliterations[r]; ok {
+			b.WriteString(spelled)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	sanitized := strings.Trim(nonNameChars.ReplaceAllString(b.String(), "_"), "_")
+	if len(sanitized) > maxNameLength {
+		sanitized = strings.TrimRight(sanitized[:maxNameLength], "_")
+	}
+	if sanitized == "" {
+		return "", fmt.Errorf("invalid deployment name %q: it needs letters or digits that can be written in ASCII", name)
+	}
+	return sanitized, nil
+}
+
+// checkNameCollision fails if a deployment in deploymentsPath already has the name, ignoring
+// case, which usually means the deployment was created twice and would otherwise be confusing
+// in status output and on case-insensitive file systems
+func checkNameCollision(deploymentsPath, name string, l *layout) error {
 	entries, err := os.ReadDir(deploymentsPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			// No deployments directory, start with ID 1
-			return "000001", nil
+			return nil
 		}
-		return "", fmt.Errorf("failed to read deployments directory: %w", err)
+		return fmt.Errorf("failed to read deployments directory: %w", err)
 	}
 
-	// Find the last deployment directory (entries are sorted, so last is highest)
-	var lastID string
-	for i := len(entries) - 1; i >= 0; i-- {
-		entry := entries[i]
-		if !entry.IsDir() {
-			continue
+	for _, entry := range entries {
+		matches := l.dirPattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() && len(matches) == 3 && strings.EqualFold(matches[2], name) {
+			return fmt.Errorf("deployment %s is already named %s; choose another name", matches[1], matches[2])
 		}
+	}
+	return nil
+}
 
-		// Extract ID from directory name (format: XXXXXX_name)
-		matches := deploymentDirPattern.FindStringSubmatch(entry.Name())
-		if len(matches) == 3 {
-			lastID = matches[1]
-			break
-		}
+// CreateDeployment creates a new deployment directory with the given name in the module
+func CreateDeployment(module Module, name string) (*Deployment, error) {
+	return createDeploymentFiles(module, name, blankDeploymentFiles())
+}
+
+// blankDeploymentFiles are the template files of a new deployment
+func blankDeploymentFiles() []deploymentFile {
+	return []deploymentFile{
+		{"expand.sql", expandSQLTemplate, 0644},
+		{"migrate.sql", migrateSQLTemplate, 0644},
+		{"contract.sql", contractSQLTemplate, 0644},
+		{"expand.sh", expandScriptTemplate, 0755},
+		{"migrate.sh", migrateScriptTemplate, 0755},
+		{"contract.sh", contractScriptTemplate, 0755},
+		{"post.sh", postScriptTemplate, 0755},
 	}
+}
 
-	// If no valid deployment directories found, start with ID 1
-	if lastID == "" {
-		return "000001", nil
+// CreateDeploymentFromSQL creates a deployment in the module whose phase runs a copy of the SQL
+// file at sqlPath. An empty name is taken from the file's name.
+func CreateDeploymentFromSQL(module Module, name, phase, sqlPath string) (*Deployment, error) {
+	l, err := module.Layout.compile()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(l.sqlPhases, phase) {
+		return nil, fmt.Errorf("invalid phase %q for SQL (expected one of %v)", phase, l.sqlPhases)
 	}
 
-	// Parse the last ID and increment it
-	idNum, err := strconv.Atoi(lastID)
+	content, err := os.ReadFile(sqlPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse deployment ID %s: %w", lastID, err)
+		return nil, fmt.Errorf("failed to read SQL file %s: %w", sqlPath, err)
+	}
+	if name == "" {
+		name = sanitizeName(strings.TrimSuffix(filepath.Base(sqlPath), filepath.Ext(sqlPath)))
+		if name == "" {
+			return nil, fmt.Errorf("cannot derive a deployment name from %s; pass one", sqlPath)
+		}
 	}
 
-	// Format next ID as 6-digit zero-padded string
-	return fmt.Sprintf("%06d", idNum+1), nil
+	return createDeploymentFiles(module, name, []deploymentFile{{phase + ".sql", string(content), 0644}})
+}
+
+// deploymentFile is a file written into a new deployment directory
+type deploymentFile struct {
+	name    string
+	content string
+	mode    os.FileMode
 }
 
-// CreateDeployment creates a new deployment directory with the given name
-func CreateDeployment(deploymentsPath, name string) (*Deployment, error) {
+// renderDeploymentFiles fills in the named templates of dir in fsys with data
+func renderDeploymentFiles(fsys fs.FS, dir string, names []string, data any) ([]deploymentFile, error) {
+	var files []deploymentFile
+	for _, name := range names {
+		tmpl, err := template.ParseFS(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s template: %w", name, err)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", name, err)
+		}
+		files = append(files, deploymentFile{name, buf.String(), 0644})
+	}
+	return files, nil
+}
+
+// createDeploymentFiles creates a deployment directory in the module with the next ID of its
+// layout and writes files into it
+func createDeploymentFiles(module Module, name string, files []deploymentFile) (*Deployment, error) {
+	deploymentsPath := module.Path
 	if deploymentsPath == "" {
 		deploymentsPath = deploymentsDir
 	}
+	l, err := module.Layout.compile()
+	if err != nil {
+		return nil, err
+	}
 
-	// Sanitize name
-	name = strings.ReplaceAll(name, " ", "_")
-	name = strings.ToLower(name)
+	name, err = deploymentName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNameCollision(deploymentsPath, name, l); err != nil {
+		return nil, err
+	}
 
 	// Get the next deployment ID
-	id, err := getNextDeploymentID(deploymentsPath)
+	id, err := getNextDeploymentID(deploymentsPath, l)
 	if err != nil {
 		return nil, fmt.Errorf("failed to determine next deployment ID: %w", err)
 	}
 
-	dirName := fmt.Sprintf("%s_%s", id, name)
+	dirName := l.IDs.dirName(id, name)
 	deploymentPath := filepath.Join(deploymentsPath, dirName)
 
 	// Create deployments directory if it doesn't exist
@@ -271,37 +664,37 @@ func CreateDeployment(deploymentsPath, name string) (*Deployment, error) {
 		return nil, fmt.Errorf("failed to create deployments directory: %w", err)
 	}
 
-	// Create deployment directory
-	if err := os.MkdirAll(deploymentPath, 0755); err != nil {
+	// Create deployment directory, failing if a concurrent create took the ID
+	if err := os.Mkdir(deploymentPath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create deployment directory: %w", err)
 	}
 
-	// Define deployment files to create
-	files := []struct {
-		path    string
-		content string
-		mode    os.FileMode
-	}{
-		{filepath.Join(deploymentPath, "expand.sql"), expandSQLTemplate, 0644},
-		{filepath.Join(deploymentPath, "migrate.sql"), migrateSQLTemplate, 0644},
-		{filepath.Join(deploymentPath, "contract.sql"), contractSQLTemplate, 0644},
-		{filepath.Join(deploymentPath, "expand.sh"), expandScriptTemplate, 0755},
-		{filepath.Join(deploymentPath, "migrate.sh"), migrateScriptTemplate, 0755},
-		{filepath.Join(deploymentPath, "contract.sh"), contractScriptTemplate, 0755},
-		{filepath.Join(deploymentPath, "post.sh"), postScriptTemplate, 0755},
-	}
-
 	// Create all deployment files
 	for _, f := range files {
-		if err := os.WriteFile(f.path, []byte(f.content), f.mode); err != nil {
-			return nil, fmt.Errorf("failed to create %s: %w", filepath.Base(f.path), err)
+		if err := os.WriteFile(filepath.Join(deploymentPath, f.name), []byte(f.content), f.mode); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", f.name, err)
 		}
 	}
 
 	deployment := &Deployment{
 		ID:        id,
 		Name:      name,
+		Module:    module.Name,
 		Directory: deploymentPath,
+		layout:    l,
+	}
+
+	// Deployments created with SQL get a revert.sql if its inverse is provable
+	loaded := &Deployment{ID: id, Name: name, Module: module.Name, Directory: deploymentPath, Phases: make(map[string]DeploymentPhase), layout: l}
+	if err := loadFiles(loaded, deploymentPath); err != nil {
+		return nil, err
+	}
+	if sql, err := GenerateRollback(*loaded); err == nil && sql != "" {
+		path, err := writeRollback(loaded, sql)
+		if err != nil {
+			return nil, err
+		}
+		deployment.RevertPath = path
 	}
 
 	return deployment, nil
@@ -311,12 +704,12 @@ func CreateDeployment(deploymentsPath, name string) (*Deployment, error) {
 func CompareDeployments(local []Deployment, applied []DeploymentDBRecord) *DeploymentStatus {
 	appliedMap := make(map[string]DeploymentDBRecord)
 	for _, m := range applied {
-		appliedMap[m.ID] = m
+		appliedMap[m.Key()] = m
 	}
 
 	localMap := make(map[string]Deployment)
 	for _, m := range local {
-		localMap[m.ID] = m
+		localMap[m.Key()] = m
 	}
 
 	status := &DeploymentStatus{
@@ -328,9 +721,14 @@ func CompareDeployments(local []Deployment, applied []DeploymentDBRecord) *Deplo
 
 	// Classify local deployments
 	for _, deployment := range local {
-		if appliedRecord, exists := appliedMap[deployment.ID]; exists {
+		if appliedRecord, exists := appliedMap[deployment.Key()]; exists {
 			// Deployment has been applied
 			deployment.AppliedAt = &appliedRecord.AppliedAt
+			deployment.SkippedTasks = appliedRecord.SkippedTasks
+			deployment.RestorePoint = appliedRecord.RestorePoint
+			deployment.ConfigSnapshot = appliedRecord.ConfigSnapshot
+			deployment.RunID = appliedRecord.RunID
+			deployment.AppliedChecksum = appliedRecord.Checksum
 			status.Applied = append(status.Applied, deployment)
 		} else {
 			// Deployment is pending
@@ -340,12 +738,21 @@ func CompareDeployments(local []Deployment, applied []DeploymentDBRecord) *Deplo
 
 	// Find deployments that exist in DB but not locally
 	for _, appliedRecord := range applied {
-		if _, exists := localMap[appliedRecord.ID]; !exists {
+		if _, exists := localMap[appliedRecord.Key()]; !exists {
 			// Create a deployment struct for the missing deployment
 			missingDeployment := Deployment{
-				ID:        appliedRecord.ID,
-				Name:      appliedRecord.Name,
-				AppliedAt: &appliedRecord.AppliedAt,
+				ID:              appliedRecord.ID,
+				Name:            appliedRecord.Name,
+				Module:          appliedRecord.Module,
+				AppliedAt:       &appliedRecord.AppliedAt,
+				SkippedTasks:    appliedRecord.SkippedTasks,
+				RestorePoint:    appliedRecord.RestorePoint,
+				ConfigSnapshot:  appliedRecord.ConfigSnapshot,
+				RunID:           appliedRecord.RunID,
+				AppliedChecksum: appliedRecord.Checksum,
+			}
+			if appliedRecord.Ticket != "" {
+				missingDeployment.Ticket = &Ticket{Key: appliedRecord.Ticket}
 			}
 			status.Missing = append(status.Missing, missingDeployment)
 		}
@@ -354,15 +761,79 @@ func CompareDeployments(local []Deployment, applied []DeploymentDBRecord) *Deplo
 	return status
 }
 
-// CalculateChecksum calculates a checksum for a deployment based on its SQL file paths
-// TODO: Implement checksum calculation based on file paths or content if needed
+// appliedRecords returns the applied deployments of the named modules, in the order they were
+// applied, filtering them in the database if it is a ModuleRecordLister
+func appliedRecords(db DatabaseProvider, modules []string) ([]DeploymentDBRecord, error) {
+	if lister, ok := db.(ModuleRecordLister); ok {
+		return lister.GetModuleAppliedDeployments(modules)
+	}
+
+	records, err := db.GetAppliedDeployments()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(records, func(record DeploymentDBRecord) bool {
+		return !slices.Contains(modules, record.Module)
+	}), nil
+}
+
+// Key identifies the attempted deployment like Deployment.Key
+func (a DeploymentAttempt) Key() string {
+	return Deployment{ID: a.ID, Module: a.Module}.Key()
+}
+
+// details summarises who made the attempt and, for failed ones, the first line of the error
+func (a DeploymentAttempt) details() string {
+	details := "by " + a.RunBy
+	if a.Status != "failed" || a.Error == "" {
+		return details
+	}
+
+	message, _, _ := strings.Cut(a.Error, "\n")
+	const maxLength = 80
+	if len([]rune(message)) > maxLength {
+		message = string([]rune(message)[:maxLength-1]) + "…"
+	}
+	return details + ": " + message
+}
+
+// Key uniquely identifies the deployment across modules: the ID, prefixed by "module/" for named modules
+func (d Deployment) Key() string {
+	return deploymentKey(d.Module, d.ID)
+}
+
+// Key uniquely identifies the recorded deployment across modules, matching Deployment.Key
+func (r DeploymentDBRecord) Key() string {
+	return deploymentKey(r.Module, r.ID)
+}
+
+func deploymentKey(module, id string) string {
+	if module == "" {
+		return id
+	}
+	return module + "/" + id
+}
+
+// CalculateChecksum calculates a checksum for a deployment from the contents of its SQL files,
+// keyed by their paths relative to the deployment directory so it does not depend on where the
+// deployments are checked out
 func CalculateChecksum(deployment Deployment) string {
 	hasher := sha256.New()
 
-	// Include SQL file paths from phases
-	for phase, phaseData := range deployment.Phases {
-		if phaseData.SQLFilePath != nil {
-			hasher.Write([]byte(phase + ":" + *phaseData.SQLFilePath))
+	// Include SQL files from phases, in the order they run
+	for _, phase := range deployment.layout.orDefault().phases {
+		for _, path := range deployment.Phases[phase].SQLFilePaths {
+			name := path
+			if rel, err := filepath.Rel(deployment.Directory, path); err == nil && deployment.Directory != "" {
+				name = filepath.ToSlash(rel)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				// An unreadable file checksums differently from any content it could have
+				content = []byte("unreadable: " + err.Error())
+			}
+			fmt.Fprintf(hasher, "%s:%s:%d:", phase, name, len(content))
+			hasher.Write(content)
 		}
 	}
 
@@ -374,33 +845,62 @@ func (d Deployment) Tasks() []Task {
 	var tasks []Task
 	deployment := d
 
-	// Define phases in order: expand, migrate, contract, post
-	phaseOrder := []string{"expand", "migrate", "contract", "post"}
-
-	for _, phaseName := range phaseOrder {
-		phaseData, exists := d.Phases[phaseName]
-		if !exists {
-			continue
+	for _, phaseName := range d.layout.orDefault().phases {
+		// Validations gate the contract phase; assertions check its result before post scripts run
+		if phaseName == "contract" && len(d.Validations) > 0 {
+			tasks = append(tasks, deployment.validateTask())
 		}
+		if phaseName == "post" && len(d.Assertions) > 0 {
+			tasks = append(tasks, deployment.assertTask())
+		}
+		// Enum values are committed before expand runs, so the whole deployment can use them
+		if phaseName == "expand" && len(d.EnumValues) > 0 {
+			tasks = append(tasks, deployment.enumTask())
+		}
+
+		// A phase without files still runs the tasks its directives add below
+		phaseData := d.Phases[phaseName]
 
-		// Add script task if script exists
-		if phaseData.ScriptFilePath != nil {
+		// Add a script task for each script, then a SQL task for each SQL file (for expand,
+		// migrate, contract only)
+		for _, path := range phaseData.ScriptFilePaths {
 			tasks = append(tasks, Task{
 				TaskType:   "script",
-				Path:       *phaseData.ScriptFilePath,
+				Path:       path,
 				Phase:      phaseName,
 				Deployment: &deployment,
 			})
 		}
+		if phaseName != "post" {
+			for _, path := range phaseData.SQLFilePaths {
+				tasks = append(tasks, Task{
+					TaskType:   "sql",
+					Path:       path,
+					Phase:      phaseName,
+					Deployment: &deployment,
+				})
+			}
+		}
 
-		// Add SQL task if SQL file exists (for expand, migrate, contract only)
-		if phaseData.SQLFilePath != nil && phaseName != "post" {
-			tasks = append(tasks, Task{
-				TaskType:   "sql",
-				Path:       *phaseData.SQLFilePath,
-				Phase:      phaseName,
-				Deployment: &deployment,
-			})
+		if deployment.hasPartitionDirectives(phaseName) {
+			tasks = append(tasks, deployment.partitionsTask(phaseName))
+		}
+
+		// Views left under old table names are tracked once contract SQL has created them
+		if phaseName == "contract" && len(d.CompatViews) > 0 {
+			tasks = append(tasks, deployment.viewsTask())
+		}
+
+		// pgTAP tests check the deployment once its post scripts have run
+		if phaseName == "post" {
+			for _, path := range d.PgTAPPaths {
+				tasks = append(tasks, deployment.pgTAPTask(path))
+			}
+		}
+
+		// Flags change once everything else in the phase has run
+		if deployment.hasFeatureFlags(phaseName) {
+			tasks = append(tasks, deployment.flagsTask(phaseName))
 		}
 	}
 
@@ -423,68 +923,85 @@ func IsNonEmptySQL(sqlFilePath string) bool {
 
 // ListDeployments loads deployments, optionally compares with database, and outputs a formatted status report
 func ListDeployments(deploymentsPath string, db DatabaseProvider) error {
-	// Load local deployments
-	localDeployments, err := LoadDeployments(deploymentsPath)
-	if err != nil {
-		return fmt.Errorf("failed to load local deployments: %w", err)
-	}
+	return ListModules([]Module{{Path: deploymentsPath}}, db)
+}
 
-	// Get applied deployments from database if connected
-	var appliedDeployments []DeploymentDBRecord
-	if db != nil {
-		if err := db.InitDeploymentSchema(); err != nil {
-			return fmt.Errorf("failed to initialize deployment schema: %w", err)
-		}
+// printDeploymentStatus outputs the status report for a single module as a table
+func printDeploymentStatus(w io.Writer, status *DeploymentStatus, style TableStyle) {
+	t := newTable("STATUS", "ID", "NAME", "APPLIED", "DETAILS")
 
-		appliedDeployments, err = db.GetAppliedDeployments()
-		if err != nil {
-			return fmt.Errorf("failed to get applied deployments: %w", err)
+	for _, d := range status.Applied {
+		var details []string
+		if d.Ticket != nil {
+			details = append(details, d.Ticket.Key)
 		}
-	}
-
-	// Compare and display
-	status := CompareDeployments(localDeployments, appliedDeployments)
-
-	fmt.Println("Deployment Status:")
-	fmt.Println("==================")
-
-	if len(status.Applied) > 0 {
-		fmt.Printf("\nApplied (%d):\n", len(status.Applied))
-		for _, d := range status.Applied {
-			fmt.Printf("  ✓ %s - %s (applied: %s)\n", d.ID, d.Name, d.AppliedAt.Format("2006-01-02 15:04:05"))
+		if len(d.SkippedTasks) > 0 {
+			details = append(details, "skipped: "+strings.Join(d.SkippedTasks, ","))
 		}
-	}
-
-	if len(status.Pending) > 0 {
-		fmt.Printf("\nPending (%d):\n", len(status.Pending))
-		for _, d := range status.Pending {
-			var phases []string
-			for _, phaseName := range []string{"expand", "migrate", "contract"} {
-				if phaseData, exists := d.Phases[phaseName]; exists && phaseData.SQLFilePath != nil {
-					if IsNonEmptySQL(*phaseData.SQLFilePath) {
-						phases = append(phases, phaseName)
-					}
-				}
+		if d.RestorePoint != "" {
+			details = append(details, "restore point: "+d.RestorePoint)
+		}
+		t.addRow(
+			tableCell{text: "✓ applied", color: colorGreen},
+			tableCell{text: d.ID},
+			tableCell{text: d.Name},
+			tableCell{text: style.formatTime(d.AppliedAt)},
+			tableCell{text: strings.Join(details, "; ")},
+		)
+	}
+
+	for _, d := range status.Pending {
+		if attempt, ok := status.Attempts[d.Key()]; ok {
+			cell := tableCell{text: "✗ failed", color: colorRed}
+			if attempt.Status == "running" {
+				cell = tableCell{text: "▶ running", color: colorYellow}
 			}
+			t.addRow(
+				cell,
+				tableCell{text: d.ID},
+				tableCell{text: d.Name},
+				tableCell{text: style.formatTime(&attempt.StartedAt)},
+				tableCell{text: attempt.details()},
+			)
+			continue
+		}
 
-			phaseInfo := ""
-			if len(phases) > 0 {
-				phaseInfo = fmt.Sprintf(" [%s]", strings.Join(phases, "+"))
+		var phases []string
+		for _, phaseName := range d.layout.orDefault().sqlPhases {
+			if slices.ContainsFunc(d.Phases[phaseName].SQLFilePaths, IsNonEmptySQL) {
+				phases = append(phases, phaseName)
 			}
-			fmt.Printf("  ○ %s - %s%s\n", d.ID, d.Name, phaseInfo)
 		}
+		t.addRow(
+			tableCell{text: "○ pending", color: colorYellow},
+			tableCell{text: d.ID},
+			tableCell{text: d.Name},
+			tableCell{},
+			tableCell{text: strings.Join(phases, "+")},
+		)
 	}
 
-	if len(status.Missing) > 0 {
-		fmt.Printf("\nMissing Locally (%d):\n", len(status.Missing))
-		for _, d := range status.Missing {
-			fmt.Printf("  ! %s - %s (applied: %s)\n", d.ID, d.Name, d.AppliedAt.Format("2006-01-02 15:04:05"))
-		}
+	for _, d := range status.Missing {
+		t.addRow(
+			tableCell{text: "! missing", color: colorRed},
+			tableCell{text: d.ID},
+			tableCell{text: d.Name},
+			tableCell{text: style.formatTime(d.AppliedAt)},
+			tableCell{text: "not found locally"},
+		)
 	}
 
-	if len(status.Pending) == 0 && len(status.Missing) == 0 {
-		fmt.Println("\nAll deployments are up to date!")
+	fmt.Fprintln(w)
+	if len(t.rows) > 0 {
+		t.render(w, style)
+		fmt.Fprintln(w)
 	}
 
-	return nil
+	if len(status.Pending) == 0 && len(status.Missing) == 0 {
+		fmt.Fprintln(w, "All deployments are up to date!")
+	} else if len(status.Attempts) > 0 {
+		fmt.Fprintf(w, "%d applied, %d pending (%d attempted), %d missing locally\n", len(status.Applied), len(status.Pending), len(status.Attempts), len(status.Missing))
+	} else {
+		fmt.Fprintf(w, "%d applied, %d pending, %d missing locally\n", len(status.Applied), len(status.Pending), len(status.Missing))
+	}
 }
@@ -0,0 +1,109 @@
+package zdd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type (
+	// Deprecation is a table or column that a pending deployment's contract phase drops, which
+	// applications must stop referencing before it is deployed
+	Deprecation struct {
+		Module         string `json:"module"`
+		DeploymentID   string `json:"deployment_id"`
+		DeploymentName string `json:"deployment_name"`
+		Kind           string `json:"kind"` // table or column
+		Name           string `json:"name"` // e.g. orders or orders.legacy
+		Path           string `json:"path"` // Contract SQL file that drops it
+		Line           int    `json:"line"`
+	}
+
+	// DeprecationRegistry is implemented by databases that keep a registry of deprecations,
+	// zdd_deployments.deprecations, for applications to query
+	DeprecationRegistry interface {
+		// ReplaceDeprecations replaces the registered deprecations of the named modules
+		ReplaceDeprecations(modules []string, deprecations []Deprecation) error
+		// GetDeprecations returns the registered deprecations of every module
+		GetDeprecations() ([]Deprecation, error)
+	}
+)
+
+// Deprecations returns the tables and columns that the contract phases of the plan's pending
+// deployments drop, in the order they are dropped. The plan's SQL is replayed as by
+// CheckContracts, so objects the plan itself creates are left out.
+func (p *Plan) Deprecations() ([]Deprecation, error) {
+	model := schemaModel{objects: make(map[string]bool), open: make(map[string]bool)}
+	deprecations := make([]Deprecation, 0)
+	for _, task := range p.Tasks {
+		if task.TaskType != "sql" {
+			continue
+		}
+		content, err := readPsqlFile(task.Path, func(string) {})
+		if err != nil {
+			return nil, err
+		}
+		for _, statement := range scanStatements(content) {
+			sql := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(statement.SQL, " "))
+			for _, drop := range model.apply(sql) {
+				if task.Phase != "contract" || drop.known {
+					continue
+				}
+				deprecations = append(deprecations, Deprecation{
+					Module:         task.Deployment.Module,
+					DeploymentID:   task.Deployment.ID,
+					DeploymentName: task.Deployment.Name,
+					Kind:           drop.kind,
+					Name:           drop.name,
+					Path:           task.Path,
+					Line:           statement.Line,
+				})
+			}
+		}
+	}
+	return deprecations, nil
+}
+
+// RegisterDeprecations replaces the deprecations registered for the plan's modules with those
+// of its pending contract phases. The registry lists a deployment's deprecations until it is
+// applied.
+func (p *Plan) RegisterDeprecations(modules []Module) ([]Deprecation, error) {
+	registry, ok := p.db.(DeprecationRegistry)
+	if !ok {
+		return nil, fmt.Errorf("database does not keep a deprecation registry")
+	}
+	deprecations, err := p.Deprecations()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(modules))
+	for i, module := range modules {
+		names[i] = module.Name
+	}
+	if err := registry.ReplaceDeprecations(names, deprecations); err != nil {
+		return nil, err
+	}
+	return deprecations, nil
+}
+
+// WriteDeprecations lists deprecations, one per line, grouped by the deployment dropping them
+func WriteDeprecations(w io.Writer, deprecations []Deprecation) error {
+	var s strings.Builder
+	if len(deprecations) == 0 {
+		s.WriteString("No pending contract phase drops a table or column\n")
+	}
+	last := ""
+	for _, d := range deprecations {
+		if key := deploymentKey(d.Module, d.DeploymentID); key != last {
+			fmt.Fprintf(&s, "Deployment %s: %s\n", key, d.DeploymentName)
+			last = key
+		}
+		fmt.Fprintf(&s, "  drops %s %s (%s:%d)\n", d.Kind, d.Name, d.Path, d.Line)
+	}
+
+	if _, err := io.WriteString(w, s.String()); err != nil {
+		return fmt.Errorf("failed to write deprecations: %w", err)
+	}
+	return nil
+}
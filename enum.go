@@ -0,0 +1,160 @@
+package zdd
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	enumsFileName = "enums.yaml"
+	// maxIdentifierLength is PostgreSQL's limit on identifier length in bytes
+	maxIdentifierLength = 63
+)
+
+//go:embed assets/enum_add assets/enum_remove
+var enumTemplates embed.FS
+
+type (
+	// EnumValues lists values to add to an enum type. ALTER TYPE ... ADD VALUE cannot be used in
+	// the transaction that adds it, so zdd runs each addition on its own outside a transaction.
+	EnumValues struct {
+		Type string   `yaml:"type"` // Possibly schema-qualified enum type
+		Add  []string `yaml:"add"`
+	}
+
+	// EnumManager is implemented by databases that can add enum values outside a transaction
+	EnumManager interface {
+		// AddEnumValue adds value to the enum type unless it already has it
+		AddEnumValue(typeName, value string) error
+	}
+
+	// EnumChange describes an enum value to add or remove with a generated deployment
+	EnumChange struct {
+		Type  string // Possibly schema-qualified enum type
+		Value string
+		// Columns lists the table.column pairs using the type; needed for removals
+		Columns []string
+		// Replacement is the value rows using Value are moved to on removal; empty leaves them to the user
+		Replacement string
+	}
+)
+
+// LoadEnumValues reads the enum additions declared in an enums.yaml file
+func LoadEnumValues(path string) ([]EnumValues, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var enums []EnumValues
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&enums); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for i, enum := range enums {
+		if enum.Type == "" || len(enum.Add) == 0 {
+			return nil, fmt.Errorf("%s: enum #%d requires type and add", path, i+1)
+		}
+	}
+
+	return enums, nil
+}
+
+// enumTask adds the deployment's enum values before its expand SQL runs
+func (d *Deployment) enumTask() Task {
+	return Task{TaskType: "enum", Path: filepath.Join(d.Directory, enumsFileName), Phase: "expand", Deployment: d}
+}
+
+// addEnumValues adds each of the deployment's enum values outside a transaction
+func (p *Plan) addEnumValues(deployment *Deployment) error {
+	manager, ok := p.db.(EnumManager)
+	if !ok {
+		return fmt.Errorf("database does not support adding enum values")
+	}
+
+	for _, enum := range deployment.EnumValues {
+		for _, value := range enum.Add {
+			fmt.Fprintf(p.out(), "  Adding value %q to enum %s\n", value, enum.Type)
+			if err := manager.AddEnumValue(enum.Type, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParseEnumChange parses an enum value written as type=value
+func ParseEnumChange(spec string) (EnumChange, error) {
+	typeName, value, ok := strings.Cut(spec, "=")
+	if !ok || typeName == "" || value == "" {
+		return EnumChange{}, fmt.Errorf("invalid enum value %q (expected type=value)", spec)
+	}
+	return EnumChange{Type: typeName, Value: value}, nil
+}
+
+// CreateAddEnumValueDeployment creates a deployment whose enums.yaml adds change.Value to the type
+func CreateAddEnumValueDeployment(module Module, name string, change EnumChange) (*Deployment, error) {
+	if name == "" {
+		name = sanitizeName("add_" + change.Type + "_" + change.Value)
+	}
+	return change.createDeployment(module, name, "assets/enum_add", []string{enumsFileName})
+}
+
+// CreateRemoveEnumValueDeployment creates a deployment that retires change.Value without a table
+// rewrite: migrate moves rows to the replacement, and contract adds a validated check constraint
+// forbidding the value on every column using the type. The value itself stays in the type.
+func CreateRemoveEnumValueDeployment(module Module, name string, change EnumChange) (*Deployment, error) {
+	if len(change.Columns) == 0 {
+		return nil, fmt.Errorf("removing an enum value requires the table.column pairs using %s", change.Type)
+	}
+	if name == "" {
+		name = sanitizeName("remove_" + change.Type + "_" + change.Value)
+	}
+	return change.createDeployment(module, name, "assets/enum_remove", []string{"migrate.sql", "contract.sql"})
+}
+
+// createDeployment renders the named templates of dir into a new deployment
+func (c EnumChange) createDeployment(module Module, name, dir string, fileNames []string) (*Deployment, error) {
+	type column struct{ Table, Column, Constraint string }
+	data := struct {
+		TypeName, ValueName, Type, Value, Replacement string
+		Columns                                       []column
+	}{
+		TypeName:  c.Type,
+		ValueName: c.Value,
+		Type:      quoteIdentifier(c.Type),
+		Value:     quoteLiteral(c.Value),
+	}
+	if c.Replacement != "" {
+		data.Replacement = quoteLiteral(c.Replacement)
+	}
+	for _, spec := range c.Columns {
+		dot := strings.LastIndex(spec, ".")
+		if dot <= 0 || dot == len(spec)-1 {
+			return nil, fmt.Errorf("invalid column %q (expected table.column)", spec)
+		}
+		table, col := spec[:dot], spec[dot+1:]
+		constraint := sanitizeName(table[strings.LastIndex(table, ".")+1:] + "_" + col + "_not_" + c.Value)
+		data.Columns = append(data.Columns, column{
+			Table:      quoteIdentifier(table),
+			Column:     quoteIdentifier(col),
+			Constraint: quoteIdentifier(constraint[:min(len(constraint), maxIdentifierLength)]),
+		})
+	}
+
+	files, err := renderDeploymentFiles(enumTemplates, dir, fileNames, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return createDeploymentFiles(module, name, files)
+}
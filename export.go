@@ -0,0 +1,331 @@
+package zdd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ExportFormats are the formats `zdd export` writes the schema in
+var ExportFormats = []string{"sql", "json", "gorm"}
+
+// goPackagePattern matches the Go package names gorm models are generated in
+var goPackagePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+type (
+	// ExportConfig is an artifact regenerated from the migrated schema after every deploy, such
+	// as the schema.sql sqlc reads
+	ExportConfig struct {
+		// Format is sql (CREATE statements, e.g. for sqlc), json (a snapshot of tables, columns,
+		// constraints, indexes, views and enums) or gorm (Go models with gorm tags)
+		Format string `yaml:"format"`
+		Path   string `yaml:"path"`
+		// Package is the Go package of gorm models (default the name of Path's directory, or
+		// models)
+		Package string `yaml:"package"`
+	}
+
+	// SchemaColumn is a column of an exported table
+	SchemaColumn struct {
+		Name    string `json:"name"`
+		Type    string `json:"type"` // As format_type renders it, e.g. character varying(255)
+		NotNull bool   `json:"not_null"`
+		Default string `json:"default,omitempty"`
+		// Identity is ALWAYS or BY DEFAULT for identity columns
+		Identity string `json:"identity,omitempty"`
+		// Generated is the expression of a stored generated column
+		Generated string `json:"generated,omitempty"`
+	}
+
+	// SchemaConstraint is a constraint of an exported table
+	SchemaConstraint struct {
+		Name       string `json:"name"`
+		Kind       string `json:"kind"` // primary_key, unique, foreign_key, check or exclude
+		Definition string `json:"definition"`
+		// Columns are the columns the constraint covers, in order
+		Columns []string `json:"columns,omitempty"`
+	}
+
+	// SchemaTable is an exported table
+	SchemaTable struct {
+		Schema      string             `json:"schema"`
+		Name        string             `json:"name"`
+		Columns     []SchemaColumn     `json:"columns"`
+		Constraints []SchemaConstraint `json:"constraints,omitempty"`
+		// Indexes are the CREATE INDEX statements of indexes that back no constraint
+		Indexes []string `json:"indexes,omitempty"`
+	}
+
+	// SchemaView is an exported view
+	SchemaView struct {
+		Schema     string `json:"schema"`
+		Name       string `json:"name"`
+		Definition string `json:"definition"`
+	}
+
+	// SchemaEnum is an exported enum type
+	SchemaEnum struct {
+		Schema string   `json:"schema"`
+		Name   string   `json:"name"`
+		Values []string `json:"values"`
+	}
+
+	// DatabaseSchema describes the enums, sequences, tables and views of every schema but the
+	// system's and zdd's own, sorted by name
+	DatabaseSchema struct {
+		Enums []SchemaEnum `json:"enums"`
+		// Sequences are the schema-qualified names of sequences other than identity columns'
+		Sequences []string      `json:"sequences"`
+		Tables    []SchemaTable `json:"tables"`
+		Views     []SchemaView  `json:"views"`
+	}
+
+	// SchemaExporter is implemented by databases that can describe their schema for export
+	SchemaExporter interface {
+		ExportSchema() (*DatabaseSchema, error)
+	}
+)
+
+// check validates an export
+func (e ExportConfig) check() error {
+	if e.Path == "" {
+		return fmt.Errorf("export path is required")
+	}
+	for _, format := range ExportFormats {
+		if e.Format == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown export format %q; expected one of %s", e.Format, strings.Join(ExportFormats, ", "))
+}
+
+// ExportSchema describes the database's schema once and writes each export from it
+func ExportSchema(db DatabaseProvider, exports []ExportConfig, w io.Writer) error {
+	for _, export := range exports {
+		if err := export.check(); err != nil {
+			return err
+		}
+	}
+	exporter, ok := db.(SchemaExporter)
+	if !ok {
+		return fmt.Errorf("database cannot export its schema")
+	}
+	schema, err := exporter.ExportSchema()
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		content, err := schema.Render(export)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(export.Path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", export.Path, err)
+		}
+		if err := os.WriteFile(export.Path, content, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", export.Path, err)
+		}
+		fmt.Fprintf(w, "Exported the schema as %s to %s\n", export.Format, export.Path)
+	}
+	return nil
+}
+
+// Render renders the schema in the export's format
+func (s *DatabaseSchema) Render(export ExportConfig) ([]byte, error) {
+	switch export.Format {
+	case "sql":
+		return []byte(s.SQL()), nil
+	case "json":
+		content, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the schema: %w", err)
+		}
+		return append(content, '\n'), nil
+	case "gorm":
+		pkg := export.Package
+		if pkg == "" {
+			pkg = filepath.Base(filepath.Dir(export.Path))
+		}
+		if !goPackagePattern.MatchString(pkg) {
+			pkg = "models"
+		}
+		return s.Gorm(pkg)
+	default:
+		return nil, fmt.Errorf("unknown export format %q; expected one of %s", export.Format, strings.Join(ExportFormats, ", "))
+	}
+}
+
+// SQL renders the schema as CREATE statements: enums, sequences, then tables with their
+// constraints, indexes and views. Foreign keys are added after every table is created, so tables can
+// reference each other in any order.
+func (s *DatabaseSchema) SQL() string {
+	var b strings.Builder
+	b.WriteString("-- Code generated by zdd export. DO NOT EDIT.\n")
+
+	for _, enum := range s.Enums {
+		values := make([]string, len(enum.Values))
+		for i, value := range enum.Values {
+			values[i] = quoteLiteral(value)
+		}
+		fmt.Fprintf(&b, "\nCREATE TYPE %s AS ENUM (%s);\n", exportName(enum.Schema, enum.Name), strings.Join(values, ", "))
+	}
+
+	if len(s.Sequences) > 0 {
+		b.WriteString("\n")
+	}
+	for _, sequence := range s.Sequences {
+		schema, name, _ := strings.Cut(sequence, ".")
+		fmt.Fprintf(&b, "CREATE SEQUENCE %s;\n", exportName(schema, name))
+	}
+
+	var foreignKeys []string
+	for _, table := range s.Tables {
+		name := exportName(table.Schema, table.Name)
+		var elements []string
+		for _, column := range table.Columns {
+			element := fmt.Sprintf("%s %s", quoteIdentifier(column.Name), column.Type)
+			if column.NotNull {
+				element += " NOT NULL"
+			}
+			switch {
+			case column.Generated != "":
+				element += " GENERATED ALWAYS AS (" + column.Generated + ") STORED"
+			case column.Identity != "":
+				element += " GENERATED " + column.Identity + " AS IDENTITY"
+			case column.Default != "":
+				element += " DEFAULT " + column.Default
+			}
+			elements = append(elements, element)
+		}
+		for _, constraint := range table.Constraints {
+			element := fmt.Sprintf("CONSTRAINT %s %s", quoteIdentifier(constraint.Name), constraint.Definition)
+			if constraint.Kind == "foreign_key" {
+				foreignKeys = append(foreignKeys, fmt.Sprintf("ALTER TABLE %s ADD %s;\n", name, element))
+				continue
+			}
+			elements = append(elements, element)
+		}
+		fmt.Fprintf(&b, "\nCREATE TABLE %s (\n    %s\n);\n", name, strings.Join(elements, ",\n    "))
+		for _, index := range table.Indexes {
+			fmt.Fprintf(&b, "%s;\n", index)
+		}
+	}
+
+	if len(foreignKeys) > 0 {
+		b.WriteString("\n")
+		for _, foreignKey := range foreignKeys {
+			b.WriteString(foreignKey)
+		}
+	}
+
+	for _, view := range s.Views {
+		fmt.Fprintf(&b, "\nCREATE VIEW %s AS\n%s\n", exportName(view.Schema, view.Name), strings.TrimSpace(view.Definition))
+	}
+	return b.String()
+}
+
+// exportName returns the quoted name of an object, qualified unless it is in public
+func exportName(schema, name string) string {
+	if schema == "public" {
+		return quoteIdentifier(name)
+	}
+	return quoteIdentifier(schema) + "." + quoteIdentifier(name)
+}
+
+// Gorm renders the schema's tables as Go structs with gorm tags, in package pkg. Each struct
+// has a TableName method, so gorm uses the table's name as it is.
+func (s *DatabaseSchema) Gorm(pkg string) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by zdd export. DO NOT EDIT.\n\npackage %s\n", pkg)
+
+	var body bytes.Buffer
+	usesTime := false
+	for _, table := range s.Tables {
+		primary := make(map[string]bool)
+		for _, constraint := range table.Constraints {
+			if constraint.Kind == "primary_key" {
+				for _, column := range constraint.Columns {
+					primary[column] = true
+				}
+			}
+		}
+
+		name := goIdentifier(table.Name)
+		if table.Schema != "public" {
+			name = goIdentifier(table.Schema) + name
+		}
+		fmt.Fprintf(&body, "\n// %s is a row of %s\ntype %s struct {\n", name, exportName(table.Schema, table.Name), name)
+		for _, column := range table.Columns {
+			goType := gormType(column)
+			usesTime = usesTime || strings.HasSuffix(goType, "time.Time")
+
+			tag := "column:" + column.Name + ";type:" + column.Type
+			if primary[column.Name] {
+				tag += ";primaryKey"
+			}
+			if column.NotNull {
+				tag += ";not null"
+			}
+			switch {
+			case column.Generated != "":
+				tag += ";->" // Read-only
+			case column.Identity != "":
+				tag += ";autoIncrement"
+			case column.Default != "":
+				tag += ";default:" + strings.ReplaceAll(column.Default, ";", `\;`)
+			}
+			fmt.Fprintf(&body, "\t%s %s `gorm:%q json:%q`\n", goIdentifier(column.Name), goType, tag, column.Name)
+		}
+		fmt.Fprintf(&body, "}\n\n// TableName returns the name of the table\nfunc (%s) TableName() string {\n\treturn %q\n}\n", name, strings.TrimPrefix(table.Schema+"."+table.Name, "public."))
+	}
+
+	if usesTime {
+		b.WriteString("\nimport \"time\"\n")
+	}
+	b.Write(body.Bytes())
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format gorm models: %w", err)
+	}
+	return src, nil
+}
+
+// gormType returns the Go type of a column's values, a pointer if the column is nullable
+func gormType(column SchemaColumn) string {
+	var goType string
+	base, _, _ := strings.Cut(column.Type, "(")
+	switch {
+	case strings.HasSuffix(column.Type, "[]"):
+		goType = "string" // Arrays in their text form
+	case base == "bytea", base == "json", base == "jsonb":
+		goType = "[]byte"
+	case base == "smallint":
+		goType = "int16"
+	case base == "integer":
+		goType = "int32"
+	case base == "bigint":
+		goType = "int64"
+	case base == "real":
+		goType = "float32"
+	case base == "double precision":
+		goType = "float64"
+	case base == "boolean":
+		goType = "bool"
+	case strings.HasPrefix(base, "timestamp"), base == "date", strings.HasPrefix(base, "time"):
+		goType = "time.Time"
+	default:
+		goType = "string" // Text, numeric, uuid, enums and anything else in their text form
+	}
+	if column.NotNull || goType == "[]byte" {
+		return goType
+	}
+	return "*" + goType
+}
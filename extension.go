@@ -0,0 +1,177 @@
+package zdd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Extension is a PostgreSQL extension the project requires, written in zdd.yaml as a name
+	// with an optional version constraint, e.g. pg_trgm or postgis>=3.3
+	Extension struct {
+		Name       string
+		Constraint string // Same syntax as required_version; empty accepts any version
+	}
+
+	// ExtensionManager is implemented by databases that can install and update extensions
+	ExtensionManager interface {
+		// ExtensionVersions returns the installed version of an extension ("" if not installed), the
+		// version installed by default, and every version the server offers; none if it lacks the extension
+		ExtensionVersions(name string) (installed, defaultVersion string, available []string, err error)
+		CreateExtension(name, version string) error
+		UpdateExtension(name, version string) error
+	}
+
+	// extensionChange is an install or update decided when planning
+	extensionChange struct {
+		Extension
+		From    string // Installed version; empty to install the extension
+		Version string
+	}
+)
+
+// ParseExtension parses an extension requirement such as postgis>=3.3
+func ParseExtension(spec string) (Extension, error) {
+	spec = strings.TrimSpace(spec)
+	i := strings.IndexAny(spec, "<>=!")
+	if i < 0 {
+		i = len(spec)
+	}
+
+	ext := Extension{Name: strings.TrimSpace(spec[:i]), Constraint: strings.TrimSpace(spec[i:])}
+	if ext.Name == "" {
+		return Extension{}, fmt.Errorf("invalid extension %q: missing name", spec)
+	}
+	if ext.Constraint != "" {
+		if _, err := satisfiesVersion("0", ext.Constraint); err != nil {
+			return Extension{}, fmt.Errorf("invalid extension %q: %w", spec, err)
+		}
+	}
+	return ext, nil
+}
+
+// UnmarshalYAML parses an extension requirement from a string such as "postgis>=3.3"
+func (e *Extension) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+
+	ext, err := ParseExtension(s)
+	if err != nil {
+		return &ConfigError{Line: value.Line, Column: value.Column, Msg: err.Error()}
+	}
+
+	*e = ext
+	return nil
+}
+
+// String renders the requirement as written in zdd.yaml
+func (e Extension) String() string {
+	return e.Name + e.Constraint
+}
+
+// accepts reports whether version satisfies the extension's constraint. Versions zdd cannot
+// parse only satisfy an empty constraint.
+func (e Extension) accepts(version string) bool {
+	if e.Constraint == "" {
+		return true
+	}
+	ok, err := satisfiesVersion(version, e.Constraint)
+	return err == nil && ok
+}
+
+// AddExtensions checks the required extensions against the server and, if any must be
+// installed or updated, adds an extensions task before the first task of the plan. It fails
+// when the server cannot provide a required extension, so nothing is applied.
+func (p *Plan) AddExtensions(extensions []Extension) error {
+	if len(extensions) == 0 {
+		return nil
+	}
+
+	manager, ok := p.db.(ExtensionManager)
+	if !ok {
+		return fmt.Errorf("database does not support extension management")
+	}
+
+	var changes []extensionChange
+	for _, ext := range extensions {
+		installed, defaultVersion, available, err := manager.ExtensionVersions(ext.Name)
+		if err != nil {
+			return err
+		}
+		if len(available) == 0 {
+			return fmt.Errorf("extension %s is not available on the database server; install its package on the server first", ext.Name)
+		}
+		if installed != "" && ext.accepts(installed) {
+			continue
+		}
+
+		version := defaultVersion
+		if !ext.accepts(version) {
+			version = ""
+			for _, candidate := range available {
+				if !ext.accepts(candidate) {
+					continue
+				}
+				if version == "" || compareVersionStrings(candidate, version) > 0 {
+					version = candidate
+				}
+			}
+		}
+		if version == "" {
+			slices.SortFunc(available, compareVersionStrings)
+			return fmt.Errorf("extension %s requires version %s, but the server offers %s", ext.Name, ext.Constraint, strings.Join(available, ", "))
+		}
+
+		changes = append(changes, extensionChange{Extension: ext, From: installed, Version: version})
+	}
+
+	if len(changes) == 0 || len(p.Tasks) == 0 {
+		return nil
+	}
+
+	p.extensionChanges = changes
+	task := Task{TaskType: "extensions", Phase: "expand", Deployment: p.Tasks[0].Deployment}
+	p.Tasks = slices.Insert(p.Tasks, 0, task)
+	return nil
+}
+
+// applyExtensions installs or updates the extensions decided when planning
+func (p *Plan) applyExtensions() error {
+	manager := p.db.(ExtensionManager)
+
+	for _, change := range p.extensionChanges {
+		if change.From == "" {
+			fmt.Fprintf(p.out(), "  Installing extension %s %s\n", change.Name, change.Version)
+			if err := manager.CreateExtension(change.Name, change.Version); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Fprintf(p.out(), "  Updating extension %s from %s to %s\n", change.Name, change.From, change.Version)
+		if err := manager.UpdateExtension(change.Name, change.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareVersionStrings orders extension versions; versions zdd cannot parse sort first
+func compareVersionStrings(a, b string) int {
+	va, errA := parseVersion(a)
+	vb, errB := parseVersion(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	return compareVersions(va, vb)
+}
@@ -0,0 +1,242 @@
+package zdd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	flagsFileName = "flags.yaml"
+	// defaultFlagTimeout bounds each flag change when feature_flags.timeout is unset
+	defaultFlagTimeout = 30 * time.Second
+)
+
+type (
+	// FeatureFlag is a feature flag change from flags.yaml, made once the SQL and scripts of a
+	// phase have run, e.g. enabling dual writes after expand
+	FeatureFlag struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"` // e.g. true, or a rollout percentage
+		// Phase sets the flag after that phase; default expand
+		Phase string `yaml:"phase"`
+	}
+
+	// FlagChange is a feature flag set by a deployment, as recorded in the database
+	FlagChange struct {
+		Module       string
+		DeploymentID string
+		Name         string
+		Value        string
+		Phase        string
+		SetAt        *time.Time
+	}
+
+	// FlagProvider sets feature flags in a flag service
+	FlagProvider interface {
+		SetFlag(flag FeatureFlag, deployment Deployment) error
+	}
+
+	// FlagRecorder is implemented by databases that record the feature flags deployments set
+	FlagRecorder interface {
+		RecordFlagChange(change FlagChange) error
+	}
+
+	// FeatureFlagsConfig configures the provider setting the feature flags of flags.yaml; exactly
+	// one of Command and URL is set
+	FeatureFlagsConfig struct {
+		// Command sets a flag, e.g. [./scripts/set_flag.sh, "{name}", "{value}"]; it also
+		// receives ZDD_FLAG_NAME, ZDD_FLAG_VALUE and the deployment's ZDD_ variables
+		Command []string `yaml:"command"`
+		// URL receives a JSON request with the flag's name and value and the deployment setting
+		// it, e.g. https://flags.example.com/api/flags/{name}
+		URL     string            `yaml:"url"`
+		Method  string            `yaml:"method"` // Default: PUT
+		Headers map[string]string `yaml:"headers"`
+		// Timeout bounds each flag change (default 30s)
+		Timeout Duration `yaml:"timeout"`
+	}
+
+	// commandFlagProvider sets flags by running a command
+	commandFlagProvider struct {
+		command []string
+		timeout time.Duration
+	}
+
+	// httpFlagProvider sets flags with HTTP requests
+	httpFlagProvider struct {
+		config FeatureFlagsConfig
+		client *http.Client
+	}
+)
+
+// LoadFeatureFlags reads the flag changes of a flags.yaml file; their phases are checked against
+// the layout of the deployment when it is loaded
+func LoadFeatureFlags(path string) ([]FeatureFlag, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var flags []FeatureFlag
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&flags); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for i := range flags {
+		if flags[i].Phase == "" {
+			flags[i].Phase = "expand"
+		}
+		if flags[i].Name == "" {
+			return nil, fmt.Errorf("%s: flag #%d requires name", path, i+1)
+		}
+	}
+
+	return flags, nil
+}
+
+// flagsTask sets the deployment's feature flags of a phase once the phase has run
+func (d *Deployment) flagsTask(phase string) Task {
+	return Task{TaskType: "flags", Path: filepath.Join(d.Directory, flagsFileName), Phase: phase, Deployment: d}
+}
+
+// hasFeatureFlags reports whether any of the deployment's flags are set after phase
+func (d Deployment) hasFeatureFlags(phase string) bool {
+	return slices.ContainsFunc(d.FeatureFlags, func(f FeatureFlag) bool { return f.Phase == phase })
+}
+
+// setFeatureFlags sets the deployment's flags of phase with the plan's provider and records them
+// in the database if it is a FlagRecorder
+func (p *Plan) setFeatureFlags(deployment *Deployment, phase string) error {
+	recorder, recording := p.db.(FlagRecorder)
+	for _, flag := range deployment.FeatureFlags {
+		if flag.Phase != phase {
+			continue
+		}
+		fmt.Fprintf(p.out(), "  Setting feature flag %s to %s\n", flag.Name, flag.Value)
+		if err := p.Flags.SetFlag(flag, *deployment); err != nil {
+			return fmt.Errorf("failed to set feature flag %s: %w", flag.Name, err)
+		}
+		if !recording {
+			continue
+		}
+		change := FlagChange{Module: deployment.Module, DeploymentID: deployment.ID, Name: flag.Name, Value: flag.Value, Phase: phase}
+		if err := recorder.RecordFlagChange(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewFlagProvider returns the provider configured by config, or nil if none is
+func NewFlagProvider(config FeatureFlagsConfig) (FlagProvider, error) {
+	timeout := time.Duration(config.Timeout)
+	if timeout <= 0 {
+		timeout = defaultFlagTimeout
+	}
+
+	switch {
+	case len(config.Command) > 0 && config.URL != "":
+		return nil, fmt.Errorf("feature_flags sets both command and url; choose one")
+	case len(config.Command) > 0:
+		return &commandFlagProvider{command: config.Command, timeout: timeout}, nil
+	case config.URL != "":
+		if u, err := url.Parse(config.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("feature_flags.url must be an http or https URL")
+		}
+		if config.Method == "" {
+			config.Method = http.MethodPut
+		}
+		return &httpFlagProvider{config: config, client: &http.Client{Timeout: timeout}}, nil
+	}
+	return nil, nil
+}
+
+// SetFlag runs the command with {name} and {value} in its arguments replaced, from the
+// deployment's directory
+func (c *commandFlagProvider) SetFlag(flag FeatureFlag, deployment Deployment) error {
+	replacer := strings.NewReplacer("{name}", flag.Name, "{value}", flag.Value)
+	args := make([]string, len(c.command))
+	for i, arg := range c.command {
+		args[i] = replacer.Replace(arg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = deployment.Directory
+	cmd.Env = append(os.Environ(),
+		"ZDD_FLAG_NAME="+flag.Name,
+		"ZDD_FLAG_VALUE="+flag.Value,
+		"ZDD_FLAG_PHASE="+flag.Phase,
+		"ZDD_DEPLOYMENT_ID="+deployment.ID,
+		"ZDD_DEPLOYMENT_NAME="+deployment.Name,
+		"ZDD_MODULE="+deployment.Module,
+		"ZDD_RUN_ID="+RunID(),
+	)
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %s", filepath.Base(args[0]), c.timeout)
+	}
+	if err != nil {
+		if cmd.ProcessState == nil {
+			return fmt.Errorf("failed to run %s: %w", args[0], err)
+		}
+		return fmt.Errorf("%s failed with exit code %d: %s", filepath.Base(args[0]), cmd.ProcessState.ExitCode(), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// SetFlag sends the flag to the URL, with {name} replaced by the escaped flag name, and fails
+// unless the response is a 2xx
+func (h *httpFlagProvider) SetFlag(flag FeatureFlag, deployment Deployment) error {
+	body, err := json.Marshal(map[string]string{
+		"name":       flag.Name,
+		"value":      flag.Value,
+		"phase":      flag.Phase,
+		"module":     deployment.Module,
+		"deployment": deployment.ID,
+		"set_by":     ApplicationName(),
+		"run_id":     RunID(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode flag request: %w", err)
+	}
+
+	target := strings.ReplaceAll(h.config.URL, "{name}", url.PathEscape(flag.Name))
+	req, err := http.NewRequest(h.config.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create flag request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send flag request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("flag service returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
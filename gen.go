@@ -0,0 +1,81 @@
+package zdd
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+var goConstantsTemplate = template.Must(template.New("go").Parse(`// Code generated by zdd gen go. DO NOT EDIT.
+
+package {{ .Package }}
+
+// Deployment IDs, in the order they are applied
+const (
+{{- range .Constants }}
+	{{ .Name }} = "{{ .ID }}"
+{{- end }}
+)
+
+// Latest is the ID of the most recent deployment
+const Latest = "{{ .Latest }}"
+`))
+
+type goConstant struct {
+	Name string
+	ID   string
+}
+
+// GenerateGo renders a Go source file declaring a constant for each deployment ID and the latest ID
+func GenerateGo(deployments []Deployment, packageName string) ([]byte, error) {
+	if packageName == "" {
+		return nil, fmt.Errorf("package name is required")
+	}
+
+	data := struct {
+		Package   string
+		Constants []goConstant
+		Latest    string
+	}{Package: packageName}
+
+	for _, d := range deployments {
+		data.Constants = append(data.Constants, goConstant{
+			Name: "Deployment" + d.ID + goIdentifier(d.Name),
+			ID:   d.ID,
+		})
+		data.Latest = d.ID
+	}
+
+	var buf bytes.Buffer
+	if err := goConstantsTemplate.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render Go constants: %w", err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format Go constants: %w", err)
+	}
+
+	return src, nil
+}
+
+// goIdentifier converts a deployment name such as add_users_table into AddUsersTable
+func goIdentifier(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+package zdd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// HeartbeatInterval is how often a running deploy updates its heartbeat
+	HeartbeatInterval = 15 * time.Second
+	// DefaultStaleAfter is how long a deploy may go without a heartbeat before it is considered
+	// abandoned and its running deployments may be taken over
+	DefaultStaleAfter = 2 * time.Minute
+
+	takeoverStale  = "stale"
+	takeoverManual = "manual"
+)
+
+type (
+	// DeployRun is a zdd process applying deployments, as recorded by its heartbeat
+	DeployRun struct {
+		RunID       string
+		PID         int
+		Host        string
+		RunBy       string // user@host
+		StartedAt   time.Time
+		CurrentTask string
+		UpdatedAt   time.Time // Time of the last heartbeat
+	}
+
+	// HeartbeatRecorder is implemented by databases that keep a heartbeat row for each running
+	// deploy, so operators can see deploys in progress and abandoned ones can be taken over
+	HeartbeatRecorder interface {
+		// Heartbeat creates or refreshes the heartbeat of this process with its current task
+		Heartbeat(task string) error
+		// EndHeartbeat removes the heartbeat of this process
+		EndHeartbeat() error
+		// GetDeployRuns returns the deploys with a heartbeat, oldest first
+		GetDeployRuns() ([]DeployRun, error)
+	}
+
+	// StaleRunClearer is implemented by databases that can clear the state of abandoned deploys
+	StaleRunClearer interface {
+		// ClearStaleRuns removes the heartbeats of deploys that have gone staleAfter without one and
+		// marks their running deployments failed. Runs whose session still holds the run's lock
+		// are left alone and returned as held.
+		ClearStaleRuns(staleAfter time.Duration) (cleared, held []DeployRun, err error)
+	}
+
+	// TakeoverPolicy decides when a deploy may take over deployments another zdd process left
+	// running
+	TakeoverPolicy struct {
+		// Force takes over regardless of the other process's heartbeat
+		Force bool
+		// StaleAfter takes over from processes that have gone this long without a heartbeat and
+		// whose session no longer holds the run's lock; zero never takes over automatically
+		StaleAfter time.Duration
+	}
+
+	// heartbeat refreshes the heartbeat of a running deploy in the background
+	heartbeat struct {
+		recorder HeartbeatRecorder
+		mu       sync.Mutex
+		task     string
+		stop     chan struct{}
+		done     chan struct{}
+	}
+)
+
+// startHeartbeat records a heartbeat and keeps refreshing it until close. It returns nil if the
+// database does not keep heartbeats.
+func startHeartbeat(db DatabaseProvider) (*heartbeat, error) {
+	recorder, ok := db.(HeartbeatRecorder)
+	if !ok {
+		return nil, nil
+	}
+	if err := recorder.Heartbeat(""); err != nil {
+		return nil, fmt.Errorf("failed to record heartbeat: %w", err)
+	}
+
+	h := &heartbeat{recorder: recorder, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				h.mu.Lock()
+				task := h.task
+				h.mu.Unlock()
+				// A missed heartbeat is retried on the next tick; the deploy itself is unaffected
+				h.recorder.Heartbeat(task)
+			}
+		}
+	}()
+	return h, nil
+}
+
+// setTask records the task the deploy is running
+func (h *heartbeat) setTask(task string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.task = task
+	h.mu.Unlock()
+	h.recorder.Heartbeat(task)
+}
+
+// close stops refreshing the heartbeat and removes it
+func (h *heartbeat) close() {
+	if h == nil {
+		return
+	}
+	close(h.stop)
+	<-h.done
+	h.recorder.EndHeartbeat()
+}
+
+// TakeoverPolicy returns the automatic takeover policy set by takeover and stale_after
+func (c ProjectConfig) TakeoverPolicy() (TakeoverPolicy, error) {
+	switch c.Takeover {
+	case "", takeoverStale:
+		return TakeoverPolicy{StaleAfter: c.StaleAfterDuration()}, nil
+	case takeoverManual:
+		return TakeoverPolicy{}, nil
+	}
+	return TakeoverPolicy{}, fmt.Errorf("invalid project.takeover %q (expected stale or manual)", c.Takeover)
+}
+
+// StaleAfterDuration returns stale_after, or DefaultStaleAfter if it is not set
+func (c ProjectConfig) StaleAfterDuration() time.Duration {
+	if c.StaleAfter > 0 {
+		return time.Duration(c.StaleAfter)
+	}
+	return DefaultStaleAfter
+}
+
+// Stale reports whether the run has gone longer than staleAfter without a heartbeat
+func (r DeployRun) Stale(staleAfter time.Duration) bool {
+	return time.Since(r.UpdatedAt) > staleAfter
+}
+
+// writeDeployRuns reports the deploys in progress according to their heartbeats
+func writeDeployRuns(w io.Writer, runs []DeployRun, style TableStyle) {
+	for _, run := range runs {
+		state := "in progress"
+		if run.Stale(DefaultStaleAfter) {
+			state = "abandoned"
+		}
+		fmt.Fprintf(w, "Deploy %s: run %s by %s (pid %d) since %s", state, run.RunID, run.RunBy, run.PID, style.formatTime(&run.StartedAt))
+		if run.CurrentTask != "" {
+			fmt.Fprintf(w, ", running %s", run.CurrentTask)
+		}
+		fmt.Fprintf(w, ", last heartbeat %s ago\n", time.Since(run.UpdatedAt).Round(time.Second))
+	}
+}
@@ -0,0 +1,123 @@
+package zdd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"time"
+)
+
+type (
+	// TaskRun records how long a task took. Runs are keyed by the checksum of the task's file,
+	// so runs of the same file against any environment can be compared.
+	TaskRun struct {
+		Module       string
+		DeploymentID string
+		Task         string // Task.Name, e.g. "expand:sql"
+		FileChecksum string
+		Duration     time.Duration
+		// Runner is what ran the task: connection for SQL sent by zdd, exec for scripts executed
+		// directly, or the base name of the configured runner command, e.g. psql
+		Runner string
+	}
+
+	// TaskHistory is implemented by databases that keep a history of task runs
+	TaskHistory interface {
+		RecordTaskRun(run TaskRun) error
+		// TaskDurations returns the average duration of past runs for each file checksum that has any
+		TaskDurations(checksums []string) (map[string]time.Duration, error)
+	}
+)
+
+// FileChecksum returns the sha256 of the file at path
+func FileChecksum(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(content)), nil
+}
+
+// LoadEstimates fills Estimates from the run history of identical task files
+func (p *Plan) LoadEstimates(history TaskHistory) error {
+	checksums := make(map[string]string) // task path -> checksum
+	var all []string
+	for _, task := range p.Tasks {
+		if task.Path == "" {
+			continue
+		}
+		checksum, err := FileChecksum(task.Path)
+		if err != nil {
+			return err
+		}
+		checksums[task.Path] = checksum
+		all = append(all, checksum)
+	}
+
+	durations, err := history.TaskDurations(all)
+	if err != nil {
+		return fmt.Errorf("failed to load task history: %w", err)
+	}
+
+	p.Estimates = make(map[string]time.Duration)
+	for path, checksum := range checksums {
+		if duration, ok := durations[checksum]; ok {
+			p.Estimates[path] = duration
+		}
+	}
+
+	return nil
+}
+
+// EstimatedDuration sums the estimates of the plan's tasks starting at index from, and
+// reports how many of those tasks have no history
+func (p *Plan) EstimatedDuration(from int) (time.Duration, int) {
+	var total time.Duration
+	unknown := 0
+	for _, task := range p.Tasks[from:] {
+		if estimate, ok := p.Estimates[task.Path]; ok {
+			total += estimate
+		} else {
+			unknown++
+		}
+	}
+	return total, unknown
+}
+
+// recordTaskRun stores the duration of a completed task if the database keeps a history
+func (p *Plan) recordTaskRun(task Task, duration time.Duration) error {
+	history, ok := p.db.(TaskHistory)
+	if !ok || task.Path == "" {
+		return nil
+	}
+
+	checksum, err := FileChecksum(task.Path)
+	if err != nil {
+		return err
+	}
+
+	run := TaskRun{
+		Module:       task.Deployment.Module,
+		DeploymentID: task.Deployment.ID,
+		Task:         task.Name(),
+		FileChecksum: checksum,
+		Duration:     duration,
+		Runner:       p.taskRunner(task),
+	}
+	if err := history.RecordTaskRun(run); err != nil {
+		return fmt.Errorf("failed to record task run: %w", err)
+	}
+	return nil
+}
+
+// formatEstimate renders an estimate rounded for display
+func formatEstimate(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
@@ -0,0 +1,92 @@
+package zdd
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// idDateLayout is the date that prefixes deployment IDs when IDFormat.DatePrefix is set
+const idDateLayout = "20060102"
+
+// idSeparators are the separators allowed between a deployment's ID and name
+var idSeparators = []string{"_", "-", "."}
+
+// IDFormat configures deployment IDs and the names of deployment directories, which are the ID
+// and the name joined by the separator, e.g. 000001_add_users
+type IDFormat struct {
+	// Width is the number of digits of the sequence number (default 6)
+	Width int `yaml:"width"`
+	// Separator joins the ID and the name: "_" (the default), "-" or "."
+	Separator string `yaml:"separator"`
+	// DatePrefix starts IDs with the date they were created on, e.g. 20261017000001; the
+	// sequence number restarts every day
+	DatePrefix bool `yaml:"date_prefix"`
+}
+
+// withDefaults fills in the zero fields of the format
+func (f IDFormat) withDefaults() IDFormat {
+	if f.Width == 0 {
+		f.Width = 6
+	}
+	if f.Separator == "" {
+		f.Separator = "_"
+	}
+	return f
+}
+
+// check reports why the format cannot be used, if it cannot
+func (f IDFormat) check() error {
+	if f.Width < 1 || f.Width > 18 {
+		return fmt.Errorf("invalid ID width %d (expected 1 to 18 digits)", f.Width)
+	}
+	if !slices.Contains(idSeparators, f.Separator) {
+		return fmt.Errorf("invalid ID separator %q (expected one of %s)", f.Separator, strings.Join(idSeparators, " "))
+	}
+	return nil
+}
+
+// dirPattern matches deployment directory names, capturing the ID and the name
+func (f IDFormat) dirPattern() *regexp.Regexp {
+	digits := f.Width
+	if f.DatePrefix {
+		digits += len(idDateLayout)
+	}
+	return regexp.MustCompile(fmt.Sprintf(`^(\d{%d})%s(.+)$`, digits, regexp.QuoteMeta(f.Separator)))
+}
+
+// dirName returns the name of the directory of a deployment
+func (f IDFormat) dirName(id, name string) string {
+	return id + f.Separator + name
+}
+
+// nextID returns the ID following the sorted IDs: the highest sequence number plus one, among
+// those of today when IDs have a date prefix
+func (f IDFormat) nextID(ids []string) (string, error) {
+	prefix := ""
+	if f.DatePrefix {
+		prefix = time.Now().Format(idDateLayout)
+	}
+
+	last := 0
+	for _, id := range ids {
+		sequence, ok := strings.CutPrefix(id, prefix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(sequence)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse deployment ID %s: %w", id, err)
+		}
+		last = max(last, n)
+	}
+
+	next := strconv.Itoa(last + 1)
+	if len(next) > f.Width {
+		return "", fmt.Errorf("deployment IDs are exhausted: %s%s does not fit in %d digits; increase ids.width", prefix, next, f.Width)
+	}
+	return prefix + strings.Repeat("0", f.Width-len(next)) + next, nil
+}
@@ -0,0 +1,135 @@
+package zdd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	// idempotentAnnotationPattern matches the annotation marking an SQL file as safe to run again,
+	// e.g. "-- zdd:idempotent" or "-- zdd:idempotent rewrite", and captures its options
+	idempotentAnnotationPattern = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*zdd:idempotent\b(.*)$`)
+	// noRewritePattern matches the annotation opting a statement out of idempotent rewriting
+	noRewritePattern = regexp.MustCompile(`--[ \t]*zdd:no-rewrite\b`)
+
+	// idempotentRewrites insert IF NOT EXISTS or IF EXISTS after the prefixes they match, unless
+	// the statement already has it
+	idempotentRewrites = []struct {
+		pattern *regexp.Regexp
+		clause  string
+	}{
+		{regexp.MustCompile(`(?i)^CREATE\s+(?:(?:GLOBAL|LOCAL)\s+)?(?:(?:TEMP|TEMPORARY|UNLOGGED)\s+)?TABLE\s+(IF\s+NOT\s+EXISTS\s+)?`), "IF NOT EXISTS "},
+		{regexp.MustCompile(`(?i)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:CONCURRENTLY\s+)?(IF\s+NOT\s+EXISTS\s+)?`), "IF NOT EXISTS "},
+		{regexp.MustCompile(`(?i)^CREATE\s+(?:SCHEMA|SEQUENCE|EXTENSION)\s+(IF\s+NOT\s+EXISTS\s+)?`), "IF NOT EXISTS "},
+		{regexp.MustCompile(`(?i)^DROP\s+(?:TABLE|INDEX\s+CONCURRENTLY|INDEX|MATERIALIZED\s+VIEW|VIEW|SEQUENCE|SCHEMA|TYPE|FUNCTION|EXTENSION)\s+(IF\s+EXISTS\s+)?`), "IF EXISTS "},
+	}
+	// idempotentColumnRewrites do the same for each column clause of an ALTER TABLE statement
+	idempotentColumnRewrites = []struct {
+		pattern *regexp.Regexp
+		clause  string
+	}{
+		{regexp.MustCompile(`(?i)\bADD\s+COLUMN\s+(IF\s+NOT\s+EXISTS\s+)?`), "IF NOT EXISTS "},
+		{regexp.MustCompile(`(?i)\bDROP\s+(?:COLUMN|CONSTRAINT)\s+(IF\s+EXISTS\s+)?`), "IF EXISTS "},
+	}
+)
+
+// IdempotentAnnotation reports whether SQL content carries a `-- zdd:idempotent` annotation, and
+// whether the annotation asks for its statements to be rewritten by RewriteIdempotent
+func IdempotentAnnotation(content string) (idempotent, rewrite bool, err error) {
+	for _, match := range idempotentAnnotationPattern.FindAllStringSubmatch(content, -1) {
+		idempotent = true
+		for _, option := range strings.Fields(match[1]) {
+			if option != "rewrite" {
+				return false, false, fmt.Errorf("unknown zdd:idempotent option %q (expected rewrite)", option)
+			}
+			rewrite = true
+		}
+	}
+	return idempotent, rewrite, nil
+}
+
+// RewriteIdempotent rewrites the statements of SQL content to IF NOT EXISTS and IF EXISTS forms,
+// so running it again after a partial failure skips the objects it already created or dropped:
+// CREATE TABLE, INDEX, SCHEMA, SEQUENCE and EXTENSION; DROP TABLE, INDEX, VIEW, SEQUENCE, SCHEMA,
+// TYPE, FUNCTION and EXTENSION; and ALTER TABLE ... ADD COLUMN, DROP COLUMN and DROP CONSTRAINT.
+// Statements annotated with `-- zdd:no-rewrite`, unnamed indexes and other statements are kept
+// as they are. Content without statements to rewrite is returned unchanged.
+func RewriteIdempotent(content string) string {
+	var (
+		out       strings.Builder
+		rewritten bool
+	)
+	for _, statement := range scanStatements(content) {
+		sql := statement.SQL
+		if !noRewritePattern.MatchString(sql) {
+			if rewrite := rewriteIdempotentStatement(strings.TrimSpace(sqlCommentPattern.ReplaceAllString(sql, " "))); rewrite != "" {
+				sql, rewritten = rewrite, true
+			}
+		}
+		out.WriteString(sql + ";\n")
+	}
+	if !rewritten {
+		return content
+	}
+	return out.String()
+}
+
+// rewriteIdempotentStatement returns the IF NOT EXISTS or IF EXISTS form of a statement without
+// comments, or "" if it has none or already uses it
+func rewriteIdempotentStatement(sql string) string {
+	rewritten := sql
+	for _, rule := range idempotentRewrites {
+		match := rule.pattern.FindStringSubmatchIndex(rewritten)
+		if match == nil {
+			continue
+		}
+		// CREATE INDEX ON ... has no name to check for
+		if match[2] < 0 && !strings.HasPrefix(strings.ToUpper(rewritten[match[1]:]), "ON ") {
+			rewritten = rewritten[:match[1]] + rule.clause + rewritten[match[1]:]
+		}
+		break
+	}
+	if alterTablePattern.MatchString(rewritten) {
+		for _, rule := range idempotentColumnRewrites {
+			rewritten = rule.pattern.ReplaceAllStringFunc(rewritten, func(clause string) string {
+				if rule.pattern.FindStringSubmatch(clause)[1] != "" {
+					return clause
+				}
+				return clause + rule.clause
+			})
+		}
+	}
+	if rewritten == sql {
+		return ""
+	}
+	return rewritten
+}
+
+// idempotentDeployment reports whether every SQL file the plan runs for the deployment carries a
+// `-- zdd:idempotent` annotation, so it is safe to run again. Scripts cannot be annotated, so a
+// deployment that runs any is never idempotent by annotation.
+func (p *Plan) idempotentDeployment(deployment *Deployment) bool {
+	found := false
+	for _, task := range p.Tasks {
+		if task.Deployment.Key() != deployment.Key() {
+			continue
+		}
+		if task.TaskType == "script" {
+			return false
+		}
+		if task.TaskType != "sql" {
+			continue
+		}
+		content, err := os.ReadFile(task.Path)
+		if err != nil {
+			return false
+		}
+		if idempotent, _, err := IdempotentAnnotation(string(content)); err != nil || !idempotent {
+			return false
+		}
+		found = true
+	}
+	return found
+}
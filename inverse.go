@@ -0,0 +1,173 @@
+package zdd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	// revertFileName holds SQL undoing the whole deployment
+	revertFileName = "revert.sql"
+	// revertSuffix follows the phase in the names of files undoing a single phase, e.g.
+	// contract.revert.sql
+	revertSuffix = ".revert.sql"
+)
+
+var (
+	createIndexPattern    = regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(CONCURRENTLY\s+)?(IF\s+NOT\s+EXISTS\s+)?([^\s(]+)\s+ON\s+(?:ONLY\s+)?([^\s(]+)`)
+	ifNotExistsPattern    = regexp.MustCompile(`(?is)^(?:ADD\s+(?:COLUMN\s+)?|CREATE\s+(?:(?:GLOBAL|LOCAL|TEMP|TEMPORARY|UNLOGGED)\s+)*TABLE\s+)IF\s+NOT\s+EXISTS\b`)
+	sessionSettingPattern = regexp.MustCompile(`(?is)^(?:SET|RESET)\b`)
+)
+
+// InverseSQL returns statements undoing the SQL in content, in reverse order, for the subset
+// whose inverse is provable: CREATE TABLE, ALTER TABLE ... ADD COLUMN and CREATE INDEX, along
+// with SET and RESET, which need none. Statements with IF NOT EXISTS are not inverted, as they
+// may have left an existing object alone. Other statements are an error naming their line.
+func InverseSQL(content string) (string, error) {
+	var inverse []string
+	for _, statement := range scanStatements(content) {
+		sql := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(statement.SQL, " "))
+		if sql == "" || sessionSettingPattern.MatchString(sql) {
+			continue
+		}
+		undo, ok := inverseStatement(sql)
+		if !ok {
+			return "", fmt.Errorf("line %d: %s has no provable inverse", statement.Line, firstWords(sql, 4))
+		}
+		inverse = append(inverse, undo)
+	}
+
+	slices.Reverse(inverse)
+	if len(inverse) == 0 {
+		return "", nil
+	}
+	return strings.Join(inverse, ";\n") + ";\n", nil
+}
+
+// inverseStatement returns the statement undoing a statement without comments, if it is provable
+func inverseStatement(sql string) (string, bool) {
+	if match := createTablePattern.FindStringSubmatch(sql); match != nil {
+		if ifNotExistsPattern.MatchString(sql) {
+			return "", false
+		}
+		return "DROP TABLE " + match[1], true
+	}
+
+	if match := createIndexPattern.FindStringSubmatch(sql); match != nil {
+		if match[2] != "" {
+			return "", false
+		}
+		// An index lives in its table's schema
+		index := match[3]
+		if !strings.Contains(index, ".") && strings.Contains(match[4], ".") {
+			index = match[4][:strings.LastIndex(match[4], ".")+1] + index
+		}
+		return "DROP INDEX " + strings.ToUpper(match[1]) + index, true
+	}
+
+	match := alterTablePattern.FindStringSubmatchIndex(sql)
+	if match == nil {
+		return "", false
+	}
+	var drops []string
+	for _, action := range splitTopLevel(sql[match[4]:]) {
+		fields := strings.Fields(action)
+		names := addColumnPattern.FindStringSubmatch(action)
+		if names == nil || len(fields) < 2 || isConstraintKeyword(strings.ToUpper(fields[1])) || ifNotExistsPattern.MatchString(action) {
+			return "", false
+		}
+		drops = append(drops, "DROP COLUMN "+names[1])
+	}
+	return sql[:match[4]] + strings.Join(drops, ", "), true
+}
+
+// firstWords returns up to n words of s
+func firstWords(s string, n int) string {
+	words := strings.Fields(s)
+	return strings.Join(words[:min(n, len(words))], " ")
+}
+
+// GenerateRollback returns the SQL undoing the deployment's SQL files, last file first, or an
+// error if a task or statement has no provable inverse. Assertions and validations only read,
+// so they need none, and feature flags are outside the database.
+func GenerateRollback(deployment Deployment) (string, error) {
+	var parts []string
+	for _, task := range deployment.Tasks() {
+		switch task.TaskType {
+		case "assert", "validate", "flags", "pgtap", "replicas":
+			continue
+		case "sql":
+		default:
+			return "", fmt.Errorf("deployment %s has a %s task, whose effect cannot be inverted", deployment.Key(), task.Name())
+		}
+
+		content, err := readPsqlFile(task.Path, nil)
+		if err != nil {
+			return "", err
+		}
+		inverse, err := InverseSQL(content)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", task.Path, err)
+		}
+		if inverse != "" {
+			parts = append(parts, fmt.Sprintf("-- Undoes %s\n%s", filepath.Base(task.Path), inverse))
+		}
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+	slices.Reverse(parts)
+	return fmt.Sprintf("-- Rollback of deployment %s, generated by %s; deploy never runs it\n\n%s", deployment.Key(), ApplicationName(), strings.Join(parts, "\n")), nil
+}
+
+// writeRollback writes sql generated by GenerateRollback to the deployment's revert.sql and
+// returns its path
+func writeRollback(deployment *Deployment, sql string) (string, error) {
+	path := filepath.Join(deployment.Directory, revertFileName)
+	if err := os.WriteFile(path, []byte(sql), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	deployment.RevertPath = path
+	return path, nil
+}
+
+// CheckRollbacks returns a no-rollback issue for each deployment without revert SQL. With
+// generate, a revert.sql is first written for those whose inverse is provable, and the paths
+// written are returned.
+func CheckRollbacks(deployments []Deployment, generate bool) ([]string, []LintIssue, error) {
+	var (
+		written []string
+		issues  []LintIssue
+	)
+	for i := range deployments {
+		deployment := &deployments[i]
+		if deployment.HasRevert() {
+			continue
+		}
+
+		sql, reason := GenerateRollback(*deployment)
+		if reason == nil && sql == "" {
+			continue // Nothing to undo
+		}
+		if reason == nil && generate {
+			path, err := writeRollback(deployment, sql)
+			if err != nil {
+				return nil, nil, err
+			}
+			written = append(written, path)
+			continue
+		}
+
+		message := "has no revert.sql; zdd lint --rollbacks can generate one"
+		if reason != nil {
+			message = "has no revert.sql and none can be generated: " + reason.Error()
+		}
+		issues = append(issues, LintIssue{Path: deployment.Directory, Check: "no-rollback", Message: message})
+	}
+	return written, issues, nil
+}
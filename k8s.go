@@ -0,0 +1,172 @@
+package zdd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultKubernetesName = "zdd-deploy"
+)
+
+type (
+	// KubernetesConfig parameterises the manifests rendered by `zdd gen k8s-job`
+	KubernetesConfig struct {
+		Name           string            `yaml:"name"` // Job and container name; default zdd-deploy
+		Namespace      string            `yaml:"namespace"`
+		Image          string            `yaml:"image"` // Image containing the zdd binary and the deployments
+		Args           []string          `yaml:"args"`  // zdd arguments; default [deploy]
+		Env            map[string]string `yaml:"env"`
+		ServiceAccount string            `yaml:"service_account"`
+		BackoffLimit   *int              `yaml:"backoff_limit"`
+		// DatabaseURLSecret sources ZDD_DATABASE_URL from a secret so it never appears in the manifest
+		DatabaseURLSecret *SecretKeyRef `yaml:"database_url_secret"`
+	}
+
+	// SecretKeyRef points at a key within a Kubernetes secret
+	SecretKeyRef struct {
+		Name string `yaml:"name"`
+		Key  string `yaml:"key"`
+	}
+
+	k8sJob struct {
+		APIVersion string      `yaml:"apiVersion"`
+		Kind       string      `yaml:"kind"`
+		Metadata   k8sMetadata `yaml:"metadata"`
+		Spec       k8sJobSpec  `yaml:"spec"`
+	}
+
+	k8sMetadata struct {
+		Name      string            `yaml:"name"`
+		Namespace string            `yaml:"namespace,omitempty"`
+		Labels    map[string]string `yaml:"labels,omitempty"`
+	}
+
+	k8sJobSpec struct {
+		BackoffLimit *int           `yaml:"backoffLimit,omitempty"`
+		Template     k8sPodTemplate `yaml:"template"`
+	}
+
+	k8sPodTemplate struct {
+		Metadata k8sMetadata `yaml:"metadata"`
+		Spec     k8sPodSpec  `yaml:"spec"`
+	}
+
+	k8sPodSpec struct {
+		ServiceAccountName string         `yaml:"serviceAccountName,omitempty"`
+		RestartPolicy      string         `yaml:"restartPolicy"`
+		Containers         []k8sContainer `yaml:"containers"`
+	}
+
+	k8sContainer struct {
+		Name  string   `yaml:"name"`
+		Image string   `yaml:"image"`
+		Args  []string `yaml:"args,omitempty"`
+		Env   []k8sEnv `yaml:"env,omitempty"`
+	}
+
+	k8sEnv struct {
+		Name      string           `yaml:"name"`
+		Value     string           `yaml:"value,omitempty"`
+		ValueFrom *k8sEnvValueFrom `yaml:"valueFrom,omitempty"`
+	}
+
+	k8sEnvValueFrom struct {
+		SecretKeyRef *SecretKeyRef `yaml:"secretKeyRef"`
+	}
+)
+
+// GenerateKubernetesJob renders a batch/v1 Job manifest that runs zdd once
+func GenerateKubernetesJob(config KubernetesConfig) ([]byte, error) {
+	container, err := kubernetesContainer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{"app.kubernetes.io/name": "zdd"}
+	job := k8sJob{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Metadata: k8sMetadata{
+			Name:      container.Name,
+			Namespace: config.Namespace,
+			Labels:    labels,
+		},
+		Spec: k8sJobSpec{
+			BackoffLimit: config.BackoffLimit,
+			Template: k8sPodTemplate{
+				Metadata: k8sMetadata{Name: container.Name, Labels: labels},
+				Spec: k8sPodSpec{
+					ServiceAccountName: config.ServiceAccount,
+					RestartPolicy:      "Never",
+					Containers:         []k8sContainer{container},
+				},
+			},
+		},
+	}
+
+	return marshalKubernetes(job)
+}
+
+// GenerateKubernetesInitContainer renders an initContainers entry that runs zdd before the app starts
+func GenerateKubernetesInitContainer(config KubernetesConfig) ([]byte, error) {
+	container, err := kubernetesContainer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalKubernetes(map[string][]k8sContainer{"initContainers": {container}})
+}
+
+// kubernetesContainer builds the zdd container shared by the Job and initContainer manifests
+func kubernetesContainer(config KubernetesConfig) (k8sContainer, error) {
+	if config.Image == "" {
+		return k8sContainer{}, fmt.Errorf("kubernetes.image is required")
+	}
+
+	name := config.Name
+	if name == "" {
+		name = defaultKubernetesName
+	}
+
+	args := config.Args
+	if len(args) == 0 {
+		args = []string{"deploy"}
+	}
+
+	// Sort env names so the manifest is stable between runs
+	names := make([]string, 0, len(config.Env))
+	for envName := range config.Env {
+		names = append(names, envName)
+	}
+	sort.Strings(names)
+
+	var env []k8sEnv
+	if config.DatabaseURLSecret != nil {
+		env = append(env, k8sEnv{
+			Name:      "ZDD_DATABASE_URL",
+			ValueFrom: &k8sEnvValueFrom{SecretKeyRef: config.DatabaseURLSecret},
+		})
+	}
+	for _, envName := range names {
+		env = append(env, k8sEnv{Name: envName, Value: config.Env[envName]})
+	}
+
+	return k8sContainer{Name: name, Image: config.Image, Args: args, Env: env}, nil
+}
+
+func marshalKubernetes(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to render manifest: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to render manifest: %w", err)
+	}
+	return buf.Bytes(), nil
+}
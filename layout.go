@@ -0,0 +1,119 @@
+package zdd
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+)
+
+type (
+	// Layout describes the files of a deployment tree and how they run, from zdd.yaml: the phases
+	// of its deployments, the format of their IDs, the runners of their files and how scripts
+	// without the executable bit are handled. The zero Layout is the default one.
+	Layout struct {
+		// Phases run in this order, which must include expand, migrate, contract and post in that
+		// order; empty is those four. Every phase but post runs scripts and then SQL files; post runs
+		// scripts only.
+		Phases []string
+		// IDs is the format deployment IDs are created and deployment directories loaded with.
+		// Directories not matching it are not deployments.
+		IDs IDFormat
+		// NonExecutableScripts is how phase scripts without the executable bit are handled: "error"
+		// (the default) fails loading their deployment, "interpreter" runs them with the
+		// interpreter named by their shebang line, and "skip" ignores them
+		NonExecutableScripts string
+		// Runners run SQL files and scripts instead of zdd. Scripts are loaded with the extensions
+		// of Runners.Scripts in addition to sh.
+		Runners Runners
+	}
+
+	// layout is a Layout checked and compiled for loading and running deployments
+	layout struct {
+		Layout
+		phases           []string // Phases, or the builtin phases
+		sqlPhases        []string // The phases that run SQL files
+		scriptExtensions []string // sh and the extensions of Runners.Scripts
+		dirPattern       *regexp.Regexp
+		filePattern      *regexp.Regexp
+	}
+)
+
+// defaultLayout is the compiled zero Layout
+var defaultLayout = mustCompileLayout(Layout{})
+
+// Check reports why the layout cannot be used, if it cannot
+func (l Layout) Check() error {
+	_, err := l.compile()
+	return err
+}
+
+// compile checks the layout and derives the phases, file extensions and patterns it loads
+// deployments with
+func (l Layout) compile() (*layout, error) {
+	phases := builtinPhases
+	if len(l.Phases) > 0 {
+		if err := checkPhases(l.Phases); err != nil {
+			return nil, err
+		}
+		phases = slices.Clone(l.Phases)
+	}
+
+	l.IDs = l.IDs.withDefaults()
+	if err := l.IDs.check(); err != nil {
+		return nil, err
+	}
+
+	switch l.NonExecutableScripts {
+	case "", nonExecutableError, nonExecutableInterpreter, nonExecutableSkip:
+	default:
+		return nil, fmt.Errorf("invalid project.non_executable_scripts %q (expected error, interpreter or skip)", l.NonExecutableScripts)
+	}
+
+	if err := l.Runners.check(); err != nil {
+		return nil, err
+	}
+	extensions := slices.DeleteFunc(slices.Sorted(maps.Keys(l.Runners.Scripts)), func(extension string) bool { return extension == "sh" })
+	scriptExtensions := append([]string{"sh"}, extensions...)
+
+	return &layout{
+		Layout:           l,
+		phases:           phases,
+		sqlPhases:        phasesWithSQL(phases),
+		scriptExtensions: scriptExtensions,
+		dirPattern:       l.IDs.dirPattern(),
+		filePattern:      phaseFilePattern(phases, scriptExtensions),
+	}, nil
+}
+
+// mustCompileLayout compiles a layout known to be valid
+func mustCompileLayout(l Layout) *layout {
+	compiled, err := l.compile()
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// orDefault returns the layout, or the default one for deployments and plans not loaded with one
+func (l *layout) orDefault() *layout {
+	if l == nil {
+		return defaultLayout
+	}
+	return l
+}
+
+// phaseIndex returns the position of phase in the execution order, or -1 if it is unknown
+func (l *layout) phaseIndex(phase string) int {
+	return slices.Index(l.orDefault().phases, phase)
+}
+
+// Layout returns the layout of the deployment trees configured by the config file
+func (c *Config) Layout() Layout {
+	return Layout{
+		Phases:               c.Phases,
+		IDs:                  c.IDs,
+		NonExecutableScripts: c.Project.NonExecutableScripts,
+		Runners:              c.Runners,
+	}
+}
@@ -0,0 +1,187 @@
+package zdd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type (
+	// LintIssue is a statement in a SQL file that zdd cannot run as written, or that does not
+	// belong in the phase the file runs in
+	LintIssue struct {
+		Path    string
+		Line    int
+		Check   string // Name of the check
+		Message string
+	}
+
+	// lintRule matches a kind of statement; the first non-empty group of pattern names the object
+	lintRule struct {
+		name    string
+		pattern *regexp.Regexp
+		// exclude drops matches that also match it, as Go regexps cannot look ahead
+		exclude *regexp.Regexp
+		// phases limits the rule to files of these phases; empty applies to every phase
+		phases  []string
+		message string
+	}
+)
+
+// psqlCommandPattern matches a psql meta-command line and captures its name
+var psqlCommandPattern = regexp.MustCompile(`(?m)^[ \t]*\\(\S*).*$`)
+
+var lintRules = []lintRule{
+	{
+		name:    "transaction-control",
+		pattern: regexp.MustCompile(`(?im)^\s*(BEGIN|START\s+TRANSACTION|COMMIT|ROLLBACK)\s*;`),
+		message: "%s controls the transaction, but zdd already runs each SQL file in a transaction of its own; remove it",
+	},
+	{
+		name:    "no-transaction",
+		pattern: regexp.MustCompile(`(?is)\b((?:CREATE|DROP)\s+(?:UNIQUE\s+)?INDEX\s+CONCURRENTLY|REINDEX\b[^;]*\bCONCURRENTLY|VACUUM|CREATE\s+DATABASE|ALTER\s+SYSTEM)\b`),
+		message: "%s cannot run in a transaction block, which zdd runs SQL files in; run it from the phase's script instead",
+	},
+	{
+		name:    "enum-add-value",
+		pattern: regexp.MustCompile(`(?is)\bALTER\s+TYPE\s+([^\s;]+)\s+ADD\s+VALUE\b`),
+		message: "adds a value to enum %s, which PostgreSQL cannot use in the transaction that added it; declare it in enums.yaml (zdd create --add-enum-value) instead",
+	},
+	{
+		name:    "drop-before-contract",
+		pattern: regexp.MustCompile(`(?is)\bDROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?` + cdcTable + `|\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*\bDROP\s+COLUMN\b`),
+		phases:  []string{"expand", "migrate"},
+		message: "drops %s or one of its columns while the previous application version may still use it; move it to contract.sql",
+	},
+	{
+		name:    "add-not-null-column",
+		pattern: regexp.MustCompile(`(?is)\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*?\bADD\s+(?:COLUMN\s+)?[^;,]*\bNOT\s+NULL\b[^;,]*`),
+		exclude: regexp.MustCompile(`(?i)\bDEFAULT\b|\bGENERATED\b|\bADD\s+CONSTRAINT\b|\bIS\s+NOT\s+NULL\b`),
+		phases:  []string{"expand"},
+		message: "adds a NOT NULL column without a default to %s, so inserts by the previous application version fail; add a default, or set NOT NULL in contract",
+	},
+	{
+		name:    "set-not-null",
+		pattern: regexp.MustCompile(`(?is)\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*\bSET\s+NOT\s+NULL\b`),
+		phases:  []string{"expand"},
+		message: "makes a column of %s NOT NULL while the previous application version may still write NULLs; move it to contract.sql",
+	},
+	{
+		name:    "narrow-column-type",
+		pattern: regexp.MustCompile(`(?is)\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*\bALTER\s+(?:COLUMN\s+)?\S+\s+(?:SET\s+DATA\s+)?TYPE\s+(?:(?:VARCHAR|CHARACTER\s+VARYING|CHAR|CHARACTER|NUMERIC|DECIMAL)\s*\(|(?:SMALLINT|INT2|INTEGER|INT4|INT|REAL|FLOAT4)\b)`),
+		phases:  []string{"expand"},
+		message: "changes a column of %s to a bounded type, which may not hold values the previous application version writes; widen in expand and narrow only in contract",
+	},
+	{
+		name:    "rename-before-contract",
+		pattern: regexp.MustCompile(`(?is)\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + cdcTable + `[^;]*\bRENAME\b`),
+		phases:  []string{"expand", "migrate"},
+		message: "renames %s or one of its columns under the running application; generate a zero-downtime rename with zdd create --rename-column or --rename-table",
+	},
+}
+
+// String formats the issue as path:line: message (check), or path: message (check) for issues
+// with a whole file or deployment
+func (i LintIssue) String() string {
+	if i.Line == 0 {
+		return fmt.Sprintf("%s: %s (%s)", i.Path, i.Message, i.Check)
+	}
+	return fmt.Sprintf("%s:%d: %s (%s)", i.Path, i.Line, i.Message, i.Check)
+}
+
+// LintSQL checks the SQL file at path, which runs in phase of the layout, for statements zdd
+// cannot run as written or that do not belong in the phase. Included files are not followed.
+func LintSQL(layout Layout, path, phase string) ([]LintIssue, error) {
+	l, err := layout.compile()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(l.sqlPhases, phase) {
+		return nil, fmt.Errorf("invalid phase %q for SQL (expected one of %v)", phase, l.sqlPhases)
+	}
+	return lintSQLFile(path, phase)
+}
+
+// lintSQLFile returns the issues of the SQL file at path, which runs in phase
+func lintSQLFile(path, phase string) ([]LintIssue, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SQL file %s: %w", path, err)
+	}
+	return lintSQL(path, phase, string(content)), nil
+}
+
+// LintDeployments checks the SQL files of the deployments with LintSQL
+func LintDeployments(deployments []Deployment) ([]LintIssue, error) {
+	var issues []LintIssue
+	for _, deployment := range deployments {
+		for _, task := range deployment.Tasks() {
+			if task.TaskType != "sql" {
+				continue
+			}
+			found, err := lintSQLFile(task.Path, task.Phase)
+			if err != nil {
+				return nil, err
+			}
+			issues = append(issues, found...)
+		}
+	}
+	return issues, nil
+}
+
+// lintSQL returns the issues of the SQL in content, read from path
+func lintSQL(path, phase, content string) []LintIssue {
+	blank := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '\n' {
+				return r
+			}
+			return ' '
+		}, s)
+	}
+	// Blank out comments, keeping newlines so matches report their original line
+	content = sqlCommentPattern.ReplaceAllStringFunc(content, blank)
+
+	var issues []LintIssue
+	for _, match := range psqlCommandPattern.FindAllStringSubmatchIndex(content, -1) {
+		command := content[match[2]:match[3]]
+		if !slices.Contains(psqlCommands, command) {
+			issues = append(issues, LintIssue{
+				Path:    path,
+				Line:    strings.Count(content[:match[0]], "\n") + 1,
+				Check:   "psql-meta-command",
+				Message: fmt.Sprintf("psql meta-command \\%s is not supported (use \\i, \\ir, \\set, \\unset or \\echo)", command),
+			})
+		}
+	}
+	content = psqlCommandPattern.ReplaceAllStringFunc(content, blank)
+
+	for _, rule := range lintRules {
+		if len(rule.phases) > 0 && !slices.Contains(rule.phases, phase) {
+			continue
+		}
+		for _, match := range rule.pattern.FindAllStringSubmatchIndex(content, -1) {
+			if rule.exclude != nil && rule.exclude.MatchString(content[match[0]:match[1]]) {
+				continue
+			}
+			var object string
+			for i := 2; i < len(match); i += 2 {
+				if match[i] >= 0 {
+					object = content[match[i]:match[i+1]]
+					break
+				}
+			}
+			issues = append(issues, LintIssue{
+				Path:    path,
+				Line:    strings.Count(content[:match[0]], "\n") + 1,
+				Check:   rule.name,
+				Message: fmt.Sprintf(rule.message, strings.Join(strings.Fields(object), " ")),
+			})
+		}
+	}
+
+	slices.SortStableFunc(issues, func(a, b LintIssue) int { return a.Line - b.Line })
+	return issues
+}
@@ -0,0 +1,143 @@
+package zdd
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+type (
+	// Module is a named deployment tree. Deployments of different modules share the metadata
+	// table, distinguished by its module column; the default module has an empty name.
+	Module struct {
+		Name string `yaml:"name"`
+		Path string `yaml:"path"`
+		// Requires maps a deployment ID of this module to deployments of other modules,
+		// written as module/id, that must be applied before it
+		Requires map[string][]string `yaml:"requires"`
+		// Layout is the layout of the module's deployments, from the top level of the config file;
+		// modules deployed together share it
+		Layout Layout `yaml:"-"`
+	}
+)
+
+// LoadModuleDeployments loads all deployments of the module with its layout and tags them with
+// its name
+func LoadModuleDeployments(module Module) ([]Deployment, error) {
+	l, err := module.Layout.compile()
+	if err != nil {
+		return nil, err
+	}
+	deployments, err := loadDeployments(module.Path, l)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range deployments {
+		deployments[i].Module = module.Name
+	}
+
+	return deployments, nil
+}
+
+// ListModules loads the deployments of every module, optionally compares them with the database,
+// and outputs a combined status report with a section per named module to stdout
+func ListModules(modules []Module, db DatabaseProvider) error {
+	return WriteModuleList(os.Stdout, modules, db, TableStyle{})
+}
+
+// WriteModuleList is ListModules writing to w with the given table style
+func WriteModuleList(w io.Writer, modules []Module, db DatabaseProvider, style TableStyle) error {
+	statuses, err := moduleStatuses(modules, db)
+	if err != nil {
+		return err
+	}
+
+	repeatables, err := repeatableStates(modules, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "Deployment Status:")
+	fmt.Fprintln(w, "==================")
+
+	if recorder, ok := db.(HeartbeatRecorder); ok {
+		runs, err := recorder.GetDeployRuns()
+		if err != nil {
+			return fmt.Errorf("failed to get running deploys: %w", err)
+		}
+		writeDeployRuns(w, runs, style)
+	}
+
+	for i, module := range modules {
+		if module.Name != "" {
+			fmt.Fprintf(w, "\nModule %s (%s):\n", module.Name, module.Path)
+		}
+
+		printDeploymentStatus(w, statuses[i], style)
+		printRepeatableStatus(w, repeatables[i], style)
+	}
+
+	return nil
+}
+
+// moduleStatuses compares the local deployments of each module with those applied to the
+// database (if db is non-nil), returning one status per module
+func moduleStatuses(modules []Module, db DatabaseProvider) ([]*DeploymentStatus, error) {
+	// Get applied deployments from database if connected
+	var appliedDeployments []DeploymentDBRecord
+	attempts := make(map[string]DeploymentAttempt)
+	if db != nil {
+		if err := db.InitDeploymentSchema(); err != nil {
+			return nil, fmt.Errorf("failed to initialize deployment schema: %w", err)
+		}
+
+		names := make([]string, len(modules))
+		for i, module := range modules {
+			names[i] = module.Name
+		}
+		var err error
+		appliedDeployments, err = appliedRecords(db, names)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get applied deployments: %w", err)
+		}
+
+		if lister, ok := db.(AttemptLister); ok {
+			records, err := lister.GetDeploymentAttempts()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get deployment attempts: %w", err)
+			}
+			for _, attempt := range records {
+				attempts[attempt.Key()] = attempt
+			}
+		}
+	}
+
+	statuses := make([]*DeploymentStatus, 0, len(modules))
+	for _, module := range modules {
+		localDeployments, err := LoadModuleDeployments(module)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load local deployments: %w", err)
+		}
+
+		var moduleApplied []DeploymentDBRecord
+		for _, record := range appliedDeployments {
+			if record.Module == module.Name {
+				moduleApplied = append(moduleApplied, record)
+			}
+		}
+
+		status := CompareDeployments(localDeployments, moduleApplied)
+		for _, deployment := range status.Pending {
+			if attempt, ok := attempts[deployment.Key()]; ok {
+				if status.Attempts == nil {
+					status.Attempts = make(map[string]DeploymentAttempt)
+				}
+				status.Attempts[deployment.Key()] = attempt
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
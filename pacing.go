@@ -0,0 +1,101 @@
+package zdd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckTimeout is how long a paced contract phase waits for its health check to pass
+	DefaultHealthCheckTimeout = time.Minute
+	// healthCheckPollInterval is how often a failing health check is run again
+	healthCheckPollInterval = 2 * time.Second
+)
+
+// ContractPacingConfig runs contract SQL files one statement at a time, pausing and checking the
+// database's health after each destructive statement, so a sequence of drops does not churn the
+// catalog and contend for locks all at once. Each statement then runs in its own transaction.
+type ContractPacingConfig struct {
+	// Pause is how long to wait after each destructive statement, e.g. 5s
+	Pause Duration `yaml:"pause"`
+	// HealthCheck is a query returning a single boolean, run before the statement after each
+	// destructive one until it is true, e.g. no sessions waiting on locks
+	HealthCheck string `yaml:"health_check"`
+	// HealthCheckTimeout is how long to wait for HealthCheck to pass (default 1m)
+	HealthCheckTimeout Duration `yaml:"health_check_timeout"`
+}
+
+// Enabled reports whether contract files are paced
+func (c ContractPacingConfig) Enabled() bool {
+	return c.Pause > 0 || c.HealthCheck != ""
+}
+
+// HealthCheckTimeoutDuration returns the health check timeout, or DefaultHealthCheckTimeout if
+// it is not set
+func (c ContractPacingConfig) HealthCheckTimeoutDuration() time.Duration {
+	if c.HealthCheckTimeout > 0 {
+		return time.Duration(c.HealthCheckTimeout)
+	}
+	return DefaultHealthCheckTimeout
+}
+
+// runPacedContract runs the statements of a contract SQL file one at a time, pausing and waiting
+// for the health check after each destructive statement but the last. Statements ClassifySQL
+// puts in contract, or does not recognize, count as destructive.
+func (p *Plan) runPacedContract(deployment *Deployment, path, content string) error {
+	statements := ClassifySQL(content)
+	for i, statement := range statements {
+		if i > 0 && destructive(statements[i-1]) {
+			if err := p.paceContract(); err != nil {
+				return fmt.Errorf("failed to execute contract SQL file %s: %w", path, err)
+			}
+		}
+		fmt.Fprintf(p.out(), "    Statement %d/%d: %s\n", i+1, len(statements), statement.Summary())
+		if err := p.db.ExecuteSQLInTransaction(runCommentHeader(*deployment, path) + statement.SQL); err != nil {
+			return fmt.Errorf("failed to execute contract SQL file %s at line %d: %w", path, statement.Line, err)
+		}
+	}
+	return nil
+}
+
+// destructive reports whether a paced contract phase pauses after the statement
+func destructive(statement ClassifiedStatement) bool {
+	return statement.Phase == "contract" || statement.Phase == ""
+}
+
+// paceContract waits for the configured pause, then until the health check passes
+func (p *Plan) paceContract() error {
+	if pause := time.Duration(p.ContractPacing.Pause); pause > 0 {
+		fmt.Fprintf(p.out(), "    Pausing %s\n", pause)
+		time.Sleep(pause)
+	}
+	query := p.ContractPacing.HealthCheck
+	if query == "" {
+		return nil
+	}
+	runner, ok := p.db.(AssertionRunner)
+	if !ok {
+		return fmt.Errorf("database cannot run the contract_pacing health check")
+	}
+
+	timeout := p.ContractPacing.HealthCheckTimeoutDuration()
+	deadline := time.Now().Add(timeout)
+	for {
+		rows, err := runner.QueryValues(query)
+		if err != nil {
+			return fmt.Errorf("failed to run health check: %w", err)
+		}
+		if len(rows) != 1 || len(rows[0]) != 1 {
+			return fmt.Errorf("health check must return a single boolean, got %d rows", len(rows))
+		}
+		if value := strings.ToLower(rows[0][0]); value == "t" || value == "true" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("health check has not passed after %s", timeout)
+		}
+		fmt.Fprintf(p.out(), "    Waiting for the health check to pass\n")
+		time.Sleep(healthCheckPollInterval)
+	}
+}
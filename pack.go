@@ -0,0 +1,283 @@
+package zdd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Pack file markers; each starts a line of its own
+const (
+	packHeader            = "--zdd:pack 1"
+	packChecksumMarker    = "--zdd:sha256 "
+	packTransactionMarker = "--zdd:segment transaction"
+	packStatementsMarker  = "--zdd:segment statements"
+	packDeploymentMarker  = "--zdd:deployment "
+)
+
+type (
+	// Pack is every deployment of a project compiled into a single file, for bootstrapping
+	// fresh databases in few round trips. Segments run in order: a transaction segment runs all
+	// its SQL in one transaction together with recording its deployments, and a statements
+	// segment runs each statement on its own, as ALTER TYPE ... ADD VALUE requires.
+	Pack struct {
+		Segments []PackSegment
+	}
+
+	// PackSegment is a unit of a pack that is applied at once
+	PackSegment struct {
+		Transaction bool
+		SQL         string
+		// Statements run one at a time outside a transaction, in statements segments
+		Statements []string
+		// Deployments are recorded as applied when the segment commits
+		Deployments []DeploymentDBRecord
+	}
+
+	// PackApplier is implemented by databases that can run a transaction segment and record its
+	// deployments in a single transaction
+	PackApplier interface {
+		ApplyPackSegment(sql string, deployments []DeploymentDBRecord) error
+	}
+)
+
+// CompileModulesPack compiles every deployment of the modules into a pack, ordered to satisfy
+// cross-module requirements
+func CompileModulesPack(modules []Module) (*Pack, error) {
+	all := make([][]Deployment, len(modules))
+	for i, module := range modules {
+		deployments, err := LoadModuleDeployments(module)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load local deployments: %w", err)
+		}
+		all[i] = deployments
+	}
+
+	ordered, err := orderDeployments(modules, all, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return CompilePack(ordered)
+}
+
+// CompilePack compiles the deployments, in order, into a pack. Packs hold SQL only, so
+// deployments with scripts, assertions, validations, compatibility views or partition
+// directives cannot be packed.
+func CompilePack(deployments []Deployment) (*Pack, error) {
+	pack := &Pack{}
+	var current *PackSegment
+
+	for _, deployment := range deployments {
+		for _, task := range deployment.Tasks() {
+			if task.TaskType != "sql" && task.TaskType != "enum" {
+				return nil, fmt.Errorf("deployment %s has a %s task, which a pack cannot contain", deployment.Key(), task.Name())
+			}
+		}
+
+		// Enum values must be committed before SQL of the same deployment uses them
+		if len(deployment.EnumValues) > 0 {
+			segment := PackSegment{}
+			for _, enum := range deployment.EnumValues {
+				for _, value := range enum.Add {
+					segment.Statements = append(segment.Statements, fmt.Sprintf("ALTER TYPE %s ADD VALUE IF NOT EXISTS %s", quoteIdentifier(enum.Type), quoteLiteral(value)))
+				}
+			}
+			pack.Segments = append(pack.Segments, segment)
+			current = nil
+		}
+
+		if current == nil {
+			pack.Segments = append(pack.Segments, PackSegment{Transaction: true})
+			current = &pack.Segments[len(pack.Segments)-1]
+		}
+
+		var sql strings.Builder
+		for _, task := range deployment.Tasks() {
+			if task.TaskType != "sql" {
+				continue
+			}
+			// Packs are applied without output, so \echo is dropped
+			text, err := readPsqlFile(task.Path, func(string) {})
+			if err != nil {
+				return nil, err
+			}
+			content := bytes.TrimSpace([]byte(text))
+			sql.WriteString(sqlCommentHeader(deployment, task.Path))
+			sql.Write(content)
+			// Terminate the last statement, which a file may leave open or end with a comment
+			if !bytes.HasSuffix(content, []byte(";")) {
+				sql.WriteString("\n;")
+			}
+			sql.WriteString("\n")
+		}
+
+		current.SQL += sql.String()
+		current.Deployments = append(current.Deployments, DeploymentDBRecord{
+			ID:       deployment.ID,
+			Name:     deployment.Name,
+			Module:   deployment.Module,
+			Checksum: CalculateChecksum(deployment),
+		})
+	}
+
+	return pack, nil
+}
+
+// WriteTo writes the pack in its file format: SQL between marker comments, preceded by a
+// checksum of everything after it
+func (p *Pack) WriteTo(w io.Writer) (int64, error) {
+	var body bytes.Buffer
+	for _, segment := range p.Segments {
+		if !segment.Transaction {
+			fmt.Fprintln(&body, packStatementsMarker)
+			for _, statement := range segment.Statements {
+				fmt.Fprintf(&body, "%s;\n", statement)
+			}
+			continue
+		}
+
+		fmt.Fprintln(&body, packTransactionMarker)
+		for _, d := range segment.Deployments {
+			fmt.Fprintf(&body, "%s%s %s %s\n", packDeploymentMarker, d.Key(), d.Checksum, d.Name)
+		}
+		body.WriteString(segment.SQL)
+	}
+
+	n, err := fmt.Fprintf(w, "%s\n%s%x\n", packHeader, packChecksumMarker, sha256.Sum256(body.Bytes()))
+	if err != nil {
+		return int64(n), fmt.Errorf("failed to write pack: %w", err)
+	}
+	m, err := w.Write(body.Bytes())
+	if err != nil {
+		return int64(n + m), fmt.Errorf("failed to write pack: %w", err)
+	}
+	return int64(n + m), nil
+}
+
+// ReadPack reads a pack file, verifying its checksum
+func ReadPack(path string) (*Pack, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack %s: %w", path, err)
+	}
+
+	header, rest, _ := strings.Cut(string(content), "\n")
+	checksumLine, body, _ := strings.Cut(rest, "\n")
+	if header != packHeader || !strings.HasPrefix(checksumLine, packChecksumMarker) {
+		return nil, fmt.Errorf("%s is not a zdd pack", path)
+	}
+	if fmt.Sprintf("%x", sha256.Sum256([]byte(body))) != strings.TrimPrefix(checksumLine, packChecksumMarker) {
+		return nil, fmt.Errorf("pack %s is corrupt: checksum mismatch", path)
+	}
+
+	pack := &Pack{}
+	var current *PackSegment
+	var sql strings.Builder
+	flush := func() {
+		if current != nil && current.Transaction {
+			current.SQL = sql.String()
+		}
+		sql.Reset()
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(nil, len(body)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == packTransactionMarker || line == packStatementsMarker:
+			flush()
+			pack.Segments = append(pack.Segments, PackSegment{Transaction: line == packTransactionMarker})
+			current = &pack.Segments[len(pack.Segments)-1]
+
+		case current == nil:
+			return nil, fmt.Errorf("pack %s: content before the first segment", path)
+
+		case current.Transaction && strings.HasPrefix(line, packDeploymentMarker):
+			fields := strings.SplitN(strings.TrimPrefix(line, packDeploymentMarker), " ", 3)
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("pack %s: invalid deployment line %q", path, line)
+			}
+			record := DeploymentDBRecord{ID: fields[0], Checksum: fields[1], Name: fields[2]}
+			if module, id, ok := strings.Cut(fields[0], "/"); ok {
+				record.Module, record.ID = module, id
+			}
+			current.Deployments = append(current.Deployments, record)
+
+		case current.Transaction:
+			sql.WriteString(line + "\n")
+
+		default:
+			current.Statements = append(current.Statements, strings.TrimSuffix(line, ";"))
+		}
+	}
+	flush()
+
+	return pack, nil
+}
+
+// ApplyPack applies every segment of the pack to a database without applied deployments of the
+// pack's modules; deployments of other trees sharing the database do not matter
+func ApplyPack(db DatabaseProvider, pack *Pack, w io.Writer) error {
+	var modules []string
+	for _, segment := range pack.Segments {
+		for _, record := range segment.Deployments {
+			if !slices.Contains(modules, record.Module) {
+				modules = append(modules, record.Module)
+			}
+		}
+	}
+	applied, err := appliedRecords(db, modules)
+	if err != nil {
+		return fmt.Errorf("failed to get applied deployments: %w", err)
+	}
+	if len(applied) > 0 {
+		return fmt.Errorf("a pack can only bootstrap a database without applied deployments, but %s is applied", applied[len(applied)-1].Key())
+	}
+
+	for i, segment := range pack.Segments {
+		if !segment.Transaction {
+			executor, ok := db.(StatementExecutor)
+			if !ok {
+				return fmt.Errorf("database does not support statements outside a transaction")
+			}
+			for _, statement := range segment.Statements {
+				if err := executor.ExecuteStatement(statement); err != nil {
+					return fmt.Errorf("pack segment %d failed: %w", i+1, err)
+				}
+			}
+			continue
+		}
+
+		if err := applyPackTransaction(db, segment); err != nil {
+			return fmt.Errorf("pack segment %d failed: %w", i+1, err)
+		}
+		for _, d := range segment.Deployments {
+			fmt.Fprintf(w, "Deployment %s applied from pack\n", d.Key())
+		}
+	}
+	return nil
+}
+
+// applyPackTransaction runs a transaction segment and records its deployments, in the same
+// transaction if the database supports it
+func applyPackTransaction(db DatabaseProvider, segment PackSegment) error {
+	if applier, ok := db.(PackApplier); ok {
+		return applier.ApplyPackSegment(segment.SQL, segment.Deployments)
+	}
+
+	if err := db.ExecuteSQLInTransaction(segment.SQL); err != nil {
+		return err
+	}
+	for _, d := range segment.Deployments {
+		if err := db.RecordDeployment(Deployment{ID: d.ID, Name: d.Name, Module: d.Module}, d.Checksum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
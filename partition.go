@@ -0,0 +1,324 @@
+package zdd
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	partitionsFileName       = "partitions.yaml"
+	defaultFuturePartitions  = 3
+	partitionedTableSuffix   = "_partitioned"
+	unpartitionedTableSuffix = "_unpartitioned"
+)
+
+//go:embed assets/partition_table
+var partitionTemplates embed.FS
+
+// partitionIntervals maps each interval to the start of the period containing a time, the
+// length of a period and the layout of partition name suffixes
+var partitionIntervals = map[string]struct {
+	start  func(time.Time) time.Time
+	next   func(time.Time) time.Time
+	layout string
+}{
+	"day": {
+		start:  func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC) },
+		next:   func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+		layout: "2006_01_02",
+	},
+	"week": {
+		start: func(t time.Time) time.Time {
+			day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			return day.AddDate(0, 0, -(int(day.Weekday())+6)%7) // Weeks start on Monday
+		},
+		next:   func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+		layout: "2006_01_02",
+	},
+	"month": {
+		start:  func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC) },
+		next:   func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+		layout: "2006_01",
+	},
+	"year": {
+		start:  func(t time.Time) time.Time { return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC) },
+		next:   func(t time.Time) time.Time { return t.AddDate(1, 0, 0) },
+		layout: "2006",
+	},
+}
+
+type (
+	// PartitionDirective is one partition maintenance step from partitions.yaml. Exactly one of
+	// CreateFuture, Attach and Detach is set.
+	PartitionDirective struct {
+		Table string `yaml:"table"` // Partitioned (parent) table, possibly schema-qualified
+		// Phase runs the directive after that phase's SQL; default expand
+		Phase        string            `yaml:"phase"`
+		CreateFuture *FuturePartitions `yaml:"create_future"`
+		// Attach names an existing table to attach as the partition for values From (inclusive) To (exclusive)
+		Attach string `yaml:"attach"`
+		From   string `yaml:"from"`
+		To     string `yaml:"to"`
+		// Detach names a partition to detach concurrently, outside a transaction
+		Detach string `yaml:"detach"`
+	}
+
+	// FuturePartitions creates range partitions for the current period and Count periods ahead
+	FuturePartitions struct {
+		Interval string `yaml:"interval"` // day, week, month or year
+		Count    int    `yaml:"count"`    // Default: 3
+	}
+
+	// StatementExecutor is implemented by databases that can run a statement outside a
+	// transaction block, as DETACH PARTITION ... CONCURRENTLY requires
+	StatementExecutor interface {
+		ExecuteStatement(sql string) error
+	}
+
+	// PartitionMigration describes moving a regular table to a range-partitioned one
+	PartitionMigration struct {
+		Table    string // Possibly schema-qualified table to partition
+		Column   string // Partition key column, usually a timestamp
+		Interval string // Partition interval: day, week, month or year
+		Key      string // Column identifying rows in both tables; default id
+	}
+
+	// partitionStep is a single statement of a partition task, reported as progress
+	partitionStep struct {
+		description string
+		sql         string
+		// outsideTransaction runs the statement outside a transaction block
+		outsideTransaction bool
+	}
+)
+
+// LoadPartitionDirectives reads the directives of a partitions.yaml file; their phases are checked
+// against the layout of the deployment when it is loaded
+func LoadPartitionDirectives(path string) ([]PartitionDirective, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var directives []PartitionDirective
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&directives); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for i := range directives {
+		if directives[i].Phase == "" {
+			directives[i].Phase = "expand"
+		}
+		if err := directives[i].check(); err != nil {
+			return nil, fmt.Errorf("%s: directive #%d: %w", path, i+1, err)
+		}
+	}
+
+	return directives, nil
+}
+
+// check reports a directive without exactly one action or with missing settings
+func (d PartitionDirective) check() error {
+	actions := 0
+	for _, set := range []bool{d.CreateFuture != nil, d.Attach != "", d.Detach != ""} {
+		if set {
+			actions++
+		}
+	}
+
+	switch {
+	case d.Table == "":
+		return fmt.Errorf("table is required")
+	case actions != 1:
+		return fmt.Errorf("exactly one of create_future, attach and detach is required")
+	case d.Attach != "" && (d.From == "" || d.To == ""):
+		return fmt.Errorf("attach requires from and to")
+	case d.CreateFuture != nil:
+		if _, ok := partitionIntervals[d.CreateFuture.Interval]; !ok {
+			return fmt.Errorf("unknown interval %q (expected day, week, month or year)", d.CreateFuture.Interval)
+		}
+		if d.CreateFuture.Count < 0 {
+			return fmt.Errorf("count must not be negative")
+		}
+	}
+	return nil
+}
+
+// steps expands the directive into the statements it runs, relative to now
+func (d PartitionDirective) steps(now time.Time) []partitionStep {
+	parent := quoteIdentifier(d.Table)
+
+	switch {
+	case d.Attach != "":
+		return []partitionStep{{
+			description: fmt.Sprintf("Attaching %s to %s", d.Attach, d.Table),
+			sql: fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM (%s) TO (%s)",
+				parent, quoteIdentifier(d.Attach), quoteLiteral(d.From), quoteLiteral(d.To)),
+		}}
+
+	case d.Detach != "":
+		return []partitionStep{{
+			description:        fmt.Sprintf("Detaching %s from %s", d.Detach, d.Table),
+			sql:                fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s CONCURRENTLY", parent, quoteIdentifier(d.Detach)),
+			outsideTransaction: true,
+		}}
+	}
+
+	interval := partitionIntervals[d.CreateFuture.Interval]
+	count := d.CreateFuture.Count
+	if count == 0 {
+		count = defaultFuturePartitions
+	}
+
+	var steps []partitionStep
+	from := interval.start(now.UTC())
+	for range count + 1 {
+		to := interval.next(from)
+		name := d.Table + "_" + from.Format(interval.layout)
+		steps = append(steps, partitionStep{
+			description: fmt.Sprintf("Creating partition %s", name),
+			sql:         createPartitionSQL(parent, quoteIdentifier(name), from.Format(time.DateOnly), to.Format(time.DateOnly)),
+		})
+		from = to
+	}
+	return steps
+}
+
+// createPartitionSQL creates a range partition unless it exists. Rows for the range already in
+// the default partition are moved into it while writes to the default partition wait.
+func createPartitionSQL(parent, partition, from, to string) string {
+	return fmt.Sprintf(`DO $$
+DECLARE
+    parent regclass := %[1]s::regclass;
+    default_partition regclass;
+    key_column name;
+BEGIN
+    IF to_regclass(%[2]s) IS NOT NULL THEN
+        RETURN;
+    END IF;
+
+    SELECT NULLIF(p.partdefid, 0)::regclass, a.attname INTO default_partition, key_column
+    FROM pg_partitioned_table p
+    JOIN pg_attribute a ON a.attrelid = p.partrelid AND a.attnum = p.partattrs[0]
+    WHERE p.partrelid = parent;
+
+    IF default_partition IS NULL THEN
+        EXECUTE format('CREATE TABLE %%s PARTITION OF %%s FOR VALUES FROM (%%L) TO (%%L)', %[2]s, parent, %[3]s, %[4]s);
+        RETURN;
+    END IF;
+
+    EXECUTE format('LOCK TABLE %%s IN ACCESS EXCLUSIVE MODE', default_partition);
+    EXECUTE format('CREATE TABLE %%s (LIKE %%s INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', %[2]s, parent);
+    EXECUTE format('WITH moved AS (DELETE FROM %%s WHERE %%I >= %%L AND %%I < %%L RETURNING *) INSERT INTO %%s SELECT * FROM moved',
+        default_partition, key_column, %[3]s, key_column, %[4]s, %[2]s);
+    EXECUTE format('ALTER TABLE %%s ATTACH PARTITION %%s FOR VALUES FROM (%%L) TO (%%L)', parent, %[2]s, %[3]s, %[4]s);
+END $$;`, quoteLiteral(parent), quoteLiteral(partition), quoteLiteral(from), quoteLiteral(to))
+}
+
+// partitionsTask runs the deployment's partition directives for a phase after its SQL
+func (d *Deployment) partitionsTask(phase string) Task {
+	return Task{TaskType: "partitions", Path: filepath.Join(d.Directory, partitionsFileName), Phase: phase, Deployment: d}
+}
+
+// hasPartitionDirectives reports whether any of the deployment's directives run in phase
+func (d Deployment) hasPartitionDirectives(phase string) bool {
+	return slices.ContainsFunc(d.Partitions, func(p PartitionDirective) bool { return p.Phase == phase })
+}
+
+// runPartitions runs the deployment's directives for phase one statement at a time, reporting
+// progress, so a failure leaves every earlier step in place and a rerun skips existing partitions
+func (p *Plan) runPartitions(deployment *Deployment, phase string) error {
+	var steps []partitionStep
+	now := time.Now()
+	for _, directive := range deployment.Partitions {
+		if directive.Phase == phase {
+			steps = append(steps, directive.steps(now)...)
+		}
+	}
+
+	for i, step := range steps {
+		fmt.Fprintf(p.out(), "  [%d/%d] %s\n", i+1, len(steps), step.description)
+
+		var err error
+		if step.outsideTransaction {
+			executor, ok := p.db.(StatementExecutor)
+			if !ok {
+				return fmt.Errorf("database does not support statements outside a transaction")
+			}
+			err = executor.ExecuteStatement(step.sql)
+		} else {
+			err = p.db.ExecuteSQLInTransaction(step.sql)
+		}
+		if err != nil {
+			return fmt.Errorf("partition step %d/%d (%s) failed: %w", i+1, len(steps), step.description, err)
+		}
+	}
+	return nil
+}
+
+// CreatePartitionDeployment creates a deployment that moves a table to a range-partitioned copy
+// over several phases. Expand creates the partitioned table with a default partition and mirrors
+// writes into it, partitions.yaml creates the current and upcoming partitions, migrate copies
+// existing rows, validate.yaml samples the copy, and contract swaps the tables' names.
+func CreatePartitionDeployment(module Module, name string, migration PartitionMigration) (*Deployment, error) {
+	if migration.Table == "" || migration.Column == "" {
+		return nil, fmt.Errorf("partitioning requires a table and a partition column")
+	}
+	if _, ok := partitionIntervals[migration.Interval]; !ok {
+		return nil, fmt.Errorf("unknown interval %q (expected day, week, month or year)", migration.Interval)
+	}
+	if name == "" {
+		name = sanitizeName("partition_" + migration.Table + "_by_" + migration.Column)
+	}
+
+	key := migration.Key
+	if key == "" {
+		key = defaultValidationKey
+	}
+	bare := migration.Table[strings.LastIndex(migration.Table, ".")+1:]
+	newName := migration.Table + partitionedTableSuffix
+	suffix := sanitizeName(migration.Table + " partition")
+
+	data := map[string]any{
+		"TableName":            migration.Table,
+		"NewName":              newName,
+		"OldName":              bare + unpartitionedTableSuffix,
+		"KeyName":              key,
+		"ColumnName":           migration.Column,
+		"Interval":             migration.Interval,
+		"Table":                quoteIdentifier(migration.Table),
+		"TableBare":            quoteIdentifier(bare),
+		"OldBare":              quoteIdentifier(bare + unpartitionedTableSuffix),
+		"New":                  quoteIdentifier(newName),
+		"Default":              quoteIdentifier(newName + "_default"),
+		"Column":               quoteIdentifier(migration.Column),
+		"Key":                  quoteIdentifier(key),
+		"KeyIndex":             quoteIdentifier(sanitizeName(bare + partitionedTableSuffix + "_" + key + "_idx")),
+		"Function":             quoteIdentifier("zdd_sync_" + suffix),
+		"Trigger":              quoteIdentifier("zdd_sync_" + suffix),
+		"TableLiteral":         quoteLiteral(quoteIdentifier(migration.Table)),
+		"PrefixLiteral":        quoteLiteral(bare + partitionedTableSuffix + "_"),
+		"PrefixLength":         utf8.RuneCountInString(bare + partitionedTableSuffix + "_"),
+		"RenamedPrefixLiteral": quoteLiteral(bare + "_"),
+	}
+
+	files, err := renderDeploymentFiles(partitionTemplates, "assets/partition_table",
+		[]string{"expand.sql", partitionsFileName, "migrate.sql", validateFileName, "contract.sql"}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return createDeploymentFiles(module, name, files)
+}
@@ -0,0 +1,159 @@
+package zdd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// pgTAPSuffix names the pgTAP test files of a deployment, e.g. orders.pgtap.sql, run once its
+// post phase has run
+const pgTAPSuffix = ".pgtap.sql"
+
+var (
+	// tapPlanPattern matches a TAP plan line, e.g. "1..4" or "1..0 # SKIP no tests"
+	tapPlanPattern = regexp.MustCompile(`^1\.\.(\d+)`)
+	// tapTestPattern matches a TAP test line, e.g. "ok 1 - users has email" or
+	// "not ok 2 - index exists # TODO later"
+	tapTestPattern = regexp.MustCompile(`^(not )?ok\b\s*(\d*)\s*(?:-\s*)?(.*?)(?:\s+#\s*((?i:skip|todo)\b.*))?$`)
+)
+
+type (
+	// TAPTest is a test of a pgTAP file's output
+	TAPTest struct {
+		Number      int    `json:"number"`
+		Passed      bool   `json:"passed"`
+		Description string `json:"description,omitempty"`
+		// Directive is a SKIP or TODO directive with its reason; a failed TODO test passes the file
+		Directive string `json:"directive,omitempty"`
+	}
+
+	// TAPResult is the parsed output of a pgTAP test file
+	TAPResult struct {
+		Module       string    `json:"module"`
+		DeploymentID string    `json:"deployment_id"`
+		File         string    `json:"file"`
+		Planned      int       `json:"planned"` // -1 if the output had no plan
+		Tests        []TAPTest `json:"tests"`
+		// Diagnostics are the comment lines of the output, without their leading #
+		Diagnostics []string `json:"diagnostics,omitempty"`
+		Passed      bool     `json:"passed"`
+	}
+
+	// TAPRunner is implemented by databases that can run pgTAP test files
+	TAPRunner interface {
+		// RunTAP runs sql in a transaction that is rolled back and returns the first column of
+		// every row its statements return, which for pgTAP is its TAP output
+		RunTAP(sql string) ([]string, error)
+	}
+)
+
+// ParseTAP parses TAP output. The result passes if it has a plan that matches its tests and
+// every test passed, was skipped or is TODO, and the output did not bail out.
+func ParseTAP(lines []string) TAPResult {
+	result := TAPResult{Planned: -1, Tests: make([]TAPTest, 0)}
+	bailedOut := false
+	for _, line := range lines {
+		for _, line := range strings.Split(line, "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "":
+			case strings.HasPrefix(line, "#"):
+				result.Diagnostics = append(result.Diagnostics, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+			case strings.HasPrefix(line, "Bail out!"):
+				bailedOut = true
+				result.Diagnostics = append(result.Diagnostics, line)
+			case tapPlanPattern.MatchString(line):
+				result.Planned, _ = strconv.Atoi(tapPlanPattern.FindStringSubmatch(line)[1])
+			case tapTestPattern.MatchString(line):
+				matches := tapTestPattern.FindStringSubmatch(line)
+				test := TAPTest{Passed: matches[1] == "", Description: matches[3], Directive: matches[4]}
+				test.Number, _ = strconv.Atoi(matches[2])
+				if test.Number == 0 {
+					test.Number = len(result.Tests) + 1
+				}
+				result.Tests = append(result.Tests, test)
+			}
+		}
+	}
+
+	result.Passed = !bailedOut && result.Planned == len(result.Tests) && !slices.ContainsFunc(result.Tests, func(test TAPTest) bool {
+		return !test.Passed && !strings.HasPrefix(strings.ToUpper(test.Directive), "TODO")
+	})
+	return result
+}
+
+// pgTAPTask runs a pgTAP test file of the deployment once its post phase has run
+func (d *Deployment) pgTAPTask(path string) Task {
+	return Task{TaskType: "pgtap", Path: path, Phase: "post", Deployment: d}
+}
+
+// runPgTAP runs a pgTAP test file, prints its tests and records each as an assertion result if
+// the database is an AssertionRunner. It fails if the file does not pass.
+func (p *Plan) runPgTAP(deployment *Deployment, path string) error {
+	runner, ok := p.db.(TAPRunner)
+	if !ok {
+		return fmt.Errorf("database does not support pgTAP tests")
+	}
+
+	fmt.Fprintf(p.out(), "  Running pgTAP tests: %s\n", path)
+	content, err := readPsqlFile(path, nil)
+	if err != nil {
+		return err
+	}
+	output, err := runner.RunTAP(runCommentHeader(*deployment, path) + content)
+	if err != nil {
+		return fmt.Errorf("pgTAP tests %s failed to run: %w", filepath.Base(path), err)
+	}
+
+	result := ParseTAP(output)
+	result.Module = deployment.Module
+	result.DeploymentID = deployment.ID
+	result.File = filepath.Base(path)
+	p.TAPResults = append(p.TAPResults, result)
+
+	recorder, recording := p.db.(AssertionRunner)
+	for _, test := range result.Tests {
+		status := "ok"
+		if !test.Passed {
+			status = "not ok"
+		}
+		line := strings.TrimSpace(fmt.Sprintf("%s %d %s", status, test.Number, test.Description))
+		if test.Directive != "" {
+			line += " # " + test.Directive
+		}
+		fmt.Fprintf(p.out(), "    %s\n", line)
+
+		if !recording {
+			continue
+		}
+		assertion := AssertionResult{
+			Module:       deployment.Module,
+			DeploymentID: deployment.ID,
+			Name:         fmt.Sprintf("%s:%d %s", result.File, test.Number, test.Description),
+			Passed:       test.Passed,
+			Expected:     "ok",
+			Actual:       status,
+		}
+		if err := recorder.RecordAssertion(assertion); err != nil {
+			return fmt.Errorf("failed to record pgTAP test %s: %w", assertion.Name, err)
+		}
+	}
+	for _, diagnostic := range result.Diagnostics {
+		fmt.Fprintf(p.out(), "    # %s\n", diagnostic)
+	}
+
+	if !result.Passed {
+		if result.Planned < 0 {
+			return fmt.Errorf("pgTAP tests %s of deployment %s failed: the output has no plan; call plan() or finish()", result.File, deployment.Key())
+		}
+		if result.Planned != len(result.Tests) {
+			return fmt.Errorf("pgTAP tests %s of deployment %s failed: planned %d tests, ran %d", result.File, deployment.Key(), result.Planned, len(result.Tests))
+		}
+		return fmt.Errorf("pgTAP tests %s of deployment %s failed", result.File, deployment.Key())
+	}
+	return nil
+}
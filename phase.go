@@ -0,0 +1,56 @@
+package zdd
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// builtinPhases are the phases zdd gives meaning to, e.g. enum values are added before expand,
+// validations gate contract and assertions run before post. Configured phase lists must keep them
+// in this order.
+var builtinPhases = []string{"expand", "migrate", "contract", "post"}
+
+// phaseNamePattern matches phase names, which are also the base names of phase files
+var phaseNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// checkPhases reports why phases cannot be used as the phase list, if it cannot
+func checkPhases(phases []string) error {
+	seen := make(map[string]bool)
+	for _, phase := range phases {
+		switch {
+		case !phaseNamePattern.MatchString(phase):
+			return fmt.Errorf("invalid phase name %q (use lower-case letters, digits and underscores)", phase)
+		case phase+".sql" == assertFileName:
+			return fmt.Errorf("invalid phase name %q: %s holds assertions", phase, assertFileName)
+		case phase+".sql" == revertFileName:
+			return fmt.Errorf("invalid phase name %q: %s holds the deployment's revert SQL", phase, phase+".sql")
+		case seen[phase]:
+			return fmt.Errorf("duplicate phase %q", phase)
+		}
+		seen[phase] = true
+	}
+
+	var builtin []string
+	for _, phase := range phases {
+		if slices.Contains(builtinPhases, phase) {
+			builtin = append(builtin, phase)
+		}
+	}
+	if !slices.Equal(builtin, builtinPhases) {
+		return fmt.Errorf("phases must include %s in that order", strings.Join(builtinPhases, ", "))
+	}
+	return nil
+}
+
+// phasesWithSQL returns the phases that run SQL files, which is every phase but post
+func phasesWithSQL(phases []string) []string {
+	return slices.DeleteFunc(slices.Clone(phases), func(phase string) bool { return phase == "post" })
+}
+
+// phaseFilePattern matches the sql and script files of the phases, optionally numbered
+// (expand.1.sql)
+func phaseFilePattern(phases, scriptExtensions []string) *regexp.Regexp {
+	return regexp.MustCompile(`^(` + strings.Join(phases, "|") + `)(?:\.(\d+))?\.(` + strings.Join(append([]string{"sql"}, scriptExtensions...), "|") + `)$`)
+}
@@ -2,183 +2,891 @@ package zdd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 )
 
-const defaultScriptTimeout = 5 * time.Minute
+const (
+	defaultScriptTimeout = 5 * time.Minute
+	// defaultScriptGracePeriod is how long a timed-out script has to exit after SIGTERM
+	defaultScriptGracePeriod = 10 * time.Second
+	// appliedIDPageSize is the number of applied deployment IDs fetched per query
+	appliedIDPageSize = 10000
+)
 
 type (
 	Task struct {
-		TaskType   string // 'sql' or 'script'
-		Path       string // Path to the file to execute
+		// TaskType is 'sql', 'script', 'assert', 'validate', 'views', 'cleanup-views', 'extensions',
+		// 'enum', 'partitions', 'flags', 'pgtap' or 'replicas'
+		TaskType   string
+		Path       string // Path to the file to execute; empty for checks from the config file
 		Phase      string // Phase name (e.g., 'expand', 'migrate', 'contract', 'post')
 		Deployment *Deployment
 	}
 
 	Plan struct {
 		Tasks           []Task
-		AlreadyDeployed map[string]bool // Key is the DeploymentID, true if the deployment already exists in the remote DB
+		Skipped         []Task          // Tasks removed from the plan by Skip, reported and recorded but never executed
+		AlreadyDeployed map[string]bool // Key is the Deployment.Key, true if the deployment already exists in the remote DB
 		db              DatabaseProvider
-		deploymentsPath string
+		scriptsDenied   bool
+		// Sandbox constrains script execution; nil runs scripts directly on the host
+		Sandbox *SandboxConfig
+		// ScriptTimeout bounds each script; zero uses the default of 5 minutes
+		ScriptTimeout time.Duration
+		// ScriptGracePeriod is how long a timed-out or interrupted script and the processes it
+		// started have to exit after SIGTERM before they are killed; zero uses the default of 10
+		// seconds
+		ScriptGracePeriod time.Duration
+		// TargetSchema is passed to scripts as ZDD_TARGET_SCHEMA, and as their search_path through
+		// PGOPTIONS for libpq clients such as psql
+		TargetSchema string
+		// RestorePoints creates a restore point before each deployment's contract phase and
+		// records its name with the deployment; the database must be a RestorePointCreator
+		RestorePoints bool
+		// Trash rewrites drops of tables and columns in contract SQL into renames, keeping their
+		// data until PurgeTrash drops it
+		Trash bool
+		// Flags sets the feature flags of flags.yaml; deployments with flags cannot run without it
+		Flags FlagProvider
+		// ConfigSnapshot is recorded with each deployment Execute applies, see NewConfigSnapshot
+		ConfigSnapshot json.RawMessage
+		// TAPResults are the parsed outputs of the pgTAP test files Execute ran
+		TAPResults []TAPResult
+		// SizeReport measures the tables each deployment's SQL touches before its migrate and
+		// contract phases and after them, adding the changes to SizeImpacts; the database must
+		// be a RelationSizer
+		SizeReport bool
+		// SizeImpacts are the table size changes measured by SizeReport
+		SizeImpacts []SizeImpact
+		// Replicas are compared with the primary's schema before contract phases, see
+		// AddReplicaChecks
+		Replicas *ReplicaCheck
+		// Approvals are tokens approving the scopes SQL files require, keyed by scope, see
+		// CheckApprovals
+		Approvals map[string]string
+		// Retry re-runs idempotent deployments after transient failures, see RetryClassifier
+		Retry RetryConfig
+		// ContractPacing runs contract SQL files a statement at a time with pauses and health
+		// checks between destructive statements
+		ContractPacing ContractPacingConfig
+		// DeployLockTimeout is how long Execute waits for another deploy to release the
+		// database's deploy lock; zero fails at once, see DeployLocker
+		DeployLockTimeout time.Duration
+		// Output receives progress messages; nil writes to stdout
+		Output io.Writer
+		// Applied lists the deployments recorded by Execute, in the order they were applied
+		Applied []Deployment
+		// TaskResults are the tasks Execute ran or skipped, in order, ending with the task that
+		// failed if one did
+		TaskResults []TaskResult
+		// Estimates holds the expected duration of tasks with run history, keyed by Task.Path
+		Estimates map[string]time.Duration
+		// dropViews are the compatibility views removed by the cleanup-views task
+		dropViews []CompatView
+		// extensionChanges are the installs and updates made by the extensions task
+		extensionChanges []extensionChange
+		// requireRLS fails Execute if tables other than rlsExempt lack row-level security
+		requireRLS bool
+		rlsExempt  []string
+		// chaos is the fault RunChaos injects into the deploy; only builds with the zddchaos tag
+		// set it
+		chaos chaosFault
+		// Takeover decides when Execute resumes deployments another zdd process left running,
+		// e.g. after it was killed
+		Takeover TakeoverPolicy
+		// Repeatables are the repeatable migrations whose SQL changed since they were last
+		// applied; Execute applies them after recording the deployments
+		Repeatables []Repeatable
+		// schemaDiffPath is passed to the post script running as ZDD_SCHEMA_DIFF_PATH
+		schemaDiffPath string
+		// Runners run SQL files and scripts instead of zdd; BuildModulesPlan takes them from the
+		// layout of the modules
+		Runners Runners
+		// layout is the layout of the modules the plan was built from; nil for the default one
+		layout *layout
+	}
+
+	// DeploymentTracker is implemented by databases that record the status of a deployment while
+	// it is applied, so a rerun resumes a failed deployment and two zdd processes cannot apply the
+	// same deployment at once
+	DeploymentTracker interface {
+		// StartDeployment marks the deployment as running in this process. It fails if another
+		// process is running it, unless the policy allows taking over.
+		StartDeployment(deployment Deployment, policy TakeoverPolicy) error
+		// FailDeployment marks a deployment this process started as failed with the error
+		FailDeployment(deployment Deployment, cause error) error
+	}
+
+	// AppliedIDLister is implemented by databases that can list applied deployment IDs without
+	// their records, which keeps planning cheap for very long histories
+	AppliedIDLister interface {
+		// AppliedDeploymentIDs returns up to limit IDs of the module's applied deployments that sort
+		// after the cursor after ("" for the first page), in ID order
+		AppliedDeploymentIDs(module, after string, limit int) ([]string, error)
+	}
+
+	// SkipOptions selects tasks to leave out of a plan
+	SkipOptions struct {
+		Scripts bool     // Skip all script tasks
+		SQL     bool     // Skip every task but scripts
+		Phases  []string // If non-empty, only tasks in these phases are kept
 	}
 )
 
 // BuildPlan creates a Plan by loading deployments and determining what needs to be applied
 func BuildPlan(deploymentsPath string, db DatabaseProvider) (*Plan, error) {
-	// Load local deployments
-	localDeployments, err := LoadDeployments(deploymentsPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load local deployments: %w", err)
-	}
+	return BuildModulesPlan([]Module{{Path: deploymentsPath}}, db)
+}
 
-	// Get applied deployments from DB
-	appliedDeployments, err := db.GetAppliedDeployments()
+// BuildModulesPlan creates a Plan covering the pending deployments of every module, in module
+// order. A nil db plans every deployment, for rendering scripts without a database.
+func BuildModulesPlan(modules []Module, db DatabaseProvider) (*Plan, error) {
+	// Get keys of applied deployments from DB
+	alreadyDeployed, err := appliedKeys(modules, db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get applied deployments: %w", err)
 	}
 
-	// Build map of already deployed
-	alreadyDeployed := make(map[string]bool)
-	for _, applied := range appliedDeployments {
-		alreadyDeployed[applied.ID] = true
+	// Modules deployed together share their layout
+	var l *layout
+	if len(modules) > 0 {
+		if l, err = modules[0].Layout.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Collect pending deployments of each module, in ID order
+	pending := make([][]Deployment, len(modules))
+	for i, module := range modules {
+		localDeployments, err := LoadModuleDeployments(module)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load local deployments: %w", err)
+		}
+
+		for _, deployment := range localDeployments {
+			if !alreadyDeployed[deployment.Key()] {
+				pending[i] = append(pending[i], deployment)
+			}
+		}
+	}
+
+	ordered, err := orderDeployments(modules, pending, alreadyDeployed)
+	if err != nil {
+		return nil, err
 	}
 
 	// Build tasks from deployments - just collect what each deployment provides
 	var tasks []Task
-	for _, deployment := range localDeployments {
-		if !alreadyDeployed[deployment.ID] {
-			tasks = append(tasks, deployment.Tasks()...)
-		}
+	for _, deployment := range ordered {
+		tasks = append(tasks, deployment.Tasks()...)
+	}
+
+	repeatables, err := planRepeatables(modules, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load repeatable migrations: %w", err)
 	}
 
 	return &Plan{
 		Tasks:           tasks,
 		AlreadyDeployed: alreadyDeployed,
 		db:              db,
-		deploymentsPath: deploymentsPath,
+		Repeatables:     repeatables,
+		Runners:         l.orDefault().Runners,
+		layout:          l,
+		chaos:           chaosFromEnv(),
 	}, nil
 }
 
+// appliedKeys returns the keys of the applied deployments of the modules involved. Databases
+// implementing AppliedIDLister are paged through for their IDs; others return full records.
+func appliedKeys(modules []Module, db DatabaseProvider) (map[string]bool, error) {
+	applied := make(map[string]bool)
+	if db == nil {
+		return applied, nil
+	}
+
+	// Requirements may name deployments of modules outside the selection
+	names := make(map[string]bool)
+	for _, module := range modules {
+		names[module.Name] = true
+		for _, required := range module.Requires {
+			for _, key := range required {
+				if name, _, ok := strings.Cut(key, "/"); ok {
+					names[name] = true
+				}
+			}
+		}
+	}
+
+	lister, ok := db.(AppliedIDLister)
+	if !ok {
+		records, err := appliedRecords(db, slices.Sorted(maps.Keys(names)))
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			applied[record.Key()] = true
+		}
+		return applied, nil
+	}
+
+	for name := range names {
+		after := ""
+		for {
+			ids, err := lister.AppliedDeploymentIDs(name, after, appliedIDPageSize)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				applied[deploymentKey(name, id)] = true
+			}
+			if len(ids) < appliedIDPageSize {
+				break
+			}
+			after = ids[len(ids)-1]
+		}
+	}
+
+	return applied, nil
+}
+
+// orderDeployments merges the pending deployments of each module into a single order that
+// satisfies every cross-module requirement. Modules keep their own ID order, and without
+// requirements every deployment of a module is placed before those of the next module.
+func orderDeployments(modules []Module, pending [][]Deployment, applied map[string]bool) ([]Deployment, error) {
+	scheduled := make(map[string]bool)
+	for _, deployments := range pending {
+		for _, deployment := range deployments {
+			scheduled[deployment.Key()] = true
+		}
+	}
+
+	// Every requirement must be applied already or be part of this plan
+	for i, module := range modules {
+		for _, deployment := range pending[i] {
+			for _, required := range module.Requires[deployment.ID] {
+				if !applied[required] && !scheduled[required] {
+					return nil, fmt.Errorf("deployment %s requires %s, which is neither applied nor pending", deployment.Key(), required)
+				}
+			}
+		}
+	}
+
+	var ordered []Deployment
+	done := make(map[string]bool)
+	next := make([]int, len(modules))
+
+	for len(ordered) < len(scheduled) {
+		progressed := false
+		for i, module := range modules {
+			if next[i] >= len(pending[i]) {
+				continue
+			}
+
+			deployment := pending[i][next[i]]
+			ready := true
+			for _, required := range module.Requires[deployment.ID] {
+				if !applied[required] && !done[required] {
+					ready = false
+					break
+				}
+			}
+			if !ready {
+				continue
+			}
+
+			ordered = append(ordered, deployment)
+			done[deployment.Key()] = true
+			next[i]++
+			progressed = true
+			break // Restart from the first module to keep module order where possible
+		}
+
+		if !progressed {
+			var blocked []string
+			for i := range modules {
+				if next[i] < len(pending[i]) {
+					blocked = append(blocked, pending[i][next[i]].Key())
+				}
+			}
+			return nil, fmt.Errorf("cyclic deployment requirements between %s", strings.Join(blocked, ", "))
+		}
+	}
+
+	return ordered, nil
+}
+
+// Skip moves every task matching opts from Tasks to Skipped and notes it on its deployment.
+// Repeatables are dropped when SQL or the post phase is skipped.
+func (p *Plan) Skip(opts SkipOptions) error {
+	for _, phase := range opts.Phases {
+		if phases := p.layout.orDefault().phases; !slices.Contains(phases, phase) {
+			return fmt.Errorf("unknown phase %q (expected one of %v)", phase, phases)
+		}
+	}
+
+	// Repeatables run with the post phase
+	if opts.SQL || (len(opts.Phases) > 0 && !slices.Contains(opts.Phases, "post")) {
+		p.Repeatables = nil
+	}
+
+	var kept []Task
+	for _, task := range p.Tasks {
+		skip := (opts.Scripts && task.TaskType == "script") ||
+			(opts.SQL && task.TaskType != "script") ||
+			(len(opts.Phases) > 0 && !slices.Contains(opts.Phases, task.Phase))
+		if !skip {
+			kept = append(kept, task)
+			continue
+		}
+
+		task.Deployment.SkippedTasks = append(task.Deployment.SkippedTasks, task.Name())
+		p.Skipped = append(p.Skipped, task)
+	}
+	p.Tasks = kept
+
+	return nil
+}
+
+// SkipHeadOnly moves the scripts of the given phases from Tasks to Skipped, except those of the
+// head (last pending) deployment of each module, e.g. so an application restart in post runs
+// once per deploy rather than once per deployment. The skips are reported but not noted on the
+// deployments, as the scripts were never meant to run for them.
+func (p *Plan) SkipHeadOnly(phases []string) error {
+	for _, phase := range phases {
+		if phases := p.layout.orDefault().phases; !slices.Contains(phases, phase) {
+			return fmt.Errorf("unknown phase %q (expected one of %v)", phase, phases)
+		}
+	}
+	if len(phases) == 0 {
+		return nil
+	}
+
+	// The last task of a module belongs to its head deployment, as in Execute
+	heads := make(map[string]string)
+	for _, task := range p.Tasks {
+		heads[task.Deployment.Module] = task.Deployment.Key()
+	}
+
+	var kept []Task
+	for _, task := range p.Tasks {
+		if task.TaskType == "script" && slices.Contains(phases, task.Phase) && heads[task.Deployment.Module] != task.Deployment.Key() {
+			p.Skipped = append(p.Skipped, task)
+			continue
+		}
+		kept = append(kept, task)
+	}
+	p.Tasks = kept
+
+	return nil
+}
+
+// DisallowScripts fails if the plan contains any script task and otherwise
+// guarantees that no script is executed by this plan
+func (p *Plan) DisallowScripts() error {
+	for _, task := range p.Tasks {
+		if task.TaskType == "script" {
+			return fmt.Errorf("scripts are not allowed, but deployment %s has %s script %s", task.Deployment.ID, task.Phase, task.Path)
+		}
+	}
+
+	p.scriptsDenied = true
+	return nil
+}
+
+// Name identifies the task within its deployment, e.g. "expand:sql"
+func (t Task) Name() string {
+	return t.Phase + ":" + t.TaskType
+}
+
+// addTaskResult adds the outcome of a task to the plan's TaskResults
+func (p *Plan) addTaskResult(task Task, status string, duration time.Duration, cause error) {
+	result := TaskResult{
+		Deployment: task.Deployment.Key(),
+		Task:       task.Name(),
+		Path:       task.Path,
+		Status:     status,
+		DurationMS: duration.Milliseconds(),
+	}
+	if cause != nil {
+		result.Error = cause.Error()
+	}
+	p.TaskResults = append(p.TaskResults, result)
+}
+
+// out returns the writer for progress messages
+func (p *Plan) out() io.Writer {
+	if p.Output == nil {
+		return os.Stdout
+	}
+	return p.Output
+}
+
 // Execute applies the plan by executing all tasks in order
-func (p *Plan) Execute() error {
-	if len(p.Tasks) == 0 {
-		fmt.Println("No pending deployments to apply")
+func (p *Plan) Execute() (err error) {
+	// Track completed deployments in the order they complete
+	completedDeployments := make(map[string]*Deployment)
+	var completedOrder []string
+	complete := func(key string, deployment *Deployment) {
+		if _, done := completedDeployments[key]; !done {
+			completedOrder = append(completedOrder, key)
+		}
+		completedDeployments[key] = deployment
+	}
+
+	// Report skipped tasks; their deployments are still recorded below
+	for _, task := range p.Skipped {
+		fmt.Fprintf(p.out(), "Skipping %s for deployment %s: %s\n", task.Name(), task.Deployment.Key(), task.Path)
+		p.addTaskResult(task, TaskSkipped, 0, nil)
+		complete(task.Deployment.Key(), task.Deployment)
+	}
+
+	if len(p.Tasks) == 0 && len(completedDeployments) == 0 && len(p.Repeatables) == 0 {
+		fmt.Fprintln(p.out(), "No pending deployments to apply")
 		return nil
 	}
+	if p.Trash && len(p.Runners.SQL) > 0 {
+		return fmt.Errorf("trash cannot rewrite SQL files run by runners.sql; disable one of them")
+	}
+	unlock, err := p.lockDeploys()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := p.checkAppliedMeanwhile(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(p.out(), "Run %s\n", RunID())
+	if err := p.CheckApprovals(); err != nil {
+		return err
+	}
+	if p.Flags == nil {
+		for _, task := range p.Tasks {
+			if task.TaskType == "flags" && !p.AlreadyDeployed[task.Deployment.Key()] {
+				return fmt.Errorf("deployment %s sets feature flags, but no feature_flags provider is configured", task.Deployment.Key())
+			}
+		}
+	}
 
-	// Determine which deployment is the head (last pending)
+	// Determine which deployment is the head (last pending) of each module
 	// Since BuildPlan only includes tasks from pending deployments,
-	// the last task belongs to the last pending deployment
-	var lastPendingID string
-	if len(p.Tasks) > 0 {
-		lastPendingID = p.Tasks[len(p.Tasks)-1].Deployment.ID
+	// the last task of a module belongs to its last pending deployment
+	lastPendingKeys := make(map[string]string)
+	for _, task := range p.Tasks {
+		lastPendingKeys[task.Deployment.Module] = task.Deployment.Key()
+	}
+
+	if p.Estimates != nil {
+		p.printEstimate(0)
+	}
+
+	beat, err := startHeartbeat(p.db)
+	if err != nil {
+		return err
 	}
+	defer beat.close()
 
-	// Track which deployments we've started and completed
+	// Track which deployments we've started
 	startedDeployments := make(map[string]bool)
-	completedDeployments := make(map[string]*Deployment)
+	var started []*Deployment
 
-	for _, task := range p.Tasks {
-		// Check if this deployment is already applied (skip entire deployment)
-		if p.AlreadyDeployed[task.Deployment.ID] {
-			continue
+	// Deployments started but not recorded are marked failed, so a rerun resumes them
+	recorded := make(map[string]bool)
+	tracker, tracking := p.db.(DeploymentTracker)
+	defer func() {
+		if err == nil || !tracking {
+			return
+		}
+		for _, deployment := range started {
+			if !recorded[deployment.Key()] {
+				// The original error matters more than a failure to mark the deployment
+				tracker.FailDeployment(*deployment, err)
+			}
 		}
+	}()
 
+	// The task running when Execute fails is reported as failed
+	var running *Task
+	var runningSince time.Time
+	defer func() {
+		if err != nil && running != nil {
+			p.addTaskResult(*running, TaskFailed, time.Since(runningSince), err)
+		}
+	}()
+
+	// Sizes are measured around the migrate and contract phases, where backfills and drops are
+	sizesBefore := make(map[string]map[string]RelationSize)
+	lastSizeTasks := make(map[string]int)
+	for i, task := range p.Tasks {
+		if task.Phase == "migrate" || task.Phase == "contract" {
+			lastSizeTasks[task.Deployment.Key()] = i
+		}
+	}
+
+	// Deployments with a schema hash check it after their last task
+	lastTasks := make(map[string]int)
+	for i, task := range p.Tasks {
+		if task.Deployment != nil {
+			lastTasks[task.Deployment.Key()] = i
+		}
+	}
+
+	// Schemas before deployments with post scripts, which receive the diff of their changes.
+	// lastSnapshot is the schema as last described, kept while only post scripts run, which are
+	// taken not to change it, so the next deployment starts from it.
+	schemasBefore := make(map[string][]string)
+	var lastSnapshot []string
+
+	// Idempotent deployments are re-run from their first task after transient failures
+	firstTasks := make(map[string]int)
+	for i, task := range slices.Backward(p.Tasks) {
+		if task.Deployment != nil {
+			firstTasks[task.Deployment.Key()] = i
+		}
+	}
+	attempts := make(map[string]int)
+
+	sqlTasks := 0
+	for i := 0; i < len(p.Tasks); i++ {
+		task := p.Tasks[i]
 		if task.Deployment == nil {
 			return fmt.Errorf("task %s missing deployment metadata", task.Path)
 		}
 		deployment := task.Deployment
-		isHead := task.Deployment.ID == lastPendingID
+		key := deployment.Key()
+
+		// Check if this deployment is already applied (skip entire deployment)
+		if p.AlreadyDeployed[key] {
+			continue
+		}
+
+		isHead := key == lastPendingKeys[deployment.Module]
 
 		// Print deployment header when we first encounter it
-		if !startedDeployments[task.Deployment.ID] {
-			fmt.Printf("Applying deployment %s: %s\n", deployment.ID, deployment.Name)
-			startedDeployments[task.Deployment.ID] = true
+		if !startedDeployments[key] {
+			fmt.Fprintf(p.out(), "Applying deployment %s: %s\n", key, deployment.Name)
+			if tracking {
+				if err := tracker.StartDeployment(*deployment, p.Takeover); err != nil {
+					return err
+				}
+			}
+			startedDeployments[key] = true
+			started = append(started, deployment)
+			if schemasBefore[key], err = p.snapshotSchema(deployment, lastSnapshot); err != nil {
+				return err
+			}
 		}
 
-		// Execute the task based on its type
-		switch task.TaskType {
-		case "script":
-			if err := p.ExecuteScript(task.Path, *deployment, task.Phase, isHead); err != nil {
-				return fmt.Errorf("failed to execute %s script for deployment %s: %w", task.Phase, task.Deployment.ID, err)
+		if p.Estimates != nil {
+			if estimate, ok := p.Estimates[task.Path]; ok {
+				fmt.Fprintf(p.out(), "  Task %d/%d %s (estimate %s)\n", i+1, len(p.Tasks), task.Name(), formatEstimate(estimate))
 			}
+		}
 
-		case "sql":
-			// Read SQL file content
-			content, err := os.ReadFile(task.Path)
+		beat.setTask(key + " " + task.Name())
+
+		if _, measured := sizesBefore[key]; p.SizeReport && !measured && (task.Phase == "migrate" || task.Phase == "contract") {
+			sizes, err := p.measureSizes(deployment)
 			if err != nil {
-				return fmt.Errorf("failed to read SQL file %s: %w", task.Path, err)
+				return err
 			}
+			sizesBefore[key] = sizes
+		}
 
-			fmt.Printf("  Executing %s SQL file: %s\n", task.Phase, task.Path)
-			if err := p.db.ExecuteSQLInTransaction(string(content)); err != nil {
-				return fmt.Errorf("failed to execute %s SQL file %s: %w", task.Phase, task.Path, err)
+		// The restore point precedes the first contract task, which may drop data
+		if p.RestorePoints && task.Phase == "contract" && deployment.RestorePoint == "" {
+			if err := p.createRestorePoint(deployment); err != nil {
+				return err
 			}
+		}
+
+		// Execute the task based on its type
+		taskStarted := time.Now()
+		running, runningSince = &task, taskStarted
+		// Task errors are returned from this function, so a failed idempotent deployment can be
+		// re-run below
+		err := func() error {
+			switch task.TaskType {
+			case "script":
+				if before := schemasBefore[key]; task.Phase == "post" && before != nil {
+					path, after, remove, err := p.writeSchemaDiff(deployment, before, lastSnapshot)
+					if err != nil {
+						return err
+					}
+					lastSnapshot = after
+					defer remove()
+					p.schemaDiffPath = path
+					defer func() { p.schemaDiffPath = "" }()
+				}
+				if err := p.ExecuteScript(task.Path, *deployment, task.Phase, isHead); err != nil {
+					return fmt.Errorf("failed to execute %s script for deployment %s: %w", task.Phase, key, err)
+				}
+
+			case "sql":
+				if len(p.Runners.SQL) > 0 {
+					if err := p.runSQLFile(task.Path, *deployment, task.Phase, isHead); err != nil {
+						return fmt.Errorf("failed to execute %s SQL file %s: %w", task.Phase, task.Path, err)
+					}
+					break
+				}
 
-		default:
-			return fmt.Errorf("unknown task type: %s", task.TaskType)
+				fmt.Fprintf(p.out(), "  Executing %s SQL file: %s\n", task.Phase, task.Path)
+
+				// Read SQL file content; \echo output is printed before the SQL runs
+				content, err := readPsqlFile(task.Path, func(text string) {
+					fmt.Fprintf(p.out(), "    %s\n", text)
+				})
+				if err != nil {
+					return err
+				}
+				_, rewrite, err := IdempotentAnnotation(content)
+				if err != nil {
+					return fmt.Errorf("invalid SQL file %s: %w", task.Path, err)
+				}
+				if rewrite {
+					content = RewriteIdempotent(content)
+				}
+				if p.Trash && task.Phase == "contract" {
+					var trashed []TrashedObject
+					content, trashed = trashSQL(content, *deployment)
+					for _, object := range trashed {
+						fmt.Fprintf(p.out(), "    Trashing %s\n", object)
+					}
+				}
+
+				sqlTasks++
+				content = p.chaos.dropConnection(content, sqlTasks, p.out())
+				if task.Phase == "contract" && p.ContractPacing.Enabled() {
+					if err := p.runPacedContract(deployment, task.Path, content); err != nil {
+						return err
+					}
+					break
+				}
+				if err := p.db.ExecuteSQLInTransaction(runCommentHeader(*deployment, task.Path) + content); err != nil {
+					return fmt.Errorf("failed to execute %s SQL file %s: %w", task.Phase, task.Path, err)
+				}
+
+			case "assert":
+				if err := p.runAssertions(deployment); err != nil {
+					return err
+				}
+
+			case "pgtap":
+				if err := p.runPgTAP(deployment, task.Path); err != nil {
+					return err
+				}
+
+			case "validate":
+				if err := p.runValidations(deployment); err != nil {
+					return err
+				}
+
+			case "replicas":
+				if err := p.checkReplicas(); err != nil {
+					return fmt.Errorf("replicas are not ready for the contract phase of deployment %s: %w", key, err)
+				}
+
+			case "views":
+				if err := p.recordCompatViews(deployment); err != nil {
+					return fmt.Errorf("failed to record compatibility views for deployment %s: %w", key, err)
+				}
+
+			case "partitions":
+				if err := p.runPartitions(deployment, task.Phase); err != nil {
+					return fmt.Errorf("failed to maintain partitions for deployment %s: %w", key, err)
+				}
+
+			case "enum":
+				if err := p.addEnumValues(deployment); err != nil {
+					return fmt.Errorf("failed to add enum values for deployment %s: %w", key, err)
+				}
+
+			case "extensions":
+				if err := p.applyExtensions(); err != nil {
+					return fmt.Errorf("failed to manage extensions: %w", err)
+				}
+
+			case "flags":
+				if err := p.setFeatureFlags(deployment, task.Phase); err != nil {
+					return fmt.Errorf("failed to set feature flags for deployment %s: %w", key, err)
+				}
+
+			case "cleanup-views":
+				if err := p.cleanupCompatViews(); err != nil {
+					return fmt.Errorf("failed to remove compatibility views: %w", err)
+				}
+
+			default:
+				return fmt.Errorf("unknown task type: %s", task.TaskType)
+			}
+			return nil
+		}()
+		if task.TaskType != "script" || task.Phase != "post" {
+			lastSnapshot = nil
+		}
+		if err != nil {
+			if !p.retryFailedTask(task, err, time.Since(taskStarted), attempts) {
+				return err
+			}
+			running = nil
+			i = firstTasks[key] - 1
+			continue
+		}
+
+		duration := time.Since(taskStarted)
+		running = nil
+		p.addTaskResult(task, TaskSucceeded, duration, nil)
+		if err := p.recordTaskRun(task, duration); err != nil {
+			return err
+		}
+
+		if last, ok := lastSizeTasks[key]; p.SizeReport && ok && last == i {
+			if err := p.reportSizes(deployment, sizesBefore[key]); err != nil {
+				return err
+			}
+		}
+
+		if lastTasks[key] == i {
+			if err := p.checkSchemaHash(deployment); err != nil {
+				return err
+			}
 		}
 
 		// Mark deployment as completed
-		completedDeployments[task.Deployment.ID] = deployment
+		complete(key, deployment)
+		p.chaos.kill(i+1, p.out())
+
+		if p.Estimates != nil && i+1 < len(p.Tasks) {
+			p.printEstimate(i + 1)
+		}
+	}
+
+	if err := p.checkRLS(); err != nil {
+		return err
 	}
 
 	// Record all completed deployments to the database
-	for deploymentID, deployment := range completedDeployments {
+	for _, key := range completedOrder {
+		deployment := completedDeployments[key]
 		checksum := CalculateChecksum(*deployment)
+		deployment.ConfigSnapshot = p.ConfigSnapshot
 		if err := p.db.RecordDeployment(*deployment, checksum); err != nil {
-			return fmt.Errorf("failed to record deployment %s: %w", deploymentID, err)
+			return fmt.Errorf("failed to record deployment %s: %w", key, err)
 		}
-		fmt.Printf("Deployment %s applied successfully\n", deploymentID)
+		recorded[key] = true
+		p.Applied = append(p.Applied, *deployment)
+		fmt.Fprintf(p.out(), "Deployment %s applied successfully\n", key)
+	}
+
+	if err := p.applyRepeatables(); err != nil {
+		return err
 	}
 
-	fmt.Println("All deployments applied successfully!")
+	fmt.Fprintln(p.out(), "All deployments applied successfully!")
 	return nil
 }
 
-// ExecuteScript executes a shell script with ZDD environment variables
-func (p *Plan) ExecuteScript(scriptPath string, deployment Deployment, phase string, isHead bool) error {
-	if strings.TrimSpace(scriptPath) == "" {
-		return nil
+// printEstimate reports the expected time to run the tasks from index from onwards
+func (p *Plan) printEstimate(from int) {
+	remaining, unknown := p.EstimatedDuration(from)
+	switch {
+	case unknown == len(p.Tasks)-from:
+		return // No history for any remaining task
+	case unknown > 0:
+		fmt.Fprintf(p.out(), "ETA: ~%s remaining (%d tasks without history)\n", formatEstimate(remaining), unknown)
+	default:
+		fmt.Fprintf(p.out(), "ETA: ~%s remaining\n", formatEstimate(remaining))
 	}
+}
 
-	// Set environment variables
+// scriptEnv returns the ZDD environment variables of the deployment's tasks in a phase
+func (p *Plan) scriptEnv(deployment Deployment, phase string, isHead bool) map[string]string {
 	env := map[string]string{
 		"ZDD_IS_HEAD":          fmt.Sprintf("%t", isHead),
 		"ZDD_DEPLOYMENT_ID":    deployment.ID,
 		"ZDD_DEPLOYMENT_NAME":  deployment.Name,
 		"ZDD_PHASE":            phase,
-		"ZDD_DEPLOYMENTS_PATH": p.deploymentsPath,
+		"ZDD_PHASES":           strings.Join(deployment.layout.orDefault().phases, ","),
+		"ZDD_DEPLOYMENTS_PATH": filepath.Dir(deployment.Directory),
+		"ZDD_MODULE":           deployment.Module,
 		"ZDD_DATABASE_URL":     p.db.ConnectionString(),
+		"ZDD_RUN_ID":           RunID(),
+	}
+	if p.schemaDiffPath != "" {
+		env["ZDD_SCHEMA_DIFF_PATH"] = p.schemaDiffPath
+	}
+	if p.TargetSchema != "" {
+		env["ZDD_TARGET_SCHEMA"] = p.TargetSchema
+		env["PGOPTIONS"] = strings.TrimSpace(os.Getenv("PGOPTIONS") + " -c search_path=" + p.TargetSchema)
 	}
+	return env
+}
 
-	fmt.Printf("  Executing %s script: %s\n", phase, scriptPath)
+// ExecuteScript executes a shell script with ZDD environment variables
+func (p *Plan) ExecuteScript(scriptPath string, deployment Deployment, phase string, isHead bool) error {
+	if strings.TrimSpace(scriptPath) == "" {
+		return nil
+	}
+
+	if p.scriptsDenied {
+		return fmt.Errorf("scripts are not allowed: refusing to execute %s", scriptPath)
+	}
+
+	env := p.scriptEnv(deployment, phase, isHead)
+
+	fmt.Fprintf(p.out(), "  Executing %s script: %s\n", phase, scriptPath)
 	log.Printf("Executing script in directory: %s", deployment.Directory)
 	log.Printf("Running script: %s", scriptPath)
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultScriptTimeout)
+	timeout := p.ScriptTimeout
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+	timeout = p.Sandbox.timeout(timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+	// Scripts run in a process group of their own, out of reach of the terminal's Ctrl-C, so
+	// interrupting zdd kills them explicitly
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Execute the script directly
-	cmd := exec.CommandContext(ctx, scriptPath)
-	cmd.Dir = deployment.Directory
-
-	// Set environment variables
-	cmd.Env = append(cmd.Environ(), []string{}...)
+	// Build the script environment on top of the (possibly scrubbed) base environment
+	cmdEnv := p.Sandbox.environ()
 	for key, value := range env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+		cmdEnv = append(cmdEnv, fmt.Sprintf("%s=%s", key, value))
 		log.Printf("Setting env: %s=%s", key, value)
 	}
 
-	output, err := cmd.CombinedOutput()
+	runner, _ := p.Runners.script(scriptPath)
+	cmd, cleanup, err := p.Sandbox.command(ctx, scriptPath, runner, deployment.Directory, cmdEnv)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+
+	grace := p.ScriptGracePeriod
+	if grace <= 0 {
+		grace = defaultScriptGracePeriod
+	}
+	output, err := runProcessTree(cmd, grace)
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("script timed out after %v", defaultScriptTimeout)
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			return fmt.Errorf("script timed out after %v", timeout)
+		case ctx.Err() != nil:
+			return fmt.Errorf("script interrupted")
 		}
 		log.Printf("Script output: %s", string(output))
 		return fmt.Errorf("script failed with exit code %d: %s", cmd.ProcessState.ExitCode(), string(output))
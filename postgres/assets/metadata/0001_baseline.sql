@@ -0,0 +1,155 @@
+-- The metadata schema of zdd releases before it was versioned. Every statement is idempotent, so
+-- it also brings the schema of any of those releases up to date.
+
+CREATE SCHEMA IF NOT EXISTS zdd_deployments;
+
+CREATE TABLE IF NOT EXISTS zdd_deployments.applied_deployments (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(500) NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    checksum VARCHAR(64)
+);
+
+ALTER TABLE zdd_deployments.applied_deployments
+    ADD COLUMN IF NOT EXISTS skipped_tasks TEXT[];
+
+-- Deployments of different modules may share IDs, so the module is part of the key
+ALTER TABLE zdd_deployments.applied_deployments
+    ADD COLUMN IF NOT EXISTS module VARCHAR(255) NOT NULL DEFAULT '';
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.key_column_usage
+        WHERE table_schema = 'zdd_deployments'
+          AND table_name = 'applied_deployments'
+          AND constraint_name = 'applied_deployments_pkey'
+          AND column_name = 'module'
+    ) THEN
+        ALTER TABLE zdd_deployments.applied_deployments DROP CONSTRAINT applied_deployments_pkey;
+        ALTER TABLE zdd_deployments.applied_deployments ADD CONSTRAINT applied_deployments_pkey PRIMARY KEY (module, id);
+    END IF;
+END $$;
+
+-- Deployments are recorded as running when started, then applied or failed; only applied ones
+-- count as applied. run_id identifies the zdd process that last changed the status.
+ALTER TABLE zdd_deployments.applied_deployments
+    ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'applied'
+        CHECK (status IN ('running', 'applied', 'failed'));
+
+ALTER TABLE zdd_deployments.applied_deployments
+    ADD COLUMN IF NOT EXISTS run_id VARCHAR(64);
+
+-- Who last changed the status (user@host), and the error a failed attempt stopped with
+ALTER TABLE zdd_deployments.applied_deployments
+    ADD COLUMN IF NOT EXISTS run_by VARCHAR(255);
+
+ALTER TABLE zdd_deployments.applied_deployments
+    ADD COLUMN IF NOT EXISTS error TEXT;
+
+-- Key of the issue tracker ticket the deployment implements, from its ticket.yaml
+ALTER TABLE zdd_deployments.applied_deployments
+    ADD COLUMN IF NOT EXISTS ticket VARCHAR(255);
+
+-- Restore point created before the contract phase, a recovery target for point-in-time recovery
+ALTER TABLE zdd_deployments.applied_deployments
+    ADD COLUMN IF NOT EXISTS restore_point VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_applied_deployments_applied_at
+    ON zdd_deployments.applied_deployments(applied_at);
+
+-- Task durations, keyed by file checksum, used to estimate future runs of the same files
+CREATE TABLE IF NOT EXISTS zdd_deployments.task_runs (
+    module VARCHAR(255) NOT NULL DEFAULT '',
+    deployment_id VARCHAR(255) NOT NULL,
+    task VARCHAR(64) NOT NULL,
+    file_checksum VARCHAR(64) NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    ran_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_runs_file_checksum
+    ON zdd_deployments.task_runs(file_checksum);
+
+-- What ran each task: connection, exec, or the base name of a configured runner such as psql
+ALTER TABLE zdd_deployments.task_runs
+    ADD COLUMN IF NOT EXISTS runner VARCHAR(64);
+
+-- Outcomes of post-deploy assertions from assert.sql files and zdd.yaml
+CREATE TABLE IF NOT EXISTS zdd_deployments.assertion_results (
+    module VARCHAR(255) NOT NULL DEFAULT '',
+    deployment_id VARCHAR(255) NOT NULL,
+    name VARCHAR(500) NOT NULL,
+    passed BOOLEAN NOT NULL,
+    expected TEXT,
+    actual TEXT,
+    checked_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Views left under renamed tables' old names, removed once a later deployment contracts
+CREATE TABLE IF NOT EXISTS zdd_deployments.compat_views (
+    view_name VARCHAR(255) PRIMARY KEY,
+    table_name VARCHAR(255) NOT NULL,
+    module VARCHAR(255) NOT NULL DEFAULT '',
+    deployment_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Tables and columns contract phases renamed instead of dropping, until zdd purge-trash drops them
+CREATE TABLE IF NOT EXISTS zdd_deployments.trash (
+    trashed_table VARCHAR(255) NOT NULL,
+    trashed_column VARCHAR(255) NOT NULL DEFAULT '',
+    kind VARCHAR(16) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    module VARCHAR(255) NOT NULL DEFAULT '',
+    deployment_id VARCHAR(255) NOT NULL,
+    trashed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (trashed_table, trashed_column)
+);
+
+-- One row per running deploy, refreshed while it runs; deploys whose row goes stale are abandoned
+CREATE TABLE IF NOT EXISTS zdd_deployments.heartbeats (
+    run_id VARCHAR(64) PRIMARY KEY,
+    pid INTEGER NOT NULL,
+    host VARCHAR(255) NOT NULL,
+    run_by VARCHAR(255) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    current_task TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Repeatable migrations, applied again whenever the checksum of their SQL changes
+CREATE TABLE IF NOT EXISTS zdd_deployments.repeatable_migrations (
+    module VARCHAR(255) NOT NULL DEFAULT '',
+    name VARCHAR(500) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    run_by VARCHAR(255),
+    PRIMARY KEY (module, name)
+);
+
+-- The views and functions each repeatable created, e.g. 'view reports.daily', for zdd list
+ALTER TABLE zdd_deployments.repeatable_migrations
+    ADD COLUMN IF NOT EXISTS objects TEXT[];
+
+-- The feature flags each deployment set, from its flags.yaml
+CREATE TABLE IF NOT EXISTS zdd_deployments.feature_flags (
+    module VARCHAR(255) NOT NULL DEFAULT '',
+    deployment_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    value TEXT NOT NULL,
+    phase VARCHAR(64) NOT NULL,
+    set_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (module, deployment_id, name, phase)
+);
+
+-- The latest check of each deployment's rollback.sql against a scratch database
+CREATE TABLE IF NOT EXISTS zdd_deployments.rollback_verifications (
+    module VARCHAR(255) NOT NULL DEFAULT '',
+    deployment_id VARCHAR(255) NOT NULL,
+    rollback_checksum VARCHAR(64) NOT NULL,
+    passed BOOLEAN NOT NULL,
+    differences TEXT[],
+    verified_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (module, deployment_id)
+);
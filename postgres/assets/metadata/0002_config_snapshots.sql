@@ -0,0 +1,3 @@
+-- The effective zdd configuration each deployment was applied under, as JSON
+ALTER TABLE zdd_deployments.applied_deployments
+    ADD COLUMN IF NOT EXISTS config JSONB;
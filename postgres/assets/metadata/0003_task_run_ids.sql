@@ -0,0 +1,3 @@
+-- The zdd invocation that ran each task, matching applied_deployments.run_id
+ALTER TABLE zdd_deployments.task_runs
+    ADD COLUMN IF NOT EXISTS run_id VARCHAR(64);
@@ -0,0 +1,12 @@
+-- Approvals of the scopes that `-- zdd:requires-approval` annotations in SQL files require
+CREATE TABLE IF NOT EXISTS zdd_deployments.approvals (
+    module VARCHAR(255) NOT NULL DEFAULT '',
+    deployment_id VARCHAR(255) NOT NULL,
+    scope VARCHAR(255) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    token TEXT NOT NULL,
+    approved_by VARCHAR(255),
+    approved_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    run_id VARCHAR(64),
+    PRIMARY KEY (module, deployment_id, scope, checksum)
+);
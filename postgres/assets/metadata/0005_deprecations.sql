@@ -0,0 +1,23 @@
+-- Tables and columns that pending contract phases drop, registered by `zdd deprecations` for
+-- applications to check their references against
+CREATE TABLE IF NOT EXISTS zdd_deployments.deprecations (
+    module VARCHAR(255) NOT NULL DEFAULT '',
+    deployment_id VARCHAR(255) NOT NULL,
+    deployment_name VARCHAR(255) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    registered_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    run_id VARCHAR(64),
+    PRIMARY KEY (module, deployment_id, kind, name)
+);
+
+-- The registered deprecations whose deployments are not applied yet
+CREATE OR REPLACE VIEW zdd_deployments.pending_deprecations AS
+SELECT d.*
+FROM zdd_deployments.deprecations d
+WHERE NOT EXISTS (
+    SELECT 1 FROM zdd_deployments.applied_deployments a
+    WHERE a.module = d.module AND a.id = d.deployment_id AND a.status = 'applied'
+);
@@ -0,0 +1,6 @@
+-- Rollback verifications check the revert SQL of a deployment, from revert.sql or its
+-- <phase>.revert.sql files
+COMMENT ON TABLE zdd_deployments.rollback_verifications IS
+    'The latest check of each deployment''s revert SQL against a scratch database';
+COMMENT ON COLUMN zdd_deployments.rollback_verifications.rollback_checksum IS
+    'sha256 of the revert SQL verified, its files concatenated in the order they run';
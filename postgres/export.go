@@ -0,0 +1,198 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mantty/zdd"
+)
+
+// exportSchemaFilter excludes the system's schemas and zdd's own, for a namespace aliased n
+const exportSchemaFilter = `n.nspname <> 'information_schema' AND n.nspname NOT LIKE 'pg\_%' AND n.nspname NOT LIKE 'zdd\_%'`
+
+// constraintKinds names the constraint types of pg_constraint.contype
+var constraintKinds = map[string]string{
+	"p": "primary_key",
+	"u": "unique",
+	"f": "foreign_key",
+	"c": "check",
+	"x": "exclude",
+}
+
+// ExportSchema describes the enums, sequences, tables and views of every schema but the
+// system's and zdd's own. Partitions are left out, as they share their parent's definition.
+func (db *DB) ExportSchema() (*zdd.DatabaseSchema, error) {
+	schema := &zdd.DatabaseSchema{Enums: []zdd.SchemaEnum{}, Sequences: []string{}, Tables: []zdd.SchemaTable{}, Views: []zdd.SchemaView{}}
+
+	rows, err := db.pool.Query(db.ctx, `
+		SELECT n.nspname, t.typname, array_agg(e.enumlabel::text ORDER BY e.enumsortorder)
+		FROM pg_enum e
+		JOIN pg_type t ON t.oid = e.enumtypid
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		WHERE `+exportSchemaFilter+`
+		GROUP BY n.nspname, t.typname
+		ORDER BY n.nspname, t.typname
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query enums: %w", err)
+	}
+	schema.Enums, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (zdd.SchemaEnum, error) {
+		var enum zdd.SchemaEnum
+		err := row.Scan(&enum.Schema, &enum.Name, &enum.Values)
+		return enum, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read enums: %w", err)
+	}
+
+	rows, err = db.pool.Query(db.ctx, `
+		SELECT n.nspname || '.' || c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'S' AND `+exportSchemaFilter+`
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = c.oid AND d.deptype = 'i')
+		ORDER BY n.nspname, c.relname
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sequences: %w", err)
+	}
+	if schema.Sequences, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil {
+		return nil, fmt.Errorf("failed to read sequences: %w", err)
+	}
+
+	if err := db.exportTables(schema); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.pool.Query(db.ctx, `
+		SELECT n.nspname, c.relname, pg_get_viewdef(c.oid)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'v' AND `+exportSchemaFilter+`
+		ORDER BY n.nspname, c.relname
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query views: %w", err)
+	}
+	schema.Views, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (zdd.SchemaView, error) {
+		var view zdd.SchemaView
+		err := row.Scan(&view.Schema, &view.Name, &view.Definition)
+		return view, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read views: %w", err)
+	}
+
+	return schema, nil
+}
+
+// exportTables adds the tables to schema with their columns, constraints and the indexes that
+// back no constraint
+func (db *DB) exportTables(schema *zdd.DatabaseSchema) error {
+	rows, err := db.pool.Query(db.ctx, `
+		SELECT n.nspname, c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+		       COALESCE(pg_get_expr(d.adbin, d.adrelid), ''), a.attidentity::text, a.attgenerated::text
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition AND `+exportSchemaFilter+`
+		ORDER BY n.nspname, c.relname, a.attnum
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query columns: %w", err)
+	}
+	defer rows.Close()
+
+	tables := make(map[string]int) // schema.name -> index in schema.Tables
+	for rows.Next() {
+		var (
+			schemaName, tableName, identity, generated string
+			column                                     zdd.SchemaColumn
+		)
+		if err := rows.Scan(&schemaName, &tableName, &column.Name, &column.Type, &column.NotNull, &column.Default, &identity, &generated); err != nil {
+			return fmt.Errorf("failed to scan column: %w", err)
+		}
+		switch identity {
+		case "a":
+			column.Identity = "ALWAYS"
+		case "d":
+			column.Identity = "BY DEFAULT"
+		}
+		if generated == "s" {
+			column.Generated, column.Default = column.Default, ""
+		}
+
+		key := schemaName + "." + tableName
+		i, ok := tables[key]
+		if !ok {
+			i = len(schema.Tables)
+			tables[key] = i
+			schema.Tables = append(schema.Tables, zdd.SchemaTable{Schema: schemaName, Name: tableName})
+		}
+		schema.Tables[i].Columns = append(schema.Tables[i].Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating columns: %w", err)
+	}
+
+	rows, err = db.pool.Query(db.ctx, `
+		SELECT n.nspname, c.relname, con.conname, con.contype::text, pg_get_constraintdef(con.oid),
+		       ARRAY(SELECT a.attname::text FROM unnest(con.conkey) WITH ORDINALITY k(attnum, i)
+		             JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum ORDER BY k.i)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE con.contype IN ('p', 'u', 'f', 'c', 'x') AND `+exportSchemaFilter+`
+		ORDER BY n.nspname, c.relname, position(con.contype IN 'pufcx'), con.conname
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query constraints: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			schemaName, tableName, kind string
+			constraint                  zdd.SchemaConstraint
+		)
+		if err := rows.Scan(&schemaName, &tableName, &constraint.Name, &kind, &constraint.Definition, &constraint.Columns); err != nil {
+			return fmt.Errorf("failed to scan constraint: %w", err)
+		}
+		if i, ok := tables[schemaName+"."+tableName]; ok {
+			constraint.Kind = constraintKinds[kind]
+			schema.Tables[i].Constraints = append(schema.Tables[i].Constraints, constraint)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating constraints: %w", err)
+	}
+
+	rows, err = db.pool.Query(db.ctx, `
+		SELECT n.nspname, c.relname, pg_get_indexdef(x.indexrelid)
+		FROM pg_index x
+		JOIN pg_class c ON c.oid = x.indrelid
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = x.indexrelid AND con.conrelid = x.indrelid)
+		  AND `+exportSchemaFilter+`
+		ORDER BY n.nspname, c.relname, i.relname
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query indexes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schemaName, tableName, definition string
+		if err := rows.Scan(&schemaName, &tableName, &definition); err != nil {
+			return fmt.Errorf("failed to scan index: %w", err)
+		}
+		if i, ok := tables[schemaName+"."+tableName]; ok {
+			schema.Tables[i].Indexes = append(schema.Tables[i].Indexes, definition)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating indexes: %w", err)
+	}
+
+	return nil
+}
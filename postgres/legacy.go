@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	// legacyHistoryTable is where zdd releases before the zdd_deployments schema recorded applied
+	// migrations
+	legacyHistoryTable = "zdd_migrations.applied_migrations"
+	// upgradedLegacySchema is what the zdd_migrations schema is renamed to once its history is
+	// upgraded, keeping it for reference
+	upgradedLegacySchema = "zdd_migrations_upgraded"
+)
+
+// InitMigrationSchema initializes the deployment schema
+//
+// Deprecated: use InitDeploymentSchema.
+func (db *DB) InitMigrationSchema() error {
+	return db.InitDeploymentSchema()
+}
+
+// HasLegacyHistory reports whether the database has migration history in the zdd_migrations
+// schema of early zdd releases that has not been upgraded
+func (db *DB) HasLegacyHistory() (bool, error) {
+	var exists bool
+	if err := db.pool.QueryRow(db.ctx, "SELECT to_regclass($1) IS NOT NULL", legacyHistoryTable).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to look up %s: %w", legacyHistoryTable, err)
+	}
+	return exists, nil
+}
+
+// UpgradeLegacyHistory copies the migrations recorded in zdd_migrations.applied_migrations into
+// zdd_deployments.applied_deployments as applied deployments of the root module, and renames the
+// zdd_migrations schema to zdd_migrations_upgraded, in one transaction. Deployments already
+// recorded are left alone. It returns the number of deployments copied.
+func (db *DB) UpgradeLegacyHistory() (int, error) {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	if _, err := tx.Exec(db.ctx, "SELECT pg_advisory_xact_lock($1, 0)", metadataLockNamespace); err != nil {
+		return 0, fmt.Errorf("failed to lock the metadata schema: %w", err)
+	}
+
+	// Early releases changed the table's columns, so only id is relied on
+	rows, err := tx.Query(db.ctx, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = 'zdd_migrations' AND table_name = 'applied_migrations'
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the columns of %s: %w", legacyHistoryTable, err)
+	}
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan column: %w", err)
+		}
+		columns = append(columns, column)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read the columns of %s: %w", legacyHistoryTable, err)
+	}
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("the database has no %s to upgrade", legacyHistoryTable)
+	}
+	if !slices.Contains(columns, "id") {
+		return 0, fmt.Errorf("%s has no id column", legacyHistoryTable)
+	}
+
+	name, appliedAt, checksum := "id", "NOW()", "NULL"
+	if slices.Contains(columns, "name") {
+		name = "COALESCE(name, id)"
+	}
+	if slices.Contains(columns, "applied_at") {
+		appliedAt = "COALESCE(applied_at, NOW())"
+	}
+	if slices.Contains(columns, "checksum") {
+		checksum = "checksum"
+	}
+	tag, err := tx.Exec(db.ctx, fmt.Sprintf(`
+		INSERT INTO zdd_deployments.applied_deployments (module, id, name, applied_at, checksum, status, run_by)
+		SELECT '', id, %s, %s, %s, 'applied', $1 FROM %s
+		ON CONFLICT (module, id) DO NOTHING
+	`, name, appliedAt, checksum, legacyHistoryTable), db.runBy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy %s: %w", legacyHistoryTable, err)
+	}
+
+	if _, err := tx.Exec(db.ctx, "ALTER SCHEMA zdd_migrations RENAME TO "+upgradedLegacySchema); err != nil {
+		return 0, fmt.Errorf("failed to rename the zdd_migrations schema: %w", err)
+	}
+
+	if err := tx.Commit(db.ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
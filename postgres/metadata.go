@@ -0,0 +1,157 @@
+package postgres
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// metadataLockNamespace is the first key of the advisory lock held while the metadata schema is
+// migrated, so concurrent zdd processes migrate it once
+const metadataLockNamespace int32 = 0x7a646401
+
+// metadataMigrationFiles are the migrations of the zdd_deployments schema, named
+// NNNN_description.sql and applied in order. A migration is never changed once released; a
+// change to the schema is a new migration.
+//
+//go:embed assets/metadata/*.sql
+var metadataMigrationFiles embed.FS
+
+// metadataMigration is one migration of the zdd_deployments schema
+type metadataMigration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// metadataMigrations are the migrations of the zdd_deployments schema, in version order
+var metadataMigrations = loadMetadataMigrations()
+
+// createMetadataVersionsSQL creates the table recording the applied metadata migrations
+const createMetadataVersionsSQL = `
+CREATE SCHEMA IF NOT EXISTS zdd_deployments;
+
+CREATE TABLE IF NOT EXISTS zdd_deployments.metadata_versions (
+    version INTEGER PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    applied_by VARCHAR(255)
+);
+`
+
+// loadMetadataMigrations reads the embedded migrations, which embed lists in name order
+func loadMetadataMigrations() []metadataMigration {
+	entries, err := metadataMigrationFiles.ReadDir("assets/metadata")
+	if err != nil {
+		panic(err)
+	}
+
+	var migrations []metadataMigration
+	for i, entry := range entries {
+		prefix, name, _ := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version != i+1 {
+			panic(fmt.Sprintf("metadata migration %s is not numbered %04d", entry.Name(), i+1))
+		}
+		content, err := metadataMigrationFiles.ReadFile(path.Join("assets/metadata", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		migrations = append(migrations, metadataMigration{version: version, name: name, sql: string(content)})
+	}
+	return migrations
+}
+
+// MetadataSchemaVersion is the version of the zdd_deployments schema this build migrates to
+func MetadataSchemaVersion() int {
+	return len(metadataMigrations)
+}
+
+// SetupSchemaSQL returns the SQL InitDeploymentSchema runs on a new database, for scripts that
+// are run without zdd
+func SetupSchemaSQL() string {
+	var sql strings.Builder
+	sql.WriteString(createMetadataVersionsSQL)
+	for _, migration := range metadataMigrations {
+		fmt.Fprintf(&sql, "\n-- Metadata schema version %d: %s\n%s\n", migration.version, migration.name, migration.sql)
+		fmt.Fprintf(&sql, "INSERT INTO zdd_deployments.metadata_versions (version, name, applied_by) VALUES (%d, '%s', current_user) ON CONFLICT DO NOTHING;\n",
+			migration.version, migration.name)
+	}
+	return sql.String()
+}
+
+// InitDeploymentSchema creates the zdd_deployments schema, or migrates it to the version of this
+// build, in one transaction. A schema already migrated by a newer zdd is an error, as this build
+// may not record deployments the way that one expects.
+func (db *DB) InitDeploymentSchema() error {
+	current, err := db.MetadataVersion()
+	if err != nil {
+		return err
+	}
+	if current == MetadataSchemaVersion() {
+		return nil
+	}
+	if current > MetadataSchemaVersion() {
+		return newerMetadataError(current)
+	}
+
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	if _, err := tx.Exec(db.ctx, "SELECT pg_advisory_xact_lock($1, 0)", metadataLockNamespace); err != nil {
+		return fmt.Errorf("failed to lock the metadata schema: %w", err)
+	}
+	if _, err := tx.Exec(db.ctx, createMetadataVersionsSQL); err != nil {
+		return fmt.Errorf("failed to initialize deployment schema: %w", err)
+	}
+	// Another process may have migrated the schema while this one waited for the lock
+	if err := tx.QueryRow(db.ctx, "SELECT COALESCE(MAX(version), 0) FROM zdd_deployments.metadata_versions").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read the metadata schema version: %w", err)
+	}
+	if current > MetadataSchemaVersion() {
+		return newerMetadataError(current)
+	}
+
+	for _, migration := range metadataMigrations[current:] {
+		if _, err := tx.Exec(db.ctx, migration.sql); err != nil {
+			return fmt.Errorf("failed to migrate the metadata schema to version %d (%s): %w", migration.version, migration.name, err)
+		}
+		if _, err := tx.Exec(db.ctx, "INSERT INTO zdd_deployments.metadata_versions (version, name, applied_by) VALUES ($1, $2, $3)",
+			migration.version, migration.name, db.runBy); err != nil {
+			return fmt.Errorf("failed to record metadata schema version %d: %w", migration.version, err)
+		}
+	}
+
+	if err := tx.Commit(db.ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// MetadataVersion returns the version of the database's zdd_deployments schema: 0 if it has none
+// or was created before the schema was versioned
+func (db *DB) MetadataVersion() (int, error) {
+	var exists bool
+	if err := db.pool.QueryRow(db.ctx, "SELECT to_regclass('zdd_deployments.metadata_versions') IS NOT NULL").Scan(&exists); err != nil {
+		return 0, fmt.Errorf("failed to look up the metadata schema version: %w", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	if err := db.pool.QueryRow(db.ctx, "SELECT COALESCE(MAX(version), 0) FROM zdd_deployments.metadata_versions").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read the metadata schema version: %w", err)
+	}
+	return version, nil
+}
+
+// newerMetadataError explains that the metadata schema was migrated by a newer zdd
+func newerMetadataError(version int) error {
+	return fmt.Errorf("the zdd_deployments schema is at version %d, but this zdd supports up to version %d; upgrade zdd", version, MetadataSchemaVersion())
+}
@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// DatabasePool hands out databases cloned from a TemplateDatabase to parallel tests. The
+// databases are cloned up front, and a released database is dropped and cloned again in the
+// background, so a test waits for a database only when every one is in use.
+type DatabasePool struct {
+	template  *TemplateDatabase
+	names     []string
+	available chan string
+
+	recycling sync.WaitGroup
+	mu        sync.Mutex
+	errs      []error // Failures to recycle databases, returned by Close
+}
+
+// NewDatabasePool clones size databases from template. Close the pool before the template.
+func NewDatabasePool(ctx context.Context, template *TemplateDatabase, size int) (*DatabasePool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("database pool size must be at least 1")
+	}
+
+	pool := &DatabasePool{template: template, available: make(chan string, size)}
+	for i := range size {
+		name := fmt.Sprintf("%s_pool_%d", template.name, i+1)
+		if len(name) > 63 {
+			return nil, fmt.Errorf("pool database name %s exceeds PostgreSQL's 63-byte limit; use a shorter template name", name)
+		}
+		// A pool left behind by an interrupted run is replaced
+		if err := template.dropClone(ctx, name); err != nil {
+			pool.Close(ctx)
+			return nil, err
+		}
+		if err := template.clone(ctx, name); err != nil {
+			pool.Close(ctx)
+			return nil, err
+		}
+		pool.names = append(pool.names, name)
+		pool.available <- name
+	}
+	return pool, nil
+}
+
+// Acquire waits for a database and returns its URL and the function that releases it. A
+// released database is recycled, so the next test that acquires it starts from the template.
+func (p *DatabasePool) Acquire(ctx context.Context) (string, func(), error) {
+	var name string
+	select {
+	case name = <-p.available:
+	case <-ctx.Done():
+		return "", nil, fmt.Errorf("failed to acquire a pool database: %w", ctx.Err())
+	}
+	if name == "" {
+		// A database could not be recycled; fail every waiter rather than leave it blocked
+		p.available <- name
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return "", nil, p.errs[0]
+	}
+
+	databaseURL, err := withDatabase(p.template.databaseURL, name)
+	if err != nil {
+		p.available <- name
+		return "", nil, err
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			p.recycling.Add(1)
+			go p.recycle(name)
+		})
+	}
+	return databaseURL, release, nil
+}
+
+// ForTest acquires a database for tb, released when tb and its subtests complete. It is safe
+// to call from tests that run with t.Parallel.
+func (p *DatabasePool) ForTest(tb testing.TB) string {
+	tb.Helper()
+	databaseURL, release, err := p.Acquire(context.Background())
+	if err != nil {
+		tb.Fatalf("failed to acquire a pool database: %v", err)
+	}
+	tb.Cleanup(release)
+	return databaseURL
+}
+
+// recycle drops the database, which disconnects any sessions a test left open, and clones it
+// from the template again before making it available
+func (p *DatabasePool) recycle(name string) {
+	defer p.recycling.Done()
+
+	ctx := context.Background()
+	err := p.template.dropClone(ctx, name)
+	if err == nil {
+		err = p.template.clone(ctx, name)
+	}
+	if err != nil {
+		p.mu.Lock()
+		p.errs = append(p.errs, fmt.Errorf("failed to recycle pool database %s: %w", name, err))
+		p.mu.Unlock()
+		p.available <- ""
+		return
+	}
+	p.available <- name
+}
+
+// Close waits for released databases to be recycled and drops every database of the pool.
+// Databases still acquired are dropped too.
+func (p *DatabasePool) Close(ctx context.Context) error {
+	p.recycling.Wait()
+
+	p.mu.Lock()
+	errs := slices.Clone(p.errs)
+	p.mu.Unlock()
+
+	for _, name := range p.names {
+		if err := p.template.dropClone(ctx, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
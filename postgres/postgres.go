@@ -2,11 +2,18 @@ package postgres
 
 import (
 	"context"
-	_ "embed"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"os"
+	"os/user"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mantty/zdd"
 )
@@ -17,19 +24,41 @@ type (
 		pool    *pgxpool.Pool
 		ctx     context.Context
 		connStr string
+		batch   bool   // Pipeline the statements of each SQL file, see SetBatchStatements
+		runID   string // Identifies this process in the deployments it starts and records
+		runBy   string // user@host of this process
+
+		lockMu   sync.Mutex
+		lockConn *pgxpool.Conn // Session holding the run's advisory lock while a heartbeat exists
+		// deployLockConn is the session holding the deploy lock while Plan.Execute runs
+		deployLockConn *pgxpool.Conn
 	}
+
+	// Option customises the connection pool created by NewDB
+	Option func(*pgxpool.Config)
 )
 
-//go:embed assets/setup_schema.sql
-var createDeploymentsTableSQL string
+// maxErrorLength bounds the error excerpt stored with a failed deployment, in bytes
+const maxErrorLength = 2000
+
+// WithRuntimeParam sets a PostgreSQL setting such as lock_timeout on every connection
+func WithRuntimeParam(name, value string) Option {
+	return func(config *pgxpool.Config) {
+		config.ConnConfig.RuntimeParams[name] = value
+	}
+}
 
 // NewDB creates a new PostgreSQL database connection
-func NewDB(ctx context.Context, databaseURL string) (*DB, error) {
+func NewDB(ctx context.Context, databaseURL string, opts ...Option) (*DB, error) {
 	config, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse database URL: %w", err)
 	}
 
+	for _, opt := range opts {
+		opt(config)
+	}
+
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create connection pool: %w", err)
@@ -44,6 +73,8 @@ func NewDB(ctx context.Context, databaseURL string) (*DB, error) {
 		pool:    pool,
 		ctx:     ctx,
 		connStr: databaseURL,
+		runID:   zdd.RunID(),
+		runBy:   runBy(),
 	}
 	if err := db.InitDeploymentSchema(); err != nil {
 		pool.Close()
@@ -55,6 +86,8 @@ func NewDB(ctx context.Context, databaseURL string) (*DB, error) {
 
 // Close closes the database connection
 func (db *DB) Close() error {
+	db.releaseRunLock()
+	db.UnlockDeploys()
 	db.pool.Close()
 	return nil
 }
@@ -64,24 +97,29 @@ func (db *DB) ConnectionString() string {
 	return db.connStr
 }
 
-// InitDeploymentSchema creates the zdd_deployments schema and table if they don't exist
-func (db *DB) InitDeploymentSchema() error {
-	_, err := db.pool.Exec(db.ctx, createDeploymentsTableSQL)
-	if err != nil {
-		return fmt.Errorf("failed to initialize deployment schema: %w", err)
-	}
-	return nil
-}
-
 // GetAppliedDeployments returns all deployments that have been applied to the database
 func (db *DB) GetAppliedDeployments() ([]zdd.DeploymentDBRecord, error) {
+	return db.queryAppliedDeployments("")
+}
+
+// GetModuleAppliedDeployments returns the applied deployments of the modules, oldest first
+func (db *DB) GetModuleAppliedDeployments(modules []string) ([]zdd.DeploymentDBRecord, error) {
+	return db.queryAppliedDeployments("AND module = ANY($1)", modules)
+}
+
+// queryAppliedDeployments returns the applied deployments matching the extra conditions, oldest first
+func (db *DB) queryAppliedDeployments(conditions string, args ...any) ([]zdd.DeploymentDBRecord, error) {
 	query := `
-		SELECT id, name, applied_at, COALESCE(checksum, '') as checksum 
+		SELECT id, name, module, applied_at, COALESCE(checksum, '') as checksum,
+		       COALESCE(skipped_tasks, '{}') as skipped_tasks, COALESCE(ticket, '') as ticket,
+		       COALESCE(restore_point, '') as restore_point, config,
+		       COALESCE(run_id, '') as run_id
 		FROM zdd_deployments.applied_deployments 
+		WHERE status = 'applied' ` + conditions + `
 		ORDER BY applied_at ASC
 	`
 
-	rows, err := db.pool.Query(db.ctx, query)
+	rows, err := db.pool.Query(db.ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query applied deployments: %w", err)
 	}
@@ -90,7 +128,7 @@ func (db *DB) GetAppliedDeployments() ([]zdd.DeploymentDBRecord, error) {
 	var deployments []zdd.DeploymentDBRecord
 	for rows.Next() {
 		var d zdd.DeploymentDBRecord
-		if err := rows.Scan(&d.ID, &d.Name, &d.AppliedAt, &d.Checksum); err != nil {
+		if err := rows.Scan(&d.ID, &d.Name, &d.Module, &d.AppliedAt, &d.Checksum, &d.SkippedTasks, &d.Ticket, &d.RestorePoint, &d.ConfigSnapshot, &d.RunID); err != nil {
 			return nil, fmt.Errorf("failed to scan deployment record: %w", err)
 		}
 		deployments = append(deployments, d)
@@ -103,17 +141,42 @@ func (db *DB) GetAppliedDeployments() ([]zdd.DeploymentDBRecord, error) {
 	return deployments, nil
 }
 
+// AppliedDeploymentIDs returns up to limit IDs of the module's applied deployments after the
+// cursor after, in ID order; the primary key index serves each page
+func (db *DB) AppliedDeploymentIDs(module, after string, limit int) ([]string, error) {
+	query := `
+		SELECT id FROM zdd_deployments.applied_deployments
+		WHERE module = $1 AND id > $2 AND status = 'applied'
+		ORDER BY id
+		LIMIT $3
+	`
+
+	rows, err := db.pool.Query(db.ctx, query, module, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied deployment IDs: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied deployment IDs: %w", err)
+	}
+	return ids, nil
+}
+
 // GetLastAppliedDeployment returns the most recently applied deployment
 func (db *DB) GetLastAppliedDeployment() (*zdd.DeploymentDBRecord, error) {
 	query := `
-		SELECT id, name, applied_at, COALESCE(checksum, '') as checksum 
+		SELECT id, name, module, applied_at, COALESCE(checksum, '') as checksum,
+		       COALESCE(skipped_tasks, '{}') as skipped_tasks, COALESCE(ticket, '') as ticket,
+		       COALESCE(restore_point, '') as restore_point, config,
+		       COALESCE(run_id, '') as run_id
 		FROM zdd_deployments.applied_deployments 
+		WHERE status = 'applied'
 		ORDER BY applied_at DESC 
 		LIMIT 1
 	`
 
 	var d zdd.DeploymentDBRecord
-	err := db.pool.QueryRow(db.ctx, query).Scan(&d.ID, &d.Name, &d.AppliedAt, &d.Checksum)
+	err := db.pool.QueryRow(db.ctx, query).Scan(&d.ID, &d.Name, &d.Module, &d.AppliedAt, &d.Checksum, &d.SkippedTasks, &d.Ticket, &d.RestorePoint, &d.ConfigSnapshot, &d.RunID)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, nil // No deployments applied yet
@@ -124,21 +187,817 @@ func (db *DB) GetLastAppliedDeployment() (*zdd.DeploymentDBRecord, error) {
 	return &d, nil
 }
 
-// RecordDeployment records that a deployment has been applied
+// RecordDeployment records that a deployment has been applied. Recording is idempotent for
+// deployments this process started or that failed earlier, but fails if another process
+// recorded or is running the deployment.
 func (db *DB) RecordDeployment(deployment zdd.Deployment, checksum string) error {
 	query := `
-		INSERT INTO zdd_deployments.applied_deployments (id, name, applied_at, checksum)
-		VALUES ($1, $2, NOW(), $3)
+		INSERT INTO zdd_deployments.applied_deployments (id, name, module, applied_at, checksum, skipped_tasks, status, run_id, run_by, ticket, restore_point, config)
+		VALUES ($1, $2, $3, NOW(), $4, $5, 'applied', $6, $7, $8, NULLIF($9, ''), $10)
+		ON CONFLICT (module, id) DO UPDATE
+		SET name = EXCLUDED.name, applied_at = EXCLUDED.applied_at, checksum = EXCLUDED.checksum,
+		    skipped_tasks = EXCLUDED.skipped_tasks, status = 'applied', run_id = EXCLUDED.run_id,
+		    run_by = EXCLUDED.run_by, error = NULL, ticket = EXCLUDED.ticket,
+		    restore_point = EXCLUDED.restore_point, config = EXCLUDED.config
+		WHERE applied_deployments.status = 'failed'
+		   OR (applied_deployments.status = 'running' AND applied_deployments.run_id = EXCLUDED.run_id)
+	`
+
+	var ticket *string
+	if deployment.Ticket != nil {
+		ticket = &deployment.Ticket.Key
+	}
+
+	result, err := db.pool.Exec(db.ctx, query, deployment.ID, deployment.Name, deployment.Module, checksum, deployment.SkippedTasks, db.runID, db.runBy, ticket, deployment.RestorePoint, configSnapshot(deployment.ConfigSnapshot))
+	if err != nil {
+		return fmt.Errorf("failed to record deployment %s: %w", deployment.Key(), err)
+	}
+	if result.RowsAffected() == 0 {
+		return db.deploymentConflict(deployment)
+	}
+
+	return nil
+}
+
+// configSnapshot returns the snapshot as a JSONB parameter, NULL if there is none
+func configSnapshot(snapshot []byte) any {
+	if len(snapshot) == 0 {
+		return nil
+	}
+	return string(snapshot)
+}
+
+// StartDeployment marks a deployment as running in this process. A deployment that failed
+// earlier is resumed. One running in another process is taken over if the policy forces it, or
+// if that process is abandoned: it has gone policy.StaleAfter without a heartbeat and its
+// session no longer holds the run's lock.
+func (db *DB) StartDeployment(deployment zdd.Deployment, policy zdd.TakeoverPolicy) error {
+	started, err := db.claimDeployment(deployment, policy.Force, "")
+	if err != nil || started {
+		return err
+	}
+
+	if policy.StaleAfter > 0 {
+		runID, abandoned, err := db.abandonedRun(deployment, policy.StaleAfter)
+		if err != nil {
+			return err
+		}
+		if abandoned {
+			// Only take over from the abandoned run, in case another process got there first
+			started, err := db.claimDeployment(deployment, false, runID)
+			if err != nil || started {
+				return err
+			}
+		}
+	}
+
+	return db.deploymentConflict(deployment)
+}
+
+// claimDeployment marks a deployment as running in this process if it is not recorded, failed,
+// or already running in this process, in the run from, or in any run if force is set
+func (db *DB) claimDeployment(deployment zdd.Deployment, force bool, from string) (bool, error) {
+	query := `
+		INSERT INTO zdd_deployments.applied_deployments (id, name, module, applied_at, status, run_id, run_by)
+		VALUES ($1, $2, $3, NOW(), 'running', $4, $5)
+		ON CONFLICT (module, id) DO UPDATE
+		SET name = EXCLUDED.name, applied_at = EXCLUDED.applied_at, status = 'running', run_id = EXCLUDED.run_id,
+		    run_by = EXCLUDED.run_by, error = NULL
+		WHERE applied_deployments.status = 'failed'
+		   OR (applied_deployments.status = 'running' AND (applied_deployments.run_id IN (EXCLUDED.run_id, $7) OR $6))
+	`
+
+	result, err := db.pool.Exec(db.ctx, query, deployment.ID, deployment.Name, deployment.Module, db.runID, db.runBy, force, from)
+	if err != nil {
+		return false, fmt.Errorf("failed to start deployment %s: %w", deployment.Key(), err)
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// FailDeployment marks a deployment this process started as failed with an excerpt of the
+// error, so list shows the attempt and a later run resumes it
+func (db *DB) FailDeployment(deployment zdd.Deployment, cause error) error {
+	query := `
+		UPDATE zdd_deployments.applied_deployments SET status = 'failed', error = $4
+		WHERE module = $1 AND id = $2 AND status = 'running' AND run_id = $3
+	`
+
+	message := ""
+	if cause != nil {
+		message = cause.Error()
+	}
+	if len(message) > maxErrorLength {
+		message = strings.ToValidUTF8(message[:maxErrorLength], "") + "…"
+	}
+
+	if _, err := db.pool.Exec(db.ctx, query, deployment.Module, deployment.ID, db.runID, message); err != nil {
+		return fmt.Errorf("failed to mark deployment %s as failed: %w", deployment.Key(), err)
+	}
+	return nil
+}
+
+// GetDeploymentAttempts returns the deployments that are running or failed
+func (db *DB) GetDeploymentAttempts() ([]zdd.DeploymentAttempt, error) {
+	query := `
+		SELECT id, name, module, status, applied_at, COALESCE(run_by, ''), COALESCE(error, '')
+		FROM zdd_deployments.applied_deployments
+		WHERE status <> 'applied'
+		ORDER BY applied_at
+	`
+
+	rows, err := db.pool.Query(db.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deployment attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []zdd.DeploymentAttempt
+	for rows.Next() {
+		var a zdd.DeploymentAttempt
+		if err := rows.Scan(&a.ID, &a.Name, &a.Module, &a.Status, &a.StartedAt, &a.RunBy, &a.Error); err != nil {
+			return nil, fmt.Errorf("failed to scan deployment attempt: %w", err)
+		}
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating deployment attempts: %w", err)
+	}
+
+	return attempts, nil
+}
+
+// runBy identifies the user and host running this process, as user@host
+func runBy() string {
+	name := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
+
+// deploymentConflict describes the record that kept this process from starting or recording a
+// deployment
+func (db *DB) deploymentConflict(deployment zdd.Deployment) error {
+	query := `
+		SELECT status, COALESCE(run_id, ''), COALESCE(run_by, ''), applied_at FROM zdd_deployments.applied_deployments
+		WHERE module = $1 AND id = $2
+	`
+
+	var status, runID, runBy string
+	var at time.Time
+	if err := db.pool.QueryRow(db.ctx, query, deployment.Module, deployment.ID).Scan(&status, &runID, &runBy, &at); err != nil {
+		return fmt.Errorf("failed to look up deployment %s: %w", deployment.Key(), err)
+	}
+
+	if status == "running" {
+		return fmt.Errorf("deployment %s is being applied by another zdd process (run %s by %s, started %s); if that process is no longer running, run zdd unlock or rerun with --takeover", deployment.Key(), runID, runBy, at.Format(time.RFC3339))
+	}
+	return fmt.Errorf("deployment %s was already recorded as %s by another zdd process (run %s by %s at %s)", deployment.Key(), status, runID, runBy, at.Format(time.RFC3339))
+}
+
+// ApplyPackSegment runs the SQL of a pack segment and records its deployments in one transaction
+func (db *DB) ApplyPackSegment(sql string, deployments []zdd.DeploymentDBRecord) error {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	if strings.TrimSpace(sql) != "" {
+		if _, err := tx.Exec(db.ctx, sql); err != nil {
+			return fmt.Errorf("failed to execute pack SQL: %w", err)
+		}
+	}
+
+	ids := make([]string, len(deployments))
+	names := make([]string, len(deployments))
+	modules := make([]string, len(deployments))
+	checksums := make([]string, len(deployments))
+	for i, d := range deployments {
+		ids[i], names[i], modules[i], checksums[i] = d.ID, d.Name, d.Module, d.Checksum
+	}
+
+	query := `
+		INSERT INTO zdd_deployments.applied_deployments (id, name, module, applied_at, checksum)
+		SELECT id, name, module, NOW(), checksum
+		FROM unnest($1::text[], $2::text[], $3::text[], $4::text[]) AS d(id, name, module, checksum)
+	`
+	if _, err := tx.Exec(db.ctx, query, ids, names, modules, checksums); err != nil {
+		return fmt.Errorf("failed to record packed deployments: %w", err)
+	}
+
+	if err := tx.Commit(db.ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RecordTaskRun records how long a task took, for estimating future runs of the same file
+func (db *DB) RecordTaskRun(run zdd.TaskRun) error {
+	query := `
+		INSERT INTO zdd_deployments.task_runs (module, deployment_id, task, file_checksum, duration_ms, runner, run_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+	`
+
+	_, err := db.pool.Exec(db.ctx, query, run.Module, run.DeploymentID, run.Task, run.FileChecksum, run.Duration.Milliseconds(), run.Runner, db.runID)
+	if err != nil {
+		return fmt.Errorf("failed to record task run: %w", err)
+	}
+
+	return nil
+}
+
+// TaskDurations returns the average recorded duration for each file checksum with run history
+func (db *DB) TaskDurations(checksums []string) (map[string]time.Duration, error) {
+	query := `
+		SELECT file_checksum, AVG(duration_ms)::BIGINT
+		FROM zdd_deployments.task_runs
+		WHERE file_checksum = ANY($1)
+		GROUP BY file_checksum
+	`
+
+	rows, err := db.pool.Query(db.ctx, query, checksums)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query task runs: %w", err)
+	}
+	defer rows.Close()
+
+	durations := make(map[string]time.Duration)
+	for rows.Next() {
+		var checksum string
+		var ms int64
+		if err := rows.Scan(&checksum, &ms); err != nil {
+			return nil, fmt.Errorf("failed to scan task run: %w", err)
+		}
+		durations[checksum] = time.Duration(ms) * time.Millisecond
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating task runs: %w", err)
+	}
+
+	return durations, nil
+}
+
+// QueryValues runs query in a read-only transaction and returns every row with its columns
+// rendered as text, NULL as "NULL"
+func (db *DB) QueryValues(query string) ([][]string, error) {
+	tx, err := db.pool.BeginTx(db.ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx)
+
+	rows, err := tx.Query(db.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run query: %w", err)
+	}
+	defer rows.Close()
+
+	var result [][]string
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read row: %w", err)
+		}
+
+		row := make([]string, len(values))
+		for i, value := range values {
+			row[i] = formatValue(value)
+		}
+		result = append(result, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return result, nil
+}
+
+// RunTAP runs a pgTAP test file in a transaction that is rolled back, so tests leave no trace,
+// and returns the first column of every row its statements return as text
+func (db *DB) RunTAP(sql string) ([]string, error) {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx)
+
+	// The simple protocol returns the rows of every statement of the file
+	results, err := tx.Conn().PgConn().Exec(db.ctx, sql).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, result := range results {
+		for _, row := range result.Rows {
+			if len(row) > 0 && row[0] != nil {
+				lines = append(lines, string(row[0]))
+			}
+		}
+	}
+	return lines, nil
+}
+
+// formatValue renders a value scanned by pgx as text
+func formatValue(value any) string {
+	if valuer, ok := value.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err == nil {
+			value = v
+		}
+	}
+	if value == nil {
+		return "NULL"
+	}
+	return fmt.Sprint(value)
+}
+
+// RecordAssertion records the outcome of a post-deploy assertion
+func (db *DB) RecordAssertion(result zdd.AssertionResult) error {
+	query := `
+		INSERT INTO zdd_deployments.assertion_results (module, deployment_id, name, passed, expected, actual)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := db.pool.Exec(db.ctx, query, result.Module, result.DeploymentID, result.Name, result.Passed, result.Expected, result.Actual)
+	if err != nil {
+		return fmt.Errorf("failed to record assertion %s: %w", result.Name, err)
+	}
+
+	return nil
+}
+
+// CompatViews returns the tracked compatibility views, oldest first
+func (db *DB) CompatViews() ([]zdd.CompatView, error) {
+	query := `
+		SELECT view_name, table_name, module, deployment_id, created_at
+		FROM zdd_deployments.compat_views
+		ORDER BY created_at ASC
+	`
+
+	rows, err := db.pool.Query(db.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compatibility views: %w", err)
+	}
+	defer rows.Close()
+
+	var views []zdd.CompatView
+	for rows.Next() {
+		var v zdd.CompatView
+		if err := rows.Scan(&v.View, &v.Table, &v.Module, &v.DeploymentID, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan compatibility view: %w", err)
+		}
+		views = append(views, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating compatibility views: %w", err)
+	}
+
+	return views, nil
+}
+
+// RecordCompatView starts tracking a compatibility view, which must already exist
+func (db *DB) RecordCompatView(view zdd.CompatView) error {
+	var exists bool
+	if err := db.pool.QueryRow(db.ctx, "SELECT to_regclass($1) IS NOT NULL", view.View).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up view %s: %w", view.View, err)
+	}
+	if !exists {
+		return fmt.Errorf("view %s declared in views.yaml was not created by the contract phase", view.View)
+	}
+
+	query := `
+		INSERT INTO zdd_deployments.compat_views (view_name, table_name, module, deployment_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (view_name) DO UPDATE
+		SET table_name = EXCLUDED.table_name, module = EXCLUDED.module,
+		    deployment_id = EXCLUDED.deployment_id, created_at = NOW()
+	`
+
+	_, err := db.pool.Exec(db.ctx, query, view.View, view.Table, view.Module, view.DeploymentID)
+	if err != nil {
+		return fmt.Errorf("failed to record compatibility view %s: %w", view.View, err)
+	}
+
+	return nil
+}
+
+// DropCompatView drops a compatibility view if it exists and stops tracking it, in one transaction
+func (db *DB) DropCompatView(view zdd.CompatView) error {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	if _, err := tx.Exec(db.ctx, "DROP VIEW IF EXISTS "+pgx.Identifier(strings.Split(view.View, ".")).Sanitize()); err != nil {
+		return fmt.Errorf("failed to drop compatibility view %s: %w", view.View, err)
+	}
+	if _, err := tx.Exec(db.ctx, "DELETE FROM zdd_deployments.compat_views WHERE view_name = $1", view.View); err != nil {
+		return fmt.Errorf("failed to forget compatibility view %s: %w", view.View, err)
+	}
+
+	if err := tx.Commit(db.ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ReplaceDeprecations replaces the registered deprecations of the named modules in one
+// transaction
+func (db *DB) ReplaceDeprecations(modules []string, deprecations []zdd.Deprecation) error {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	if _, err := tx.Exec(db.ctx, "DELETE FROM zdd_deployments.deprecations WHERE module = ANY($1)", modules); err != nil {
+		return fmt.Errorf("failed to clear deprecations: %w", err)
+	}
+
+	query := `
+		INSERT INTO zdd_deployments.deprecations (module, deployment_id, deployment_name, kind, name, path, line, run_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (module, deployment_id, kind, name) DO NOTHING
+	`
+	for _, d := range deprecations {
+		if _, err := tx.Exec(db.ctx, query, d.Module, d.DeploymentID, d.DeploymentName, d.Kind, d.Name, d.Path, d.Line, db.runID); err != nil {
+			return fmt.Errorf("failed to register deprecation of %s %s: %w", d.Kind, d.Name, err)
+		}
+	}
+
+	if err := tx.Commit(db.ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetDeprecations returns the registered deprecations of deployments that are not applied yet
+func (db *DB) GetDeprecations() ([]zdd.Deprecation, error) {
+	query := `
+		SELECT module, deployment_id, deployment_name, kind, name, path, line
+		FROM zdd_deployments.pending_deprecations
+		ORDER BY module, deployment_id, path, line
+	`
+
+	rows, err := db.pool.Query(db.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deprecations: %w", err)
+	}
+	defer rows.Close()
+
+	var deprecations []zdd.Deprecation
+	for rows.Next() {
+		var d zdd.Deprecation
+		if err := rows.Scan(&d.Module, &d.DeploymentID, &d.DeploymentName, &d.Kind, &d.Name, &d.Path, &d.Line); err != nil {
+			return nil, fmt.Errorf("failed to scan deprecation: %w", err)
+		}
+		deprecations = append(deprecations, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating deprecations: %w", err)
+	}
+
+	return deprecations, nil
+}
+
+// TrashedObjects returns the tracked trashed tables and columns, oldest first
+func (db *DB) TrashedObjects() ([]zdd.TrashedObject, error) {
+	query := `
+		SELECT kind, name, trashed_table, trashed_column, module, deployment_id, trashed_at
+		FROM zdd_deployments.trash
+		ORDER BY trashed_at ASC
+	`
+
+	rows, err := db.pool.Query(db.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trashed objects: %w", err)
+	}
+	defer rows.Close()
+
+	var objects []zdd.TrashedObject
+	for rows.Next() {
+		var o zdd.TrashedObject
+		if err := rows.Scan(&o.Kind, &o.Name, &o.Table, &o.Column, &o.Module, &o.DeploymentID, &o.TrashedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan trashed object: %w", err)
+		}
+		objects = append(objects, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trashed objects: %w", err)
+	}
+
+	return objects, nil
+}
+
+// PurgeTrashedObject drops a trashed table or column if it exists and stops tracking it, in one
+// transaction. Views and foreign keys still depending on it are dropped with it.
+func (db *DB) PurgeTrashedObject(object zdd.TrashedObject) error {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	table := pgx.Identifier(strings.Split(object.Table, ".")).Sanitize()
+	drop := "DROP TABLE IF EXISTS " + table + " CASCADE"
+	if object.Kind == "column" {
+		drop = "ALTER TABLE IF EXISTS " + table + " DROP COLUMN IF EXISTS " + pgx.Identifier{object.Column}.Sanitize() + " CASCADE"
+	}
+	if _, err := tx.Exec(db.ctx, drop); err != nil {
+		return fmt.Errorf("failed to purge %s: %w", object, err)
+	}
+	if _, err := tx.Exec(db.ctx, "DELETE FROM zdd_deployments.trash WHERE trashed_table = $1 AND trashed_column = $2", object.Table, object.Column); err != nil {
+		return fmt.Errorf("failed to forget %s: %w", object, err)
+	}
+
+	if err := tx.Commit(db.ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RecordFlagChange records a feature flag set by a deployment, replacing an earlier record of
+// the same flag and phase from a resumed deployment
+func (db *DB) RecordFlagChange(change zdd.FlagChange) error {
+	query := `
+		INSERT INTO zdd_deployments.feature_flags (module, deployment_id, name, value, phase)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (module, deployment_id, name, phase) DO UPDATE
+		SET value = EXCLUDED.value, set_at = NOW()
+	`
+
+	_, err := db.pool.Exec(db.ctx, query, change.Module, change.DeploymentID, change.Name, change.Value, change.Phase)
+	if err != nil {
+		return fmt.Errorf("failed to record feature flag %s: %w", change.Name, err)
+	}
+
+	return nil
+}
+
+// RecordApproval records an approval of a deployment for a scope; approving the same deployment
+// content for the scope again replaces the token
+func (db *DB) RecordApproval(approval zdd.Approval) error {
+	query := `
+		INSERT INTO zdd_deployments.approvals (module, deployment_id, scope, checksum, token, approved_by, run_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (module, deployment_id, scope, checksum) DO UPDATE
+		SET token = EXCLUDED.token, approved_by = EXCLUDED.approved_by, approved_at = NOW(), run_id = EXCLUDED.run_id
+	`
+
+	_, err := db.pool.Exec(db.ctx, query, approval.Module, approval.DeploymentID, approval.Scope, approval.Checksum, approval.Token, db.runBy, db.runID)
+	if err != nil {
+		return fmt.Errorf("failed to record approval: %w", err)
+	}
+
+	return nil
+}
+
+// GetApprovals returns the approvals recorded for a deployment, oldest first
+func (db *DB) GetApprovals(deployment zdd.Deployment) ([]zdd.Approval, error) {
+	query := `
+		SELECT scope, checksum, token, COALESCE(approved_by, ''), approved_at
+		FROM zdd_deployments.approvals
+		WHERE module = $1 AND deployment_id = $2
+		ORDER BY approved_at
 	`
 
-	_, err := db.pool.Exec(db.ctx, query, deployment.ID, deployment.Name, checksum)
+	rows, err := db.pool.Query(db.ctx, query, deployment.Module, deployment.ID)
 	if err != nil {
-		return fmt.Errorf("failed to record deployment %s: %w", deployment.ID, err)
+		return nil, fmt.Errorf("failed to query approvals: %w", err)
 	}
+	defer rows.Close()
+
+	var approvals []zdd.Approval
+	for rows.Next() {
+		approval := zdd.Approval{Module: deployment.Module, DeploymentID: deployment.ID}
+		if err := rows.Scan(&approval.Scope, &approval.Checksum, &approval.Token, &approval.ApprovedBy, &approval.ApprovedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan approval: %w", err)
+		}
+		approvals = append(approvals, approval)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating approvals: %w", err)
+	}
+
+	return approvals, nil
+}
+
+// RecordRollbackVerification records the latest verification of a deployment's revert SQL
+func (db *DB) RecordRollbackVerification(verification zdd.RollbackVerification) error {
+	query := `
+		INSERT INTO zdd_deployments.rollback_verifications (module, deployment_id, rollback_checksum, passed, differences, verified_at)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, NOW()))
+		ON CONFLICT (module, deployment_id) DO UPDATE
+		SET rollback_checksum = EXCLUDED.rollback_checksum, passed = EXCLUDED.passed,
+		    differences = EXCLUDED.differences, verified_at = EXCLUDED.verified_at
+	`
+
+	_, err := db.pool.Exec(db.ctx, query, verification.Module, verification.DeploymentID, verification.Checksum,
+		verification.Passed, verification.Differences, verification.VerifiedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record rollback verification of %s: %w", verification.Key(), err)
+	}
+
+	return nil
+}
+
+// SchemaSnapshot describes the schemas, tables, columns, constraints, indexes, views, functions,
+// triggers and types outside the system and zdd schemas, or only in schemas if any are given,
+// one per line and sorted
+func (db *DB) SchemaSnapshot(schemas ...string) ([]string, error) {
+	query := `
+		WITH schemas AS (
+			SELECT oid, nspname FROM pg_namespace
+			WHERE nspname NOT IN ('pg_catalog', 'information_schema', 'pg_toast', 'zdd_deployments', 'zdd_trash')
+			  AND nspname NOT LIKE 'pg\_%'
+			  AND (cardinality($1::text[]) = 0 OR nspname = ANY($1))
+		)
+		SELECT 'schema ' || nspname FROM schemas
+		UNION ALL
+		SELECT 'relation ' || s.nspname || '.' || c.relname || ' ' || c.relkind
+		FROM pg_class c JOIN schemas s ON s.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f')
+		UNION ALL
+		SELECT 'column ' || s.nspname || '.' || c.relname || '.' || a.attname || ' ' || format_type(a.atttypid, a.atttypmod)
+		       || CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END
+		       || COALESCE(' DEFAULT ' || pg_get_expr(d.adbin, d.adrelid), '')
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN schemas s ON s.oid = c.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f') AND a.attnum > 0 AND NOT a.attisdropped
+		UNION ALL
+		SELECT 'constraint ' || s.nspname || '.' || c.relname || '.' || con.conname || ' ' || pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN schemas s ON s.oid = c.relnamespace
+		UNION ALL
+		SELECT 'index ' || s.nspname || '.' || i.relname || ' ' || pg_get_indexdef(i.oid)
+		FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN schemas s ON s.oid = i.relnamespace
+		UNION ALL
+		SELECT 'view ' || s.nspname || '.' || c.relname || ' ' || md5(pg_get_viewdef(c.oid))
+		FROM pg_class c JOIN schemas s ON s.oid = c.relnamespace
+		WHERE c.relkind IN ('v', 'm')
+		UNION ALL
+		SELECT 'function ' || s.nspname || '.' || p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ') '
+		       || md5(pg_get_functiondef(p.oid))
+		FROM pg_proc p JOIN schemas s ON s.oid = p.pronamespace
+		WHERE p.prokind IN ('f', 'p')
+		UNION ALL
+		SELECT 'trigger ' || s.nspname || '.' || c.relname || '.' || t.tgname || ' ' || pg_get_triggerdef(t.oid)
+		FROM pg_trigger t
+		JOIN pg_class c ON c.oid = t.tgrelid
+		JOIN schemas s ON s.oid = c.relnamespace
+		WHERE NOT t.tgisinternal
+		UNION ALL
+		SELECT 'type ' || s.nspname || '.' || t.typname || ' ' || t.typtype
+		       || COALESCE(' ' || (SELECT string_agg(e.enumlabel, ',' ORDER BY e.enumsortorder) FROM pg_enum e WHERE e.enumtypid = t.oid), '')
+		FROM pg_type t JOIN schemas s ON s.oid = t.typnamespace
+		WHERE t.typtype IN ('e', 'd') OR (t.typtype = 'c' AND (SELECT relkind FROM pg_class WHERE oid = t.typrelid) = 'c')
+		ORDER BY 1
+	`
+
+	if schemas == nil {
+		schemas = []string{}
+	}
+	rows, err := db.pool.Query(db.ctx, query, schemas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the schema: %w", err)
+	}
+	snapshot, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the schema: %w", err)
+	}
+	return snapshot, nil
+}
+
+// ExtensionVersions returns the installed and default versions of an extension and every
+// version the server offers, or no versions if the server lacks the extension
+func (db *DB) ExtensionVersions(name string) (string, string, []string, error) {
+	var installed, defaultVersion string
+	err := db.pool.QueryRow(db.ctx, `
+		SELECT COALESCE(installed_version, ''), COALESCE(default_version, '')
+		FROM pg_available_extensions
+		WHERE name = $1
+	`, name).Scan(&installed, &defaultVersion)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", "", nil, nil
+		}
+		return "", "", nil, fmt.Errorf("failed to look up extension %s: %w", name, err)
+	}
+
+	rows, err := db.pool.Query(db.ctx, "SELECT version FROM pg_available_extension_versions WHERE name = $1", name)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to query versions of extension %s: %w", name, err)
+	}
+	available, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read versions of extension %s: %w", name, err)
+	}
+
+	return installed, defaultVersion, available, nil
+}
+
+// CreateExtension installs an extension at the given version
+func (db *DB) CreateExtension(name, version string) error {
+	sql := fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s VERSION %s", pgx.Identifier{name}.Sanitize(), pgx.Identifier{version}.Sanitize())
+	if _, err := db.pool.Exec(db.ctx, sql); err != nil {
+		return fmt.Errorf("failed to create extension %s %s: %w", name, version, err)
+	}
+	return nil
+}
+
+// UpdateExtension updates an installed extension to the given version
+func (db *DB) UpdateExtension(name, version string) error {
+	sql := fmt.Sprintf("ALTER EXTENSION %s UPDATE TO %s", pgx.Identifier{name}.Sanitize(), pgx.Identifier{version}.Sanitize())
+	if _, err := db.pool.Exec(db.ctx, sql); err != nil {
+		return fmt.Errorf("failed to update extension %s to %s: %w", name, version, err)
+	}
+	return nil
+}
+
+// AddEnumValue adds a value to an enum type outside a transaction, so it is committed and
+// usable as soon as this returns
+func (db *DB) AddEnumValue(typeName, value string) error {
+	sql := fmt.Sprintf("ALTER TYPE %s ADD VALUE IF NOT EXISTS '%s'",
+		pgx.Identifier(strings.Split(typeName, ".")).Sanitize(), strings.ReplaceAll(value, "'", "''"))
+	if _, err := db.pool.Exec(db.ctx, sql); err != nil {
+		return fmt.Errorf("failed to add value %q to enum %s: %w", value, typeName, err)
+	}
+	return nil
+}
 
+// ExecuteStatement runs a single statement outside a transaction block
+func (db *DB) ExecuteStatement(sql string) error {
+	if _, err := db.pool.Exec(db.ctx, sql); err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
 	return nil
 }
 
+// LogicalReplication returns the names of the logical replication slots and publications
+func (db *DB) LogicalReplication() ([]string, []string, error) {
+	rows, err := db.pool.Query(db.ctx, "SELECT slot_name::text FROM pg_replication_slots WHERE slot_type = 'logical' ORDER BY 1")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query replication slots: %w", err)
+	}
+	slots, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read replication slots: %w", err)
+	}
+
+	rows, err = db.pool.Query(db.ctx, "SELECT pubname::text FROM pg_publication ORDER BY 1")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query publications: %w", err)
+	}
+	publications, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read publications: %w", err)
+	}
+
+	return slots, publications, nil
+}
+
+// TablesWithoutRLS returns user tables without row-level security enabled, as schema.table
+func (db *DB) TablesWithoutRLS() ([]string, error) {
+	query := `
+		SELECT n.nspname || '.' || c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p') AND NOT c.relrowsecurity AND NOT c.relispartition
+		  AND n.nspname NOT IN ('pg_catalog', 'information_schema', 'zdd_deployments')
+		  AND n.nspname NOT LIKE 'pg\_%'
+		ORDER BY 1
+	`
+
+	rows, err := db.pool.Query(db.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tables without row-level security: %w", err)
+	}
+	tables, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tables without row-level security: %w", err)
+	}
+	return tables, nil
+}
+
 // ExecuteSQLInTransaction executes SQL statements within a transaction
 func (db *DB) ExecuteSQLInTransaction(sqlStatements ...string) error {
 	tx, err := db.pool.Begin(db.ctx)
@@ -148,14 +1007,147 @@ func (db *DB) ExecuteSQLInTransaction(sqlStatements ...string) error {
 	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
 
 	for i, sql := range sqlStatements {
-		sql = strings.TrimSpace(sql)
-		if sql == "" {
-			continue
+		if err := db.execSQL(tx, sql); err != nil {
+			return fmt.Errorf("failed to execute SQL statement %d: %w", i+1, err)
 		}
+	}
 
-		_, err := tx.Exec(db.ctx, sql)
-		if err != nil {
-			return fmt.Errorf("failed to execute SQL statement %d: %w", i+1, err)
+	if err := tx.Commit(db.ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// retryableCodes are the SQLSTATEs of failures that may succeed when run again
+var retryableCodes = []string{
+	"40001", // serialization_failure
+	"40P01", // deadlock_detected
+}
+
+// Retryable reports whether err is a serialization failure or a deadlock
+func (db *DB) Retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && slices.Contains(retryableCodes, pgErr.Code)
+}
+
+// RevertDeployment runs the revert SQL of a deployment, one file's SQL after another, and deletes
+// its applied record in one transaction
+func (db *DB) RevertDeployment(deployment zdd.Deployment, sql ...string) error {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	for _, statement := range sql {
+		if err := db.execSQL(tx, statement); err != nil {
+			return fmt.Errorf("failed to execute revert SQL: %w", err)
+		}
+	}
+
+	query := `
+		DELETE FROM zdd_deployments.applied_deployments
+		WHERE module = $1 AND id = $2 AND status = 'applied'
+	`
+	result, err := tx.Exec(db.ctx, query, deployment.Module, deployment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete deployment record: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("deployment %s is not recorded as applied", deployment.Key())
+	}
+
+	if err := tx.Commit(db.ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// CreateRestorePoint marks a named point in the write-ahead log that point-in-time recovery can
+// stop at. It needs wal_level replica or higher and superuser or EXECUTE on
+// pg_create_restore_point.
+func (db *DB) CreateRestorePoint(name string) error {
+	if _, err := db.pool.Exec(db.ctx, "SELECT pg_create_restore_point($1)", name); err != nil {
+		return fmt.Errorf("failed to create restore point %s: %w", name, err)
+	}
+	return nil
+}
+
+// execSQL runs SQL that may hold several statements within tx, pipelining the statements when
+// batching is enabled
+func (db *DB) execSQL(tx pgx.Tx, sql string) error {
+	sql = strings.TrimSpace(sql)
+	if sql == "" {
+		return nil
+	}
+
+	if statements, ok := splitStatements(sql); db.batch && ok && len(statements) > 1 {
+		return execBatch(db.ctx, tx, statements)
+	}
+
+	_, err := tx.Exec(db.ctx, sql)
+	return err
+}
+
+// GetAppliedRepeatables returns the last application of every repeatable migration
+func (db *DB) GetAppliedRepeatables() ([]zdd.RepeatableRecord, error) {
+	query := `
+		SELECT name, module, checksum, applied_at, objects
+		FROM zdd_deployments.repeatable_migrations
+		ORDER BY module, name
+	`
+
+	rows, err := db.pool.Query(db.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query repeatable migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var records []zdd.RepeatableRecord
+	for rows.Next() {
+		var r zdd.RepeatableRecord
+		if err := rows.Scan(&r.Name, &r.Module, &r.Checksum, &r.AppliedAt, &r.Objects); err != nil {
+			return nil, fmt.Errorf("failed to scan repeatable migration: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating repeatable migrations: %w", err)
+	}
+
+	return records, nil
+}
+
+// ApplyRepeatables runs the SQL of repeatable migrations and records their checksums in one
+// transaction, so a failed application is retried by the next deploy
+func (db *DB) ApplyRepeatables(repeatables []zdd.Repeatable, sql string) error {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	if err := db.execSQL(tx, sql); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO zdd_deployments.repeatable_migrations (module, name, checksum, applied_at, run_by, objects)
+		VALUES ($1, $2, $3, NOW(), $4, $5)
+		ON CONFLICT (module, name) DO UPDATE
+		SET checksum = EXCLUDED.checksum, applied_at = EXCLUDED.applied_at, run_by = EXCLUDED.run_by,
+			objects = EXCLUDED.objects
+	`
+	for _, repeatable := range repeatables {
+		objects := make([]string, 0, len(repeatable.Objects))
+		for _, object := range repeatable.Objects {
+			objects = append(objects, object.String())
+		}
+		if _, err := tx.Exec(db.ctx, query, repeatable.Module, repeatable.Name, repeatable.Checksum, db.runBy, objects); err != nil {
+			return fmt.Errorf("failed to record repeatable migration %s: %w", repeatable.Key(), err)
 		}
 	}
 
@@ -165,3 +1157,149 @@ func (db *DB) ExecuteSQLInTransaction(sqlStatements ...string) error {
 
 	return nil
 }
+
+// SetRunID identifies this process by id in the deployments and task runs it records, instead
+// of zdd.RunID(). It must be called before the first deployment is started.
+func (db *DB) SetRunID(id string) {
+	db.runID = id
+}
+
+// SetBatchStatements makes ExecuteSQLInTransaction split SQL into statements and send them in a
+// single pipelined round trip, which is faster on high-latency connections. SQL that cannot be
+// split safely (transaction control, BEGIN ATOMIC bodies, COPY FROM STDIN, psql meta-commands)
+// is still sent whole. Pipelined statements use the extended protocol, so each must be a single
+// statement that PostgreSQL can run in a transaction block.
+func (db *DB) SetBatchStatements(enabled bool) {
+	db.batch = enabled
+}
+
+// execBatch runs the statements in a single pipeline within tx, reporting the statement that failed
+func execBatch(ctx context.Context, tx pgx.Tx, statements []sqlStatement) error {
+	batch := &pgconn.Batch{}
+	for _, statement := range statements {
+		batch.ExecParams(statement.SQL, nil, nil, nil, nil)
+	}
+
+	results, err := tx.Conn().PgConn().ExecBatch(ctx, batch).ReadAll()
+	for j, result := range results {
+		if result.Err != nil {
+			return fmt.Errorf("batched statement %d (line %d): %w", j+1, statements[j].Line, result.Err)
+		}
+	}
+	if err != nil {
+		if len(results) < len(statements) {
+			return fmt.Errorf("batched statement %d (line %d): %w", len(results)+1, statements[len(results)].Line, err)
+		}
+		return fmt.Errorf("failed to execute batch: %w", err)
+	}
+	return nil
+}
+
+// DependentViews returns the views and materialized views that depend on the named views or
+// materialized views, directly or through other views, schema-qualified
+func (db *DB) DependentViews(names []string) ([]string, error) {
+	query := `
+		WITH RECURSIVE objects AS (
+			SELECT to_regclass(name)::oid AS oid FROM unnest($1::text[]) AS name
+			WHERE to_regclass(name) IS NOT NULL
+		), dependents AS (
+			SELECT DISTINCT r.ev_class AS oid
+			FROM pg_depend d JOIN pg_rewrite r ON r.oid = d.objid
+			WHERE d.classid = 'pg_rewrite'::regclass AND d.refobjid IN (SELECT oid FROM objects)
+			  AND r.ev_class NOT IN (SELECT oid FROM objects)
+			UNION
+			SELECT r.ev_class
+			FROM dependents x
+			JOIN pg_depend d ON d.refobjid = x.oid AND d.classid = 'pg_rewrite'::regclass
+			JOIN pg_rewrite r ON r.oid = d.objid
+			WHERE r.ev_class <> x.oid
+		)
+		SELECT format('%I.%I', n.nspname, c.relname)
+		FROM dependents JOIN pg_class c ON c.oid = dependents.oid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('v', 'm')
+		ORDER BY 1
+	`
+
+	rows, err := db.pool.Query(db.ctx, query, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dependent views: %w", err)
+	}
+	defer rows.Close()
+
+	var views []string
+	for rows.Next() {
+		var view string
+		if err := rows.Scan(&view); err != nil {
+			return nil, fmt.Errorf("failed to scan dependent view: %w", err)
+		}
+		views = append(views, view)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dependent views: %w", err)
+	}
+
+	return views, nil
+}
+
+// DescribeObject returns a textual definition of a table, column or index, or "" if it does not exist.
+// Names may be schema-qualified; unqualified names are resolved against the current schema.
+func (db *DB) DescribeObject(kind, name string) (string, error) {
+	var query string
+	var args []any
+
+	parts := strings.Split(name, ".")
+	switch kind {
+	case "table":
+		schema, table := splitQualifiedName(parts)
+		query = `
+			SELECT COALESCE(string_agg(
+				column_name || ' ' || data_type || ' ' || is_nullable || ' ' || COALESCE(column_default, ''),
+				', ' ORDER BY ordinal_position), '')
+			FROM information_schema.columns
+			WHERE table_schema = COALESCE($1, current_schema()) AND table_name = $2
+		`
+		args = []any{schema, table}
+	case "column":
+		if len(parts) < 2 {
+			return "", fmt.Errorf("column name must be of the form table.column: %s", name)
+		}
+		schema, table := splitQualifiedName(parts[:len(parts)-1])
+		query = `
+			SELECT COALESCE((
+				SELECT data_type || ' ' || is_nullable || ' ' || COALESCE(column_default, '')
+				FROM information_schema.columns
+				WHERE table_schema = COALESCE($1, current_schema()) AND table_name = $2 AND column_name = $3
+			), '')
+		`
+		args = []any{schema, table, parts[len(parts)-1]}
+	case "index":
+		schema, index := splitQualifiedName(parts)
+		query = `
+			SELECT COALESCE((
+				SELECT indexdef FROM pg_indexes
+				WHERE schemaname = COALESCE($1, current_schema()) AND indexname = $2
+			), '')
+		`
+		args = []any{schema, index}
+	default:
+		return "", fmt.Errorf("unsupported object kind: %s", kind)
+	}
+
+	var definition string
+	if err := db.pool.QueryRow(db.ctx, query, args...).Scan(&definition); err != nil {
+		return "", fmt.Errorf("failed to describe %s %s: %w", kind, name, err)
+	}
+
+	return definition, nil
+}
+
+// splitQualifiedName splits [schema.]name parts, returning a nil schema when unqualified
+func splitQualifiedName(parts []string) (*string, string) {
+	if len(parts) == 1 {
+		return nil, parts[0]
+	}
+	schema := strings.Join(parts[:len(parts)-1], ".")
+	return &schema, parts[len(parts)-1]
+}
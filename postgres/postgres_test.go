@@ -2,8 +2,16 @@ package postgres
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mantty/zdd"
 	"github.com/testcontainers/testcontainers-go"
 	pgTest "github.com/testcontainers/testcontainers-go/modules/postgres"
 )
@@ -50,4 +58,563 @@ func TestNewDBInitializesMigrationSchema(t *testing.T) {
 	if err := db.ExecuteSQLInTransaction("SELECT COUNT(*) FROM zdd_deployments.applied_deployments"); err != nil {
 		t.Fatalf("expected applied_migrations table to exist: %v", err)
 	}
+
+	version, err := db.MetadataVersion()
+	if err != nil {
+		t.Fatalf("failed to read metadata version: %v", err)
+	}
+	if version != MetadataSchemaVersion() {
+		t.Fatalf("expected metadata version %d, got %d", MetadataSchemaVersion(), version)
+	}
+	if err := db.InitDeploymentSchema(); err != nil {
+		t.Fatalf("expected a second initialization to be a no-op: %v", err)
+	}
+
+	// A schema migrated by a newer zdd is refused
+	newer := MetadataSchemaVersion() + 1
+	if _, err := db.pool.Exec(ctx, "INSERT INTO zdd_deployments.metadata_versions (version, name) VALUES ($1, 'future')", newer); err != nil {
+		t.Fatalf("failed to record newer metadata version: %v", err)
+	}
+	if err := db.InitDeploymentSchema(); err == nil || !strings.Contains(err.Error(), "upgrade zdd") {
+		t.Fatalf("expected an error for a newer metadata schema, got %v", err)
+	}
+}
+
+func TestUpgradeLegacyHistory(t *testing.T) {
+	ctx := context.Background()
+	container, err := pgTest.Run(ctx,
+		"postgres:17-alpine",
+		pgTest.WithDatabase("test"),
+		pgTest.WithUsername("user"),
+		pgTest.WithPassword("password"),
+		pgTest.BasicWaitStrategies(),
+	)
+	if err != nil {
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		testcontainers.CleanupContainer(t, container)
+	})
+
+	dbURL, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	db, err := NewDB(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.ExecuteSQLInTransaction(`
+		CREATE SCHEMA zdd_migrations;
+		CREATE TABLE zdd_migrations.applied_migrations (id VARCHAR(255) PRIMARY KEY, name VARCHAR(500), applied_at TIMESTAMP WITH TIME ZONE);
+		INSERT INTO zdd_migrations.applied_migrations VALUES ('000001', 'init', '2024-01-02'), ('000002', 'add_users', NULL);
+	`); err != nil {
+		t.Fatalf("failed to create legacy history: %v", err)
+	}
+
+	legacy, err := db.HasLegacyHistory()
+	if err != nil || !legacy {
+		t.Fatalf("expected legacy history to be detected, got %v, %v", legacy, err)
+	}
+	upgraded, err := db.UpgradeLegacyHistory()
+	if err != nil {
+		t.Fatalf("failed to upgrade legacy history: %v", err)
+	}
+	if upgraded != 2 {
+		t.Fatalf("expected 2 upgraded deployments, got %d", upgraded)
+	}
+
+	records, err := db.GetAppliedDeployments()
+	if err != nil {
+		t.Fatalf("failed to get applied deployments: %v", err)
+	}
+	if len(records) != 2 || records[0].ID != "000001" || records[0].Name != "init" || records[1].Name != "000002" {
+		t.Fatalf("unexpected applied deployments: %+v", records)
+	}
+	if legacy, err := db.HasLegacyHistory(); err != nil || legacy {
+		t.Fatalf("expected legacy history to be upgraded, got %v, %v", legacy, err)
+	}
+}
+
+func TestCheckResetSafety(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		protected []string
+		wantErr   bool
+	}{
+		{"local database", "postgres://user:pw@localhost:5432/app_dev", nil, false},
+		{"loopback address", "postgres://user:pw@127.0.0.1/app_dev", nil, false},
+		{"remote host", "postgres://user:pw@db.example.com/app", nil, true},
+		{"reserved database", "postgres://user:pw@localhost/postgres", nil, true},
+		{"protected pattern", "postgres://user:pw@localhost/app_prod", []string{"prod"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckResetSafety(tt.url, tt.protected)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckResetSafety() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		sql    string
+		want   []string
+		wantOK bool
+	}{
+		{"statements", "CREATE TABLE a (id int);\nCREATE TABLE b (id int);", []string{"CREATE TABLE a (id int)", "CREATE TABLE b (id int)"}, true},
+		{"no trailing semicolon", "SELECT 1;\nSELECT 2", []string{"SELECT 1", "SELECT 2"}, true},
+		{"comments only", "SELECT 1;\n-- done;\n/* really; */", []string{"SELECT 1"}, true},
+		{"quoted semicolons", "INSERT INTO a VALUES ('x;''y', E'\\';', \"b;\");", []string{"INSERT INTO a VALUES ('x;''y', E'\\';', \"b;\")"}, true},
+		{"dollar quotes", "CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;\nDO $$ BEGIN PERFORM 1; END $$;", []string{"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql", "DO $$ BEGIN PERFORM 1; END $$"}, true},
+		{"nested comment", "/* a /* b; */ c; */ SELECT 1;", []string{"/* a /* b; */ c; */ SELECT 1"}, true},
+		{"transaction control", "BEGIN;\nSELECT 1;\nCOMMIT;", nil, false},
+		{"begin atomic", "CREATE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; END;", nil, false},
+		{"copy from stdin", "COPY a FROM STDIN;\n1\n\\.\n", nil, false},
+		{"meta-command", "\\set ON_ERROR_STOP on\nSELECT 1;", nil, false},
+		{"unterminated quote", "SELECT 'oops;", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statements, ok := splitStatements(tt.sql)
+			if ok != tt.wantOK {
+				t.Fatalf("splitStatements() ok = %v, want %v", ok, tt.wantOK)
+			}
+			var got []string
+			for _, statement := range statements {
+				got = append(got, statement.SQL)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("splitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateDatabaseClones(t *testing.T) {
+	ctx := context.Background()
+	container, err := pgTest.Run(ctx,
+		"postgres:17-alpine",
+		pgTest.WithDatabase("test"),
+		pgTest.WithUsername("user"),
+		pgTest.WithPassword("password"),
+		pgTest.BasicWaitStrategies(),
+	)
+	if err != nil {
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		testcontainers.CleanupContainer(t, container)
+	})
+
+	dbURL, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	deploymentsDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(deploymentsDir, "000001_create_items"), 0755); err != nil {
+		t.Fatalf("failed to create deployment: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(deploymentsDir, "000001_create_items", "migrate.sql"), []byte("CREATE TABLE items (id SERIAL PRIMARY KEY);"), 0644); err != nil {
+		t.Fatalf("failed to write migrate.sql: %v", err)
+	}
+
+	template, err := NewTemplateDatabase(ctx, dbURL, "app_template", deploymentsDir)
+	if err != nil {
+		t.Fatalf("failed to create template database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := template.Close(ctx); err != nil {
+			t.Errorf("failed to close template database: %v", err)
+		}
+	})
+
+	var counts []int
+	for range 2 {
+		cloneURL, err := template.CloneForTest(ctx)
+		if err != nil {
+			t.Fatalf("failed to clone template: %v", err)
+		}
+
+		db, err := NewDB(ctx, cloneURL)
+		if err != nil {
+			t.Fatalf("failed to connect to clone: %v", err)
+		}
+		if err := db.ExecuteSQLInTransaction("INSERT INTO items DEFAULT VALUES"); err != nil {
+			t.Fatalf("expected clone to have the deployed schema: %v", err)
+		}
+
+		var count int
+		if err := db.pool.QueryRow(ctx, "SELECT count(*) FROM items").Scan(&count); err != nil {
+			t.Fatalf("failed to count items: %v", err)
+		}
+		counts = append(counts, count)
+		db.Close()
+	}
+
+	if counts[0] != 1 || counts[1] != 1 {
+		t.Fatalf("expected each clone to start empty, got row counts %v", counts)
+	}
+}
+
+func TestDatabasePoolRecyclesDatabases(t *testing.T) {
+	ctx := context.Background()
+	container, err := pgTest.Run(ctx,
+		"postgres:17-alpine",
+		pgTest.WithDatabase("test"),
+		pgTest.WithUsername("user"),
+		pgTest.WithPassword("password"),
+		pgTest.BasicWaitStrategies(),
+	)
+	if err != nil {
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		testcontainers.CleanupContainer(t, container)
+	})
+
+	dbURL, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	deploymentsDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(deploymentsDir, "000001_create_items"), 0755); err != nil {
+		t.Fatalf("failed to create deployment: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(deploymentsDir, "000001_create_items", "migrate.sql"), []byte("CREATE TABLE items (id SERIAL PRIMARY KEY);"), 0644); err != nil {
+		t.Fatalf("failed to write migrate.sql: %v", err)
+	}
+
+	template, err := NewTemplateDatabase(ctx, dbURL, "pool_template", deploymentsDir)
+	if err != nil {
+		t.Fatalf("failed to create template database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := template.Close(ctx); err != nil {
+			t.Errorf("failed to close template database: %v", err)
+		}
+	})
+
+	pool, err := NewDatabasePool(ctx, template, 2)
+	if err != nil {
+		t.Fatalf("failed to create database pool: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Close(ctx); err != nil {
+			t.Errorf("failed to close database pool: %v", err)
+		}
+	})
+
+	// More tests than databases, so databases are recycled between them
+	t.Run("group", func(t *testing.T) {
+		for i := range 6 {
+			t.Run(fmt.Sprintf("test%d", i), func(t *testing.T) {
+				t.Parallel()
+				db, err := NewDB(ctx, pool.ForTest(t))
+				if err != nil {
+					t.Fatalf("failed to connect to pool database: %v", err)
+				}
+				defer db.Close()
+
+				if err := db.ExecuteSQLInTransaction("INSERT INTO items DEFAULT VALUES"); err != nil {
+					t.Fatalf("expected pool database to have the deployed schema: %v", err)
+				}
+				var count int
+				if err := db.pool.QueryRow(ctx, "SELECT count(*) FROM items").Scan(&count); err != nil {
+					t.Fatalf("failed to count items: %v", err)
+				}
+				if count != 1 {
+					t.Errorf("expected a fresh database with 1 item, got %d", count)
+				}
+			})
+		}
+	})
+}
+
+func TestIndexUsage(t *testing.T) {
+	ctx := context.Background()
+	container, err := pgTest.Run(ctx,
+		"postgres:17-alpine",
+		pgTest.WithDatabase("test"),
+		pgTest.WithUsername("user"),
+		pgTest.WithPassword("password"),
+		pgTest.BasicWaitStrategies(),
+	)
+	if err != nil {
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		testcontainers.CleanupContainer(t, container)
+	})
+
+	dbURL, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	db, err := NewDB(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.ExecuteSQLInTransaction(`
+		CREATE TABLE invoices (id INT PRIMARY KEY, total NUMERIC, ref TEXT UNIQUE);
+		CREATE INDEX invoices_total ON invoices (total);
+	`); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	usage, _, err := db.IndexUsage()
+	if err != nil {
+		t.Fatalf("failed to read index usage: %v", err)
+	}
+	enforces := make(map[string]bool)
+	for _, index := range usage {
+		if index.Table == "invoices" {
+			enforces[index.Name] = index.Enforces
+		}
+		if index.Name == "invoices_total" && (index.Schema != "public" || index.Scans != 0 || !strings.Contains(index.Definition, "(total)")) {
+			t.Errorf("unexpected usage of invoices_total: %+v", index)
+		}
+	}
+	if len(enforces) != 3 || !enforces["invoices_pkey"] || !enforces["invoices_ref_key"] || enforces["invoices_total"] {
+		t.Errorf("expected the primary key and unique indexes to enforce constraints, got %v", enforces)
+	}
+}
+
+func TestSchemaHash(t *testing.T) {
+	ctx := context.Background()
+	container, err := pgTest.Run(ctx,
+		"postgres:17-alpine",
+		pgTest.WithDatabase("test"),
+		pgTest.WithUsername("user"),
+		pgTest.WithPassword("password"),
+		pgTest.BasicWaitStrategies(),
+	)
+	if err != nil {
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		testcontainers.CleanupContainer(t, container)
+	})
+
+	dbURL, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	db, err := NewDB(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	replica, err := OpenReplica(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("failed to open replica: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = replica.Close()
+	})
+
+	before, err := db.SchemaHash()
+	if err != nil {
+		t.Fatalf("failed to hash schema: %v", err)
+	}
+	// zdd's own metadata is left out of the hash
+	if err := db.ExecuteSQLInTransaction("CREATE TABLE zdd_deployments.scratch (id INT)"); err != nil {
+		t.Fatalf("failed to create metadata table: %v", err)
+	}
+	if hash, err := replica.SchemaHash(); err != nil || hash != before {
+		t.Errorf("expected zdd's schemas not to change the hash, got %s, %v", hash, err)
+	}
+
+	if err := db.ExecuteSQLInTransaction("CREATE TABLE orders (id INT PRIMARY KEY, total NUMERIC)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	after, err := replica.SchemaHash()
+	if err != nil || after == before {
+		t.Errorf("expected a new table to change the hash, got %s, %v", after, err)
+	}
+	if hash, err := db.SchemaHash(); err != nil || hash != after {
+		t.Errorf("expected the same schema to hash the same through a DB and a Replica, got %s and %s, %v", hash, after, err)
+	}
+}
+
+func TestExportSchema(t *testing.T) {
+	ctx := context.Background()
+	container, err := pgTest.Run(ctx,
+		"postgres:17-alpine",
+		pgTest.WithDatabase("test"),
+		pgTest.WithUsername("user"),
+		pgTest.WithPassword("password"),
+		pgTest.BasicWaitStrategies(),
+	)
+	if err != nil {
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		testcontainers.CleanupContainer(t, container)
+	})
+
+	dbURL, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	db, err := NewDB(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	err = db.ExecuteSQLInTransaction(`
+		CREATE TYPE order_status AS ENUM ('new', 'paid');
+		CREATE TABLE customers (id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY, email TEXT NOT NULL UNIQUE);
+		CREATE TABLE orders (
+			id SERIAL PRIMARY KEY,
+			customer_id BIGINT REFERENCES customers (id),
+			status order_status NOT NULL DEFAULT 'new',
+			total NUMERIC(10, 2),
+			total_cents BIGINT GENERATED ALWAYS AS ((total * 100)::bigint) STORED
+		);
+		CREATE INDEX orders_status ON orders (status);
+		CREATE VIEW paid_orders AS SELECT id FROM orders WHERE status = 'paid';
+	`)
+	if err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	schema, err := db.ExportSchema()
+	if err != nil {
+		t.Fatalf("failed to export schema: %v", err)
+	}
+	if len(schema.Enums) != 1 || !reflect.DeepEqual(schema.Enums[0].Values, []string{"new", "paid"}) {
+		t.Errorf("expected the order_status enum, got %+v", schema.Enums)
+	}
+	if len(schema.Views) != 1 || schema.Views[0].Name != "paid_orders" {
+		t.Errorf("expected the paid_orders view, got %+v", schema.Views)
+	}
+	var tables []string
+	for _, table := range schema.Tables {
+		tables = append(tables, table.Name)
+	}
+	if !reflect.DeepEqual(tables, []string{"customers", "orders"}) {
+		t.Fatalf("expected tables customers and orders without zdd's metadata, got %v", tables)
+	}
+
+	customers, orders := schema.Tables[0], schema.Tables[1]
+	if customers.Columns[0].Identity != "ALWAYS" || customers.Columns[0].Default != "" {
+		t.Errorf("expected customers.id to be an identity column, got %+v", customers.Columns[0])
+	}
+	if generated := orders.Columns[4]; generated.Generated == "" || generated.Default != "" {
+		t.Errorf("expected orders.total_cents to be generated, got %+v", generated)
+	}
+	var kinds []string
+	for _, constraint := range orders.Constraints {
+		kinds = append(kinds, constraint.Kind+" "+strings.Join(constraint.Columns, ","))
+	}
+	if !reflect.DeepEqual(kinds, []string{"primary_key id", "foreign_key customer_id"}) {
+		t.Errorf("expected the primary and foreign keys of orders, got %v", kinds)
+	}
+	if len(orders.Indexes) != 1 || !strings.Contains(orders.Indexes[0], "orders_status") {
+		t.Errorf("expected only the index backing no constraint, got %v", orders.Indexes)
+	}
+
+	// The rendered schema.sql recreates the schema
+	if _, err := db.pool.Exec(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatalf("failed to reset schema: %v", err)
+	}
+	if err := db.ExecuteSQLInTransaction(schema.SQL()); err != nil {
+		t.Fatalf("failed to apply exported SQL: %v\n%s", err, schema.SQL())
+	}
+	again, err := db.ExportSchema()
+	if err != nil {
+		t.Fatalf("failed to export schema: %v", err)
+	}
+	if !reflect.DeepEqual(again, schema) {
+		t.Errorf("expected the exported SQL to recreate the schema, got %+v", again)
+	}
+}
+
+func TestDeployLock(t *testing.T) {
+	ctx := context.Background()
+	container, err := pgTest.Run(ctx,
+		"postgres:17-alpine",
+		pgTest.WithDatabase("test"),
+		pgTest.WithUsername("user"),
+		pgTest.WithPassword("password"),
+		pgTest.BasicWaitStrategies(),
+	)
+	if err != nil {
+		t.Fatalf("failed to start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		testcontainers.CleanupContainer(t, container)
+	})
+
+	dbURL, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	first, err := NewDB(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = first.Close()
+	})
+	second, err := NewDB(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = second.Close()
+	})
+
+	if err := first.LockDeploys(0); err != nil {
+		t.Fatalf("failed to take deploy lock: %v", err)
+	}
+	if err := second.LockDeploys(0); !errors.Is(err, zdd.ErrDeployInProgress) {
+		t.Fatalf("expected another deployment to be in progress, got %v", err)
+	}
+
+	started := time.Now()
+	if err := second.LockDeploys(1500 * time.Millisecond); !errors.Is(err, zdd.ErrDeployInProgress) {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+	if waited := time.Since(started); waited < time.Second {
+		t.Errorf("expected to wait for the timeout, waited %s", waited)
+	}
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		first.UnlockDeploys()
+	}()
+	if err := second.LockDeploys(10 * time.Second); err != nil {
+		t.Fatalf("expected the lock once the first deploy released it, got %v", err)
+	}
+	if err := second.UnlockDeploys(); err != nil {
+		t.Fatalf("failed to release deploy lock: %v", err)
+	}
 }
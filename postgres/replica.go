@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// schemaHashQuery hashes the definitions of columns, indexes, constraints, views and enum
+// values outside the system schemas and zdd's own
+const schemaHashQuery = `
+	WITH items AS (
+		SELECT format('column %I.%I.%I %s %s %s', table_schema, table_name, column_name, data_type, is_nullable, column_default) AS item
+		FROM information_schema.columns
+		WHERE table_schema <> 'information_schema' AND table_schema NOT LIKE 'pg\_%' AND table_schema NOT LIKE 'zdd\_%'
+		UNION ALL
+		SELECT format('index %s', indexdef)
+		FROM pg_indexes
+		WHERE schemaname <> 'information_schema' AND schemaname NOT LIKE 'pg\_%' AND schemaname NOT LIKE 'zdd\_%'
+		UNION ALL
+		SELECT format('constraint %I.%I %s', n.nspname, c.conname, pg_get_constraintdef(c.oid))
+		FROM pg_constraint c JOIN pg_namespace n ON n.oid = c.connamespace
+		WHERE n.nspname <> 'information_schema' AND n.nspname NOT LIKE 'pg\_%' AND n.nspname NOT LIKE 'zdd\_%'
+		UNION ALL
+		SELECT format('view %I.%I %s', schemaname, viewname, definition)
+		FROM pg_views
+		WHERE schemaname <> 'information_schema' AND schemaname NOT LIKE 'pg\_%' AND schemaname NOT LIKE 'zdd\_%'
+		UNION ALL
+		SELECT format('enum %I.%I %s %s', n.nspname, t.typname, e.enumsortorder, e.enumlabel)
+		FROM pg_enum e JOIN pg_type t ON t.oid = e.enumtypid JOIN pg_namespace n ON n.oid = t.typnamespace
+		WHERE n.nspname NOT LIKE 'pg\_%' AND n.nspname NOT LIKE 'zdd\_%'
+	)
+	SELECT md5(COALESCE(string_agg(item, E'\n' ORDER BY item), '')) FROM items
+`
+
+// Replica is a read-only connection to a replica of the database, which, unlike a DB, never
+// writes zdd's metadata schema
+type Replica struct {
+	pool *pgxpool.Pool
+	ctx  context.Context
+}
+
+// OpenReplica connects to the replica at databaseURL
+func OpenReplica(ctx context.Context, databaseURL string, opts ...Option) (*Replica, error) {
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database URL: %w", err)
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	config.MaxConns = 1
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return &Replica{pool: pool, ctx: ctx}, nil
+}
+
+// Close closes the connection
+func (r *Replica) Close() error {
+	r.pool.Close()
+	return nil
+}
+
+// SchemaHash returns a hash of the replica's schema, equal to the primary's once it has replayed
+// the primary's DDL
+func (r *Replica) SchemaHash() (string, error) {
+	return schemaHash(r.ctx, r.pool)
+}
+
+// SchemaHash returns a hash of the columns, indexes, constraints, views and enum values of every
+// schema but the system's and zdd's own
+func (db *DB) SchemaHash() (string, error) {
+	return schemaHash(db.ctx, db.pool)
+}
+
+// schemaHash runs schemaHashQuery on pool
+func schemaHash(ctx context.Context, pool *pgxpool.Pool) (string, error) {
+	var hash string
+	if err := pool.QueryRow(ctx, schemaHashQuery).Scan(&hash); err != nil {
+		return "", fmt.Errorf("failed to hash schema: %w", err)
+	}
+	return hash, nil
+}
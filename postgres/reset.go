@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// maintenanceDatabase is the database we connect to while the target database is dropped and recreated
+const maintenanceDatabase = "postgres"
+
+// reservedDatabases can never be reset, regardless of any other setting
+var reservedDatabases = map[string]bool{
+	"postgres":  true,
+	"template0": true,
+	"template1": true,
+}
+
+// localHosts are the hosts considered to be a development machine
+var localHosts = map[string]bool{
+	"localhost": true,
+	"127.0.0.1": true,
+	"::1":       true,
+}
+
+// CheckResetSafety returns an error if the database described by databaseURL must not be reset.
+// A database is only eligible when it lives on a local host (or unix socket), is not a reserved
+// database, and the URL does not contain any of the protected patterns.
+func CheckResetSafety(databaseURL string, protected []string) error {
+	config, err := pgx.ParseConfig(databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse database URL: %w", err)
+	}
+
+	for _, pattern := range protected {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" && strings.Contains(databaseURL, pattern) {
+			return fmt.Errorf("refusing to reset database: URL matches protected pattern %q", pattern)
+		}
+	}
+
+	if config.Database == "" || reservedDatabases[config.Database] {
+		return fmt.Errorf("refusing to reset reserved database %q", config.Database)
+	}
+
+	if !strings.HasPrefix(config.Host, "/") && !localHosts[config.Host] {
+		return fmt.Errorf("refusing to reset database on non-local host %q", config.Host)
+	}
+
+	return nil
+}
+
+// ResetDatabase drops and recreates the database described by databaseURL.
+// CheckResetSafety is always run first and any violation aborts the reset.
+func ResetDatabase(ctx context.Context, databaseURL string, protected []string) error {
+	if err := CheckResetSafety(databaseURL, protected); err != nil {
+		return err
+	}
+
+	config, err := pgx.ParseConfig(databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse database URL: %w", err)
+	}
+
+	target := config.Database
+	config.Database = maintenanceDatabase
+
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to maintenance database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	ident := pgx.Identifier{target}.Sanitize()
+
+	if _, err := conn.Exec(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", ident)); err != nil {
+		return fmt.Errorf("failed to drop database %s: %w", target, err)
+	}
+
+	if _, err := conn.Exec(ctx, fmt.Sprintf("CREATE DATABASE %s", ident)); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", target, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,304 @@
+package postgres
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mantty/zdd"
+)
+
+const (
+	// runLockNamespace is the first key of the advisory locks that deploys hold for their run;
+	// the second is hashtext(run_id)
+	runLockNamespace int32 = 0x7a646400
+	// deployLockNamespace is the first key of the advisory lock a deploy holds while it applies
+	// deployments; the second is 0
+	deployLockNamespace int32 = 0x7a646402
+	// deployLockPollInterval is how often LockDeploys retries the deploy lock while waiting
+	deployLockPollInterval = time.Second
+)
+
+// Heartbeat creates or refreshes the heartbeat row of this process. The first heartbeat also
+// takes the run's advisory lock on a dedicated session, which the server releases if the process
+// dies, so other processes can tell an abandoned run from a hung one.
+func (db *DB) Heartbeat(task string) error {
+	if err := db.acquireRunLock(); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO zdd_deployments.heartbeats (run_id, pid, host, run_by, started_at, current_task, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), $5, NOW())
+		ON CONFLICT (run_id) DO UPDATE SET current_task = EXCLUDED.current_task, updated_at = NOW()
+	`
+
+	host, _ := os.Hostname()
+	if _, err := db.pool.Exec(db.ctx, query, db.runID, os.Getpid(), host, db.runBy, task); err != nil {
+		return fmt.Errorf("failed to record heartbeat: %w", err)
+	}
+	return nil
+}
+
+// EndHeartbeat removes the heartbeat row of this process and releases the run's lock
+func (db *DB) EndHeartbeat() error {
+	defer db.releaseRunLock()
+
+	if _, err := db.pool.Exec(db.ctx, "DELETE FROM zdd_deployments.heartbeats WHERE run_id = $1", db.runID); err != nil {
+		return fmt.Errorf("failed to remove heartbeat: %w", err)
+	}
+	return nil
+}
+
+// GetDeployRuns returns the deploys with a heartbeat row, oldest first
+func (db *DB) GetDeployRuns() ([]zdd.DeployRun, error) {
+	query := `
+		SELECT run_id, pid, host, run_by, started_at, COALESCE(current_task, ''), updated_at
+		FROM zdd_deployments.heartbeats
+		ORDER BY started_at
+	`
+
+	rows, err := db.pool.Query(db.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query heartbeats: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []zdd.DeployRun
+	for rows.Next() {
+		var r zdd.DeployRun
+		if err := rows.Scan(&r.RunID, &r.PID, &r.Host, &r.RunBy, &r.StartedAt, &r.CurrentTask, &r.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan heartbeat: %w", err)
+		}
+		runs = append(runs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating heartbeats: %w", err)
+	}
+
+	return runs, nil
+}
+
+// ClearStaleRuns removes the heartbeats of runs that have gone staleAfter without one, and of
+// running deployments started staleAfter ago by runs without a heartbeat, marking those
+// deployments failed so the next deploy resumes them. Runs whose session still holds the run's
+// lock, such as a hung process, are left alone and returned as held.
+func (db *DB) ClearStaleRuns(staleAfter time.Duration) (cleared, held []zdd.DeployRun, err error) {
+	query := `
+		SELECT run_id, pid, host, run_by, started_at, COALESCE(current_task, ''), updated_at
+		FROM zdd_deployments.heartbeats
+		WHERE updated_at < NOW() - $1 * INTERVAL '1 second'
+		UNION ALL
+		SELECT run_id, 0, '', COALESCE(MAX(run_by), ''), MIN(applied_at), '', MIN(applied_at)
+		FROM zdd_deployments.applied_deployments a
+		WHERE status = 'running' AND applied_at < NOW() - $1 * INTERVAL '1 second'
+		  AND NOT EXISTS (SELECT 1 FROM zdd_deployments.heartbeats h WHERE h.run_id = a.run_id)
+		GROUP BY run_id
+		ORDER BY 5
+	`
+
+	rows, err := db.pool.Query(db.ctx, query, staleAfter.Seconds())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query stale runs: %w", err)
+	}
+	var stale []zdd.DeployRun
+	for rows.Next() {
+		var r zdd.DeployRun
+		if err := rows.Scan(&r.RunID, &r.PID, &r.Host, &r.RunBy, &r.StartedAt, &r.CurrentTask, &r.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan stale run: %w", err)
+		}
+		stale = append(stale, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating stale runs: %w", err)
+	}
+
+	for _, run := range stale {
+		locked, err := db.runLockHeld(run.RunID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if locked {
+			held = append(held, run)
+			continue
+		}
+		if err := db.clearRun(run.RunID); err != nil {
+			return nil, nil, err
+		}
+		cleared = append(cleared, run)
+	}
+
+	return cleared, held, nil
+}
+
+// clearRun removes the heartbeat of an abandoned run and marks its running deployments failed
+func (db *DB) clearRun(runID string) error {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	query := `
+		UPDATE zdd_deployments.applied_deployments SET status = 'failed', error = $2
+		WHERE status = 'running' AND run_id = $1
+	`
+	if _, err := tx.Exec(db.ctx, query, runID, fmt.Sprintf("abandoned by run %s; cleared by %s", runID, db.runBy)); err != nil {
+		return fmt.Errorf("failed to mark deployments of run %s as failed: %w", runID, err)
+	}
+	if _, err := tx.Exec(db.ctx, "DELETE FROM zdd_deployments.heartbeats WHERE run_id = $1", runID); err != nil {
+		return fmt.Errorf("failed to remove heartbeat of run %s: %w", runID, err)
+	}
+
+	if err := tx.Commit(db.ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// abandonedRun returns the run a deployment is running in, and whether that run is abandoned:
+// it has gone staleAfter without a heartbeat and no session holds its lock
+func (db *DB) abandonedRun(deployment zdd.Deployment, staleAfter time.Duration) (string, bool, error) {
+	query := `
+		SELECT COALESCE(a.run_id, ''), NOT EXISTS (
+			SELECT 1 FROM zdd_deployments.heartbeats h
+			WHERE h.run_id = a.run_id AND h.updated_at > NOW() - $3 * INTERVAL '1 second'
+		)
+		FROM zdd_deployments.applied_deployments a
+		WHERE a.module = $1 AND a.id = $2 AND a.status = 'running'
+	`
+
+	var runID string
+	var stale bool
+	if err := db.pool.QueryRow(db.ctx, query, deployment.Module, deployment.ID, staleAfter.Seconds()).Scan(&runID, &stale); err != nil {
+		if err == pgx.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to look up run of deployment %s: %w", deployment.Key(), err)
+	}
+	if !stale {
+		return runID, false, nil
+	}
+
+	locked, err := db.runLockHeld(runID)
+	if err != nil {
+		return "", false, err
+	}
+	return runID, !locked, nil
+}
+
+// runLockHeld reports whether any session holds the advisory lock of the run
+func (db *DB) runLockHeld(runID string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM pg_locks
+			WHERE locktype = 'advisory' AND granted AND objsubid = 2
+			  AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+			  AND classid = $1::int4::oid AND objid = hashtext($2)::oid
+		)
+	`
+
+	var held bool
+	if err := db.pool.QueryRow(db.ctx, query, runLockNamespace, runID).Scan(&held); err != nil {
+		return false, fmt.Errorf("failed to check lock of run %s: %w", runID, err)
+	}
+	return held, nil
+}
+
+// acquireRunLock takes the advisory lock of this process's run on a dedicated session, unless it
+// already holds it
+func (db *DB) acquireRunLock() error {
+	db.lockMu.Lock()
+	defer db.lockMu.Unlock()
+	if db.lockConn != nil {
+		return nil
+	}
+
+	conn, err := db.pool.Acquire(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection for run lock: %w", err)
+	}
+	if _, err := conn.Exec(db.ctx, "SELECT pg_advisory_lock($1, hashtext($2))", runLockNamespace, db.runID); err != nil {
+		conn.Release()
+		return fmt.Errorf("failed to take run lock: %w", err)
+	}
+	db.lockConn = conn
+	return nil
+}
+
+// releaseRunLock releases the run's advisory lock and its session, if held
+func (db *DB) releaseRunLock() {
+	db.lockMu.Lock()
+	defer db.lockMu.Unlock()
+	if db.lockConn == nil {
+		return
+	}
+
+	// Closing the session would release the lock too, so a failed unlock only costs the connection
+	if _, err := db.lockConn.Exec(db.ctx, "SELECT pg_advisory_unlock($1, hashtext($2))", runLockNamespace, db.runID); err != nil {
+		db.lockConn.Conn().Close(db.ctx)
+	}
+	db.lockConn.Release()
+	db.lockConn = nil
+}
+
+// LockDeploys takes the deploy lock on a dedicated session, which the server releases if the
+// process dies. It retries until timeout passes, then returns zdd.ErrDeployInProgress.
+func (db *DB) LockDeploys(timeout time.Duration) error {
+	db.lockMu.Lock()
+	defer db.lockMu.Unlock()
+	if db.deployLockConn != nil {
+		return nil
+	}
+
+	conn, err := db.pool.Acquire(db.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection for deploy lock: %w", err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		if err := conn.QueryRow(db.ctx, "SELECT pg_try_advisory_lock($1, 0)", deployLockNamespace).Scan(&locked); err != nil {
+			conn.Release()
+			return fmt.Errorf("failed to take deploy lock: %w", err)
+		}
+		if locked {
+			db.deployLockConn = conn
+			return nil
+		}
+
+		wait := min(deployLockPollInterval, time.Until(deadline))
+		if wait <= 0 {
+			conn.Release()
+			return zdd.ErrDeployInProgress
+		}
+		select {
+		case <-db.ctx.Done():
+			conn.Release()
+			return fmt.Errorf("interrupted waiting for deploy lock: %w", db.ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// UnlockDeploys releases the deploy lock and its session, if held
+func (db *DB) UnlockDeploys() error {
+	db.lockMu.Lock()
+	defer db.lockMu.Unlock()
+	if db.deployLockConn == nil {
+		return nil
+	}
+
+	var err error
+	if _, err = db.deployLockConn.Exec(db.ctx, "SELECT pg_advisory_unlock($1, 0)", deployLockNamespace); err != nil {
+		// Closing the session releases the lock
+		db.deployLockConn.Conn().Close(db.ctx)
+		err = fmt.Errorf("failed to release deploy lock: %w", err)
+	}
+	db.deployLockConn.Release()
+	db.deployLockConn = nil
+	return err
+}
@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ScratchDatabase is an empty database created on the server of a deployment database, which
+// deployments are shadow-applied to, e.g. to verify their revert SQL. Close drops it.
+type ScratchDatabase struct {
+	*DB
+	name  string
+	admin *pgxpool.Pool // Connection to the maintenance database, which creates and drops the scratch database
+}
+
+// NewScratchDatabase creates the scratch database name on the server of databaseURL, replacing
+// any left over from an earlier run, and connects to it. It refuses to replace the database
+// databaseURL names.
+func NewScratchDatabase(ctx context.Context, databaseURL, name string, opts ...Option) (*ScratchDatabase, error) {
+	adminURL, err := withDatabase(databaseURL, maintenanceDatabase)
+	if err != nil {
+		return nil, err
+	}
+	scratchURL, err := withDatabase(databaseURL, name)
+	if err != nil {
+		return nil, err
+	}
+	if u, _ := url.Parse(databaseURL); strings.TrimPrefix(u.Path, "/") == name {
+		return nil, fmt.Errorf("the scratch database %s is the deployment database", name)
+	}
+
+	admin, err := pgxpool.New(ctx, adminURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to maintenance database: %w", err)
+	}
+	scratch := &ScratchDatabase{name: name, admin: admin}
+
+	if err := scratch.drop(ctx); err != nil {
+		admin.Close()
+		return nil, err
+	}
+	if _, err := admin.Exec(ctx, "CREATE DATABASE "+pgx.Identifier{name}.Sanitize()); err != nil {
+		admin.Close()
+		return nil, fmt.Errorf("failed to create scratch database %s: %w", name, err)
+	}
+
+	scratch.DB, err = NewDB(ctx, scratchURL, opts...)
+	if err != nil {
+		scratch.drop(ctx)
+		admin.Close()
+		return nil, err
+	}
+	return scratch, nil
+}
+
+// Close disconnects from the scratch database and drops it
+func (s *ScratchDatabase) Close(ctx context.Context) error {
+	defer s.admin.Close()
+	s.DB.Close()
+	return s.drop(ctx)
+}
+
+// drop drops the scratch database if it exists
+func (s *ScratchDatabase) drop(ctx context.Context) error {
+	if _, err := s.admin.Exec(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", pgx.Identifier{s.name}.Sanitize())); err != nil {
+		return fmt.Errorf("failed to drop scratch database %s: %w", s.name, err)
+	}
+	return nil
+}
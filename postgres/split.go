@@ -0,0 +1,179 @@
+package postgres
+
+import (
+	"strings"
+	"unicode"
+)
+
+// transactionControl are the leading keywords of statements that would end or nest the
+// transaction a batch runs in
+var transactionControl = map[string]bool{
+	"BEGIN":     true,
+	"START":     true,
+	"COMMIT":    true,
+	"END":       true,
+	"ROLLBACK":  true,
+	"ABORT":     true,
+	"SAVEPOINT": true,
+	"RELEASE":   true,
+	"PREPARE":   true,
+}
+
+// sqlStatement is a statement of a SQL file and the line it starts on
+type sqlStatement struct {
+	SQL  string
+	Line int
+}
+
+// splitStatements splits sql into statements at top-level semicolons, skipping those with
+// only comments. ok is false when the SQL uses something a split cannot run safely as separate
+// statements: transaction control, BEGIN ATOMIC function bodies, COPY ... FROM STDIN, psql
+// meta-commands, or an unterminated quote, comment or dollar-quoted string.
+func splitStatements(sql string) (statements []sqlStatement, ok bool) {
+	var (
+		start, line, startLine = 0, 1, 1
+		words                  []string // Keywords of the current statement, upper case
+		content                bool     // The statement has more than comments and whitespace
+		lineStart              = true   // Only whitespace since the start of the line
+	)
+
+	end := func(i int) bool {
+		if content {
+			for j := 1; j < len(words); j++ {
+				if words[j-1] == "BEGIN" && words[j] == "ATOMIC" {
+					return false
+				}
+			}
+			if len(words) > 0 && transactionControl[words[0]] {
+				return false
+			}
+			if len(words) > 0 && words[0] == "COPY" && strings.Contains(strings.ToUpper(sql[start:i]), "STDIN") {
+				return false
+			}
+			statements = append(statements, sqlStatement{SQL: strings.TrimSpace(sql[start:i]), Line: startLine})
+		}
+		start, words, content = i+1, nil, false
+		return true
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		if !content && !isSpace(c) && c != '\n' && !strings.HasPrefix(sql[i:], "--") && !strings.HasPrefix(sql[i:], "/*") {
+			content, startLine = true, line
+		}
+
+		switch {
+		case c == '\n':
+			line++
+			lineStart = true
+			continue
+
+		case isSpace(c):
+			continue
+
+		case c == '\\' && lineStart:
+			return nil, false
+
+		case strings.HasPrefix(sql[i:], "--"):
+			next := strings.IndexByte(sql[i:], '\n')
+			if next < 0 {
+				i = len(sql)
+				continue
+			}
+			i += next - 1
+
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth := 0
+			for ; i < len(sql); i++ {
+				switch {
+				case strings.HasPrefix(sql[i:], "/*"):
+					depth++
+					i++
+				case strings.HasPrefix(sql[i:], "*/"):
+					depth--
+					i++
+				case sql[i] == '\n':
+					line++
+				}
+				if depth == 0 {
+					break
+				}
+			}
+			if depth != 0 {
+				return nil, false
+			}
+
+		case c == '\'' || c == '"':
+			// E'...' strings allow backslash escapes
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i < 2 || !isIdentChar(sql[i-2]))
+			i++
+			for ; i < len(sql); i++ {
+				if sql[i] == '\n' {
+					line++
+				}
+				if escapes && sql[i] == '\\' {
+					i++
+					continue
+				}
+				if sql[i] == c {
+					if i+1 < len(sql) && sql[i+1] == c {
+						i++ // Doubled quote
+						continue
+					}
+					break
+				}
+			}
+			if i >= len(sql) {
+				return nil, false
+			}
+
+		case c == '$' && (i == 0 || !isIdentChar(sql[i-1])):
+			tagEnd := i + 1
+			for tagEnd < len(sql) && sql[tagEnd] != '$' && isIdentChar(sql[tagEnd]) && !(tagEnd == i+1 && unicode.IsDigit(rune(sql[tagEnd]))) {
+				tagEnd++
+			}
+			if tagEnd >= len(sql) || sql[tagEnd] != '$' {
+				break // A parameter such as $1
+			}
+			tag := sql[i : tagEnd+1]
+			closing := strings.Index(sql[tagEnd+1:], tag)
+			if closing < 0 {
+				return nil, false
+			}
+			body := sql[i : tagEnd+1+closing+len(tag)]
+			line += strings.Count(body, "\n")
+			i += len(body) - 1
+
+		case c == ';':
+			if !end(i) {
+				return nil, false
+			}
+
+		case isIdentChar(c):
+			wordEnd := i
+			for wordEnd < len(sql) && isIdentChar(sql[wordEnd]) {
+				wordEnd++
+			}
+			if len(words) < 64 {
+				words = append(words, strings.ToUpper(sql[i:wordEnd]))
+			}
+			i = wordEnd - 1
+		}
+		lineStart = false
+	}
+
+	if !end(len(sql)) {
+		return nil, false
+	}
+	return statements, true
+}
+
+// isSpace reports whether c is SQL whitespace other than a newline
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\f'
+}
+
+// isIdentChar reports whether c can be part of an unquoted identifier or keyword
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
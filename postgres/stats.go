@@ -0,0 +1,137 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mantty/zdd"
+)
+
+// IndexUsage returns the indexes of user tables with their scan counts from
+// pg_stat_user_indexes, and when the database's statistics were last reset
+func (db *DB) IndexUsage() ([]zdd.IndexUsage, time.Time, error) {
+	var statsReset *time.Time
+	if err := db.pool.QueryRow(db.ctx, "SELECT stats_reset FROM pg_stat_database WHERE datname = current_database()").Scan(&statsReset); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read statistics reset time: %w", err)
+	}
+
+	rows, err := db.pool.Query(db.ctx, `
+		SELECT s.schemaname, s.indexrelname, s.relname, s.idx_scan,
+			pg_relation_size(s.indexrelid), pg_get_indexdef(s.indexrelid),
+			i.indisunique OR i.indisprimary OR EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = s.indexrelid)
+		FROM pg_stat_user_indexes s
+		JOIN pg_index i ON i.indexrelid = s.indexrelid
+		ORDER BY s.schemaname, s.indexrelname
+	`)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to query index usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []zdd.IndexUsage
+	for rows.Next() {
+		var index zdd.IndexUsage
+		if err := rows.Scan(&index.Schema, &index.Name, &index.Table, &index.Scans, &index.SizeBytes, &index.Definition, &index.Enforces); err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to scan index usage: %w", err)
+		}
+		usage = append(usage, index)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("error iterating index usage: %w", err)
+	}
+
+	if statsReset == nil {
+		return usage, time.Time{}, nil
+	}
+	return usage, *statsReset, nil
+}
+
+// RelationSizes returns the sizes of the named tables, resolved with to_regclass against the
+// search path, with bloat estimated from the dead rows counted in pg_stat_user_tables
+func (db *DB) RelationSizes(tables []string) (map[string]zdd.RelationSize, error) {
+	rows, err := db.pool.Query(db.ctx, `
+		SELECT t.name, pg_table_size(c.oid), pg_indexes_size(c.oid),
+			COALESCE(pg_table_size(c.oid) * s.n_dead_tup / NULLIF(s.n_live_tup + s.n_dead_tup, 0), 0)
+		FROM unnest($1::text[]) AS t(name)
+		JOIN pg_class c ON c.oid = to_regclass(t.name)
+		LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid
+	`, tables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table sizes: %w", err)
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]zdd.RelationSize)
+	for rows.Next() {
+		var name string
+		var size zdd.RelationSize
+		if err := rows.Scan(&name, &size.TableBytes, &size.IndexBytes, &size.BloatBytes); err != nil {
+			return nil, fmt.Errorf("failed to scan table size: %w", err)
+		}
+		sizes[name] = size
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating table sizes: %w", err)
+	}
+	return sizes, nil
+}
+
+// BenchStatements runs statements one at a time in a transaction and commits it, timing each
+// and listing the locks the transaction holds on relations outside the system catalogs after
+// each, so every lock is attributed to the statement that took it
+func (db *DB) BenchStatements(statements []string) ([]time.Duration, []zdd.LockHold, error) {
+	tx, err := db.pool.Begin(db.ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(db.ctx) // Will be ignored if transaction is committed
+
+	type lockKey struct{ relation, mode string }
+	acquired := make(map[lockKey]time.Time)
+	var locks []zdd.LockHold
+	var durations []time.Duration
+	for i, statement := range statements {
+		started := time.Now()
+		if _, err := tx.Exec(db.ctx, statement); err != nil {
+			return nil, nil, fmt.Errorf("failed to execute SQL statement %d: %w", i+1, err)
+		}
+		durations = append(durations, time.Since(started))
+
+		rows, err := tx.Query(db.ctx, `
+			SELECT l.relation::regclass::text, l.mode
+			FROM pg_locks l
+			JOIN pg_class c ON c.oid = l.relation
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE l.pid = pg_backend_pid() AND l.locktype = 'relation' AND l.granted
+				AND n.nspname <> 'information_schema' AND n.nspname NOT LIKE 'pg\_%'
+			ORDER BY 1, 2
+		`)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to query locks: %w", err)
+		}
+		for rows.Next() {
+			var key lockKey
+			if err := rows.Scan(&key.relation, &key.mode); err != nil {
+				rows.Close()
+				return nil, nil, fmt.Errorf("failed to scan lock: %w", err)
+			}
+			if _, ok := acquired[key]; !ok {
+				acquired[key] = started
+				locks = append(locks, zdd.LockHold{Relation: key.relation, Mode: key.mode, Statement: i})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, fmt.Errorf("error iterating locks: %w", err)
+		}
+	}
+
+	if err := tx.Commit(db.ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed := time.Now()
+	for i := range locks {
+		locks[i].Held = committed.Sub(acquired[lockKey{locks[i].Relation, locks[i].Mode}])
+	}
+	return durations, locks, nil
+}
@@ -0,0 +1,60 @@
+package zdd
+
+import (
+	"bytes"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// processPollInterval is how often a terminated process tree is checked for survivors
+const processPollInterval = 50 * time.Millisecond
+
+// runProcessTree runs cmd, created with exec.CommandContext, and returns its combined output.
+// When the context ends, cmd and every process it started are asked to terminate, and those
+// still running after the grace period are killed, so background processes of a timed-out
+// script do not keep running after zdd reports the failure.
+func runProcessTree(cmd *exec.Cmd, grace time.Duration) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	tree, err := newProcessTree(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer tree.release()
+
+	var canceled atomic.Bool
+	stopped := make(chan struct{})
+	cmd.Cancel = func() error {
+		canceled.Store(true)
+		err := tree.terminate()
+		go func() {
+			defer close(stopped)
+			deadline := time.Now().Add(grace)
+			for tree.alive() && time.Now().Before(deadline) {
+				time.Sleep(processPollInterval)
+			}
+			if tree.alive() {
+				_ = tree.kill()
+			}
+		}()
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	if err := tree.started(); err != nil {
+		_ = tree.kill()
+		_ = cmd.Wait()
+		return nil, err
+	}
+
+	err = cmd.Wait()
+	if canceled.Load() {
+		<-stopped // Report the failure only once the whole tree has stopped
+	}
+	return output.Bytes(), err
+}
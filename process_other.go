@@ -0,0 +1,39 @@
+//go:build !unix && !windows
+
+package zdd
+
+import "os/exec"
+
+// processTree is a command; platforms without process groups or job objects can only kill
+// the command itself
+type processTree struct {
+	cmd *exec.Cmd
+}
+
+// newProcessTree returns the tree of cmd
+func newProcessTree(cmd *exec.Cmd) (*processTree, error) {
+	return &processTree{cmd: cmd}, nil
+}
+
+// started is called once the command has started
+func (t *processTree) started() error {
+	return nil
+}
+
+// terminate kills the command
+func (t *processTree) terminate() error {
+	return t.kill()
+}
+
+// alive reports whether the command is still running, which after terminate it is not
+func (t *processTree) alive() bool {
+	return false
+}
+
+// kill kills the command
+func (t *processTree) kill() error {
+	return t.cmd.Process.Kill()
+}
+
+// release frees the resources of the tree
+func (t *processTree) release() {}
@@ -0,0 +1,57 @@
+//go:build unix
+
+package zdd
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// processTree is a command and its descendants: on Unix, the process group the command leads
+type processTree struct {
+	cmd *exec.Cmd
+}
+
+// newProcessTree makes cmd start a process group of its own
+func newProcessTree(cmd *exec.Cmd) (*processTree, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	return &processTree{cmd: cmd}, nil
+}
+
+// started is called once the command has started
+func (t *processTree) started() error {
+	return nil
+}
+
+// terminate asks every process of the group to exit with SIGTERM
+func (t *processTree) terminate() error {
+	return t.signal(syscall.SIGTERM)
+}
+
+// alive reports whether any process of the group is still running
+func (t *processTree) alive() bool {
+	return syscall.Kill(-t.cmd.Process.Pid, 0) == nil
+}
+
+// kill kills every process of the group
+func (t *processTree) kill() error {
+	return t.signal(syscall.SIGKILL)
+}
+
+// signal sends sig to every process of the group
+func (t *processTree) signal(sig syscall.Signal) error {
+	if err := syscall.Kill(-t.cmd.Process.Pid, sig); err != nil {
+		if err == syscall.ESRCH {
+			return os.ErrProcessDone
+		}
+		return err
+	}
+	return nil
+}
+
+// release frees the resources of the tree
+func (t *processTree) release() {}
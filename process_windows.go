@@ -0,0 +1,65 @@
+//go:build windows
+
+package zdd
+
+import (
+	"fmt"
+	"os/exec"
+
+	"golang.org/x/sys/windows"
+)
+
+// processTree is a command and its descendants: on Windows, a job object the command is
+// assigned to, which the processes it starts join
+type processTree struct {
+	cmd *exec.Cmd
+	job windows.Handle
+}
+
+// newProcessTree creates the job object for cmd
+func newProcessTree(cmd *exec.Cmd) (*processTree, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job object: %w", err)
+	}
+	return &processTree{cmd: cmd, job: job}, nil
+}
+
+// started assigns the started command to the job object. Processes the command starts before
+// it is assigned are not part of the job.
+func (t *processTree) started() error {
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(t.cmd.Process.Pid))
+	if err != nil {
+		return fmt.Errorf("failed to open process %d: %w", t.cmd.Process.Pid, err)
+	}
+	defer windows.CloseHandle(process)
+
+	if err := windows.AssignProcessToJobObject(t.job, process); err != nil {
+		return fmt.Errorf("failed to assign process %d to job object: %w", t.cmd.Process.Pid, err)
+	}
+	return nil
+}
+
+// terminate kills every process of the job: Windows has no signal asking arbitrary processes to
+// exit
+func (t *processTree) terminate() error {
+	return t.kill()
+}
+
+// alive reports whether any process of the job is still running, which after terminate is none
+func (t *processTree) alive() bool {
+	return false
+}
+
+// kill terminates every process of the job, and the command itself in case it has not been
+// assigned to the job yet
+func (t *processTree) kill() error {
+	err := windows.TerminateJobObject(t.job, 1)
+	_ = t.cmd.Process.Kill()
+	return err
+}
+
+// release closes the job object
+func (t *processTree) release() {
+	windows.CloseHandle(t.job)
+}
@@ -0,0 +1,270 @@
+package zdd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// psqlMaxIncludeDepth bounds nested \i, which would otherwise recurse forever on a cycle
+const psqlMaxIncludeDepth = 16
+
+// psqlCommands are the psql meta-commands zdd supports
+var psqlCommands = []string{"i", "include", "ir", "include_relative", "set", "unset", "echo"}
+
+// psqlExpander applies the psql meta-commands zdd supports to SQL files
+type psqlExpander struct {
+	vars map[string]string
+	// echo receives the text of \echo; nil keeps \echo in the SQL, for psql scripts
+	echo func(string)
+}
+
+// readPsqlFile reads the SQL file at path with the psql meta-commands zdd supports applied, so
+// scripts written for psql can be used as deployment SQL. \i and \ir include a file relative to
+// the including one, \set and \unset define the variables that :name, :'name' and :"name" are
+// replaced with, and \echo passes its text to echo. A nil echo keeps \echo lines in the SQL.
+// Other meta-commands are an error, since the SQL would not do what its author meant without
+// them.
+func readPsqlFile(path string, echo func(string)) (string, error) {
+	e := psqlExpander{vars: make(map[string]string), echo: echo}
+	var out strings.Builder
+	if err := e.expand(&out, path, 0); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// expand writes the file at path to out, running its meta-commands and substituting variables
+// outside quoted strings and comments
+func (e *psqlExpander) expand(out *strings.Builder, path string, depth int) error {
+	if depth > psqlMaxIncludeDepth {
+		return fmt.Errorf("%s: includes nested more than %d deep", path, psqlMaxIncludeDepth)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read SQL file %s: %w", path, err)
+	}
+
+	sql := string(content)
+	var (
+		closing   string // Ends the quoted string or comment being scanned, if any
+		escapes   bool   // The quoted string is an E'...' string with backslash escapes
+		lineStart = true
+	)
+	for i := 0; i < len(sql); {
+		if closing == "" && lineStart {
+			j := i
+			for j < len(sql) && (sql[j] == ' ' || sql[j] == '\t') {
+				j++
+			}
+			if j < len(sql) && sql[j] == '\\' {
+				end := strings.IndexByte(sql[j:], '\n')
+				if end < 0 {
+					end = len(sql) - j
+				}
+				line := strings.Count(sql[:j], "\n") + 1
+				if err := e.command(out, path, line, strings.TrimRight(sql[j:j+end], "\r"), depth); err != nil {
+					return err
+				}
+				i = j + end + 1
+				continue
+			}
+		}
+
+		c := sql[i]
+		lineStart = c == '\n'
+		switch {
+		case closing != "":
+			if escapes && c == '\\' && i+1 < len(sql) {
+				out.WriteString(sql[i : i+2])
+				i += 2
+				continue
+			}
+			if strings.HasPrefix(sql[i:], closing) {
+				out.WriteString(closing)
+				i += len(closing)
+				lineStart = closing == "\n"
+				closing = ""
+				continue
+			}
+
+		case strings.HasPrefix(sql[i:], "--"):
+			closing = "\n"
+
+		case strings.HasPrefix(sql[i:], "/*"):
+			out.WriteString("/*")
+			i += 2
+			closing = "*/"
+			continue
+
+		case c == '\'' || c == '"':
+			closing = string(c)
+			escapes = c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+
+		case c == '$' && (i == 0 || !isPsqlNameChar(sql[i-1])):
+			if tag, ok := dollarTag(sql[i:]); ok {
+				out.WriteString(tag)
+				i += len(tag)
+				closing = tag
+				continue
+			}
+
+		case c == ':' && !strings.HasPrefix(sql[i:], "::") && (i == 0 || sql[i-1] != ':'):
+			if value, n, ok := e.variable(sql[i:]); ok {
+				out.WriteString(value)
+				i += n
+				continue
+			}
+		}
+
+		out.WriteByte(c)
+		i++
+	}
+
+	if !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString("\n")
+	}
+	return nil
+}
+
+// command runs the meta-command on a line of the file at path
+func (e *psqlExpander) command(out *strings.Builder, path string, line int, command string, depth int) error {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(command, `\`), " ")
+	args := e.arguments(rest)
+
+	switch name {
+	case "i", "include", "ir", "include_relative":
+		if len(args) != 1 {
+			return fmt.Errorf("%s:%d: \\%s takes a file name", path, line, name)
+		}
+		include := args[0]
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		return e.expand(out, include, depth+1)
+
+	case "set":
+		if len(args) == 0 {
+			return fmt.Errorf("%s:%d: \\set takes a variable name", path, line)
+		}
+		e.vars[args[0]] = strings.Join(args[1:], "")
+
+	case "unset":
+		if len(args) != 1 {
+			return fmt.Errorf("%s:%d: \\unset takes a variable name", path, line)
+		}
+		delete(e.vars, args[0])
+
+	case "echo":
+		text := strings.Join(args, " ")
+		if e.echo == nil {
+			fmt.Fprintf(out, "\\echo %s\n", quoteLiteral(text))
+		} else {
+			e.echo(text)
+		}
+
+	default:
+		return fmt.Errorf("%s:%d: psql meta-command \\%s is not supported (use \\i, \\ir, \\set, \\unset or \\echo)", path, line, name)
+	}
+	return nil
+}
+
+// arguments splits the arguments of a meta-command at whitespace, removing single quotes
+// around them and substituting variables in unquoted ones
+func (e *psqlExpander) arguments(s string) []string {
+	var args []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return args
+		}
+
+		var arg strings.Builder
+		for s != "" && s[0] != ' ' && s[0] != '\t' {
+			switch {
+			case s[0] == '\'':
+				end := 1
+				for end < len(s) {
+					if s[end] == '\'' {
+						if end+1 < len(s) && s[end+1] == '\'' {
+							arg.WriteByte('\'')
+							end += 2
+							continue
+						}
+						break
+					}
+					arg.WriteByte(s[end])
+					end++
+				}
+				s = s[min(end+1, len(s)):]
+			case s[0] == ':':
+				if value, n, ok := e.variable(s); ok {
+					arg.WriteString(value)
+					s = s[n:]
+					continue
+				}
+				arg.WriteByte(s[0])
+				s = s[1:]
+			default:
+				arg.WriteByte(s[0])
+				s = s[1:]
+			}
+		}
+		args = append(args, arg.String())
+	}
+}
+
+// variable returns the value of the variable reference :name, :'name' or :"name" at the start
+// of s, quoted as a literal or identifier as its form asks, and the length of the reference.
+// References to undefined variables are left alone, as psql does.
+func (e *psqlExpander) variable(s string) (string, int, bool) {
+	quote := byte(0)
+	start := 1
+	if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+		quote = s[1]
+		start = 2
+	}
+	end := start
+	for end < len(s) && isPsqlNameChar(s[end]) && s[end] != '$' {
+		end++
+	}
+	if end == start {
+		return "", 0, false
+	}
+	value, ok := e.vars[s[start:end]]
+	if !ok {
+		return "", 0, false
+	}
+
+	switch quote {
+	case '\'':
+		if end >= len(s) || s[end] != '\'' {
+			return "", 0, false
+		}
+		return quoteLiteral(value), end + 1, true
+	case '"':
+		if end >= len(s) || s[end] != '"' {
+			return "", 0, false
+		}
+		return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`, end + 1, true
+	}
+	return value, end, true
+}
+
+// dollarTag returns the opening $tag$ of a dollar-quoted string at the start of s
+func dollarTag(s string) (string, bool) {
+	end := 1
+	for end < len(s) && s[end] != '$' && isPsqlNameChar(s[end]) && !(end == 1 && '0' <= s[end] && s[end] <= '9') {
+		end++
+	}
+	if end >= len(s) || s[end] != '$' {
+		return "", false // A parameter such as $1
+	}
+	return s[:end+1], true
+}
+
+// isPsqlNameChar reports whether c can be part of an unquoted identifier or variable name
+func isPsqlNameChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,157 @@
+package zdd
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+//go:embed assets/rename_column assets/rename_table assets/rename_table_view
+var renameTemplates embed.FS
+
+// Rename strategies
+const (
+	// RenameStrategyTrigger copies into the new column or table, kept in sync by a trigger until contract
+	RenameStrategyTrigger = "trigger"
+	// RenameStrategyView renames a table at contract and leaves a view under the old name for one release
+	RenameStrategyView = "view"
+)
+
+// nonIdentifierChars are replaced when deriving trigger and function names from table and column names
+var nonIdentifierChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// Rename describes a column or table rename performed with the expand-migrate-contract pattern
+type Rename struct {
+	Table   string // Possibly schema-qualified table name
+	Column  string // Column to rename; empty to rename the table itself
+	NewName string // New column name, or new (possibly schema-qualified) table name
+	Key     string // Primary key column used to match rows of a renamed table; default id
+	// Strategy is RenameStrategyTrigger (default) or, for tables only, RenameStrategyView
+	Strategy string
+}
+
+// ParseColumnRename parses a column rename written as table.column=new_column
+func ParseColumnRename(spec string) (Rename, error) {
+	from, to, ok := strings.Cut(spec, "=")
+	dot := strings.LastIndex(from, ".")
+	if !ok || dot <= 0 || dot == len(from)-1 || to == "" {
+		return Rename{}, fmt.Errorf("invalid column rename %q (expected table.column=new_column)", spec)
+	}
+	return Rename{Table: from[:dot], Column: from[dot+1:], NewName: to}, nil
+}
+
+// ParseTableRename parses a table rename written as table=new_table
+func ParseTableRename(spec string) (Rename, error) {
+	from, to, ok := strings.Cut(spec, "=")
+	if !ok || from == "" || to == "" {
+		return Rename{}, fmt.Errorf("invalid table rename %q (expected table=new_table)", spec)
+	}
+	return Rename{Table: from, NewName: to}, nil
+}
+
+// DefaultName is the deployment name used when none is given, e.g. rename_users_email_to_email_address
+func (r Rename) DefaultName() string {
+	from := r.Table
+	if r.Column != "" {
+		from += "_" + r.Column
+	}
+	return sanitizeName("rename_" + from + "_to_" + r.NewName)
+}
+
+// CreateRenameDeployment creates a deployment that renames a column or table without downtime.
+// Expand adds the new column or table and installs a trigger keeping it in sync with the old one,
+// migrate backfills existing rows, validate.yaml checks the copy before contract, and contract
+// drops the trigger and the old column or table. With RenameStrategyView, contract instead renames
+// the table and creates a view under the old name, tracked through views.yaml.
+func CreateRenameDeployment(module Module, name string, rename Rename) (*Deployment, error) {
+	if name == "" {
+		name = rename.DefaultName()
+	}
+
+	fileNames := []string{"expand.sql", "migrate.sql", "validate.yaml", "contract.sql"}
+	switch rename.Strategy {
+	case "", RenameStrategyTrigger:
+	case RenameStrategyView:
+		if rename.Column != "" {
+			return nil, fmt.Errorf("the %s rename strategy only applies to tables", RenameStrategyView)
+		}
+		fileNames = []string{"contract.sql", viewsFileName}
+	default:
+		return nil, fmt.Errorf("unknown rename strategy %q (expected %s or %s)", rename.Strategy, RenameStrategyTrigger, RenameStrategyView)
+	}
+
+	var files []deploymentFile
+	for _, file := range fileNames {
+		content, err := rename.render(file)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, deploymentFile{file, content, 0644})
+	}
+
+	return createDeploymentFiles(module, name, files)
+}
+
+// render fills in the named template of the rename
+func (r Rename) render(file string) (string, error) {
+	dir := "assets/rename_table"
+	switch {
+	case r.Column != "":
+		dir = "assets/rename_column"
+	case r.Strategy == RenameStrategyView:
+		dir = "assets/rename_table_view"
+	}
+
+	tmpl, err := template.ParseFS(renameTemplates, path.Join(dir, file))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s template: %w", file, err)
+	}
+
+	key := r.Key
+	if key == "" {
+		key = defaultValidationKey
+	}
+	oldName := r.Column
+	if oldName == "" {
+		oldName = r.Table
+	}
+	suffix := sanitizeName(strings.Join([]string{r.Table, r.Column, r.NewName}, " "))
+
+	data := map[string]string{
+		"TableName":       r.Table,
+		"OldName":         oldName,
+		"NewName":         r.NewName,
+		"KeyName":         key,
+		"Table":           quoteIdentifier(r.Table),
+		"Old":             quoteIdentifier(r.Column),
+		"New":             quoteIdentifier(r.NewName),
+		"NewBare":         quoteIdentifier(r.NewName[strings.LastIndex(r.NewName, ".")+1:]),
+		"Key":             quoteIdentifier(key),
+		"TableLiteral":    quoteLiteral(quoteIdentifier(r.Table)),
+		"OldLiteral":      quoteLiteral(r.Column),
+		"NewLiteral":      quoteLiteral(r.NewName),
+		"NewIdentLiteral": quoteLiteral(quoteIdentifier(r.NewName)),
+		"Function":        quoteIdentifier("zdd_sync_" + suffix),
+		"Trigger":         quoteIdentifier("zdd_sync_" + suffix),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", file, err)
+	}
+	return buf.String(), nil
+}
+
+// quoteLiteral quotes s as an SQL string literal
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// sanitizeName lowercases s and replaces anything but letters, digits and underscores
+func sanitizeName(s string) string {
+	return strings.Trim(nonIdentifierChars.ReplaceAllString(strings.ToLower(s), "_"), "_")
+}
@@ -0,0 +1,161 @@
+package zdd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// RenderOptions controls the script written by Plan.Render
+type RenderOptions struct {
+	// Preamble is SQL run before any deployment, such as the setup of the deployment schema
+	Preamble string
+	// Settings are session settings SET at the start of the script, e.g. lock_timeout
+	Settings map[string]string
+}
+
+// Render writes the plan as a psql script that applies its tasks and records its deployments,
+// for databases where changes must be run by hand. Each SQL file runs in a transaction of its
+// own, enum values are added outside one, and each deployment is recorded as applied once its
+// last task has run. Changed repeatable migrations follow the deployments. Scripts, assertions,
+// validations and other tasks that need zdd cannot be rendered.
+func (p *Plan) Render(w io.Writer, options RenderOptions) error {
+	for _, task := range p.Tasks {
+		if task.TaskType != "sql" && task.TaskType != "enum" {
+			return fmt.Errorf("deployment %s has a %s task, which cannot be rendered; skip it or deploy with zdd", task.Deployment.Key(), task.Name())
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "-- Rendered by %s\n", ApplicationName())
+	fmt.Fprintln(&out, "-- Run with psql -f; each SQL file commits on its own, and ON_ERROR_STOP stops at the first error")
+	fmt.Fprintln(&out, "\\set ON_ERROR_STOP on")
+	names := make([]string, 0, len(options.Settings))
+	for name := range options.Settings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&out, "SET %s = %s;\n", name, quoteLiteral(options.Settings[name]))
+	}
+	if preamble := strings.TrimSpace(options.Preamble); preamble != "" {
+		fmt.Fprintf(&out, "\n%s\n", preamble)
+	}
+
+	// A deployment is recorded after its last task, or at once if all its tasks were skipped
+	last := make(map[string]int)
+	for i, task := range p.Tasks {
+		last[task.Deployment.Key()] = i
+	}
+	var recorded []string
+	record := func(deployment *Deployment) {
+		if slices.Contains(recorded, deployment.Key()) {
+			return
+		}
+		recorded = append(recorded, deployment.Key())
+		out.WriteString(renderRecord(*deployment))
+	}
+	for _, task := range p.Skipped {
+		if _, pending := last[task.Deployment.Key()]; !pending {
+			fmt.Fprintf(&out, "\n-- Deployment %s: %s (all tasks skipped)\n", task.Deployment.Key(), task.Deployment.Name)
+			record(task.Deployment)
+		}
+	}
+
+	current := ""
+	for i, task := range p.Tasks {
+		deployment := task.Deployment
+		if key := deployment.Key(); key != current {
+			fmt.Fprintf(&out, "\n-- Deployment %s: %s\n", key, deployment.Name)
+			current = key
+		}
+
+		switch task.TaskType {
+		case "enum":
+			// ALTER TYPE ... ADD VALUE must be committed before the deployment's SQL uses the values
+			fmt.Fprintln(&out, "-- enum values")
+			for _, enum := range deployment.EnumValues {
+				for _, value := range enum.Add {
+					fmt.Fprintf(&out, "ALTER TYPE %s ADD VALUE IF NOT EXISTS %s;\n", quoteIdentifier(enum.Type), quoteLiteral(value))
+				}
+			}
+
+		case "sql":
+			// Includes and variables are resolved here; \echo is left for psql to print
+			text, err := readPsqlFile(task.Path, nil)
+			if err != nil {
+				return err
+			}
+			if p.Trash && task.Phase == "contract" {
+				text, _ = trashSQL(text, *deployment)
+			}
+			content := bytes.TrimSpace([]byte(text))
+			fmt.Fprintf(&out, "-- %s: %s\nBEGIN;\n", task.Phase, task.Path)
+			out.WriteString(sqlCommentHeader(*deployment, task.Path))
+			out.Write(content)
+			// Terminate the last statement, which a file may leave open or end with a comment
+			if !bytes.HasSuffix(content, []byte(";")) {
+				out.WriteString("\n;")
+			}
+			out.WriteString("\nCOMMIT;\n")
+		}
+
+		if last[deployment.Key()] == i {
+			record(deployment)
+		}
+	}
+
+	// Repeatables are applied after the deployments and recorded in one transaction
+	if len(p.Repeatables) > 0 {
+		sql, err := repeatableSQL(p.Repeatables, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&out, "\n-- Repeatables: %s\nBEGIN;\n%s", repeatableKeys(p.Repeatables), sql)
+		for _, repeatable := range p.Repeatables {
+			objects := make([]string, len(repeatable.Objects))
+			for i, object := range repeatable.Objects {
+				objects[i] = quoteLiteral(object.String())
+			}
+			fmt.Fprintf(&out, `INSERT INTO zdd_deployments.repeatable_migrations (module, name, checksum, applied_at, run_by, objects)
+VALUES (%s, %s, %s, NOW(), current_user || '@psql', ARRAY[%s]::text[])
+ON CONFLICT (module, name) DO UPDATE SET
+    checksum = EXCLUDED.checksum, applied_at = EXCLUDED.applied_at, run_by = EXCLUDED.run_by,
+    objects = EXCLUDED.objects;
+`, quoteLiteral(repeatable.Module), quoteLiteral(repeatable.Name), quoteLiteral(repeatable.Checksum), strings.Join(objects, ", "))
+		}
+		out.WriteString("COMMIT;\n")
+	}
+
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return fmt.Errorf("failed to write script: %w", err)
+	}
+	return nil
+}
+
+// renderRecord returns the statement recording the deployment as applied, which also completes
+// an attempt a zdd deploy left failed
+func renderRecord(deployment Deployment) string {
+	skipped := "NULL"
+	if len(deployment.SkippedTasks) > 0 {
+		quoted := make([]string, len(deployment.SkippedTasks))
+		for i, task := range deployment.SkippedTasks {
+			quoted[i] = quoteLiteral(task)
+		}
+		skipped = "ARRAY[" + strings.Join(quoted, ", ") + "]::text[]"
+	}
+	ticket := "NULL"
+	if deployment.Ticket != nil {
+		ticket = quoteLiteral(deployment.Ticket.Key)
+	}
+
+	return fmt.Sprintf(`INSERT INTO zdd_deployments.applied_deployments (id, name, module, applied_at, checksum, skipped_tasks, status, run_id, run_by, error, ticket)
+VALUES (%s, %s, %s, NOW(), %s, %s, 'applied', NULL, current_user || '@psql', NULL, %s)
+ON CONFLICT (module, id) DO UPDATE SET
+    name = EXCLUDED.name, applied_at = EXCLUDED.applied_at, checksum = EXCLUDED.checksum,
+    skipped_tasks = EXCLUDED.skipped_tasks, status = 'applied', run_id = NULL,
+    run_by = EXCLUDED.run_by, error = NULL, ticket = EXCLUDED.ticket;
+`, quoteLiteral(deployment.ID), quoteLiteral(deployment.Name), quoteLiteral(deployment.Module), quoteLiteral(CalculateChecksum(deployment)), skipped, ticket)
+}
@@ -0,0 +1,455 @@
+package zdd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// repeatableDir is the directory of a deployments path holding repeatable migrations
+const repeatableDir = "repeatable"
+
+var (
+	// repeatableCreatePattern matches the definitions repeatables are ordered by
+	repeatableCreatePattern = regexp.MustCompile(`(?is)\bCREATE\s+(?:OR\s+REPLACE\s+)?(?:(?:TEMP|TEMPORARY|RECURSIVE)\s+)*(MATERIALIZED\s+VIEW|VIEW|FUNCTION|PROCEDURE)\s+(?:IF\s+NOT\s+EXISTS\s+)?([^\s(;]+)`)
+
+	// sqlNamePattern matches possibly qualified and quoted names in SQL
+	sqlNamePattern = regexp.MustCompile(`(?:"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*)(?:\.(?:"(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*))?`)
+)
+
+type (
+	// Repeatable is a SQL file of definitions such as views and functions that is applied again
+	// whenever its content changes, after the versioned deployments. Its SQL must be safe to run
+	// repeatedly, e.g. CREATE OR REPLACE.
+	Repeatable struct {
+		Name     string // File name without .sql, unique within its module
+		Module   string // Empty for the default (unnamed) module
+		Path     string
+		Checksum string // Of the SQL with includes and variables resolved
+		// Objects are the views, materialized views, functions and procedures the SQL creates
+		Objects []RepeatableObject
+		// names are the normalized names the SQL mentions, which order it after the repeatables
+		// defining them
+		names map[string]bool
+	}
+
+	// RepeatableObject is an object created by a repeatable migration
+	RepeatableObject struct {
+		Kind string // "view", "materialized view", "function" or "procedure"
+		Name string // Normalized, without the public schema
+	}
+
+	// RepeatableRecord is the last application of a repeatable migration
+	RepeatableRecord struct {
+		Name      string
+		Module    string
+		Checksum  string
+		AppliedAt time.Time
+		Objects   []string // The objects the applied SQL created, as RepeatableObject.String
+	}
+
+	// RepeatableTracker is implemented by databases that record applied repeatable migrations
+	RepeatableTracker interface {
+		GetAppliedRepeatables() ([]RepeatableRecord, error)
+		// ApplyRepeatables runs sql and records the checksums of the repeatables in one transaction
+		ApplyRepeatables(repeatables []Repeatable, sql string) error
+	}
+
+	// DependencyInspector is implemented by databases that can list the views depending on
+	// objects, so repeatables defining them can be dropped and recreated together
+	DependencyInspector interface {
+		// DependentViews returns the views and materialized views that depend on the named views
+		// or materialized views, directly or through other views
+		DependentViews(names []string) ([]string, error)
+	}
+)
+
+// Key uniquely identifies the repeatable across modules, e.g. "billing/views"
+func (r Repeatable) Key() string {
+	return deploymentKey(r.Module, r.Name)
+}
+
+// Key uniquely identifies the recorded repeatable across modules, matching Repeatable.Key
+func (r RepeatableRecord) Key() string {
+	return deploymentKey(r.Module, r.Name)
+}
+
+// String describes the object as its kind and name, e.g. "view reports.daily"
+func (o RepeatableObject) String() string {
+	return o.Kind + " " + o.Name
+}
+
+// defines reports whether the repeatable creates the object with the normalized name
+func (r Repeatable) defines(name string) bool {
+	return slices.ContainsFunc(r.Objects, func(o RepeatableObject) bool { return o.Name == name })
+}
+
+// LoadRepeatables loads the SQL files in the repeatable directory of a deployments path, in
+// name order. A missing directory has none.
+func LoadRepeatables(deploymentsPath, module string) ([]Repeatable, error) {
+	if deploymentsPath == "" {
+		deploymentsPath = deploymentsDir
+	}
+	dir := filepath.Join(deploymentsPath, repeatableDir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read repeatable directory: %w", err)
+	}
+
+	var repeatables []Repeatable
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		content, err := readPsqlFile(path, func(string) {})
+		if err != nil {
+			return nil, err
+		}
+		repeatable := Repeatable{
+			Name:     strings.TrimSuffix(entry.Name(), ".sql"),
+			Module:   module,
+			Path:     path,
+			Checksum: fmt.Sprintf("%x", sha256.Sum256([]byte(content))),
+			names:    make(map[string]bool),
+		}
+
+		sql := sqlCommentPattern.ReplaceAllString(content, " ")
+		for _, match := range repeatableCreatePattern.FindAllStringSubmatch(sql, -1) {
+			repeatable.Objects = append(repeatable.Objects, RepeatableObject{
+				Kind: strings.ToLower(strings.Join(strings.Fields(match[1]), " ")),
+				Name: normalizeName(match[2]),
+			})
+		}
+		for _, name := range sqlNamePattern.FindAllString(sql, -1) {
+			repeatable.names[normalizeName(name)] = true
+		}
+		repeatables = append(repeatables, repeatable)
+	}
+
+	sort.Slice(repeatables, func(i, j int) bool { return repeatables[i].Name < repeatables[j].Name })
+	return repeatables, nil
+}
+
+// orderRepeatables sorts repeatables so each comes after those defining objects it mentions,
+// e.g. a view after the views it selects from and a trigger after its function. Otherwise
+// they keep their order.
+func orderRepeatables(repeatables []Repeatable) ([]Repeatable, error) {
+	// after[i] lists the repeatables that must be applied before repeatable i
+	after := make([][]int, len(repeatables))
+	for i, repeatable := range repeatables {
+		for j, other := range repeatables {
+			if i != j && slices.ContainsFunc(other.Objects, func(o RepeatableObject) bool { return repeatable.names[o.Name] }) {
+				after[i] = append(after[i], j)
+			}
+		}
+	}
+
+	var ordered []Repeatable
+	placed := make([]bool, len(repeatables))
+	for len(ordered) < len(repeatables) {
+		progressed := false
+		for i, repeatable := range repeatables {
+			if placed[i] || slices.ContainsFunc(after[i], func(j int) bool { return !placed[j] }) {
+				continue
+			}
+			ordered = append(ordered, repeatable)
+			placed[i] = true
+			progressed = true
+			break // Restart, so earlier repeatables go first whenever they can
+		}
+
+		if !progressed {
+			var cycle []string
+			for i, repeatable := range repeatables {
+				if !placed[i] {
+					cycle = append(cycle, repeatable.Key())
+				}
+			}
+			return nil, fmt.Errorf("repeatable migrations %s depend on each other", strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+// planRepeatables loads the repeatables of every module in dependency order and returns those
+// that were never applied or whose checksum differs from the last application. Views they
+// define are dropped and recreated, so repeatables defining views that depend on them in the
+// database are applied again too. A nil db returns all of them.
+func planRepeatables(modules []Module, db DatabaseProvider) ([]Repeatable, error) {
+	var all []Repeatable
+	for _, module := range modules {
+		repeatables, err := LoadRepeatables(module.Path, module.Name)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, repeatables...)
+	}
+	all, err := orderRepeatables(all)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]string)
+	if tracker, ok := db.(RepeatableTracker); ok {
+		records, err := tracker.GetAppliedRepeatables()
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			applied[record.Key()] = record.Checksum
+		}
+	}
+
+	planned := make(map[string]bool)
+	for _, repeatable := range all {
+		if applied[repeatable.Key()] != repeatable.Checksum {
+			planned[repeatable.Key()] = true
+		}
+	}
+
+	if inspector, ok := db.(DependencyInspector); ok {
+		// Adding a repeatable drops its views too, so repeat until no more are added
+		for checked := make(map[string]bool); ; {
+			var views []string
+			var owners []Repeatable
+			for _, repeatable := range all {
+				if !planned[repeatable.Key()] || checked[repeatable.Key()] {
+					continue
+				}
+				checked[repeatable.Key()] = true
+				for _, object := range repeatable.Objects {
+					if strings.HasSuffix(object.Kind, "view") {
+						views = append(views, object.Name)
+						owners = append(owners, repeatable)
+					}
+				}
+			}
+			if len(views) == 0 {
+				break
+			}
+
+			dependents, err := inspector.DependentViews(views)
+			if err != nil {
+				return nil, err
+			}
+			for _, dependent := range dependents {
+				name := normalizeName(dependent)
+				at := slices.IndexFunc(all, func(r Repeatable) bool { return r.defines(name) })
+				if at < 0 {
+					return nil, fmt.Errorf("view %s depends on views of repeatable migrations %s, which are dropped and recreated; define it in a repeatable migration too", dependent, repeatableKeys(owners))
+				}
+				planned[all[at].Key()] = true
+			}
+		}
+	}
+
+	var changed []Repeatable
+	for _, repeatable := range all {
+		if planned[repeatable.Key()] {
+			changed = append(changed, repeatable)
+		}
+	}
+	return changed, nil
+}
+
+// repeatableKeys returns the distinct keys of the repeatables, comma-separated
+func repeatableKeys(repeatables []Repeatable) string {
+	var keys []string
+	for _, repeatable := range repeatables {
+		if !slices.Contains(keys, repeatable.Key()) {
+			keys = append(keys, repeatable.Key())
+		}
+	}
+	return strings.Join(keys, ", ")
+}
+
+// repeatableSQL returns the SQL applying the repeatables, in order: their views are dropped,
+// dependents first, since CREATE OR REPLACE VIEW cannot change a view's columns, and then
+// each file runs. echo receives the text of \echo; nil keeps it in the SQL, for psql.
+func repeatableSQL(repeatables []Repeatable, echo func(string)) (string, error) {
+	var b strings.Builder
+	for i := len(repeatables) - 1; i >= 0; i-- {
+		objects := repeatables[i].Objects
+		for j := len(objects) - 1; j >= 0; j-- {
+			if strings.HasSuffix(objects[j].Kind, "view") {
+				fmt.Fprintf(&b, "DROP %s IF EXISTS %s;\n", strings.ToUpper(objects[j].Kind), quoteIdentifier(objects[j].Name))
+			}
+		}
+	}
+
+	for _, repeatable := range repeatables {
+		content, err := readPsqlFile(repeatable.Path, echo)
+		if err != nil {
+			return "", err
+		}
+		content = strings.TrimSpace(content)
+		fmt.Fprintf(&b, "/* %s repeatable=%s file=%s */\n%s", ApplicationName(), repeatable.Key(), filepath.Base(repeatable.Path), content)
+		// Terminate the last statement, which a file may leave open or end with a comment
+		if !strings.HasSuffix(content, ";") {
+			b.WriteString("\n;")
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// applyRepeatables applies the plan's repeatables in one transaction once its deployments are
+// recorded, so views dropped for recreation are never missing
+func (p *Plan) applyRepeatables() error {
+	if len(p.Repeatables) == 0 {
+		return nil
+	}
+	tracker, ok := p.db.(RepeatableTracker)
+	if !ok {
+		return fmt.Errorf("the database does not support repeatable migrations")
+	}
+
+	for _, repeatable := range p.Repeatables {
+		fmt.Fprintf(p.out(), "Applying repeatable %s: %s\n", repeatable.Key(), repeatable.Path)
+	}
+	sql, err := repeatableSQL(p.Repeatables, func(text string) {
+		fmt.Fprintf(p.out(), "    %s\n", text)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tracker.ApplyRepeatables(p.Repeatables, sql); err != nil {
+		return fmt.Errorf("failed to apply repeatables %s: %w", repeatableKeys(p.Repeatables), err)
+	}
+	return nil
+}
+
+// repeatableStates compares the local repeatables of each module with their last application
+// (if db is a RepeatableTracker), returning the states of each module in name order followed
+// by those recorded but no longer found locally
+func repeatableStates(modules []Module, db DatabaseProvider) ([][]RepeatableState, error) {
+	var records []RepeatableRecord
+	if tracker, ok := db.(RepeatableTracker); ok {
+		var err error
+		records, err = tracker.GetAppliedRepeatables()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get applied repeatables: %w", err)
+		}
+	}
+
+	utc := func(t time.Time) *time.Time {
+		t = t.UTC()
+		return &t
+	}
+
+	states := make([][]RepeatableState, 0, len(modules))
+	for _, module := range modules {
+		repeatables, err := LoadRepeatables(module.Path, module.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		var moduleStates []RepeatableState
+		local := make(map[string]bool)
+		for _, repeatable := range repeatables {
+			local[repeatable.Key()] = true
+			state := RepeatableState{
+				Module:   module.Name,
+				Name:     repeatable.Name,
+				Status:   StatusPending,
+				Checksum: repeatable.Checksum,
+				Objects:  make([]string, 0, len(repeatable.Objects)),
+			}
+			for _, object := range repeatable.Objects {
+				state.Objects = append(state.Objects, object.String())
+			}
+			if at := slices.IndexFunc(records, func(r RepeatableRecord) bool { return r.Key() == repeatable.Key() }); at >= 0 {
+				state.AppliedChecksum = records[at].Checksum
+				state.AppliedAt = utc(records[at].AppliedAt)
+				state.Status = StatusApplied
+				if records[at].Checksum != repeatable.Checksum {
+					state.Status = StatusDrifted
+				}
+			}
+			moduleStates = append(moduleStates, state)
+		}
+
+		for _, record := range records {
+			if record.Module != module.Name || local[record.Key()] {
+				continue
+			}
+			objects := record.Objects
+			if objects == nil {
+				objects = make([]string, 0)
+			}
+			moduleStates = append(moduleStates, RepeatableState{
+				Module:          module.Name,
+				Name:            record.Name,
+				Status:          StatusMissing,
+				AppliedChecksum: record.Checksum,
+				AppliedAt:       utc(record.AppliedAt),
+				Objects:         objects,
+			})
+		}
+		states = append(states, moduleStates)
+	}
+	return states, nil
+}
+
+// printRepeatableStatus outputs the repeatables of a module as a table, if it has any
+func printRepeatableStatus(w io.Writer, states []RepeatableState, style TableStyle) {
+	if len(states) == 0 {
+		return
+	}
+
+	// Checksums are shortened like git commits, which is enough to tell versions apart
+	short := func(checksum string) string {
+		return checksum[:min(len(checksum), 12)]
+	}
+
+	t := newTable("STATUS", "REPEATABLE", "APPLIED", "CHECKSUM", "OBJECTS")
+	drifted, pending := 0, 0
+	for _, state := range states {
+		var cell tableCell
+		checksum := short(state.Checksum)
+		switch state.Status {
+		case StatusApplied:
+			cell = tableCell{text: "✓ applied", color: colorGreen}
+		case StatusDrifted:
+			cell = tableCell{text: "~ drifted", color: colorYellow}
+			checksum = short(state.AppliedChecksum) + " → " + checksum
+			drifted++
+		case StatusPending:
+			cell = tableCell{text: "○ pending", color: colorYellow}
+			pending++
+		case StatusMissing:
+			cell = tableCell{text: "! missing", color: colorRed}
+			checksum = short(state.AppliedChecksum)
+		}
+		t.addRow(
+			cell,
+			tableCell{text: state.Name},
+			tableCell{text: style.formatTime(state.AppliedAt)},
+			tableCell{text: checksum},
+			tableCell{text: strings.Join(state.Objects, ", ")},
+		)
+	}
+
+	fmt.Fprintln(w)
+	t.render(w, style)
+	fmt.Fprintln(w)
+	if drifted+pending == 0 {
+		fmt.Fprintln(w, "All repeatables are up to date!")
+	} else {
+		fmt.Fprintf(w, "%d repeatables drifted, %d pending; the next deploy applies them\n", drifted, pending)
+	}
+}
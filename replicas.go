@@ -0,0 +1,137 @@
+package zdd
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	// DefaultReplicaTimeout is how long a deploy waits for replicas to catch up with the schema
+	DefaultReplicaTimeout = 5 * time.Minute
+	// replicaPollInterval is how often the schema of a lagging replica is compared again
+	replicaPollInterval = 2 * time.Second
+)
+
+type (
+	// ReplicasConfig lists read replicas that must have the primary's schema before a deployment's
+	// contract phase runs, so applications reading from them never see a schema the contract
+	// phase is about to break
+	ReplicasConfig struct {
+		// URLs are connection strings of the replicas
+		URLs []string `yaml:"urls"`
+		// Timeout is how long to wait for each replica to catch up (default 5m)
+		Timeout Duration `yaml:"timeout"`
+	}
+
+	// SchemaHasher is implemented by databases that can fingerprint their schema
+	SchemaHasher interface {
+		// SchemaHash returns a hash of the columns, indexes, constraints, views and enum values of
+		// every schema but the system's and zdd's own
+		SchemaHash() (string, error)
+	}
+
+	// ReplicaConn is a read-only connection to a replica
+	ReplicaConn interface {
+		SchemaHasher
+		Close() error
+	}
+
+	// ReplicaCheck compares the schema of replicas with the primary's before contract phases
+	ReplicaCheck struct {
+		URLs    []string
+		Timeout time.Duration
+		// Connect opens a connection to the replica at url
+		Connect func(url string) (ReplicaConn, error)
+	}
+)
+
+// TimeoutDuration returns timeout, or DefaultReplicaTimeout if it is not set
+func (c ReplicasConfig) TimeoutDuration() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout)
+	}
+	return DefaultReplicaTimeout
+}
+
+// AddReplicaChecks inserts a task comparing the schema of the replicas with the primary's before
+// the contract phase of each pending deployment that has one. The database must be a
+// SchemaHasher. A nil check or one without replicas adds nothing.
+func (p *Plan) AddReplicaChecks(check *ReplicaCheck) error {
+	if check == nil || len(check.URLs) == 0 {
+		return nil
+	}
+	if _, ok := p.db.(SchemaHasher); !ok {
+		return fmt.Errorf("the database cannot compare its schema with replicas; remove the replicas section")
+	}
+	p.Replicas = check
+
+	// A deployment's tasks are contiguous
+	var tasks []Task
+	for start := 0; start < len(p.Tasks); {
+		deployment := p.Tasks[start].Deployment
+		end := start
+		for end < len(p.Tasks) && p.Tasks[end].Deployment == deployment {
+			end++
+		}
+		group := p.Tasks[start:end]
+		start = end
+
+		at := slices.IndexFunc(group, func(t Task) bool { return t.Phase == "contract" })
+		if at < 0 {
+			tasks = append(tasks, group...)
+			continue
+		}
+		tasks = append(tasks, group[:at]...)
+		tasks = append(tasks, Task{TaskType: "replicas", Phase: "contract", Deployment: deployment})
+		tasks = append(tasks, group[at:]...)
+	}
+	p.Tasks = tasks
+	return nil
+}
+
+// checkReplicas waits until every replica has the primary's schema, failing once a replica
+// has lagged behind for the check's timeout
+func (p *Plan) checkReplicas() error {
+	primary, err := p.db.(SchemaHasher).SchemaHash()
+	if err != nil {
+		return fmt.Errorf("failed to hash the schema of the primary: %w", err)
+	}
+
+	for _, url := range p.Replicas.URLs {
+		name := redactConnectionString(url)
+		if err := p.waitForReplica(url, primary); err != nil {
+			return fmt.Errorf("replica %s: %w", name, err)
+		}
+		fmt.Fprintf(p.out(), "  Replica %s has the schema\n", name)
+	}
+	return nil
+}
+
+// waitForReplica polls the replica at url until its schema hash is primary
+func (p *Plan) waitForReplica(url, primary string) error {
+	replica, err := p.Replicas.Connect(url)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer replica.Close()
+
+	timeout := p.Replicas.Timeout
+	if timeout <= 0 {
+		timeout = DefaultReplicaTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		hash, err := replica.SchemaHash()
+		if err != nil {
+			return fmt.Errorf("failed to hash the schema: %w", err)
+		}
+		if hash == primary {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the schema has not caught up with the primary's after %s; contract is not run", timeout)
+		}
+		time.Sleep(replicaPollInterval)
+	}
+}
@@ -0,0 +1,129 @@
+package zdd
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+//go:embed assets/report
+var reportTemplates embed.FS
+
+// ReportFormats are the built-in report templates of `zdd state --report`
+var ReportFormats = []string{"markdown", "html"}
+
+// defaultReportLabels are the English labels of reports, keyed by the names report templates
+// pass to label, including each deployment status
+var defaultReportLabels = map[string]string{
+	"title":       "Deployment report",
+	"up_to_date":  "Up to date",
+	"deployment":  "Deployment",
+	"status":      "Status",
+	"phases":      "Phases",
+	"applied_at":  "Applied at",
+	"run":         "Run",
+	"api_impact":  "Impacted API resources",
+	"resource":    "Resource",
+	"owners":      "Owners",
+	"tables":      "Tables",
+	StatusApplied: "applied",
+	StatusPending: "pending",
+	StatusMissing: "missing",
+	StatusDrifted: "drifted",
+}
+
+// ReportConfig localizes the phase names and labels of reports rendered by `zdd state --report`
+// or a --template
+type ReportConfig struct {
+	// PhaseNames replaces phase names in reports, e.g. {expand: Erweitern}
+	PhaseNames map[string]string `yaml:"phase_names"`
+	// Labels replaces the built-in labels of reports, e.g. {title: Änderungsbericht, pending: ausstehend}
+	Labels map[string]string `yaml:"labels"`
+}
+
+// phaseName returns the report name of a phase
+func (c ReportConfig) phaseName(phase string) string {
+	if name, ok := c.PhaseNames[phase]; ok {
+		return name
+	}
+	return phase
+}
+
+// label returns the report label of key, or key itself if it has none
+func (c ReportConfig) label(key string) string {
+	if label, ok := c.Labels[key]; ok {
+		return label
+	}
+	if label, ok := defaultReportLabels[key]; ok {
+		return label
+	}
+	return key
+}
+
+// WriteReport renders state with a Go template: the built-in one of format (markdown or html)
+// when templatePath is empty, otherwise the template at templatePath, which is HTML-escaped if
+// it ends in .html or .htm. Templates are executed with the State and can call phase to
+// localize a phase name and label to localize a label or status with config.
+func WriteReport(w io.Writer, state *State, format, templatePath string, config ReportConfig) error {
+	name, content, html, err := reportTemplate(format, templatePath)
+	if err != nil {
+		return err
+	}
+
+	funcs := map[string]any{
+		"phase": config.phaseName,
+		"label": config.label,
+		"join":  strings.Join,
+	}
+	if html {
+		tmpl, err := htmltemplate.New(name).Funcs(funcs).Parse(content)
+		if err != nil {
+			return fmt.Errorf("failed to parse report template %s: %w", name, err)
+		}
+		if err := tmpl.Execute(w, state); err != nil {
+			return fmt.Errorf("failed to render report template %s: %w", name, err)
+		}
+		return nil
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Parse(content)
+	if err != nil {
+		return fmt.Errorf("failed to parse report template %s: %w", name, err)
+	}
+	if err := tmpl.Execute(w, state); err != nil {
+		return fmt.Errorf("failed to render report template %s: %w", name, err)
+	}
+	return nil
+}
+
+// reportTemplate returns the name and content of a report template and whether it is HTML
+func reportTemplate(format, templatePath string) (string, string, bool, error) {
+	if templatePath != "" {
+		content, err := os.ReadFile(templatePath)
+		if err != nil {
+			return "", "", false, fmt.Errorf("failed to read report template: %w", err)
+		}
+		ext := strings.ToLower(filepath.Ext(templatePath))
+		return filepath.Base(templatePath), string(content), ext == ".html" || ext == ".htm", nil
+	}
+
+	var name string
+	switch format {
+	case "markdown", "md":
+		name = "report.md"
+	case "html":
+		name = "report.html"
+	default:
+		return "", "", false, fmt.Errorf("unknown report format %q; expected one of %s", format, strings.Join(ReportFormats, ", "))
+	}
+	content, err := reportTemplates.ReadFile("assets/report/" + name)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to read report template %s: %w", name, err)
+	}
+	return name, string(content), format == "html", nil
+}
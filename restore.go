@@ -0,0 +1,41 @@
+package zdd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxRestorePointName is the longest restore point name PostgreSQL accepts
+const maxRestorePointName = 63
+
+// RestorePointCreator is implemented by databases that can mark a point in their write-ahead
+// log for point-in-time recovery to stop at
+type RestorePointCreator interface {
+	CreateRestorePoint(name string) error
+}
+
+// restorePointName returns the name of the restore point created before the deployment's
+// contract phase, e.g. zdd_000042 or zdd_billing_000042
+func restorePointName(deployment Deployment) string {
+	name := "zdd_" + strings.ReplaceAll(deployment.Key(), "/", "_")
+	if len(name) > maxRestorePointName {
+		name = name[:maxRestorePointName]
+	}
+	return name
+}
+
+// createRestorePoint creates a restore point before the deployment's contract phase and sets
+// it on the deployment, which records it when the deployment is applied
+func (p *Plan) createRestorePoint(deployment *Deployment) error {
+	creator, ok := p.db.(RestorePointCreator)
+	if !ok {
+		return fmt.Errorf("the database does not support restore points; unset project.restore_points")
+	}
+	name := restorePointName(*deployment)
+	if err := creator.CreateRestorePoint(name); err != nil {
+		return fmt.Errorf("failed to create a restore point before the contract phase of deployment %s: %w", deployment.Key(), err)
+	}
+	deployment.RestorePoint = name
+	fmt.Fprintf(p.out(), "  Created restore point %s\n", name)
+	return nil
+}
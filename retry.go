@@ -0,0 +1,78 @@
+package zdd
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	// DefaultDeploymentRetries is how many times an idempotent deployment is re-run by default
+	DefaultDeploymentRetries = 3
+	// DefaultRetryDelay is the default wait before the first re-run, doubled for each one after
+	DefaultRetryDelay = time.Second
+)
+
+type (
+	// RetryConfig re-runs deployments that are safe to run again from their first task when one
+	// of their tasks fails on a transient condition, such as a deadlock or serialization failure
+	RetryConfig struct {
+		// Idempotent lists the deployments that may be re-run, by ID or module/ID
+		Idempotent []string `yaml:"idempotent"`
+		// Retries is how many times a deployment is re-run before its failure stops the deploy
+		// (default 3)
+		Retries int `yaml:"retries"`
+		// Delay is the wait before the first re-run, doubled for each one after (default 1s)
+		Delay Duration `yaml:"delay"`
+	}
+
+	// RetryClassifier is implemented by databases that can tell transient failures, which may
+	// succeed when run again, from others
+	RetryClassifier interface {
+		Retryable(err error) bool
+	}
+)
+
+// RetriesCount returns how many times an idempotent deployment is re-run
+func (c RetryConfig) RetriesCount() int {
+	if c.Retries > 0 {
+		return c.Retries
+	}
+	return DefaultDeploymentRetries
+}
+
+// DelayDuration returns the wait before the re-run following the given number of attempts
+func (c RetryConfig) DelayDuration(attempts int) time.Duration {
+	delay := DefaultRetryDelay
+	if c.Delay > 0 {
+		delay = time.Duration(c.Delay)
+	}
+	return delay << (attempts - 1)
+}
+
+// retryFailedTask reports whether the task's deployment is re-run after the task failed with err
+// in duration. If so, the failure is reported, attempts counts the retry, and the retry delay has
+// passed when it returns.
+func (p *Plan) retryFailedTask(task Task, err error, duration time.Duration, attempts map[string]int) bool {
+	key := task.Deployment.Key()
+	if !p.retryDeployment(task.Deployment, err, attempts[key]+1) {
+		return false
+	}
+	attempts[key]++
+	p.addTaskResult(task, TaskFailed, duration, err)
+	delay := p.Retry.DelayDuration(attempts[key])
+	fmt.Fprintf(p.out(), "  Re-running deployment %s in %s after a transient failure (retry %d/%d): %v\n", key, delay, attempts[key], p.Retry.RetriesCount(), err)
+	time.Sleep(delay)
+	return true
+}
+
+// retryDeployment reports whether the deployment may be re-run after failing with err, having
+// been attempted attempts times: it must be listed as idempotent, or have no scripts and only SQL
+// files annotated with `-- zdd:idempotent`
+func (p *Plan) retryDeployment(deployment *Deployment, err error, attempts int) bool {
+	classifier, ok := p.db.(RetryClassifier)
+	if !ok || !classifier.Retryable(err) || attempts > p.Retry.RetriesCount() {
+		return false
+	}
+	return slices.Contains(p.Retry.Idempotent, deployment.Key()) || p.idempotentDeployment(deployment)
+}
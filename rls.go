@@ -0,0 +1,118 @@
+package zdd
+
+import (
+	"embed"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const defaultTenantSetting = "app.tenant_id"
+
+//go:embed assets/rls
+var rlsTemplates embed.FS
+
+type (
+	// RLSConfig is the project's row-level security policy
+	RLSConfig struct {
+		// Required fails a deploy that creates tables without row-level security enabled
+		Required bool `yaml:"required"`
+		// Exclude lists tables (schema.table) exempt from the requirement
+		Exclude []string `yaml:"exclude"`
+	}
+
+	// RLSInspector is implemented by databases that can list tables lacking row-level security
+	RLSInspector interface {
+		// TablesWithoutRLS returns the schema-qualified names of user tables without row-level
+		// security enabled, excluding zdd's own metadata tables
+		TablesWithoutRLS() ([]string, error)
+	}
+
+	// TenantPolicy describes a standard tenant isolation policy on a table
+	TenantPolicy struct {
+		Table  string // Possibly schema-qualified table
+		Column string // Column holding the row's tenant
+		// Setting is the session setting holding the current tenant; default app.tenant_id
+		Setting string
+	}
+)
+
+// CreateTenantPolicyDeployment creates a deployment enabling row-level security with a tenant
+// isolation policy: expand creates the policy, which has no effect until contract enables
+// row-level security on the table
+func CreateTenantPolicyDeployment(module Module, name string, policy TenantPolicy) (*Deployment, error) {
+	if policy.Table == "" || policy.Column == "" {
+		return nil, fmt.Errorf("a tenant policy requires a table and a tenant column")
+	}
+	if policy.Setting == "" {
+		policy.Setting = defaultTenantSetting
+	}
+	if !strings.Contains(policy.Setting, ".") {
+		return nil, fmt.Errorf("tenant setting %q must be a custom setting of the form prefix.name", policy.Setting)
+	}
+	if name == "" {
+		name = sanitizeName("enable_rls_" + policy.Table)
+	}
+
+	bare := policy.Table[strings.LastIndex(policy.Table, ".")+1:]
+	data := map[string]string{
+		"TableName":      policy.Table,
+		"Setting":        policy.Setting,
+		"Table":          quoteIdentifier(policy.Table),
+		"Column":         quoteIdentifier(policy.Column),
+		"Policy":         quoteIdentifier(sanitizeName(bare + "_tenant_isolation")),
+		"SettingLiteral": quoteLiteral(policy.Setting),
+	}
+
+	files, err := renderDeploymentFiles(rlsTemplates, "assets/rls", []string{"expand.sql", "contract.sql"}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return createDeploymentFiles(module, name, files)
+}
+
+// RequireRLS makes Execute fail if the plan creates tables without row-level security, other
+// than those excluded. Tables lacking it before the plan runs are not reported.
+func (p *Plan) RequireRLS(config RLSConfig) error {
+	if !config.Required {
+		return nil
+	}
+
+	inspector, ok := p.db.(RLSInspector)
+	if !ok {
+		return fmt.Errorf("database does not support row-level security checks")
+	}
+
+	existing, err := inspector.TablesWithoutRLS()
+	if err != nil {
+		return err
+	}
+
+	p.requireRLS = true
+	p.rlsExempt = append(existing, config.Exclude...)
+	return nil
+}
+
+// checkRLS reports tables created by the plan without row-level security
+func (p *Plan) checkRLS() error {
+	if !p.requireRLS {
+		return nil
+	}
+
+	tables, err := p.db.(RLSInspector).TablesWithoutRLS()
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, table := range tables {
+		if !slices.Contains(p.rlsExempt, table) {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("row-level security is required but not enabled on new tables: %s (enable it, e.g. with zdd create --enable-rls, or add them to rls.exclude)", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package zdd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+type (
+	// RollbackVerification is the outcome of shadow-applying a deployment and then its revert
+	// SQL to a scratch database
+	RollbackVerification struct {
+		Module       string
+		DeploymentID string
+		Checksum     string // Of the revert SQL verified, its files concatenated in the order they run
+		Passed       bool
+		// Differences are the schema objects the rollback left behind ("+ ...") or did not
+		// restore ("- ..."), or the error that stopped the verification
+		Differences []string
+		VerifiedAt  *time.Time
+	}
+
+	// SchemaSnapshotter is implemented by databases that can describe their schema
+	SchemaSnapshotter interface {
+		// SchemaSnapshot describes each schema object outside zdd's own schemas on a line of its
+		// own, sorted, so that equal schemas have equal snapshots. Given schemas, only the objects
+		// in them are described.
+		SchemaSnapshot(schemas ...string) ([]string, error)
+	}
+
+	// RollbackVerificationRecorder is implemented by databases that record rollback verifications
+	RollbackVerificationRecorder interface {
+		RecordRollbackVerification(verification RollbackVerification) error
+	}
+
+	// DeploymentReverter is implemented by databases that can roll back applied deployments
+	DeploymentReverter interface {
+		// RevertDeployment runs the SQL of each file in turn and deletes the deployment's applied
+		// record in one transaction
+		RevertDeployment(deployment Deployment, sql ...string) error
+	}
+)
+
+// RevertTasks returns the "rollback" tasks undoing the deployment, in the order they run: its
+// revert.sql, or else the <phase>.revert.sql of each phase, last phase first
+func (d Deployment) RevertTasks() []Task {
+	deployment := d
+	if d.RevertPath != "" {
+		return []Task{{TaskType: "rollback", Phase: "rollback", Path: d.RevertPath, Deployment: &deployment}}
+	}
+
+	var tasks []Task
+	phases := d.layout.orDefault().phases
+	for i := len(phases) - 1; i >= 0; i-- {
+		if path := d.Phases[phases[i]].RevertFilePath; path != "" {
+			tasks = append(tasks, Task{TaskType: "rollback", Phase: phases[i], Path: path, Deployment: &deployment})
+		}
+	}
+	return tasks
+}
+
+// HasRevert reports whether the deployment has revert SQL to roll it back with
+func (d Deployment) HasRevert() bool {
+	return len(d.RevertTasks()) > 0
+}
+
+// BuildRollbackPlan returns a plan rolling back the most recently applied deployment of the
+// module, which must have ID id unless id is empty, as later deployments may depend on earlier
+// ones. Its tasks are the deployment's RevertTasks. The plan is run by Rollback, not Execute.
+func BuildRollbackPlan(module Module, db DatabaseProvider, id string) (*Plan, error) {
+	if _, ok := db.(DeploymentReverter); !ok {
+		return nil, fmt.Errorf("database does not support rolling back deployments")
+	}
+	records, err := appliedRecords(db, []string{module.Name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied deployments: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no deployment is applied")
+	}
+	last := records[len(records)-1]
+	if id != "" && id != last.ID {
+		if !slices.ContainsFunc(records, func(r DeploymentDBRecord) bool { return r.ID == id }) {
+			return nil, fmt.Errorf("deployment %s is not applied", deploymentKey(module.Name, id))
+		}
+		return nil, fmt.Errorf("deployment %s is not the most recently applied deployment; roll back %s first", deploymentKey(module.Name, id), last.Key())
+	}
+
+	deployments, err := LoadModuleDeployments(module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local deployments: %w", err)
+	}
+	i := slices.IndexFunc(deployments, func(d Deployment) bool { return d.Key() == last.Key() })
+	if i < 0 {
+		return nil, fmt.Errorf("deployment %s is applied but not found locally", last.Key())
+	}
+	deployment := deployments[i]
+	tasks := deployment.RevertTasks()
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("deployment %s has no %s or <phase>%s; zdd lint --rollbacks can generate a %s if its inverse is provable", deployment.Key(), revertFileName, revertSuffix, revertFileName)
+	}
+
+	return &Plan{
+		Tasks:           tasks,
+		AlreadyDeployed: map[string]bool{deployment.Key(): true},
+		db:              db,
+	}, nil
+}
+
+// Rollback runs the tasks of a plan built by BuildRollbackPlan. The revert SQL of each deployment
+// runs in one transaction with the deletion of its applied record, so it can be deployed again.
+// Scripts, feature flags and the trash are not undone.
+func (p *Plan) Rollback() error {
+	reverter, ok := p.db.(DeploymentReverter)
+	if !ok {
+		return fmt.Errorf("database does not support rolling back deployments")
+	}
+	unlock, err := p.lockDeploys()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	fmt.Fprintf(p.out(), "Run %s\n", RunID())
+	for _, tasks := range deploymentTasks(p.Tasks) {
+		deployment := tasks[0].Deployment
+		fmt.Fprintf(p.out(), "Rolling back deployment %s: %s\n", deployment.Key(), deployment.Name)
+
+		var sql []string
+		for _, task := range tasks {
+			if task.TaskType != "rollback" {
+				return fmt.Errorf("unknown rollback task type: %s", task.TaskType)
+			}
+			fmt.Fprintf(p.out(), "  Executing %s\n", task.Path)
+			content, err := readPsqlFile(task.Path, func(text string) {
+				fmt.Fprintf(p.out(), "    %s\n", text)
+			})
+			if err != nil {
+				return err
+			}
+			sql = append(sql, runCommentHeader(*deployment, task.Path)+content)
+		}
+		if err := reverter.RevertDeployment(*deployment, sql...); err != nil {
+			return fmt.Errorf("failed to roll back deployment %s: %w", deployment.Key(), err)
+		}
+		fmt.Fprintf(p.out(), "Rolled back deployment %s\n", deployment.Key())
+	}
+	return nil
+}
+
+// Key returns the key of the verified deployment
+func (v RollbackVerification) Key() string {
+	return deploymentKey(v.Module, v.DeploymentID)
+}
+
+// VerifyRollbacks checks that the revert SQL of each pending deployment restores the schema it
+// started from. Every deployment of the modules is applied in turn to scratch, an empty
+// database; a deployment with revert SQL is applied, rolled back and applied again, and the
+// schema before it is compared with the schema after its rollback. Scripts are not run, so the
+// schema changes of the SQL alone are verified. Results are recorded in the plan's database if it is a
+// RollbackVerificationRecorder. A rollback that fails or does not restore the schema is a
+// failed verification rather than an error.
+func (p *Plan) VerifyRollbacks(modules []Module, scratch DatabaseProvider) ([]RollbackVerification, error) {
+	verify := make(map[string]bool)
+	for _, task := range p.Tasks {
+		if task.Deployment.HasRevert() {
+			verify[task.Deployment.Key()] = true
+		}
+	}
+	if len(verify) == 0 {
+		return nil, nil
+	}
+
+	snapshotter, ok := scratch.(SchemaSnapshotter)
+	if !ok {
+		return nil, fmt.Errorf("the scratch database cannot describe its schema")
+	}
+	if err := scratch.InitDeploymentSchema(); err != nil {
+		return nil, fmt.Errorf("failed to initialize the scratch database: %w", err)
+	}
+	shadow, err := BuildModulesPlan(modules, scratch)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []RollbackVerification
+	for _, tasks := range deploymentTasks(shadow.Tasks) {
+		if len(results) == len(verify) {
+			break
+		}
+		deployment := tasks[0].Deployment
+		if !verify[deployment.Key()] {
+			if err := p.shadowPlan(tasks, scratch).Execute(); err != nil {
+				return nil, fmt.Errorf("failed to apply deployment %s to the scratch database: %w", deployment.Key(), err)
+			}
+			continue
+		}
+
+		result, err := p.verifyRollback(tasks, scratch, snapshotter)
+		if err != nil {
+			return nil, err
+		}
+		if recorder, ok := p.db.(RollbackVerificationRecorder); ok {
+			if err := recorder.RecordRollbackVerification(result); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, result)
+		// Later deployments cannot be applied to a schema the deployment is missing from
+		if !result.Passed && len(result.Differences) > 0 && result.Differences[0] == reapplyFailed {
+			break
+		}
+	}
+	return results, nil
+}
+
+// reapplyFailed heads the differences of a deployment that could not be applied after its rollback
+const reapplyFailed = "deployment could not be applied again after its rollback"
+
+// verifyRollback applies the deployment's tasks to scratch, runs its revert SQL, compares the
+// schema with the one before, and applies the deployment again for the deployments after it
+func (p *Plan) verifyRollback(tasks []Task, scratch DatabaseProvider, snapshotter SchemaSnapshotter) (RollbackVerification, error) {
+	deployment := tasks[0].Deployment
+	now := time.Now()
+	result := RollbackVerification{Module: deployment.Module, DeploymentID: deployment.ID, VerifiedAt: &now}
+	revertTasks := deployment.RevertTasks()
+	hash := sha256.New()
+	for _, task := range revertTasks {
+		content, err := os.ReadFile(task.Path)
+		if err != nil {
+			return result, fmt.Errorf("failed to read %s: %w", task.Path, err)
+		}
+		hash.Write(content)
+	}
+	result.Checksum = fmt.Sprintf("%x", hash.Sum(nil))
+
+	before, err := snapshotter.SchemaSnapshot()
+	if err != nil {
+		return result, err
+	}
+	if err := p.shadowPlan(tasks, scratch).Execute(); err != nil {
+		return result, fmt.Errorf("failed to apply deployment %s to the scratch database: %w", deployment.Key(), err)
+	}
+
+	var revert []string
+	for _, task := range revertTasks {
+		content, err := readPsqlFile(task.Path, nil)
+		if err != nil {
+			return result, err
+		}
+		revert = append(revert, content)
+	}
+	if err := scratch.ExecuteSQLInTransaction(revert...); err != nil {
+		// The deployment is still applied, so the deployments after it can be verified
+		result.Differences = []string{fmt.Sprintf("revert SQL failed: %v", err)}
+		return result, nil
+	}
+
+	after, err := snapshotter.SchemaSnapshot()
+	if err != nil {
+		return result, err
+	}
+	result.Differences = schemaDifferences(before, after)
+	result.Passed = len(result.Differences) == 0
+
+	forget := fmt.Sprintf("DELETE FROM zdd_deployments.applied_deployments WHERE module = %s AND id = %s", quoteLiteral(deployment.Module), quoteLiteral(deployment.ID))
+	if err := scratch.ExecuteSQLInTransaction(forget); err != nil {
+		return result, fmt.Errorf("failed to reset deployment %s in the scratch database: %w", deployment.Key(), err)
+	}
+	if err := p.shadowPlan(tasks, scratch).Execute(); err != nil {
+		result.Passed = false
+		result.Differences = append([]string{reapplyFailed, err.Error()}, result.Differences...)
+	}
+	return result, nil
+}
+
+// shadowPlan returns a plan applying the SQL of tasks to scratch. Scripts are left out, as
+// they can act outside the database, and so are feature flags, which are shared with the real
+// deployment.
+func (p *Plan) shadowPlan(tasks []Task, scratch DatabaseProvider) *Plan {
+	return &Plan{
+		Tasks: slices.DeleteFunc(slices.Clone(tasks), func(task Task) bool {
+			return task.TaskType == "script" || task.TaskType == "flags"
+		}),
+		AlreadyDeployed: make(map[string]bool),
+		db:              scratch,
+		Runners:         p.Runners,
+		layout:          p.layout,
+		Output:          io.Discard,
+	}
+}
+
+// deploymentTasks groups tasks by deployment, in plan order
+func deploymentTasks(tasks []Task) [][]Task {
+	var groups [][]Task
+	for _, task := range tasks {
+		if n := len(groups); n > 0 && groups[n-1][0].Deployment.Key() == task.Deployment.Key() {
+			groups[n-1] = append(groups[n-1], task)
+			continue
+		}
+		groups = append(groups, []Task{task})
+	}
+	return groups
+}
+
+// schemaDifferences returns the lines of after missing from before as "+ line", then the lines
+// of before missing from after as "- line"
+func schemaDifferences(before, after []string) []string {
+	var differences []string
+	for _, line := range after {
+		if !slices.Contains(before, line) {
+			differences = append(differences, "+ "+line)
+		}
+	}
+	for _, line := range before {
+		if !slices.Contains(after, line) {
+			differences = append(differences, "- "+line)
+		}
+	}
+	return differences
+}
@@ -0,0 +1,114 @@
+package zdd
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Runner names recorded in task history for tasks run by zdd itself
+const (
+	runnerConnection = "connection" // SQL files sent over zdd's database connection
+	runnerExec       = "exec"       // Scripts executed directly
+)
+
+// scriptExtensionPattern matches the file extensions scripts can be configured for
+var scriptExtensionPattern = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// Runners configures external commands that run tasks instead of zdd, from the runners section
+// of zdd.yaml. In commands, {file} is replaced by the task's file, which is appended if no
+// argument contains it, and {url} by the database URL.
+type Runners struct {
+	// SQL runs SQL files, e.g. [psql, "{url}", -X, -v, ON_ERROR_STOP=1, --single-transaction, -f, "{file}"]
+	// for full psql compatibility
+	SQL []string `yaml:"sql"`
+	// Scripts maps file extensions to the command running phase scripts with that extension,
+	// e.g. {py: [python3], js: [node]}. Such scripts need not be executable.
+	Scripts map[string][]string `yaml:"scripts"`
+}
+
+// check reports why the runners cannot be used, if they cannot
+func (r Runners) check() error {
+	if r.SQL != nil && len(r.SQL) == 0 {
+		return fmt.Errorf("runners.sql must name a command")
+	}
+	for extension, command := range r.Scripts {
+		if !scriptExtensionPattern.MatchString(extension) || extension == "sql" {
+			return fmt.Errorf("invalid script extension %q in runners.scripts (expected e.g. py)", extension)
+		}
+		if len(command) == 0 {
+			return fmt.Errorf("runners.scripts.%s must name a command", extension)
+		}
+	}
+	return nil
+}
+
+// script returns the command configured for the script at path, if there is one
+func (r Runners) script(path string) ([]string, bool) {
+	command, ok := r.Scripts[strings.TrimPrefix(filepath.Ext(path), ".")]
+	return command, ok
+}
+
+// taskRunner names what runs a task, as recorded in task history: the base name of the
+// command configured in the plan's runners, or connection or exec for tasks zdd runs itself
+func (p *Plan) taskRunner(task Task) string {
+	switch task.TaskType {
+	case "sql":
+		if len(p.Runners.SQL) > 0 {
+			return filepath.Base(p.Runners.SQL[0])
+		}
+		return runnerConnection
+	case "script":
+		if command, ok := p.Runners.script(task.Path); ok {
+			return filepath.Base(command[0])
+		}
+		return runnerExec
+	}
+	return ""
+}
+
+// runnerArgs expands the placeholders of a runner command for the file and database URL,
+// appending the file if no argument contains it
+func runnerArgs(command []string, file, url string) []string {
+	replacer := strings.NewReplacer("{file}", file, "{url}", url)
+	args := make([]string, 0, len(command)+1)
+	hasFile := false
+	for _, arg := range command {
+		hasFile = hasFile || strings.Contains(arg, "{file}")
+		args = append(args, replacer.Replace(arg))
+	}
+	if !hasFile {
+		args = append(args, file)
+	}
+	return args
+}
+
+// runSQLFile runs a SQL file with the configured SQL runner from the deployment's directory,
+// with the environment of its scripts
+func (p *Plan) runSQLFile(path string, deployment Deployment, phase string, isHead bool) error {
+	args := runnerArgs(p.Runners.SQL, path, p.db.ConnectionString())
+	fmt.Fprintf(p.out(), "  Executing %s SQL file with %s: %s\n", phase, filepath.Base(args[0]), path)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = deployment.Directory
+	cmd.Env = p.Sandbox.environ()
+	for key, value := range p.scriptEnv(deployment, phase, isHead) {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if cmd.ProcessState == nil {
+			return fmt.Errorf("failed to run %s: %w", args[0], err)
+		}
+		return fmt.Errorf("%s failed with exit code %d: %s", filepath.Base(args[0]), cmd.ProcessState.ExitCode(), string(output))
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(p.out(), "    %s\n", line)
+		}
+	}
+	return nil
+}
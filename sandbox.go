@@ -0,0 +1,318 @@
+package zdd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Duration is a time.Duration that is written as a string such as "90s" or "5m" in zdd.yaml
+	Duration time.Duration
+
+	// SandboxConfig constrains how phase scripts are executed
+	SandboxConfig struct {
+		Enabled bool `yaml:"enabled"`
+		// EnvAllowlist names the variables passed through from the zdd process; ZDD_* variables are always set
+		EnvAllowlist []string `yaml:"env_allowlist"`
+		// ReadOnly runs the script from a read-only copy of its deployment directory
+		ReadOnly      bool     `yaml:"read_only"`
+		Timeout       Duration `yaml:"timeout"`
+		MaxMemoryMB   int      `yaml:"max_memory_mb"`
+		MaxCPUSeconds int      `yaml:"max_cpu_seconds"`
+		// Container runs the script inside a container instead of on the host
+		Container *ContainerConfig `yaml:"container"`
+	}
+
+	// ContainerConfig selects a container runtime and image for sandboxed scripts
+	ContainerConfig struct {
+		Runtime string `yaml:"runtime"` // docker, podman, or any CLI compatible with `docker run`; default docker
+		Image   string `yaml:"image"`
+		Network string `yaml:"network"` // e.g. "host" so scripts can reach a local database
+	}
+)
+
+const (
+	// containerWorkdir is where the deployment directory is mounted inside a container
+	containerWorkdir = "/zdd/deployment"
+	// containerShebang runs the script $0 inside a container with the interpreter of its #! line,
+	// as read there, for scripts without the executable bit
+	containerShebang = `IFS= read -r line < "$0"; line=${line%"$(printf '\r')"}; exec ${line#"#!"} "$0"`
+)
+
+// MarshalYAML writes the duration as a string such as "5m0s"
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+// UnmarshalYAML parses a duration string such as "5m"
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return &ConfigError{Line: value.Line, Column: value.Column, Msg: fmt.Sprintf("invalid duration %q: %v", s, err)}
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// timeout returns the sandbox timeout, or fallback if none is configured
+func (s *SandboxConfig) timeout(fallback time.Duration) time.Duration {
+	if s == nil || !s.Enabled || s.Timeout <= 0 {
+		return fallback
+	}
+	return time.Duration(s.Timeout)
+}
+
+// environ returns the base environment for a script before ZDD variables are added
+func (s *SandboxConfig) environ() []string {
+	if s == nil || !s.Enabled {
+		return os.Environ()
+	}
+
+	var env []string
+	for _, key := range s.EnvAllowlist {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	return env
+}
+
+// command builds the command that runs scriptPath from dir with env, with the runner configured
+// for it if there is one. The returned cleanup function removes any temporary files and must
+// always be called.
+func (s *SandboxConfig) command(ctx context.Context, scriptPath string, runner []string, dir string, env []string) (*exec.Cmd, func(), error) {
+	cleanup := func() {}
+	if s == nil || !s.Enabled {
+		args, err := scriptArgs(scriptPath, runner)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = dir
+		cmd.Env = env
+		return cmd, cleanup, nil
+	}
+
+	if s.ReadOnly {
+		copyDir, err := readOnlyCopy(dir)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cleanup = func() { removeReadOnlyCopy(copyDir) }
+
+		rel, err := filepath.Rel(dir, scriptPath)
+		if err != nil {
+			cleanup()
+			return nil, func() {}, fmt.Errorf("failed to resolve script path: %w", err)
+		}
+		dir = copyDir
+		scriptPath = filepath.Join(copyDir, rel)
+	}
+
+	if s.Container != nil {
+		cmd, err := s.containerCommand(ctx, scriptPath, runner, dir, env)
+		if err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+		return cmd, cleanup, nil
+	}
+
+	args, err := scriptArgs(scriptPath, runner)
+	if err != nil {
+		cleanup()
+		return nil, func() {}, err
+	}
+
+	// Apply resource limits through the shell before exec'ing the script
+	var limits []string
+	if s.MaxMemoryMB > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -v %d", s.MaxMemoryMB*1024))
+	}
+	if s.MaxCPUSeconds > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -t %d", s.MaxCPUSeconds))
+	}
+
+	var cmd *exec.Cmd
+	if len(limits) > 0 {
+		script := strings.Join(append(limits, `exec "$0" "$@"`), "; ")
+		cmd = exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", script}, args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+	}
+	cmd.Dir = dir
+	cmd.Env = env
+
+	return cmd, cleanup, nil
+}
+
+// containerCommand runs the script in dir with the configured container runtime, mounting dir
+// read-only when the sandbox is read-only. The script runs with its runner if there is one and
+// otherwise by its #! line, which names an interpreter inside the container, not on the host.
+func (s *SandboxConfig) containerCommand(ctx context.Context, scriptPath string, runner []string, dir string, env []string) (*exec.Cmd, error) {
+	script := "./" + filepath.Base(scriptPath)
+	var command []string
+	if runner != nil {
+		command = append(slices.Clone(runner), script)
+	} else {
+		info, err := os.Stat(scriptPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read script %s: %w", scriptPath, err)
+		}
+		if info.Mode()&0111 != 0 {
+			command = []string{script}
+		} else {
+			// Checked on the host so a missing #! line fails with the usual hint
+			if _, err := shebang(scriptPath); err != nil {
+				return nil, err
+			}
+			command = []string{"/bin/sh", "-c", containerShebang, script}
+		}
+	}
+
+	runtime := s.Container.Runtime
+	if runtime == "" {
+		runtime = "docker"
+	}
+
+	mount := dir + ":" + containerWorkdir
+	if s.ReadOnly {
+		mount += ":ro"
+	}
+
+	args := []string{"run", "--rm", "-v", mount, "-w", containerWorkdir}
+	if s.Container.Network != "" {
+		args = append(args, "--network", s.Container.Network)
+	}
+	if s.MaxMemoryMB > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%dm", s.MaxMemoryMB))
+	}
+	for _, kv := range env {
+		args = append(args, "-e", kv)
+	}
+	args = append(args, s.Container.Image)
+	args = append(args, command...)
+
+	return exec.CommandContext(ctx, runtime, args...), nil
+}
+
+// readOnlyCopy copies dir into a new temporary directory and strips all write permissions.
+// Symlinks are replaced by copies of what they point to, so the copy does not reach outside
+// itself.
+func readOnlyCopy(dir string) (string, error) {
+	target, err := os.MkdirTemp("", "zdd-sandbox-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create sandbox directory: %w", err)
+	}
+
+	if err := copyTree(dir, target, nil); err != nil {
+		removeReadOnlyCopy(target)
+		return "", fmt.Errorf("failed to copy deployment into sandbox: %w", err)
+	}
+
+	err = filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return os.Chmod(path, info.Mode().Perm()&^0222)
+	})
+	if err != nil {
+		removeReadOnlyCopy(target)
+		return "", fmt.Errorf("failed to make sandbox read-only: %w", err)
+	}
+
+	return target, nil
+}
+
+// copyTree copies the contents of the directory src into the existing directory dst, following
+// symlinks. ancestors holds the resolved directories being copied, to refuse symlink loops.
+func copyTree(src, dst string, ancestors []string) error {
+	resolved, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(ancestors, resolved) {
+		return fmt.Errorf("%s: symlink loop", src)
+	}
+	ancestors = append(ancestors, resolved)
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+
+		info, err := os.Stat(srcPath) // Follows symlinks
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			if err := os.Mkdir(dstPath, 0755); err != nil {
+				return err
+			}
+			if err := copyTree(srcPath, dstPath, ancestors); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := copyFile(srcPath, dstPath, info.Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: cannot copy %s", srcPath, info.Mode().Type())
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file src to the new file dst with permissions perm
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// removeReadOnlyCopy restores write permission on a sandbox directory so it can be deleted
+func removeReadOnlyCopy(dir string) {
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = os.Chmod(path, 0755)
+		}
+		return nil
+	})
+	_ = os.RemoveAll(dir)
+}
@@ -0,0 +1,72 @@
+package zdd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// snapshotSchema describes the schema before the deployment runs, if it has post scripts to
+// pass the schema diff to and the database can describe its schema; it returns nil otherwise.
+// current is the schema as last described, if nothing has changed it since.
+func (p *Plan) snapshotSchema(deployment *Deployment, current []string) ([]string, error) {
+	if _, ok := p.db.(SchemaSnapshotter); !ok || len(deployment.Phases["post"].ScriptFilePaths) == 0 {
+		return nil, nil
+	}
+	if current != nil {
+		return current, nil
+	}
+	snapshot, err := p.schemaSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the schema before deployment %s: %w", deployment.Key(), err)
+	}
+	if snapshot == nil {
+		snapshot = make([]string, 0) // An empty schema still has a diff
+	}
+	return snapshot, nil
+}
+
+// writeSchemaDiff writes the differences between the schema before the deployment and now to a
+// temporary file, one "+ object" or "- object" per line, and returns its path, the schema now
+// and the function removing the file. current is the schema now if it is already described.
+func (p *Plan) writeSchemaDiff(deployment *Deployment, before, current []string) (string, []string, func(), error) {
+	after := current
+	if after == nil {
+		var err error
+		if after, err = p.schemaSnapshot(); err != nil {
+			return "", nil, nil, fmt.Errorf("failed to describe the schema after deployment %s: %w", deployment.Key(), err)
+		}
+		if after == nil {
+			after = make([]string, 0)
+		}
+	}
+	file, err := os.CreateTemp("", "zdd-schema-diff-*.txt")
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to create schema diff file: %w", err)
+	}
+	remove := func() { os.Remove(file.Name()) }
+
+	var content strings.Builder
+	for _, line := range schemaDifferences(before, after) {
+		content.WriteString(line + "\n")
+	}
+	if _, err := file.WriteString(content.String()); err != nil {
+		file.Close()
+		remove()
+		return "", nil, nil, fmt.Errorf("failed to write schema diff file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		remove()
+		return "", nil, nil, fmt.Errorf("failed to write schema diff file: %w", err)
+	}
+	return file.Name(), after, remove, nil
+}
+
+// schemaSnapshot describes the schema, only the target schema if the plan has one
+func (p *Plan) schemaSnapshot() ([]string, error) {
+	var schemas []string
+	if p.TargetSchema != "" {
+		schemas = []string{p.TargetSchema}
+	}
+	return p.db.(SchemaSnapshotter).SchemaSnapshot(schemas...)
+}
@@ -0,0 +1,136 @@
+package zdd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// deploymentConfigFileName holds settings of a single deployment, such as its schema hash
+const deploymentConfigFileName = "zdd.yaml"
+
+type (
+	// DeploymentConfig is the content of a deployment's zdd.yaml
+	DeploymentConfig struct {
+		// SchemaHash is the SchemaHash of the schema after the deployment, when every deployment
+		// up to it is applied to an empty database; see GenerateSchemaHashes
+		SchemaHash string `yaml:"schema_hash,omitempty"`
+	}
+
+	// SchemaMismatchError is returned by Execute when the schema after a deployment does not have
+	// the deployment's recorded schema hash
+	SchemaMismatchError struct {
+		Deployment string // Key of the deployment
+		Expected   string
+		Actual     string
+	}
+)
+
+func (e *SchemaMismatchError) Error() string {
+	return fmt.Sprintf("schema after deployment %s has hash %s, not %s as when its deployments are applied to an empty database; "+
+		"the database has objects, or lacks objects, that the deployments do not account for", e.Deployment, e.Actual, e.Expected)
+}
+
+// LoadDeploymentConfig reads a deployment's zdd.yaml at path
+func LoadDeploymentConfig(path string) (*DeploymentConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var config DeploymentConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// SchemaHash returns the sha256 of a SchemaSnapshot
+func SchemaHash(snapshot []string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(snapshot, "\n"))))
+}
+
+// GenerateSchemaHashes applies the deployments of the module one at a time to db, which
+// must be an empty database that can describe its schema, and records the schema hash after
+// each in the deployment's zdd.yaml. It returns the deployments whose hash was added or changed.
+func GenerateSchemaHashes(module Module, db DatabaseProvider) ([]Deployment, error) {
+	snapshotter, ok := db.(SchemaSnapshotter)
+	if !ok {
+		return nil, fmt.Errorf("the scratch database cannot describe its schema")
+	}
+	deployments, err := LoadModuleDeployments(module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local deployments: %w", err)
+	}
+
+	var changed []Deployment
+	for _, deployment := range deployments {
+		// The hash being generated replaces the one recorded, which Execute would check
+		unchecked := deployment
+		unchecked.SchemaHash = ""
+		plan := &Plan{
+			Tasks:           unchecked.Tasks(),
+			AlreadyDeployed: make(map[string]bool),
+			db:              db,
+			Runners:         module.Layout.Runners,
+			Output:          io.Discard,
+		}
+		if err := plan.Execute(); err != nil {
+			return nil, fmt.Errorf("failed to apply deployment %s to the scratch database: %w", deployment.Key(), err)
+		}
+
+		snapshot, err := snapshotter.SchemaSnapshot()
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe the schema after deployment %s: %w", deployment.Key(), err)
+		}
+		if hash := SchemaHash(snapshot); hash != deployment.SchemaHash {
+			deployment.SchemaHash = hash
+			if err := writeDeploymentConfig(deployment); err != nil {
+				return nil, err
+			}
+			changed = append(changed, deployment)
+		}
+	}
+	return changed, nil
+}
+
+// writeDeploymentConfig writes the deployment's zdd.yaml
+func writeDeploymentConfig(deployment Deployment) error {
+	content, err := yaml.Marshal(DeploymentConfig{SchemaHash: deployment.SchemaHash})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", deploymentConfigFileName, err)
+	}
+	path := filepath.Join(deployment.Directory, deploymentConfigFileName)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// checkSchemaHash fails with a SchemaMismatchError if the deployment has a schema hash that the
+// database's schema does not have
+func (p *Plan) checkSchemaHash(deployment *Deployment) error {
+	if deployment.SchemaHash == "" {
+		return nil
+	}
+	snapshotter, ok := p.db.(SchemaSnapshotter)
+	if !ok {
+		return fmt.Errorf("database cannot describe its schema to check the schema hash of deployment %s", deployment.Key())
+	}
+	snapshot, err := snapshotter.SchemaSnapshot()
+	if err != nil {
+		return fmt.Errorf("failed to describe the schema after deployment %s: %w", deployment.Key(), err)
+	}
+	if hash := SchemaHash(snapshot); hash != deployment.SchemaHash {
+		return &SchemaMismatchError{Deployment: deployment.Key(), Expected: deployment.SchemaHash, Actual: hash}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package zdd
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Modes for phase scripts without the executable bit, which is easily lost by Windows checkouts
+// and zip transfers
+const (
+	nonExecutableError       = "error"       // Fail loading the deployment with a hint (the default)
+	nonExecutableInterpreter = "interpreter" // Run the script with the interpreter of its shebang
+	nonExecutableSkip        = "skip"        // Ignore the script
+)
+
+// acceptScript reports whether the phase script at path with the mode runs, failing for scripts
+// without the executable bit or a configured runner unless NonExecutableScripts allows them
+func (l *layout) acceptScript(path string, mode fs.FileMode) (bool, error) {
+	if _, ok := l.Runners.script(path); ok || mode&0111 != 0 {
+		return true, nil
+	}
+
+	switch l.NonExecutableScripts {
+	case nonExecutableSkip:
+		return false, nil
+	case nonExecutableInterpreter:
+		if _, err := shebang(path); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("script %s is not executable; run chmod +x %s (and git update-index --chmod=+x to keep it executable), or set project.non_executable_scripts: interpreter", path, path)
+}
+
+// scriptArgs returns the command line running the script at path: the runner configured for
+// it, the script itself if it is executable, and otherwise the interpreter of its shebang line,
+// followed by the script
+func scriptArgs(path string, runner []string) ([]string, error) {
+	if runner != nil {
+		return append(slices.Clone(runner), path), nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script %s: %w", path, err)
+	}
+	if info.Mode()&0111 != 0 {
+		return []string{path}, nil
+	}
+
+	interpreter, err := shebang(path)
+	if err != nil {
+		return nil, err
+	}
+	return append(interpreter, path), nil
+}
+
+// shebang returns the interpreter and arguments named by the #! line of the script at path
+func shebang(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script %s: %w", path, err)
+	}
+	defer file.Close()
+
+	line, _ := bufio.NewReader(file).ReadString('\n') // A file of one line has no newline
+	line, ok := strings.CutPrefix(strings.TrimRight(line, "\r\n"), "#!")
+	interpreter := strings.Fields(line)
+	if !ok || len(interpreter) == 0 {
+		return nil, fmt.Errorf("script %s is not executable and has no #! line; add one, e.g. #!/bin/sh, or run chmod +x %s", path, path)
+	}
+	return interpreter, nil
+}
@@ -0,0 +1,44 @@
+package zdd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ApplySeeds executes every .sql file in seedsPath in lexical order, each in its own transaction.
+// A missing seeds directory is not an error.
+func ApplySeeds(seedsPath string, db DatabaseProvider) error {
+	entries, err := os.ReadDir(seedsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read seeds directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		files = append(files, filepath.Join(seedsPath, entry.Name()))
+	}
+	sort.Strings(files)
+
+	for _, path := range files {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read seed file %s: %w", path, err)
+		}
+
+		fmt.Printf("  Executing seed file: %s\n", path)
+		if err := db.ExecuteSQLInTransaction(string(content)); err != nil {
+			return fmt.Errorf("failed to execute seed file %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
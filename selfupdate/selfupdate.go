@@ -0,0 +1,223 @@
+package selfupdate
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRepository is the GitHub repository zdd releases are published to
+	DefaultRepository = "mantty/zdd"
+
+	checksumsAsset = "checksums.txt"
+	noticeInterval = 24 * time.Hour
+)
+
+type (
+	// Release is a published GitHub release
+	Release struct {
+		TagName string  `json:"tag_name"`
+		Assets  []Asset `json:"assets"`
+	}
+
+	// Asset is a file attached to a release
+	Asset struct {
+		Name        string `json:"name"`
+		DownloadURL string `json:"browser_download_url"`
+	}
+
+	// noticeCache remembers the last update check so notices hit the network at most daily
+	noticeCache struct {
+		CheckedAt time.Time `json:"checked_at"`
+		Latest    string    `json:"latest"`
+	}
+)
+
+// Version returns the release version without its leading "v"
+func (r *Release) Version() string {
+	return strings.TrimPrefix(r.TagName, "v")
+}
+
+// LatestRelease fetches the latest release of repository (owner/name) from GitHub
+func LatestRelease(ctx context.Context, repository string) (*Release, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repository)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch latest release: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch latest release: %s", resp.Status)
+	}
+
+	var release Release
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("failed to decode release: %w", err)
+	}
+
+	return &release, nil
+}
+
+// AssetName is the release asset holding the binary for the running platform, e.g. zdd_linux_amd64
+func AssetName() string {
+	name := fmt.Sprintf("zdd_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// Update replaces the executable at exePath with the binary for this platform from release.
+// The download is verified against the release's checksums.txt before anything is replaced.
+// This is an integrity check only: it catches corrupt or truncated downloads, but checksums.txt
+// comes from the same release as the binary, so it does not prove who published them.
+func Update(ctx context.Context, release *Release, exePath string) error {
+	var binary, checksums *Asset
+	for i := range release.Assets {
+		switch release.Assets[i].Name {
+		case AssetName():
+			binary = &release.Assets[i]
+		case checksumsAsset:
+			checksums = &release.Assets[i]
+		}
+	}
+
+	if binary == nil {
+		return fmt.Errorf("release %s has no asset %s", release.TagName, AssetName())
+	}
+	if checksums == nil {
+		return fmt.Errorf("release %s has no %s to verify the download", release.TagName, checksumsAsset)
+	}
+
+	expected, err := expectedChecksum(ctx, checksums.DownloadURL, binary.Name)
+	if err != nil {
+		return err
+	}
+
+	// Download next to the executable so the final rename stays on one filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(exePath), ".zdd-update-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	if err := download(ctx, binary.DownloadURL, io.MultiWriter(tmp, hasher)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write update: %w", err)
+	}
+
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", binary.Name, expected, actual)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return fmt.Errorf("failed to make update executable: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), exePath); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exePath, err)
+	}
+
+	return nil
+}
+
+// NewerVersionNotice returns a one-line notice if a release newer than current exists, or "".
+// Results are cached in the user cache directory so the network is consulted at most daily,
+// and any failure is silently ignored so the notice never gets in the way.
+func NewerVersionNotice(ctx context.Context, repository, current string, isNewer func(latest, current string) bool) string {
+	cachePath := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		cachePath = filepath.Join(dir, "zdd", "update-check.json")
+	}
+
+	var cache noticeCache
+	if content, err := os.ReadFile(cachePath); err == nil {
+		_ = json.Unmarshal(content, &cache)
+	}
+
+	if time.Since(cache.CheckedAt) > noticeInterval {
+		release, err := LatestRelease(ctx, repository)
+		if err != nil {
+			return ""
+		}
+
+		cache = noticeCache{CheckedAt: time.Now(), Latest: release.Version()}
+		if cachePath != "" {
+			if content, err := json.Marshal(cache); err == nil {
+				_ = os.MkdirAll(filepath.Dir(cachePath), 0755)
+				_ = os.WriteFile(cachePath, content, 0644)
+			}
+		}
+	}
+
+	if cache.Latest == "" || !isNewer(cache.Latest, current) {
+		return ""
+	}
+
+	return fmt.Sprintf("zdd %s is available (current: %s); run `zdd self-update` to upgrade", cache.Latest, current)
+}
+
+// expectedChecksum finds the sha256 of name in a checksums.txt in `sha256sum` format
+func expectedChecksum(ctx context.Context, url, name string) (string, error) {
+	var buf strings.Builder
+	if err := download(ctx, url, &buf); err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(buf.String()))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("no checksum for %s in %s", name, checksumsAsset)
+}
+
+// download writes the body at url to w
+func download(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	return nil
+}
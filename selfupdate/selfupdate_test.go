@@ -0,0 +1,99 @@
+package selfupdate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRelease serves binary as this platform's asset of a release whose checksums.txt lists
+// checksum for it
+func testRelease(t *testing.T, binary, checksum string) *Release {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + AssetName():
+			_, _ = w.Write([]byte(binary))
+		case "/" + checksumsAsset:
+			_, _ = w.Write([]byte("0000  zdd_other_arch\n" + checksum + "  " + AssetName() + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return &Release{TagName: "v1.2.3", Assets: []Asset{
+		{Name: AssetName(), DownloadURL: server.URL + "/" + AssetName()},
+		{Name: checksumsAsset, DownloadURL: server.URL + "/" + checksumsAsset},
+	}}
+}
+
+// writeExecutable writes the current executable for Update to replace
+func writeExecutable(t *testing.T) string {
+	t.Helper()
+	exePath := filepath.Join(t.TempDir(), "zdd")
+	if err := os.WriteFile(exePath, []byte("old binary"), 0755); err != nil {
+		t.Fatalf("failed to write executable: %v", err)
+	}
+	return exePath
+}
+
+func TestUpdateReplacesBinary(t *testing.T) {
+	sum := sha256.Sum256([]byte("new binary"))
+	release := testRelease(t, "new binary", hex.EncodeToString(sum[:]))
+	exePath := writeExecutable(t)
+
+	if err := Update(context.Background(), release, exePath); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	content, err := os.ReadFile(exePath)
+	if err != nil || string(content) != "new binary" {
+		t.Fatalf("expected the executable to be replaced, got %q, %v", content, err)
+	}
+	info, err := os.Stat(exePath)
+	if err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("expected the new binary to be executable, got %v, %v", info.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(exePath)); len(entries) != 1 {
+		t.Errorf("expected no temporary files left beside the executable, got %d entries", len(entries))
+	}
+}
+
+func TestUpdateRejectsChecksumMismatch(t *testing.T) {
+	sum := sha256.Sum256([]byte("expected binary"))
+	release := testRelease(t, "tampered binary", hex.EncodeToString(sum[:]))
+	exePath := writeExecutable(t)
+
+	err := Update(context.Background(), release, exePath)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	content, err := os.ReadFile(exePath)
+	if err != nil || string(content) != "old binary" {
+		t.Errorf("expected the executable to be left alone, got %q, %v", content, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(exePath)); len(entries) != 1 {
+		t.Errorf("expected the download to be removed, got %d entries", len(entries))
+	}
+}
+
+func TestUpdateRequiresChecksum(t *testing.T) {
+	release := testRelease(t, "new binary", "")
+	release.Assets = release.Assets[:1]
+	if err := Update(context.Background(), release, writeExecutable(t)); err == nil || !strings.Contains(err.Error(), "no checksums.txt") {
+		t.Errorf("expected a release without checksums.txt to be refused, got %v", err)
+	}
+
+	release = testRelease(t, "new binary", "")
+	if err := Update(context.Background(), release, writeExecutable(t)); err == nil || !strings.Contains(err.Error(), "no checksum for") {
+		t.Errorf("expected a binary missing from checksums.txt to be refused, got %v", err)
+	}
+}
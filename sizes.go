@@ -0,0 +1,166 @@
+package zdd
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// dmlTablePattern matches statements writing rows of a table and captures it
+var dmlTablePattern = regexp.MustCompile(`(?is)^(?:UPDATE\s+(?:ONLY\s+)?|INSERT\s+INTO\s+|DELETE\s+FROM\s+(?:ONLY\s+)?)([^\s(]+)`)
+
+type (
+	// RelationSize is the on-disk footprint of a table
+	RelationSize struct {
+		TableBytes int64 `json:"table_bytes"` // Heap and TOAST, as pg_table_size
+		IndexBytes int64 `json:"index_bytes"`
+		// BloatBytes estimates the space held by dead rows: the table's size in the proportion of
+		// its dead to all rows, as last counted by the statistics collector
+		BloatBytes int64 `json:"bloat_bytes"`
+	}
+
+	// RelationSizer is implemented by databases that can report the size of tables
+	RelationSizer interface {
+		// RelationSizes returns the sizes of the named tables, resolved against the search path;
+		// tables that do not exist are left out
+		RelationSizes(tables []string) (map[string]RelationSize, error)
+	}
+
+	// SizeImpact is how a deployment's migrate and contract phases changed the size of a table
+	// its SQL touches
+	SizeImpact struct {
+		Module       string        `json:"module"`
+		DeploymentID string        `json:"deployment_id"`
+		Table        string        `json:"table"`
+		Before       *RelationSize `json:"before"` // nil if the table did not exist
+		After        *RelationSize `json:"after"`  // nil if the table no longer exists
+	}
+)
+
+// touchedTables returns the tables the deployment's SQL files create, alter, index or write
+// rows of, as written in the SQL, in order of first appearance
+func touchedTables(deployment *Deployment) ([]string, error) {
+	var tables, seen []string
+	for _, task := range deployment.Tasks() {
+		if task.TaskType != "sql" {
+			continue
+		}
+		content, err := readPsqlFile(task.Path, func(string) {})
+		if err != nil {
+			return nil, err
+		}
+		for _, statement := range scanStatements(content) {
+			sql := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(statement.SQL, " "))
+			var table string
+			if match := createTablePattern.FindStringSubmatch(sql); match != nil {
+				table = match[1]
+			} else if match := alterTablePattern.FindStringSubmatch(sql); match != nil {
+				table = match[1]
+			} else if match := createIndexPattern.FindStringSubmatch(sql); match != nil {
+				table = match[4]
+			} else if match := dmlTablePattern.FindStringSubmatch(sql); match != nil {
+				table = match[1]
+			}
+			if table != "" && !slices.Contains(seen, normalizeName(table)) {
+				seen = append(seen, normalizeName(table))
+				tables = append(tables, table)
+			}
+		}
+	}
+	return tables, nil
+}
+
+// measureSizes returns the sizes of the tables the deployment's SQL touches
+func (p *Plan) measureSizes(deployment *Deployment) (map[string]RelationSize, error) {
+	sizer, ok := p.db.(RelationSizer)
+	if !ok {
+		return nil, fmt.Errorf("database does not report table sizes; disable size_report")
+	}
+	tables, err := touchedTables(deployment)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := sizer.RelationSizes(tables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure tables of deployment %s: %w", deployment.Key(), err)
+	}
+	return sizes, nil
+}
+
+// reportSizes compares the sizes measured before the deployment's migrate and contract phases
+// with those after, prints the changes and adds them to SizeImpacts
+func (p *Plan) reportSizes(deployment *Deployment, before map[string]RelationSize) error {
+	after, err := p.measureSizes(deployment)
+	if err != nil {
+		return err
+	}
+	tables, err := touchedTables(deployment)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		impact := SizeImpact{Module: deployment.Module, DeploymentID: deployment.ID, Table: normalizeName(table)}
+		if size, ok := before[table]; ok {
+			impact.Before = &size
+		}
+		if size, ok := after[table]; ok {
+			impact.After = &size
+		}
+		if impact.Before == nil && impact.After == nil {
+			continue
+		}
+		p.SizeImpacts = append(p.SizeImpacts, impact)
+		fmt.Fprintf(p.out(), "  Size of %s: %s\n", impact.Table, impact)
+	}
+	return nil
+}
+
+// String summarizes the size changes, e.g. "table 8 MB -> 16 MB (+8 MB), indexes 2 MB -> 2 MB"
+func (i SizeImpact) String() string {
+	var before, after RelationSize
+	if i.Before != nil {
+		before = *i.Before
+	}
+	if i.After != nil {
+		after = *i.After
+	}
+	change := func(name string, from, to int64) string {
+		s := fmt.Sprintf("%s %s -> %s", name, FormatBytes(from), FormatBytes(to))
+		if to != from {
+			sign := "+"
+			if to < from {
+				sign = "-"
+			}
+			s += fmt.Sprintf(" (%s%s)", sign, FormatBytes(max(to-from, from-to)))
+		}
+		return s
+	}
+
+	parts := []string{
+		change("table", before.TableBytes, after.TableBytes),
+		change("indexes", before.IndexBytes, after.IndexBytes),
+		change("est. bloat", before.BloatBytes, after.BloatBytes),
+	}
+	switch {
+	case i.Before == nil:
+		parts = append(parts, "created")
+	case i.After == nil:
+		parts = append(parts, "dropped")
+	case before.TableBytes > 0 && after.TableBytes >= 2*before.TableBytes:
+		parts = append(parts, "table more than doubled")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatBytes renders a size in bytes with a binary unit, e.g. 16 kB, the way pg_size_pretty does
+func FormatBytes(size int64) string {
+	units := []string{"bytes", "kB", "MB", "GB", "TB"}
+	unit := 0
+	for size >= 10*1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%d %s", size, units[unit])
+}
@@ -0,0 +1,93 @@
+package zdd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secrets in configuration snapshots
+const redactedValue = "REDACTED"
+
+var (
+	// secretEnvPattern matches the names of environment variables holding secrets
+	secretEnvPattern = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSWORD|PASSWD|KEY)`)
+	// passwordParamPattern matches the password of a keyword/value connection string
+	passwordParamPattern = regexp.MustCompile(`password=('(?:[^'\\]|\\.)*'|\S*)`)
+)
+
+// ConfigSnapshot is the effective configuration a deploy ran under, recorded with each deployment
+// it applies so later investigations can see the policies in force
+type ConfigSnapshot struct {
+	Version string `json:"zdd_version"`
+	// Config is the merged configuration, with command line flags applied, keyed as in zdd.yaml
+	Config map[string]any `json:"config"`
+	// Environment holds the ZDD_ variables of the process
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+// NewConfigSnapshot returns the JSON snapshot of config and the ZDD_ variables of environ (as
+// returned by os.Environ). Connection string passwords, the Jira token, feature flag headers and
+// variables named like secrets are redacted.
+func NewConfigSnapshot(config Config, environ []string) (json.RawMessage, error) {
+	config.Project.DatabaseURL = redactConnectionString(config.Project.DatabaseURL)
+	if len(config.Replicas.URLs) > 0 {
+		urls := make([]string, len(config.Replicas.URLs))
+		for i, url := range config.Replicas.URLs {
+			urls[i] = redactConnectionString(url)
+		}
+		config.Replicas.URLs = urls
+	}
+	if config.Jira.Token != "" {
+		config.Jira.Token = redactedValue
+	}
+	if len(config.FeatureFlags.Headers) > 0 {
+		config.FeatureFlags.Headers = maps.Clone(config.FeatureFlags.Headers)
+		for name := range config.FeatureFlags.Headers {
+			config.FeatureFlags.Headers[name] = redactedValue
+		}
+	}
+
+	// Round trip through YAML so the snapshot uses the config file's keys
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config snapshot: %w", err)
+	}
+	snapshot := ConfigSnapshot{Version: Version}
+	if err := yaml.Unmarshal(content, &snapshot.Config); err != nil {
+		return nil, fmt.Errorf("failed to encode config snapshot: %w", err)
+	}
+
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, "ZDD_") {
+			continue
+		}
+		if snapshot.Environment == nil {
+			snapshot.Environment = make(map[string]string)
+		}
+		if secretEnvPattern.MatchString(strings.TrimPrefix(name, "ZDD_")) {
+			value = redactedValue
+		}
+		snapshot.Environment[name] = redactConnectionString(value)
+	}
+
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config snapshot: %w", err)
+	}
+	return encoded, nil
+}
+
+// redactConnectionString replaces the password of a URL or keyword/value connection string
+func redactConnectionString(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.User != nil {
+		return u.Redacted()
+	}
+	return passwordParamPattern.ReplaceAllString(value, "password="+redactedValue)
+}
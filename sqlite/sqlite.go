@@ -0,0 +1,297 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mantty/zdd"
+	sqlite3 "modernc.org/sqlite"
+	sqlite3lib "modernc.org/sqlite/lib"
+)
+
+// DB wraps a SQLite database file and implements zdd.DatabaseProvider. It keeps the applied
+// deployments in a zdd_applied_deployments table of the same file. Capabilities that need a
+// server, such as the deploy lock, run tracking and schema inspection, are not available.
+type DB struct {
+	db      *sql.DB
+	ctx     context.Context
+	connStr string
+	runID   string // Identifies this process in the deployments it records
+}
+
+// appliedAtLayout sorts applied_at values as text in the order they were recorded
+const appliedAtLayout = "2006-01-02T15:04:05.000000000Z"
+
+// IsURL reports whether databaseURL names a SQLite database, as sqlite:path or sqlite://path
+func IsURL(databaseURL string) bool {
+	return strings.HasPrefix(databaseURL, "sqlite:")
+}
+
+// NewDB opens the SQLite database file named by a sqlite:path or sqlite://path URL, creating
+// it if it does not exist; sqlite:///tmp/dev.db is an absolute path
+func NewDB(ctx context.Context, databaseURL string) (*DB, error) {
+	if !IsURL(databaseURL) {
+		return nil, fmt.Errorf("invalid SQLite database URL %q (expected sqlite:path or sqlite://path)", databaseURL)
+	}
+	path := strings.TrimPrefix(strings.TrimPrefix(databaseURL, "sqlite:"), "//")
+	if path == "" {
+		return nil, fmt.Errorf("invalid SQLite database URL %q: no file path", databaseURL)
+	}
+
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// One connection keeps the pragmas in force and writes serialized
+	conn.SetMaxOpenConns(1)
+
+	db := &DB{db: conn, ctx: ctx, connStr: databaseURL, runID: zdd.RunID()}
+	for _, pragma := range []string{"PRAGMA foreign_keys = ON", "PRAGMA busy_timeout = 5000"} {
+		if _, err := conn.ExecContext(ctx, pragma); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+	}
+	if err := db.InitDeploymentSchema(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Close closes the database
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// ConnectionString returns the database URL
+func (db *DB) ConnectionString() string {
+	return db.connStr
+}
+
+// SetRunID identifies this process by id in the deployments it records, instead of zdd.RunID()
+func (db *DB) SetRunID(id string) {
+	db.runID = id
+}
+
+// InitDeploymentSchema creates the table of applied deployments if it does not exist
+func (db *DB) InitDeploymentSchema() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS zdd_applied_deployments (
+			module TEXT NOT NULL DEFAULT '',
+			id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL,
+			checksum TEXT NOT NULL DEFAULT '',
+			skipped_tasks TEXT NOT NULL DEFAULT '[]',
+			ticket TEXT NOT NULL DEFAULT '',
+			config TEXT,
+			run_id TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (module, id)
+		)
+	`
+	if _, err := db.db.ExecContext(db.ctx, query); err != nil {
+		return fmt.Errorf("failed to create deployment schema: %w", err)
+	}
+	return nil
+}
+
+// GetAppliedDeployments returns all deployments that have been applied to the database
+func (db *DB) GetAppliedDeployments() ([]zdd.DeploymentDBRecord, error) {
+	return db.queryAppliedDeployments("", "")
+}
+
+// GetModuleAppliedDeployments returns the applied deployments of the modules, oldest first
+func (db *DB) GetModuleAppliedDeployments(modules []string) ([]zdd.DeploymentDBRecord, error) {
+	if len(modules) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(modules))
+	for i, module := range modules {
+		args[i] = module
+	}
+	return db.queryAppliedDeployments("WHERE module IN (?"+strings.Repeat(", ?", len(modules)-1)+")", "", args...)
+}
+
+// GetLastAppliedDeployment returns the most recently applied deployment
+func (db *DB) GetLastAppliedDeployment() (*zdd.DeploymentDBRecord, error) {
+	deployments, err := db.queryAppliedDeployments("", "DESC LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	if len(deployments) == 0 {
+		return nil, nil // No deployments applied yet
+	}
+	return &deployments[0], nil
+}
+
+// queryAppliedDeployments returns the applied deployments matching the conditions, oldest first
+// unless order says otherwise
+func (db *DB) queryAppliedDeployments(conditions, order string, args ...any) ([]zdd.DeploymentDBRecord, error) {
+	query := `
+		SELECT id, name, module, applied_at, checksum, skipped_tasks, ticket, config, run_id
+		FROM zdd_applied_deployments ` + conditions + `
+		ORDER BY applied_at ` + order
+
+	rows, err := db.db.QueryContext(db.ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied deployments: %w", err)
+	}
+	defer rows.Close()
+
+	var deployments []zdd.DeploymentDBRecord
+	for rows.Next() {
+		var (
+			d                  zdd.DeploymentDBRecord
+			appliedAt, skipped string
+			config             sql.NullString
+		)
+		if err := rows.Scan(&d.ID, &d.Name, &d.Module, &appliedAt, &d.Checksum, &skipped, &d.Ticket, &config, &d.RunID); err != nil {
+			return nil, fmt.Errorf("failed to scan deployment record: %w", err)
+		}
+		if d.AppliedAt, err = time.Parse(appliedAtLayout, appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse applied_at of deployment %s: %w", d.ID, err)
+		}
+		if err := json.Unmarshal([]byte(skipped), &d.SkippedTasks); err != nil {
+			return nil, fmt.Errorf("failed to parse skipped_tasks of deployment %s: %w", d.ID, err)
+		}
+		if config.Valid {
+			d.ConfigSnapshot = json.RawMessage(config.String)
+		}
+		deployments = append(deployments, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating deployment records: %w", err)
+	}
+
+	return deployments, nil
+}
+
+// AppliedDeploymentIDs returns up to limit IDs of the module's applied deployments after the
+// cursor after, in ID order
+func (db *DB) AppliedDeploymentIDs(module, after string, limit int) ([]string, error) {
+	query := `
+		SELECT id FROM zdd_applied_deployments
+		WHERE module = ? AND id > ?
+		ORDER BY id
+		LIMIT ?
+	`
+
+	rows, err := db.db.QueryContext(db.ctx, query, module, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied deployment IDs: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read applied deployment IDs: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied deployment IDs: %w", err)
+	}
+	return ids, nil
+}
+
+// RecordDeployment records that a deployment has been applied. It fails if the deployment is
+// already recorded.
+func (db *DB) RecordDeployment(deployment zdd.Deployment, checksum string) error {
+	query := `
+		INSERT INTO zdd_applied_deployments (id, name, module, applied_at, checksum, skipped_tasks, ticket, config, run_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	skipped, err := json.Marshal(deployment.SkippedTasks)
+	if err != nil {
+		return fmt.Errorf("failed to encode skipped tasks: %w", err)
+	}
+	if deployment.SkippedTasks == nil {
+		skipped = []byte("[]")
+	}
+	var ticket string
+	if deployment.Ticket != nil {
+		ticket = deployment.Ticket.Key
+	}
+	var config any
+	if len(deployment.ConfigSnapshot) > 0 {
+		config = string(deployment.ConfigSnapshot)
+	}
+
+	appliedAt := time.Now().UTC().Format(appliedAtLayout)
+	if _, err := db.db.ExecContext(db.ctx, query, deployment.ID, deployment.Name, deployment.Module, appliedAt, checksum, string(skipped), ticket, config, db.runID); err != nil {
+		if isConstraintError(err) {
+			return fmt.Errorf("deployment %s is already recorded as applied", deployment.Key())
+		}
+		return fmt.Errorf("failed to record deployment %s: %w", deployment.Key(), err)
+	}
+
+	return nil
+}
+
+// ExecuteSQLInTransaction executes SQL statements within a transaction
+func (db *DB) ExecuteSQLInTransaction(sqlStatements ...string) error {
+	tx, err := db.db.BeginTx(db.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	for i, statement := range sqlStatements {
+		if _, err := tx.ExecContext(db.ctx, statement); err != nil {
+			return fmt.Errorf("failed to execute SQL statement %d: %w", i+1, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// RevertDeployment runs the revert SQL of a deployment, one file's SQL after another, and deletes
+// its applied record in one transaction
+func (db *DB) RevertDeployment(deployment zdd.Deployment, sql ...string) error {
+	tx, err := db.db.BeginTx(db.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Will be ignored if transaction is committed
+
+	for _, statement := range sql {
+		if _, err := tx.ExecContext(db.ctx, statement); err != nil {
+			return fmt.Errorf("failed to execute revert SQL: %w", err)
+		}
+	}
+
+	result, err := tx.ExecContext(db.ctx, "DELETE FROM zdd_applied_deployments WHERE module = ? AND id = ?", deployment.Module, deployment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete deployment record: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		return fmt.Errorf("deployment %s is not recorded as applied", deployment.Key())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// isConstraintError reports whether err is a SQLite constraint violation
+func isConstraintError(err error) bool {
+	var sqliteErr *sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3lib.SQLITE_CONSTRAINT
+}
@@ -0,0 +1,131 @@
+package sqlite
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mantty/zdd"
+)
+
+func TestNewDBRejectsOtherURLs(t *testing.T) {
+	for _, databaseURL := range []string{"postgres://localhost/app", "sqlite:", "sqlite://"} {
+		if _, err := NewDB(context.Background(), databaseURL); err == nil {
+			t.Errorf("expected %q to be rejected", databaseURL)
+		}
+	}
+}
+
+func TestDeployAndRevert(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(context.Background(), "sqlite://"+filepath.Join(dir, "dev.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	deploymentsDir := filepath.Join(dir, "deployments")
+	files := map[string]string{
+		"000001_create_users/expand.sql":       "CREATE TABLE users (id INTEGER PRIMARY KEY);\nCREATE INDEX users_id ON users (id);\n",
+		"000001_create_users/revert.sql":       "DROP TABLE users;\n",
+		"000002_add_email/expand.sql":          "ALTER TABLE users ADD COLUMN email TEXT;\n",
+		"000002_add_email/contract.sql":        "CREATE TABLE audit (id INTEGER);\n",
+		"000002_add_email/expand.revert.sql":   "ALTER TABLE users DROP COLUMN email;\n",
+		"000002_add_email/contract.revert.sql": "DROP TABLE audit;\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(deploymentsDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	plan, err := zdd.BuildPlan(deploymentsDir, db)
+	if err != nil {
+		t.Fatalf("failed to build plan: %v", err)
+	}
+	plan.Output = io.Discard
+	if err := plan.Execute(); err != nil {
+		t.Fatalf("failed to execute plan: %v", err)
+	}
+
+	applied, err := db.GetAppliedDeployments()
+	if err != nil {
+		t.Fatalf("failed to get applied deployments: %v", err)
+	}
+	if len(applied) != 2 || applied[0].ID != "000001" || applied[1].ID != "000002" || applied[0].Checksum == "" {
+		t.Fatalf("expected both deployments recorded in order with checksums, got %+v", applied)
+	}
+	if applied[0].RunID != zdd.RunID() {
+		t.Errorf("expected the deployments recorded with the run ID %s, got %q", zdd.RunID(), applied[0].RunID)
+	}
+	if err := db.ExecuteSQLInTransaction("INSERT INTO users (id, email) VALUES (1, 'a@example.com')"); err != nil {
+		t.Errorf("expected the deployments to be applied: %v", err)
+	}
+
+	last, err := db.GetLastAppliedDeployment()
+	if err != nil || last == nil || last.ID != "000002" {
+		t.Errorf("expected 000002 as the last applied deployment, got %+v, %v", last, err)
+	}
+	ids, err := db.AppliedDeploymentIDs("", "000001", 10)
+	if err != nil || len(ids) != 1 || ids[0] != "000002" {
+		t.Errorf("expected the IDs after 000001 to be [000002], got %v, %v", ids, err)
+	}
+
+	plan, err = zdd.BuildPlan(deploymentsDir, db)
+	if err != nil {
+		t.Fatalf("failed to build plan: %v", err)
+	}
+	if len(plan.Tasks) != 0 {
+		t.Errorf("expected nothing pending after the deploy, got %d tasks", len(plan.Tasks))
+	}
+
+	deployments, err := zdd.LoadDeployments(deploymentsDir)
+	if err != nil {
+		t.Fatalf("failed to load deployments: %v", err)
+	}
+	if err := db.RecordDeployment(deployments[0], "checksum"); err == nil || !strings.Contains(err.Error(), "already recorded") {
+		t.Errorf("expected recording an applied deployment again to fail, got %v", err)
+	}
+
+	// 000002 is undone by its per-phase reverts, contract first
+	rollback, err := zdd.BuildRollbackPlan(zdd.Module{Path: deploymentsDir}, db, "")
+	if err != nil {
+		t.Fatalf("failed to build rollback plan: %v", err)
+	}
+	if len(rollback.Tasks) != 2 || rollback.Tasks[0].Phase != "contract" || rollback.Tasks[1].Phase != "expand" {
+		t.Fatalf("expected the contract then expand reverts of 000002, got %+v", rollback.Tasks)
+	}
+	rollback.Output = io.Discard
+	if err := rollback.Rollback(); err != nil {
+		t.Fatalf("failed to roll back: %v", err)
+	}
+	if err := db.ExecuteSQLInTransaction("SELECT email FROM users"); err == nil {
+		t.Error("expected the rollback to drop users.email")
+	}
+	if err := db.ExecuteSQLInTransaction("SELECT 1 FROM audit"); err == nil {
+		t.Error("expected the rollback to drop audit")
+	}
+
+	if err := db.RevertDeployment(deployments[0], "DROP TABLE users;"); err != nil {
+		t.Fatalf("failed to revert deployment: %v", err)
+	}
+	if err := db.ExecuteSQLInTransaction("SELECT 1 FROM users"); err == nil {
+		t.Error("expected the reverted deployment's table to be dropped")
+	}
+	modules, err := db.GetModuleAppliedDeployments([]string{""})
+	if err != nil || len(modules) != 0 {
+		t.Errorf("expected no deployments to remain applied, got %+v, %v", modules, err)
+	}
+	if err := db.RevertDeployment(deployments[0], "SELECT 1;"); err == nil {
+		t.Error("expected reverting a deployment that is not applied to fail")
+	}
+}
@@ -0,0 +1,221 @@
+package zdd
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// Deployment statuses reported in State
+const (
+	StatusApplied = "applied"
+	StatusPending = "pending"
+	StatusMissing = "missing"
+	// StatusDrifted is a repeatable migration whose local SQL changed since it was last applied
+	StatusDrifted = "drifted"
+)
+
+// Task statuses reported in TaskResult
+const (
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+	TaskSkipped   = "skipped"
+)
+
+type (
+	// State is the machine-readable deployment state. Its JSON form is a stable contract for
+	// tools wrapping zdd (e.g. a Terraform external data source): fields are only ever added.
+	State struct {
+		Deployments []DeploymentState `json:"deployments"`
+		Pending     int               `json:"pending"`
+		Missing     int               `json:"missing"`
+		// Drifted counts applied deployments whose SQL files changed since they were applied;
+		// they are not counted in UpToDate
+		Drifted  int  `json:"drifted"`
+		UpToDate bool `json:"up_to_date"`
+		// Repeatables are not counted in Pending, Missing or UpToDate
+		Repeatables []RepeatableState `json:"repeatables"`
+		// RunID identifies the zdd invocation that built the state
+		RunID string `json:"run_id"`
+		// APIImpacts are the API resources built on tables pending deployments change, when an
+		// api_impact mapping is configured
+		APIImpacts []APIImpact `json:"api_impacts,omitempty"`
+	}
+
+	// DeploymentState describes a single deployment within State
+	DeploymentState struct {
+		Module       string     `json:"module"`
+		ID           string     `json:"id"`
+		Name         string     `json:"name"`
+		Status       string     `json:"status"` // applied, pending or missing
+		AppliedAt    *time.Time `json:"applied_at"`
+		SkippedTasks []string   `json:"skipped_tasks"`
+		Ticket       string     `json:"ticket,omitempty"` // Key of the deployment's ticket
+		// RestorePoint names the restore point created before the deployment's contract phase
+		RestorePoint string `json:"restore_point,omitempty"`
+		// Phases lists the deployment's phases in execution order; empty when it is missing
+		Phases []string `json:"phases"`
+		// Config is the configuration snapshot recorded when the deployment was applied
+		Config json.RawMessage `json:"config,omitempty"`
+		// RunID identifies the zdd invocation that applied the deployment
+		RunID string `json:"run_id,omitempty"`
+		// Checksum is the CalculateChecksum of the local deployment; empty when it is missing
+		Checksum string `json:"checksum,omitempty"`
+		// AppliedChecksum is the checksum recorded when the deployment was applied, if any
+		AppliedChecksum string `json:"applied_checksum,omitempty"`
+		// Drifted is true when the deployment was applied with a different checksum than it has now
+		Drifted bool `json:"drifted"`
+	}
+
+	// RepeatableState describes a repeatable migration within State
+	RepeatableState struct {
+		Module          string     `json:"module"`
+		Name            string     `json:"name"`
+		Status          string     `json:"status"`   // applied, drifted, pending or missing
+		Checksum        string     `json:"checksum"` // Of the local SQL; empty when missing
+		AppliedChecksum string     `json:"applied_checksum"`
+		AppliedAt       *time.Time `json:"applied_at"`
+		Objects         []string   `json:"objects"` // e.g. "view reports.daily"
+	}
+
+	// ApplyResult is the machine-readable outcome of applying a plan
+	ApplyResult struct {
+		Applied []DeploymentState `json:"applied"`
+		State   *State            `json:"state"`
+		// Tests are the results of the pgTAP test files the deploy ran
+		Tests []TAPResult `json:"tests,omitempty"`
+		// Sizes are the table size changes measured with size_report
+		Sizes []SizeImpact `json:"sizes,omitempty"`
+		// Tasks are the tasks the deploy ran or skipped, in order
+		Tasks []TaskResult `json:"tasks"`
+		// Error is why the deploy failed, if it did
+		Error string `json:"error,omitempty"`
+	}
+
+	// TaskResult is the outcome of a task run by Plan.Execute
+	TaskResult struct {
+		Deployment string `json:"deployment"` // Key of the task's deployment
+		Task       string `json:"task"`       // e.g. expand:sql
+		Path       string `json:"path"`
+		Status     string `json:"status"` // succeeded, failed or skipped
+		DurationMS int64  `json:"duration_ms"`
+		Error      string `json:"error,omitempty"`
+	}
+)
+
+// BuildState compares the deployments of every module with the database
+func BuildState(modules []Module, db DatabaseProvider) (*State, error) {
+	statuses, err := moduleStatuses(modules, db)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{Deployments: make([]DeploymentState, 0), RunID: RunID()}
+	for _, status := range statuses {
+		for _, d := range status.Applied {
+			deployment := newDeploymentState(d, StatusApplied)
+			if deployment.Drifted {
+				state.Drifted++
+			}
+			state.Deployments = append(state.Deployments, deployment)
+		}
+		for _, d := range status.Pending {
+			state.Deployments = append(state.Deployments, newDeploymentState(d, StatusPending))
+		}
+		for _, d := range status.Missing {
+			state.Deployments = append(state.Deployments, newDeploymentState(d, StatusMissing))
+		}
+		state.Pending += len(status.Pending)
+		state.Missing += len(status.Missing)
+	}
+	state.UpToDate = state.Pending == 0 && state.Missing == 0
+
+	repeatables, err := repeatableStates(modules, db)
+	if err != nil {
+		return nil, err
+	}
+	state.Repeatables = make([]RepeatableState, 0)
+	for _, states := range repeatables {
+		state.Repeatables = append(state.Repeatables, states...)
+	}
+
+	return state, nil
+}
+
+// LoadState loads the deployments at source, a deployments directory, and compares them with
+// the database, which may be nil to list them all as pending. It is the library form of
+// `zdd state`, for embedders that present the state themselves instead of with ListDeployments.
+func LoadState(ctx context.Context, source string, db DatabaseProvider) (*State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return BuildState([]Module{{Path: source}}, db)
+}
+
+// NewApplyResult describes the deployments applied by an executed plan together with the resulting state,
+// which is nil if it could not be read
+func NewApplyResult(plan *Plan, state *State) *ApplyResult {
+	appliedAt := make(map[string]*time.Time)
+	if state != nil {
+		for _, d := range state.Deployments {
+			appliedAt[deploymentKey(d.Module, d.ID)] = d.AppliedAt
+		}
+	}
+
+	result := &ApplyResult{Applied: make([]DeploymentState, 0, len(plan.Applied)), State: state, Tests: plan.TAPResults, Sizes: plan.SizeImpacts, Tasks: plan.TaskResults}
+	if result.Tasks == nil {
+		result.Tasks = make([]TaskResult, 0)
+	}
+	for _, d := range plan.Applied {
+		d.AppliedAt = appliedAt[d.Key()]
+		result.Applied = append(result.Applied, newDeploymentState(d, StatusApplied))
+	}
+	return result
+}
+
+func newDeploymentState(d Deployment, status string) DeploymentState {
+	skipped := d.SkippedTasks
+	if skipped == nil {
+		skipped = make([]string, 0)
+	}
+
+	// Timestamps are always reported in UTC so output does not depend on the machine's timezone
+	var appliedAt *time.Time
+	if d.AppliedAt != nil {
+		utc := d.AppliedAt.UTC()
+		appliedAt = &utc
+	}
+
+	state := DeploymentState{
+		Module:       d.Module,
+		ID:           d.ID,
+		Name:         d.Name,
+		Status:       status,
+		AppliedAt:    appliedAt,
+		SkippedTasks: skipped,
+		RestorePoint: d.RestorePoint,
+		Config:       d.ConfigSnapshot,
+		RunID:        d.RunID,
+	}
+	state.AppliedChecksum = d.AppliedChecksum
+	if status != StatusMissing {
+		state.Checksum = CalculateChecksum(d)
+		state.Drifted = status == StatusApplied && d.AppliedChecksum != "" && d.AppliedChecksum != state.Checksum
+	}
+	if d.Ticket != nil {
+		state.Ticket = d.Ticket.Key
+	}
+	state.Phases = make([]string, 0)
+	for _, task := range d.Tasks() {
+		if !slices.Contains(state.Phases, task.Phase) {
+			state.Phases = append(state.Phases, task.Phase)
+		}
+	}
+	return state
+}
+
+// Key returns the deployment's key, e.g. 000042 or billing/000042
+func (d DeploymentState) Key() string {
+	return deploymentKey(d.Module, d.ID)
+}
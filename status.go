@@ -0,0 +1,75 @@
+package zdd
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Policy decides which differences between local and applied deployments ComputeStatus
+	// accepts. The zero Policy accepts none.
+	Policy struct {
+		// AllowOutOfOrder accepts pending deployments whose ID sorts before the last applied
+		// deployment of their module, e.g. one merged from a branch after a later one was deployed
+		AllowOutOfOrder bool
+		// AllowMissing accepts applied deployments that no longer exist locally
+		AllowMissing bool
+	}
+
+	// Status compares local deployments with applied ones, as computed by ComputeStatus
+	Status struct {
+		DeploymentStatus
+		// OutOfOrder are the pending deployments whose ID sorts before the last applied
+		// deployment of their module, in the order of Pending
+		OutOfOrder []Deployment
+	}
+)
+
+// ComputeStatus classifies the local deployments as applied or pending against the applied
+// records, and finds the applied deployments missing locally and the pending ones out of order.
+// It does no I/O and does not modify its arguments. Deployments sharing a key, in local or in
+// applied, are an error; out-of-order and missing deployments are errors unless the policy
+// allows them, in which case the status is returned along with the error.
+func ComputeStatus(local []Deployment, applied []DeploymentDBRecord, policy Policy) (Status, error) {
+	localKeys := make(map[string]bool, len(local))
+	for _, deployment := range local {
+		if localKeys[deployment.Key()] {
+			return Status{}, fmt.Errorf("deployment %s exists more than once locally", deployment.Key())
+		}
+		localKeys[deployment.Key()] = true
+	}
+	appliedKeys := make(map[string]bool, len(applied))
+	lastApplied := make(map[string]string) // module -> highest applied ID
+	for _, record := range applied {
+		if appliedKeys[record.Key()] {
+			return Status{}, fmt.Errorf("deployment %s is recorded as applied more than once", record.Key())
+		}
+		appliedKeys[record.Key()] = true
+		if record.ID > lastApplied[record.Module] {
+			lastApplied[record.Module] = record.ID
+		}
+	}
+
+	status := Status{DeploymentStatus: *CompareDeployments(local, applied)}
+	for _, deployment := range status.Pending {
+		if last, ok := lastApplied[deployment.Module]; ok && deployment.ID < last {
+			status.OutOfOrder = append(status.OutOfOrder, deployment)
+		}
+	}
+
+	if len(status.OutOfOrder) > 0 && !policy.AllowOutOfOrder {
+		keys := make([]string, len(status.OutOfOrder))
+		for i, deployment := range status.OutOfOrder {
+			keys[i] = deployment.Key()
+		}
+		return status, fmt.Errorf("pending deployments %s sort before the last applied deployment of their module", strings.Join(keys, ", "))
+	}
+	if len(status.Missing) > 0 && !policy.AllowMissing {
+		keys := make([]string, len(status.Missing))
+		for i, deployment := range status.Missing {
+			keys[i] = deployment.Key()
+		}
+		return status, fmt.Errorf("applied deployments %s do not exist locally", strings.Join(keys, ", "))
+	}
+	return status, nil
+}
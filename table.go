@@ -0,0 +1,93 @@
+package zdd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ANSI colors used to highlight deployment statuses
+const (
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorRed    = "\033[31m"
+	colorBold   = "\033[1m"
+	colorReset  = "\033[0m"
+)
+
+type (
+	// TableStyle controls how status tables are rendered
+	TableStyle struct {
+		// Color highlights statuses with ANSI colors; leave false when writing to a file or pipe
+		Color bool
+		// UTC renders timestamps in UTC as RFC3339 instead of local time
+		UTC bool
+	}
+
+	// table renders rows as left-aligned columns
+	table struct {
+		headers []string
+		rows    [][]tableCell
+	}
+
+	tableCell struct {
+		text  string
+		color string // ANSI color applied when the style enables color
+	}
+)
+
+// formatTime renders t in local time with its zone, or in UTC as RFC3339
+func (s TableStyle) formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	if s.UTC {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return t.Local().Format("2006-01-02 15:04:05 MST")
+}
+
+func newTable(headers ...string) *table {
+	return &table{headers: headers}
+}
+
+func (t *table) addRow(cells ...tableCell) {
+	t.rows = append(t.rows, cells)
+}
+
+// render writes the table with each column padded to its widest cell. Color codes are added
+// after padding so they never affect alignment.
+func (t *table) render(w io.Writer, style TableStyle) {
+	widths := make([]int, len(t.headers))
+	for i, header := range t.headers {
+		widths[i] = utf8.RuneCountInString(header)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell.text))
+		}
+	}
+
+	headerCells := make([]tableCell, len(t.headers))
+	for i, header := range t.headers {
+		headerCells[i] = tableCell{text: header, color: colorBold}
+	}
+
+	for _, row := range append([][]tableCell{headerCells}, t.rows...) {
+		var line strings.Builder
+		for i, cell := range row {
+			if style.Color && cell.color != "" && cell.text != "" {
+				line.WriteString(cell.color + cell.text + colorReset)
+			} else {
+				line.WriteString(cell.text)
+			}
+			// Pad every column but the last, so lines carry no trailing whitespace
+			if i < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell.text)+2))
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(line.String(), " "))
+	}
+}
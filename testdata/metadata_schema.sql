@@ -0,0 +1,47 @@
+-- Metadata schema version 6
+-- Schema dump generated by zdd
+
+-- Table: zdd_deployments.applied_deployments
+CREATE TABLE zdd_deployments.applied_deployments (id character varying, name character varying, applied_at timestamp with time zone, checksum character varying, skipped_tasks ARRAY, module character varying, status character varying, run_id character varying, run_by character varying, error text, ticket character varying, restore_point character varying, config jsonb);
+
+-- Table: zdd_deployments.approvals
+CREATE TABLE zdd_deployments.approvals (module character varying, deployment_id character varying, scope character varying, checksum character varying, token text, approved_by character varying, approved_at timestamp with time zone, run_id character varying);
+
+-- Table: zdd_deployments.assertion_results
+CREATE TABLE zdd_deployments.assertion_results (module character varying, deployment_id character varying, name character varying, passed boolean, expected text, actual text, checked_at timestamp with time zone);
+
+-- Table: zdd_deployments.compat_views
+CREATE TABLE zdd_deployments.compat_views (view_name character varying, table_name character varying, module character varying, deployment_id character varying, created_at timestamp with time zone);
+
+-- Table: zdd_deployments.deprecations
+CREATE TABLE zdd_deployments.deprecations (module character varying, deployment_id character varying, deployment_name character varying, kind character varying, name character varying, path text, line integer, registered_at timestamp with time zone, run_id character varying);
+
+-- Table: zdd_deployments.feature_flags
+CREATE TABLE zdd_deployments.feature_flags (module character varying, deployment_id character varying, name character varying, value text, phase character varying, set_at timestamp with time zone);
+
+-- Table: zdd_deployments.heartbeats
+CREATE TABLE zdd_deployments.heartbeats (run_id character varying, pid integer, host character varying, run_by character varying, started_at timestamp with time zone, current_task text, updated_at timestamp with time zone);
+
+-- Table: zdd_deployments.metadata_versions
+CREATE TABLE zdd_deployments.metadata_versions (version integer, name character varying, applied_at timestamp with time zone, applied_by character varying);
+
+-- Table: zdd_deployments.pending_deprecations
+CREATE TABLE zdd_deployments.pending_deprecations (module character varying, deployment_id character varying, deployment_name character varying, kind character varying, name character varying, path text, line integer, registered_at timestamp with time zone, run_id character varying);
+
+-- Table: zdd_deployments.repeatable_migrations
+CREATE TABLE zdd_deployments.repeatable_migrations (module character varying, name character varying, checksum character varying, applied_at timestamp with time zone, run_by character varying, objects ARRAY);
+
+-- Table: zdd_deployments.rollback_verifications
+CREATE TABLE zdd_deployments.rollback_verifications (module character varying, deployment_id character varying, rollback_checksum character varying, passed boolean, differences ARRAY, verified_at timestamp with time zone);
+
+-- Table: zdd_deployments.task_runs
+CREATE TABLE zdd_deployments.task_runs (module character varying, deployment_id character varying, task character varying, file_checksum character varying, duration_ms bigint, ran_at timestamp with time zone, runner character varying, run_id character varying);
+
+-- Table: zdd_deployments.trash
+CREATE TABLE zdd_deployments.trash (trashed_table character varying, trashed_column character varying, kind character varying, name character varying, module character varying, deployment_id character varying, trashed_at timestamp with time zone);
+
+-- Index: idx_applied_deployments_applied_at
+CREATE INDEX idx_applied_deployments_applied_at ON zdd_deployments.applied_deployments USING btree (applied_at);
+
+-- Index: idx_task_runs_file_checksum
+CREATE INDEX idx_task_runs_file_checksum ON zdd_deployments.task_runs USING btree (file_checksum);
@@ -0,0 +1,150 @@
+package zdd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const ticketFileName = "ticket.yaml"
+
+// ticketKeyPattern matches issue keys such as PROJ-123
+var ticketKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*-[0-9]+$`)
+
+type (
+	// Ticket is the issue tracker ticket a deployment implements, from ticket.yaml. Its key is
+	// recorded with the deployment when it is applied.
+	Ticket struct {
+		Key     string `yaml:"key"` // e.g. PROJ-123
+		Summary string `yaml:"summary,omitempty"`
+		URL     string `yaml:"url,omitempty"`
+	}
+
+	// TicketFetcher looks up tickets in an issue tracker
+	TicketFetcher interface {
+		FetchTicket(ctx context.Context, key string) (*Ticket, error)
+	}
+
+	// JiraConfig configures the Jira instance `zdd create --from-jira` fetches tickets from
+	JiraConfig struct {
+		URL string `yaml:"url"` // e.g. https://example.atlassian.net
+		// Email and Token authenticate with Jira Cloud; without Email, Token is a personal access
+		// token (Jira Data Center). Use ${VAR} to keep the token out of the file.
+		Email string `yaml:"email"`
+		Token string `yaml:"token"`
+	}
+
+	// JiraFetcher fetches tickets from the Jira REST API
+	JiraFetcher struct {
+		Config JiraConfig
+		Client *http.Client // Defaults to http.DefaultClient
+	}
+)
+
+// FetchTicket fetches the summary of the issue with the key from Jira
+func (f *JiraFetcher) FetchTicket(ctx context.Context, key string) (*Ticket, error) {
+	if f.Config.URL == "" {
+		return nil, fmt.Errorf("no Jira URL configured; set jira.url in zdd.yaml")
+	}
+	base := strings.TrimSuffix(f.Config.URL, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/rest/api/2/issue/"+url.PathEscape(key)+"?fields=summary", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case f.Config.Email != "":
+		req.SetBasicAuth(f.Config.Email, f.Config.Token)
+	case f.Config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+f.Config.Token)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Jira issue %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch Jira issue %s: %s", key, resp.Status)
+	}
+
+	var issue struct {
+		Key    string `json:"key"`
+		Fields struct {
+			Summary string `json:"summary"`
+		} `json:"fields"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&issue); err != nil {
+		return nil, fmt.Errorf("failed to decode Jira issue %s: %w", key, err)
+	}
+	if issue.Key == "" {
+		issue.Key = key
+	}
+
+	return &Ticket{Key: issue.Key, Summary: issue.Fields.Summary, URL: base + "/browse/" + issue.Key}, nil
+}
+
+// LoadTicket reads the ticket of a ticket.yaml file
+func LoadTicket(path string) (*Ticket, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var ticket Ticket
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&ticket); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if ticket.Key == "" {
+		return nil, fmt.Errorf("%s: key is required", path)
+	}
+
+	return &ticket, nil
+}
+
+// CreateTicketDeployment creates a blank deployment for the ticket with the key, fetched with
+// fetcher, and stores the ticket in its ticket.yaml. An empty name is taken from the ticket's
+// key and summary, e.g. proj_123_add_user_emails.
+func CreateTicketDeployment(ctx context.Context, module Module, name, key string, fetcher TicketFetcher) (*Deployment, error) {
+	if !ticketKeyPattern.MatchString(key) {
+		return nil, fmt.Errorf("invalid ticket key %q (expected e.g. PROJ-123)", key)
+	}
+
+	ticket, err := fetcher.FetchTicket(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = ticket.Key + " " + ticket.Summary
+	}
+
+	content, err := yaml.Marshal(ticket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", ticketFileName, err)
+	}
+	files := append(blankDeploymentFiles(), deploymentFile{ticketFileName, string(content), 0644})
+
+	deployment, err := createDeploymentFiles(module, name, files)
+	if err != nil {
+		return nil, err
+	}
+	deployment.Ticket = ticket
+	return deployment, nil
+}
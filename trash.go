@@ -0,0 +1,228 @@
+package zdd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// trashSchema holds the tables soft-dropped by contract phases
+	trashSchema = "zdd_trash"
+	// DefaultTrashRetention is how long trashed objects are kept when trash.retention is unset
+	DefaultTrashRetention = 7 * 24 * time.Hour
+)
+
+type (
+	// TrashConfig is the project's policy for destructive contract statements
+	TrashConfig struct {
+		// Enabled rewrites DROP TABLE and DROP COLUMN in contract SQL into renames, keeping the
+		// data until `zdd purge-trash` drops it
+		Enabled bool `yaml:"enabled"`
+		// Retention is how long trashed objects are kept before purge-trash drops them (default 168h)
+		Retention Duration `yaml:"retention"`
+	}
+
+	// TrashedObject is a table or column a contract phase renamed instead of dropping
+	TrashedObject struct {
+		Kind string // table or column
+		Name string // Original name, e.g. orders or orders.legacy
+		// Table is the table now named zdd_trash.zdd_000042_orders, or the table holding a
+		// trashed column
+		Table string
+		// Column is the trashed column's new name, e.g. zdd_000042_legacy; empty for tables
+		Column       string
+		Module       string
+		DeploymentID string
+		TrashedAt    *time.Time
+	}
+
+	// TrashManager is implemented by databases that track trashed objects
+	TrashManager interface {
+		TrashedObjects() ([]TrashedObject, error)
+		// PurgeTrashedObject drops the object if it still exists and stops tracking it
+		PurgeTrashedObject(object TrashedObject) error
+	}
+)
+
+// RetentionDuration returns retention, or DefaultTrashRetention if it is not set
+func (c TrashConfig) RetentionDuration() time.Duration {
+	if c.Retention > 0 {
+		return time.Duration(c.Retention)
+	}
+	return DefaultTrashRetention
+}
+
+// String describes the object and where it is kept
+func (o TrashedObject) String() string {
+	if o.Kind == "column" {
+		return fmt.Sprintf("column %s (now %s.%s)", o.Name, o.Table, o.Column)
+	}
+	return fmt.Sprintf("table %s (now %s)", o.Name, o.Table)
+}
+
+// trashName returns the name a table or column dropped by the deployment is kept under, e.g.
+// zdd_000042_orders
+func trashName(deployment Deployment, name string) string {
+	name = "zdd_" + strings.ReplaceAll(deployment.Key(), "/", "_") + "_" + name[strings.LastIndex(name, ".")+1:]
+	if len(name) > maxIdentifierLength {
+		name = name[:maxIdentifierLength]
+	}
+	return name
+}
+
+// trashSQL rewrites the DROP TABLE and ALTER TABLE ... DROP COLUMN statements of contract SQL
+// into renames, and returns the objects it trashes. Tables move to the zdd_trash schema and
+// columns are renamed in place and made nullable, so writes that omit them keep working; each is
+// recorded in zdd_deployments.trash by the same transaction. CASCADE is ignored, since views
+// and foreign keys keep working until the object is purged. Content without drops is returned
+// unchanged.
+func trashSQL(content string, deployment Deployment) (string, []TrashedObject) {
+	var (
+		out     strings.Builder
+		trashed []TrashedObject
+	)
+	for _, statement := range scanStatements(content) {
+		sql := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(statement.SQL, " "))
+		rewritten, objects := trashStatement(sql, deployment)
+		if objects == nil {
+			rewritten = statement.SQL + ";\n"
+		}
+		out.WriteString(rewritten)
+		trashed = append(trashed, objects...)
+	}
+	if len(trashed) == 0 {
+		return content, nil
+	}
+
+	for _, object := range trashed {
+		if object.Kind == "table" {
+			return "CREATE SCHEMA IF NOT EXISTS " + trashSchema + ";\n" + out.String(), trashed
+		}
+	}
+	return out.String(), trashed
+}
+
+// trashStatement returns the statements trashing the objects a statement without comments
+// drops, or no objects if it drops none
+func trashStatement(sql string, deployment Deployment) (string, []TrashedObject) {
+	var (
+		out     strings.Builder
+		trashed []TrashedObject
+	)
+
+	if match := dropTablePattern.FindStringSubmatch(sql); match != nil {
+		ifExists := ""
+		if match[1] != "" {
+			ifExists = "IF EXISTS "
+		}
+		for _, table := range strings.Split(match[2], ",") {
+			table = strings.TrimSpace(table)
+			name := trashName(deployment, normalizeName(table))
+			object := TrashedObject{Kind: "table", Name: normalizeName(table), Table: trashSchema + "." + name}
+			// Renaming first keeps the table clear of others trashed under its old name
+			schema := ""
+			if dot := strings.LastIndex(table, "."); dot >= 0 {
+				schema = table[:dot+1]
+			}
+			fmt.Fprintf(&out, "ALTER TABLE %s%s RENAME TO %s;\n", ifExists, table, quoteIdentifier(name))
+			fmt.Fprintf(&out, "ALTER TABLE %s%s%s SET SCHEMA %s;\n", ifExists, schema, quoteIdentifier(name), trashSchema)
+			out.WriteString(recordTrashSQL(object, deployment))
+			trashed = append(trashed, object)
+		}
+		return out.String(), trashed
+	}
+
+	match := alterTablePattern.FindStringSubmatchIndex(sql)
+	if match == nil {
+		return "", nil
+	}
+	prefix, table, actions := sql[:match[4]], sql[match[2]:match[3]], splitTopLevel(sql[match[4]:])
+	tableIfExists := strings.Contains(strings.ToUpper(sql[:match[2]]), "IF EXISTS")
+
+	var kept []string
+	for _, action := range actions {
+		fields := strings.Fields(action)
+		names := dropColumnPattern.FindStringSubmatch(action)
+		if names == nil || len(fields) < 2 || isConstraintKeyword(strings.ToUpper(fields[1])) {
+			kept = append(kept, action)
+			continue
+		}
+
+		column := normalizeName(names[2])
+		object := TrashedObject{Kind: "column", Name: normalizeName(table) + "." + column, Table: normalizeName(table), Column: trashName(deployment, column)}
+		rename := fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;\nALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;\n",
+			table, names[2], quoteIdentifier(object.Column), table, quoteIdentifier(object.Column))
+		if tableIfExists || names[1] != "" {
+			// RENAME COLUMN has no IF EXISTS, so the rename is skipped here when the column is missing
+			rename = fmt.Sprintf("DO $zdd_trash$\nBEGIN\nIF EXISTS (SELECT FROM pg_attribute WHERE attrelid = to_regclass(%s) AND attname = %s AND NOT attisdropped) THEN\n%sEND IF;\nEND\n$zdd_trash$;\n",
+				quoteLiteral(table), quoteLiteral(column), rename)
+		}
+		out.WriteString(rename)
+		out.WriteString(recordTrashSQL(object, deployment))
+		trashed = append(trashed, object)
+	}
+	if trashed == nil {
+		return "", nil
+	}
+
+	// Actions other than the drops keep running first, as RENAME cannot share an ALTER TABLE
+	if len(kept) > 0 {
+		return prefix + strings.Join(kept, ", ") + ";\n" + out.String(), trashed
+	}
+	return out.String(), trashed
+}
+
+// recordTrashSQL returns the statement recording a trashed object if the rename trashed it,
+// which a drop with IF EXISTS may not have
+func recordTrashSQL(object TrashedObject, deployment Deployment) string {
+	exists := fmt.Sprintf("to_regclass(%s) IS NOT NULL", quoteLiteral(quoteIdentifier(object.Table)))
+	if object.Kind == "column" {
+		exists = fmt.Sprintf("EXISTS (SELECT FROM pg_attribute WHERE attrelid = to_regclass(%s) AND attname = %s AND NOT attisdropped)",
+			quoteLiteral(quoteIdentifier(object.Table)), quoteLiteral(object.Column))
+	}
+	return fmt.Sprintf(`INSERT INTO zdd_deployments.trash (kind, name, trashed_table, trashed_column, module, deployment_id)
+SELECT %s, %s, %s, %s, %s, %s WHERE %s
+ON CONFLICT (trashed_table, trashed_column) DO UPDATE SET
+    kind = EXCLUDED.kind, name = EXCLUDED.name, module = EXCLUDED.module,
+    deployment_id = EXCLUDED.deployment_id, trashed_at = NOW();
+`, quoteLiteral(object.Kind), quoteLiteral(object.Name), quoteLiteral(object.Table), quoteLiteral(object.Column),
+		quoteLiteral(deployment.Module), quoteLiteral(deployment.ID), exists)
+}
+
+// PurgeTrash drops the trashed objects older than olderThan and stops tracking them, for
+// `zdd purge-trash`. With dryRun, it only lists them.
+func PurgeTrash(db DatabaseProvider, olderThan time.Duration, dryRun bool, w io.Writer) error {
+	manager, ok := db.(TrashManager)
+	if !ok {
+		return fmt.Errorf("database does not support the trash")
+	}
+
+	objects, err := manager.TrashedObjects()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	purged := 0
+	for _, object := range objects {
+		if object.TrashedAt != nil && object.TrashedAt.After(cutoff) {
+			continue
+		}
+		purged++
+		if dryRun {
+			fmt.Fprintf(w, "  Would purge %s (from deployment %s)\n", object, deploymentKey(object.Module, object.DeploymentID))
+			continue
+		}
+		if err := manager.PurgeTrashedObject(object); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "  Purged %s (from deployment %s)\n", object, deploymentKey(object.Module, object.DeploymentID))
+	}
+
+	if purged == 0 {
+		fmt.Fprintf(w, "No trashed objects older than %s\n", olderThan)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package zdd
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// sqlQueryPattern matches string literals that look like SQL queries
+	sqlQueryPattern = regexp.MustCompile(`(?i)\b(?:SELECT|INSERT\s+INTO|UPDATE|DELETE\s+FROM|FROM|JOIN|RETURNING)\b`)
+	// columnTagKeys are the struct tag keys whose first value names a column
+	columnTagKeys = []string{"db", "sql", "pg", "bun", "ch"}
+)
+
+// CheckGoUsage looks for references to the tables and columns of deprecations in the Go files
+// matched by patterns, each a directory or a directory followed by /... for its whole tree. It
+// is a heuristic: SQL in string literals, such as raw queries and sqlc's generated queries,
+// references a dropped table by name, and a dropped column by name alongside its table. Struct
+// fields reference a dropped column by their db, sql, pg, bun or ch tag, a gorm column, or, in
+// a struct named after the column's table like sqlc's models, their json tag or name. vendor,
+// testdata and hidden directories are skipped.
+func CheckGoUsage(patterns []string, deprecations []Deprecation) ([]LintIssue, error) {
+	if len(deprecations) == 0 {
+		return nil, nil
+	}
+
+	var files []string
+	for _, pattern := range patterns {
+		matched, err := goFiles(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range matched {
+			if !slices.Contains(files, file) {
+				files = append(files, file)
+			}
+		}
+	}
+
+	var issues []LintIssue
+	for _, path := range files {
+		found, err := checkGoFile(path, deprecations)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+	}
+	return issues, nil
+}
+
+// goFiles returns the Go files a pattern such as ./... or ./internal/store matches
+func goFiles(pattern string) ([]string, error) {
+	dir, recursive := strings.CutSuffix(pattern, "...")
+	dir = filepath.Clean(strings.TrimSuffix(dir, "/"))
+	if dir == "" {
+		dir = "."
+	}
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == dir {
+				return nil
+			}
+			name := entry.Name()
+			if !recursive || name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".go") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Go files of %s: %w", pattern, err)
+	}
+	return files, nil
+}
+
+// checkGoFile reports the references to deprecations in the Go file at path
+func checkGoFile(path string, deprecations []Deprecation) ([]LintIssue, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var issues []LintIssue
+	report := func(pos token.Pos, offset string, d Deprecation, how string) {
+		line := fset.Position(pos).Line + strings.Count(offset, "\n")
+		issues = append(issues, LintIssue{
+			Path:    path,
+			Line:    line,
+			Check:   "uses-dropped-" + d.Kind,
+			Message: fmt.Sprintf("%s %s %s, which the contract phase of deployment %s drops", how, d.Kind, d.Name, deploymentKey(d.Module, d.DeploymentID)),
+		})
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.TypeSpec:
+			if structType, ok := node.Type.(*ast.StructType); ok {
+				for _, field := range structType.Fields.List {
+					for _, d := range deprecations {
+						if d.Kind == "column" && fieldReferences(node.Name.Name, field, d.Name) {
+							report(field.Pos(), "", d, "field maps to")
+						}
+					}
+				}
+			}
+
+		case *ast.Field:
+			return false // Tags are not queries
+
+		case *ast.BasicLit:
+			if node.Kind != token.STRING {
+				return true
+			}
+			value, err := strconv.Unquote(node.Value)
+			if err != nil || !sqlQueryPattern.MatchString(value) {
+				return true
+			}
+			for _, d := range deprecations {
+				if at := sqlReference(value, d); at >= 0 {
+					report(node.Pos(), value[:at], d, "query references")
+				}
+			}
+		}
+		return true
+	})
+	return issues, nil
+}
+
+// sqlReference returns the offset in query of a reference to the deprecated object, or -1
+func sqlReference(query string, d Deprecation) int {
+	table, column, _ := strings.Cut(d.Name, ".")
+	if d.Kind == "table" {
+		return wordIndex(query, unqualified(d.Name))
+	}
+	if wordIndex(query, unqualified(table)) < 0 {
+		return -1
+	}
+	return wordIndex(query, column)
+}
+
+// unqualified returns a name without its schema
+func unqualified(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// wordIndex returns the offset of the first case-insensitive occurrence of word in s that is
+// not part of a longer identifier, or -1
+func wordIndex(s, word string) int {
+	pattern := regexp.MustCompile(`(?i)(?:^|[^\w$])"?(` + regexp.QuoteMeta(word) + `)"?(?:[^\w$]|$)`)
+	match := pattern.FindStringSubmatchIndex(s)
+	if match == nil {
+		return -1
+	}
+	return match[2]
+}
+
+// fieldReferences reports whether a field of the named struct maps to the column table.column
+func fieldReferences(structName string, field *ast.Field, name string) bool {
+	table, column, _ := strings.Cut(name, ".")
+	var tag reflect.StructTag
+	if field.Tag != nil {
+		if value, err := strconv.Unquote(field.Tag.Value); err == nil {
+			tag = reflect.StructTag(value)
+		}
+	}
+
+	for _, key := range columnTagKeys {
+		if value, ok := tag.Lookup(key); ok {
+			if tagged, _, _ := strings.Cut(value, ","); strings.EqualFold(tagged, column) {
+				return true
+			}
+		}
+	}
+	for _, option := range strings.Split(tag.Get("gorm"), ";") {
+		if value, ok := strings.CutPrefix(option, "column:"); ok && strings.EqualFold(value, column) {
+			return true
+		}
+	}
+
+	// sqlc names models after their table in the singular, e.g. Order for orders
+	model := goIdentifier(unqualified(table))
+	if !strings.EqualFold(structName, model) && !strings.EqualFold(structName+"s", model) {
+		return false
+	}
+	if tagged, _, _ := strings.Cut(tag.Get("json"), ","); strings.EqualFold(tagged, column) {
+		return true
+	}
+	return slices.ContainsFunc(field.Names, func(ident *ast.Ident) bool { return strings.EqualFold(ident.Name, goIdentifier(column)) })
+}
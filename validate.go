@@ -0,0 +1,216 @@
+package zdd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	validateFileName      = "validate.yaml"
+	defaultValidationKey  = "id"
+	defaultValidationSize = 1000
+)
+
+// Validation compares an old column with its replacement on a sample of rows before the contract
+// phase may drop the old one. The new column may live in another table joined on Key.
+type Validation struct {
+	Name string `yaml:"name"`
+	// Deployment selects the deployment (id, or module/id) in zdd.yaml; unused in validate.yaml
+	Deployment string `yaml:"deployment"`
+	Table      string `yaml:"table"`
+	Column     string `yaml:"column"`
+	NewTable   string `yaml:"new_table"` // Default: Table
+	NewColumn  string `yaml:"new_column"`
+	Key        string `yaml:"key"`         // Column joining old and new rows; default id
+	SampleSize int    `yaml:"sample_size"` // Default: 1000
+	// Tolerance is the fraction of sampled rows allowed to differ, from 0 (default) to 1
+	Tolerance float64 `yaml:"tolerance"`
+}
+
+// LoadValidations reads the list of validations in a validate.yaml file
+func LoadValidations(path string) ([]Validation, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var validations []Validation
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&validations); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for i := range validations {
+		if validations[i].Name == "" {
+			validations[i].Name = fmt.Sprintf("%s#%d", filepath.Base(path), i+1)
+		}
+		if err := validations[i].check(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return validations, nil
+}
+
+// check reports a validation missing required fields or with an out of range tolerance
+func (v Validation) check() error {
+	if v.Table == "" || v.Column == "" || v.NewColumn == "" {
+		return fmt.Errorf("validation %q requires table, column and new_column", v.Name)
+	}
+	if v.Tolerance < 0 || v.Tolerance > 1 {
+		return fmt.Errorf("validation %q has tolerance %v outside 0-1", v.Name, v.Tolerance)
+	}
+	return nil
+}
+
+// query counts the sampled rows and those whose old and new values differ
+func (v Validation) query() string {
+	newTable := v.NewTable
+	if newTable == "" {
+		newTable = v.Table
+	}
+	key := v.Key
+	if key == "" {
+		key = defaultValidationKey
+	}
+	size := v.SampleSize
+	if size <= 0 {
+		size = defaultValidationSize
+	}
+
+	return fmt.Sprintf(`SELECT count(*), count(*) FILTER (WHERE o.%[1]s IS DISTINCT FROM n.%[2]s)
+FROM (SELECT %[3]s, %[1]s FROM %[4]s ORDER BY random() LIMIT %[6]d) o
+LEFT JOIN %[5]s n ON n.%[3]s = o.%[3]s`,
+		quoteIdentifier(v.Column), quoteIdentifier(v.NewColumn), quoteIdentifier(key),
+		quoteIdentifier(v.Table), quoteIdentifier(newTable), size)
+}
+
+// quoteIdentifier quotes a possibly schema-qualified SQL identifier
+func quoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// AddValidations attaches validations from the config file to the pending deployments they name.
+// Validations for deployments that are not part of the plan are ignored.
+func (p *Plan) AddValidations(validations []Validation) error {
+	for _, validation := range validations {
+		if err := validation.check(); err != nil {
+			return err
+		}
+
+		for _, task := range p.Tasks {
+			if task.Deployment.Key() == validation.Deployment {
+				task.Deployment.Validations = append(task.Deployment.Validations, validation)
+				break
+			}
+		}
+	}
+
+	// A deployment's tasks are contiguous, so insert a validate task before the first task of
+	// contract or a later phase (or at the end) of each deployment that has validations but no
+	// validate task yet
+	var tasks []Task
+	for start := 0; start < len(p.Tasks); {
+		deployment := p.Tasks[start].Deployment
+		end := start
+		for end < len(p.Tasks) && p.Tasks[end].Deployment == deployment {
+			end++
+		}
+		group := p.Tasks[start:end]
+		start = end
+
+		if len(deployment.Validations) == 0 || slices.ContainsFunc(group, func(t Task) bool { return t.TaskType == "validate" }) {
+			tasks = append(tasks, group...)
+			continue
+		}
+
+		at := slices.IndexFunc(group, func(t Task) bool {
+			return t.Deployment.layout.phaseIndex(t.Phase) >= t.Deployment.layout.phaseIndex("contract")
+		})
+		if at < 0 {
+			at = len(group)
+		}
+		tasks = append(tasks, group[:at]...)
+		tasks = append(tasks, deployment.validateTask())
+		tasks = append(tasks, group[at:]...)
+	}
+	p.Tasks = tasks
+
+	return nil
+}
+
+// validateTask checks the deployment's validations before its contract phase runs
+func (d *Deployment) validateTask() Task {
+	path := filepath.Join(d.Directory, validateFileName)
+	if _, err := os.Stat(path); err != nil {
+		path = ""
+	}
+	return Task{TaskType: "validate", Path: path, Phase: "contract", Deployment: d}
+}
+
+// runValidations samples every validation of the deployment, recording each result, and fails
+// if more rows differ than a validation tolerates
+func (p *Plan) runValidations(deployment *Deployment) error {
+	runner, ok := p.db.(AssertionRunner)
+	if !ok {
+		return fmt.Errorf("database does not support validations")
+	}
+
+	var failed []string
+	for _, validation := range deployment.Validations {
+		rows, err := runner.QueryValues(validation.query())
+		if err != nil {
+			return fmt.Errorf("validation %s failed to run: %w", validation.Name, err)
+		}
+		if len(rows) != 1 || len(rows[0]) != 2 {
+			return fmt.Errorf("validation %s returned an unexpected result", validation.Name)
+		}
+
+		sampled, err := strconv.Atoi(rows[0][0])
+		if err != nil {
+			return fmt.Errorf("validation %s returned an invalid count: %w", validation.Name, err)
+		}
+		mismatched, err := strconv.Atoi(rows[0][1])
+		if err != nil {
+			return fmt.Errorf("validation %s returned an invalid count: %w", validation.Name, err)
+		}
+
+		allowed := int(validation.Tolerance * float64(sampled))
+		result := AssertionResult{
+			Module:       deployment.Module,
+			DeploymentID: deployment.ID,
+			Name:         validation.Name,
+			Passed:       mismatched <= allowed,
+			Expected:     fmt.Sprintf("at most %d of %d sampled rows differ", allowed, sampled),
+			Actual:       fmt.Sprintf("%d of %d sampled rows differ", mismatched, sampled),
+		}
+		if err := runner.RecordAssertion(result); err != nil {
+			return fmt.Errorf("failed to record validation %s: %w", validation.Name, err)
+		}
+
+		if result.Passed {
+			fmt.Fprintf(p.out(), "  Validation %s passed: %s\n", validation.Name, result.Actual)
+			continue
+		}
+		fmt.Fprintf(p.out(), "  Validation %s failed: %s, expected %s\n", validation.Name, result.Actual, result.Expected)
+		failed = append(failed, validation.Name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("validations failed for deployment %s: %s", deployment.Key(), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package zdd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CheckVersion returns an error unless version satisfies constraint. A constraint is a
+// comma-separated list of comparisons that must all hold, such as ">=0.3, <1.0".
+// Supported operators are =, !=, >, >=, < and <=; a bare version means =.
+func CheckVersion(version, constraint string) error {
+	ok, err := satisfiesVersion(version, constraint)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("zdd %s does not satisfy required version %q", version, constraint)
+	}
+	return nil
+}
+
+// satisfiesVersion reports whether version meets every comparison of constraint
+func satisfiesVersion(version, constraint string) (bool, error) {
+	current, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+
+	for _, clause := range strings.Split(constraint, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+
+		op, operand := splitVersionOperator(clause)
+		required, err := parseVersion(operand)
+		if err != nil {
+			return false, fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+		}
+
+		cmp := compareVersions(current, required)
+		var ok bool
+		switch op {
+		case "=":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// splitVersionOperator separates the comparison operator from the version in a constraint clause
+func splitVersionOperator(clause string) (string, string) {
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if strings.HasPrefix(clause, op) {
+			return op, strings.TrimSpace(strings.TrimPrefix(clause, op))
+		}
+	}
+	return "=", clause
+}
+
+// parseVersion parses major[.minor[.patch]] with an optional v prefix; any pre-release or
+// build suffix is ignored
+func parseVersion(version string) ([3]int, error) {
+	var parsed [3]int
+
+	v := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+
+	parts := strings.Split(v, ".")
+	if v == "" || len(parts) > 3 {
+		return parsed, fmt.Errorf("invalid version %q", version)
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, fmt.Errorf("invalid version %q", version)
+		}
+		parsed[i] = n
+	}
+
+	return parsed, nil
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
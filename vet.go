@@ -0,0 +1,165 @@
+package zdd
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EmbeddedTree is a deployments directory embedded into a Go package with //go:embed
+type EmbeddedTree struct {
+	Path string // Deployments directory
+	// Source and Line locate the first //go:embed directive embedding the tree
+	Source string
+	Line   int
+}
+
+// FindEmbeddedTrees returns the deployments directories embedded by the //go:embed directives of
+// goFiles, in the order they are first embedded. A pattern embeds a tree if it names a directory
+// holding deployment directories, or names deployment directories themselves.
+func FindEmbeddedTrees(goFiles []string) ([]EmbeddedTree, error) {
+	var trees []EmbeddedTree
+	seen := make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, file := range goFiles {
+		parsed, err := parser.ParseFile(fset, file, nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+
+		for _, group := range parsed.Comments {
+			for _, comment := range group.List {
+				patterns, ok := strings.CutPrefix(comment.Text, "//go:embed ")
+				if !ok {
+					continue
+				}
+				fields, err := embedPatterns(patterns)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", fset.Position(comment.Pos()), err)
+				}
+				for _, pattern := range fields {
+					matches, err := filepath.Glob(filepath.Join(filepath.Dir(file), filepath.FromSlash(pattern)))
+					if err != nil {
+						return nil, fmt.Errorf("%s: invalid pattern %q: %w", fset.Position(comment.Pos()), pattern, err)
+					}
+					for _, match := range matches {
+						tree, ok := deploymentsTree(match)
+						if !ok || seen[tree] {
+							continue
+						}
+						seen[tree] = true
+						trees = append(trees, EmbeddedTree{Path: tree, Source: file, Line: fset.Position(comment.Pos()).Line})
+					}
+				}
+			}
+		}
+	}
+	return trees, nil
+}
+
+// embedPatterns splits the patterns of a //go:embed directive, which may be quoted, dropping the
+// all: prefix
+func embedPatterns(directive string) ([]string, error) {
+	var patterns []string
+	for rest := strings.TrimSpace(directive); rest != ""; rest = strings.TrimSpace(rest) {
+		var pattern string
+		if rest[0] == '"' || rest[0] == '`' {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted pattern in //go:embed %s", directive)
+			}
+			pattern, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			pattern, rest = rest[:end], rest[end:]
+		}
+		patterns = append(patterns, strings.TrimPrefix(pattern, "all:"))
+	}
+	return patterns, nil
+}
+
+// deploymentsTree returns the deployments directory path is, or the one holding path if it is a
+// deployment directory
+func deploymentsTree(path string) (string, bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+	if defaultLayout.dirPattern.MatchString(filepath.Base(path)) {
+		return filepath.Dir(path), true
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", false
+	}
+	return path, slices.ContainsFunc(entries, func(entry os.DirEntry) bool {
+		return entry.IsDir() && defaultLayout.dirPattern.MatchString(entry.Name())
+	})
+}
+
+// VetEmbeddedTrees loads and lints each tree as `zdd lint` does. A tree that fails to load is
+// an invalid-deployments issue at the directive embedding it.
+func VetEmbeddedTrees(trees []EmbeddedTree) ([]LintIssue, error) {
+	var issues []LintIssue
+	for _, tree := range trees {
+		deployments, err := LoadDeployments(tree.Path)
+		if err != nil {
+			issues = append(issues, LintIssue{Path: tree.Source, Line: tree.Line, Check: "invalid-deployments", Message: fmt.Sprintf("embedded deployments %s: %v", tree.Path, err)})
+			continue
+		}
+		found, err := LintDeployments(deployments)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+	}
+	return issues, nil
+}
+
+// VetEmbeddedDeployments finds the deployments trees embedded by the Go packages in dir and its
+// subdirectories and lints them, for a test such as
+//
+//	func TestMigrations(t *testing.T) {
+//		issues, err := zdd.VetEmbeddedDeployments(".")
+//		...
+//	}
+//
+// Directories the go tool ignores (hidden, _-prefixed and testdata) and vendor are skipped.
+func VetEmbeddedDeployments(dir string) ([]LintIssue, error) {
+	var goFiles []string
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			name := entry.Name()
+			if path != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".go") {
+			goFiles = append(goFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find Go files in %s: %w", dir, err)
+	}
+
+	trees, err := FindEmbeddedTrees(goFiles)
+	if err != nil {
+		return nil, err
+	}
+	return VetEmbeddedTrees(trees)
+}
@@ -0,0 +1,149 @@
+package zdd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const viewsFileName = "views.yaml"
+
+type (
+	// CompatView is a view left under a renamed table's old name so application versions still
+	// using that name keep working for one release window
+	CompatView struct {
+		View  string `yaml:"view"`  // Old, possibly schema-qualified table name now served by the view
+		Table string `yaml:"table"` // Renamed table the view selects from
+		// Module and DeploymentID identify the deployment that created the view; unused in views.yaml
+		Module       string     `yaml:"-"`
+		DeploymentID string     `yaml:"-"`
+		CreatedAt    *time.Time `yaml:"-"`
+	}
+
+	// CompatViewManager is implemented by databases that track compatibility views
+	CompatViewManager interface {
+		CompatViews() ([]CompatView, error)
+		RecordCompatView(view CompatView) error
+		// DropCompatView drops the view if it still exists and stops tracking it
+		DropCompatView(view CompatView) error
+	}
+)
+
+// LoadCompatViews reads the compatibility views declared in a views.yaml file
+func LoadCompatViews(path string) ([]CompatView, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var views []CompatView
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&views); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for i, view := range views {
+		if view.View == "" || view.Table == "" {
+			return nil, fmt.Errorf("%s: view #%d requires view and table", path, i+1)
+		}
+	}
+
+	return views, nil
+}
+
+// viewsTask records the deployment's compatibility views once its contract SQL created them
+func (d *Deployment) viewsTask() Task {
+	return Task{TaskType: "views", Path: filepath.Join(d.Directory, viewsFileName), Phase: "contract", Deployment: d}
+}
+
+// recordCompatViews starts tracking the views created by the deployment's contract phase
+func (p *Plan) recordCompatViews(deployment *Deployment) error {
+	manager, ok := p.db.(CompatViewManager)
+	if !ok {
+		return fmt.Errorf("database does not support compatibility views")
+	}
+
+	for _, view := range deployment.CompatViews {
+		view.Module = deployment.Module
+		view.DeploymentID = deployment.ID
+		if err := manager.RecordCompatView(view); err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out(), "  Keeping compatibility view %s for %s until the next deployment\n", view.View, view.Table)
+	}
+	return nil
+}
+
+// AddViewCleanup drops the compatibility views left by earlier deployments before the first
+// contract task of the plan. Their release window ends once a later deployment contracts, as
+// every running application version then uses the renamed tables.
+func (p *Plan) AddViewCleanup() error {
+	manager, ok := p.db.(CompatViewManager)
+	if !ok {
+		return nil
+	}
+
+	views, err := manager.CompatViews()
+	if err != nil {
+		return err
+	}
+	if len(views) == 0 {
+		return nil
+	}
+
+	at := slices.IndexFunc(p.Tasks, func(t Task) bool { return t.Phase == "contract" })
+	if at < 0 {
+		return nil
+	}
+
+	p.dropViews = views
+	task := Task{TaskType: "cleanup-views", Phase: "contract", Deployment: p.Tasks[at].Deployment}
+	p.Tasks = slices.Insert(p.Tasks, at, task)
+	return nil
+}
+
+// cleanupCompatViews drops the compatibility views AddViewCleanup found
+func (p *Plan) cleanupCompatViews() error {
+	manager, ok := p.db.(CompatViewManager)
+	if !ok {
+		return fmt.Errorf("database does not support compatibility views")
+	}
+	return dropCompatViews(manager, p.dropViews, p.out())
+}
+
+// CleanupCompatViews drops every tracked compatibility view, for `zdd contract --cleanup-views`
+func CleanupCompatViews(db DatabaseProvider, w io.Writer) error {
+	manager, ok := db.(CompatViewManager)
+	if !ok {
+		return fmt.Errorf("database does not support compatibility views")
+	}
+
+	views, err := manager.CompatViews()
+	if err != nil {
+		return err
+	}
+	if len(views) == 0 {
+		fmt.Fprintln(w, "No compatibility views to remove")
+		return nil
+	}
+
+	return dropCompatViews(manager, views, w)
+}
+
+// dropCompatViews drops each view and stops tracking it
+func dropCompatViews(manager CompatViewManager, views []CompatView, w io.Writer) error {
+	for _, view := range views {
+		if err := manager.DropCompatView(view); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "  Removed compatibility view %s (from deployment %s)\n", view.View, deploymentKey(view.Module, view.DeploymentID))
+	}
+	return nil
+}